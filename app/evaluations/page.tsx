@@ -695,9 +695,8 @@ export default function EvaluationsPage() {
 
     // 员工最后确认最终得分
     if (stage === "confirm") {
-      // 检查是否所有项目都已确认最终得分
-      const alreadyConfirmed = scores.find(score => !isUnknown(score.final_score))
-      if (alreadyConfirmed) {
+      // 最终得分在HR审核完成时已计算，以评估状态判断是否已确认
+      if (selectedEvaluation?.status === "completed") {
         Alert("确认最终得分", "已确认最终得分，无法再修改。")
         return
       }
@@ -756,16 +755,6 @@ export default function EvaluationsPage() {
       const finalStatus = response.data?.status || newStatus
       const finalTotalScore = response.data?.total_score || totalScore
 
-      if (stage === "confirm") {
-        // 员工确认最终得分后，更新最终得分
-        setScores(scores =>
-          scores.map(s => ({
-            ...s,
-            final_score: s.hr_score ?? s.manager_score ?? s.self_score,
-          }))
-        )
-      }
-
       fetchEvaluations()
       if (selectedEvaluation) {
         setSelectedEvaluation({
@@ -1446,7 +1435,7 @@ export default function EvaluationsPage() {
                           evaluation.scores?.reduce(
                             (acc, score) =>
                               acc +
-                              (score.final_score ?? score.hr_score ?? score.manager_score ?? score.self_score ?? 0), 0
+                              (score.final_score ?? score.adjusted_score ?? score.hr_score ?? score.manager_score ?? score.self_score ?? 0), 0
                           )
                         )}
                       </div>
@@ -1989,9 +1978,9 @@ export default function EvaluationsPage() {
                               </div>
                               <div className="text-center">
                                 <div className="text-2xl font-bold text-blue-600">
-                                  {isUnknown(score.final_score ?? score.hr_score ?? score.manager_score ?? score.self_score)
+                                  {isUnknown(score.final_score ?? score.adjusted_score ?? score.hr_score ?? score.manager_score ?? score.self_score)
                                     ? "-"
-                                    : formatScore((score.final_score ?? score.hr_score ?? score.manager_score ?? score.self_score) as number)}
+                                    : formatScore((score.final_score ?? score.adjusted_score ?? score.hr_score ?? score.manager_score ?? score.self_score) as number)}
                                 </div>
                                 <div className="text-sm text-muted-foreground">
                                   {getScoreLabel(selectedEvaluation.status)}
@@ -2368,7 +2357,7 @@ export default function EvaluationsPage() {
                                 scores.reduce(
                                   (acc, score) =>
                                     acc +
-                                    (score.final_score ?? score.hr_score ?? score.manager_score ?? score.self_score ?? 0),
+                                    (score.final_score ?? score.adjusted_score ?? score.hr_score ?? score.manager_score ?? score.self_score ?? 0),
                                   0
                                 )
                               )}
@@ -31,12 +31,47 @@ api.interceptors.request.use(
   error => Promise.reject(error)
 )
 
+// 正在进行的刷新请求，多个请求同时过期时共用一次刷新
+let refreshPromise: Promise<string> | null = null
+
+// 使用刷新token换取新的访问token，刷新token同时更换
+const refreshAccessToken = (): Promise<string> => {
+  if (!refreshPromise) {
+    const refreshToken = storage.getItem("refresh_token")
+    refreshPromise = (
+      refreshToken
+        ? axios.post(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken }).then(response => {
+            storage.setItem("auth_token", response.data.token)
+            storage.setItem("refresh_token", response.data.refresh_token)
+            return response.data.token as string
+          })
+        : Promise.reject(new Error("缺少刷新token"))
+    ).finally(() => {
+      refreshPromise = null
+    })
+  }
+  return refreshPromise
+}
+
 // 响应拦截器
 api.interceptors.response.use(
   response => response.data,
-  error => {
+  async error => {
     console.error("API Error:", error)
 
+    // 访问token过期时先尝试刷新，刷新成功后重试原请求
+    const request = error.config
+    if (error.response?.status === 401 && request && !request._retried && !request.url?.startsWith("/auth/")) {
+      request._retried = true
+      try {
+        const token = await refreshAccessToken()
+        request.headers.Authorization = `Bearer ${token}`
+        return api(request)
+      } catch {
+        // 刷新失败，按未登录处理
+      }
+    }
+
     // 处理401错误，触发认证状态更新
     if (error.response?.status === 401) {
       if (typeof window !== "undefined") {
@@ -116,10 +151,13 @@ export interface KPIScore {
   manager_score?: number
   manager_comment: string
   manager_auto: boolean
+  manager_scorer_id?: number // 填写上级评分的评分人
   hr_score?: number
   hr_comment: string
   final_score?: number
   final_comment: string
+  adjusted_score?: number // 申诉调整后的得分，确认后直接作为最终得分
+  override_score?: number // HR指定的最终得分，代替按评分策略计算的得分
   created_at: string
   item?: KPIItem
 }
@@ -260,9 +298,22 @@ export interface RegisterRequest {
 
 export interface LoginResponse {
   token: string
+  refresh_token: string
+  expires_in: number
   user: Employee
 }
 
+export interface UserSession {
+  id: number
+  employee_id: number
+  user_agent: string
+  ip: string
+  last_used_at: string
+  expires_at: string
+  created_at: string
+  current: boolean
+}
+
 export interface AuthUser {
   id: number
   name: string
@@ -459,14 +510,28 @@ export const authApi = {
   getCurrentUser: (): Promise<{ data: AuthUser }> => api.get("/me"),
 
   // 刷新token
-  refreshToken: (): Promise<{ token: string }> => api.post("/auth/refresh"),
+  refreshToken: (): Promise<string> => refreshAccessToken(),
+
+  // 退出所有设备
+  logoutAll: (): Promise<void> => api.post("/auth/logout-all"),
+
+  // 当前用户的登录会话
+  getSessions: (): Promise<{ data: UserSession[] }> => api.get("/sessions"),
+
+  // 下线指定会话
+  revokeSession: (id: number): Promise<void> => api.delete(`/sessions/${id}`),
 
   // 获取部门列表（公开接口，用于注册）
   getDepartments: (): Promise<{ data: Department[] }> => api.get("/auth/departments"),
 
-  // 登出（清除本地token）
+  // 登出（使服务端会话失效并清除本地token）
   logout: () => {
+    const refreshToken = storage.getItem("refresh_token")
+    if (refreshToken) {
+      api.post("/auth/logout", { refresh_token: refreshToken }).catch(() => {})
+    }
     storage.removeItem("auth_token")
+    storage.removeItem("refresh_token")
     storage.removeItem("user_info")
   },
 
@@ -481,9 +546,12 @@ export const authApi = {
     return storage.getItem("auth_token")
   },
 
-  // 设置用户token和信息
-  setAuth: (token: string, user: AuthUser) => {
+  // 设置用户token和信息，登录时同时保存刷新token
+  setAuth: (token: string, user: AuthUser, refreshToken?: string) => {
     storage.setItem("auth_token", token)
+    if (refreshToken) {
+      storage.setItem("refresh_token", refreshToken)
+    }
     storage.setItem("user_info", JSON.stringify(user))
   },
 
@@ -45,7 +45,8 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
           try {
             const response = await authApi.getCurrentUser()
             setUser(response.data)
-            authApi.setAuth(token, response.data)
+            // 请求过程中访问token可能已刷新，使用最新的token
+            authApi.setAuth(authApi.getToken() || token, response.data)
           } catch {
             // Token无效，清除本地存储
             authApi.logout()
@@ -71,7 +72,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   const login = async (data: LoginRequest) => {
     try {
       const response = await authApi.login(data)
-      authApi.setAuth(response.token, response.user)
+      authApi.setAuth(response.token, response.user, response.refresh_token)
       setUser(response.user)
     } catch (error) {
       throw error
@@ -87,7 +88,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   }) => {
     try {
       const response = await authApi.register(data)
-      authApi.setAuth(response.token, response.user)
+      authApi.setAuth(response.token, response.user, response.refresh_token)
       setUser(response.user)
     } catch (error) {
       throw error
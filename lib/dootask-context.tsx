@@ -88,7 +88,7 @@ export function DootaskProvider({ children }: { children: React.ReactNode }) {
           email: dooTaskUser.email,
           token: dooTaskUser.token,
         })
-        authApi.setAuth(loginResponse.token, loginResponse.user)
+        authApi.setAuth(loginResponse.token, loginResponse.user, loginResponse.refresh_token)
         authApi.setDooTaskToken(dooTaskUser.token)
 
         setDooTaskUser(dooTaskUser)
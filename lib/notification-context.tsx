@@ -3,7 +3,7 @@
 import { createContext, useContext, useEffect, useState, useCallback, useRef } from "react"
 import { useAuth } from "./auth-context"
 import { toast } from "sonner"
-import { sseApi } from "./api"
+import { authApi, sseApi } from "./api"
 import { storage } from "./storage"
 
 // 通知事件类型
@@ -180,7 +180,11 @@ export function NotificationProvider({ children }: { children: React.ReactNode }
                 eventSourceRef.current.close()
                 eventSourceRef.current = null
               }
-              connect()
+              // 访问token有效期较短，断开可能是token过期导致，重连前先刷新
+              authApi
+                .refreshToken()
+                .catch(() => undefined)
+                .finally(() => connect())
             }, retryInterval * 1000)
           } else {
             logger.error("SSE连接重试次数已达上限")
@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"dootask-kpi-server/handlers"
+	"dootask-kpi-server/models"
+)
+
+// 随机密码使用的字符，去掉了容易混淆的字符
+const passwordAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// 创建HR管理员账号
+func runCreateAdmin(args []string) error {
+	fs := newFlagSet("create-admin")
+	email := fs.String("email", "", "登录邮箱（必填）")
+	name := fs.String("name", "管理员", "姓名")
+	password := fs.String("password", "", "登录密码，为空时自动生成")
+	department := fs.String("department", "人事部", "所属部门名称，不存在时自动创建")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return usageError("create-admin --email <邮箱> [--name <姓名>] [--password <密码>] [--department <部门>]")
+	}
+
+	models.InitDB()
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+	admin, err := handlers.CreateAdmin(*name, *email, *password, *department)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已创建HR账号 %s（ID %d）\n", admin.Email, admin.ID)
+	printGeneratedPassword(generated, *password)
+	return nil
+}
+
+// 重置员工密码
+func runResetPassword(args []string) error {
+	fs := newFlagSet("reset-password")
+	email := fs.String("email", "", "员工邮箱（必填）")
+	password := fs.String("password", "", "新密码，为空时自动生成")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return usageError("reset-password --email <邮箱> [--password <密码>]")
+	}
+
+	models.InitDB()
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+	employee, err := handlers.ResetPassword(*email, *password)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已重置 %s 的密码\n", employee.Email)
+	printGeneratedPassword(generated, *password)
+	return nil
+}
+
+// 修改员工角色
+func runChangeRole(args []string) error {
+	fs := newFlagSet("change-role")
+	email := fs.String("email", "", "员工邮箱（必填）")
+	role := fs.String("role", "", "新角色：employee, manager, hr（必填）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" || *role == "" {
+		return usageError("change-role --email <邮箱> --role <employee|manager|hr>")
+	}
+
+	models.InitDB()
+	employee, err := handlers.ChangeRole(*email, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s 的角色为 %s\n", employee.Email, employee.Role)
+	return nil
+}
+
+// 停用员工账号
+func runDeactivateUser(args []string) error {
+	fs := newFlagSet("deactivate-user")
+	email := fs.String("email", "", "员工邮箱（必填）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return usageError("deactivate-user --email <邮箱>")
+	}
+
+	models.InitDB()
+	employee, err := handlers.DeactivateUser(*email)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已停用 %s\n", employee.Email)
+	return nil
+}
+
+// 创建测试数据，已有数据时跳过
+func runSeed(args []string) error {
+	if err := newFlagSet("seed").Parse(args); err != nil {
+		return err
+	}
+	models.InitDB()
+	models.CreateTestData()
+	return nil
+}
+
+// 重新计算已完成评估的得分
+func runRecomputeScores(args []string) error {
+	fs := newFlagSet("recompute-scores")
+	ids := fs.String("evaluation", "", "评估ID，多个以逗号分隔，为空时处理全部已完成的评估")
+	dryRun := fs.Bool("dry-run", false, "只计算并输出结果，不保存")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var evaluationIDs []uint
+	for _, value := range strings.Split(*ids, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("评估ID格式错误: %s", value)
+		}
+		evaluationIDs = append(evaluationIDs, uint(id))
+	}
+
+	models.InitDB()
+	results, err := handlers.RecomputeScores(evaluationIDs, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	changed, revoked := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "评估ID\t原总分\t新总分\t原等级\t新等级")
+	for _, result := range results {
+		if result.NormalizationRevoked {
+			revoked++
+		}
+		if result.PreviousScore == result.TotalScore && result.PreviousGrade == result.Grade {
+			continue
+		}
+		changed++
+		fmt.Fprintf(w, "%d\t%.2f\t%.2f\t%s\t%s\n", result.EvaluationID, result.PreviousScore, result.TotalScore, result.PreviousGrade, result.Grade)
+	}
+	if changed > 0 {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("试算 %d 个评估，%d 个结果将发生变化，未保存\n", len(results), changed)
+	} else {
+		fmt.Printf("已重新计算 %d 个评估，%d 个结果发生变化\n", len(results), changed)
+	}
+	if revoked > 0 && *dryRun {
+		fmt.Printf("%d 个评估的标准化总分将因得分变化被撤销\n", revoked)
+	} else if revoked > 0 {
+		fmt.Printf("%d 个评估的标准化总分因得分变化已撤销，请重新应用所在考核周期的标准化\n", revoked)
+	}
+	return nil
+}
+
+// 清理导出文件
+func runCleanupExports(args []string) error {
+	fs := newFlagSet("cleanup-exports")
+	olderThan := fs.Duration("older-than", time.Hour, "删除超过该时长的导出文件")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	removed, err := handlers.RemoveExpiredExports(*olderThan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已删除 %d 个导出文件\n", removed)
+	return nil
+}
+
+// 检查数据完整性，发现未修复的问题时返回错误
+func runIntegrityCheck(args []string) error {
+	fs := newFlagSet("integrity-check")
+	fix := fs.Bool("fix", false, "修复可以自动修复的问题（删除孤立记录或清空无效关联）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	models.InitDB()
+	issues, err := handlers.CheckIntegrity(*fix)
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		fmt.Println("未发现数据完整性问题")
+		return nil
+	}
+
+	unresolved := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "问题\t数据表\t数量\t状态\t记录ID")
+	for _, issue := range issues {
+		state := "可修复"
+		switch {
+		case issue.Repaired:
+			state = "已修复"
+		case !issue.Fixable:
+			state = "需人工处理"
+		}
+		if !issue.Repaired {
+			unresolved++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", issue.Name, issue.Table, len(issue.IDs), state, formatIDs(issue.IDs))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if unresolved > 0 {
+		return fmt.Errorf("发现 %d 类未修复的数据完整性问题", unresolved)
+	}
+	return nil
+}
+
+// 创建子命令的参数解析器
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// 密码为空时生成随机密码，返回是否为生成的密码
+func passwordOrRandom(password *string) (bool, error) {
+	if *password != "" {
+		return false, nil
+	}
+	buf := make([]byte, 12)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordAlphabet))))
+		if err != nil {
+			return false, err
+		}
+		buf[i] = passwordAlphabet[n.Int64()]
+	}
+	*password = string(buf)
+	return true, nil
+}
+
+// 输出自动生成的密码，只显示这一次
+func printGeneratedPassword(generated bool, password string) {
+	if generated {
+		fmt.Printf("生成的密码：%s（仅显示这一次，请妥善保存）\n", password)
+	}
+}
+
+// 格式化记录ID列表，过多时只显示前几个
+func formatIDs(ids []uint) string {
+	const limit = 10
+	parts := make([]string, 0, limit)
+	for i, id := range ids {
+		if i == limit {
+			parts = append(parts, "...")
+			break
+		}
+		parts = append(parts, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// 命令行子命令
+type command struct {
+	Name  string
+	Usage string
+	Desc  string
+	Run   func(args []string) error
+}
+
+var commands = []command{
+	{Name: "migrate", Usage: "migrate status|up|down|to <版本>", Desc: "管理数据库结构版本", Run: runMigrate},
+	{Name: "create-admin", Usage: "create-admin --email <邮箱> [--name] [--password] [--department]", Desc: "创建HR管理员账号", Run: runCreateAdmin},
+	{Name: "reset-password", Usage: "reset-password --email <邮箱> [--password]", Desc: "重置员工密码，未指定时自动生成", Run: runResetPassword},
+	{Name: "change-role", Usage: "change-role --email <邮箱> --role <employee|manager|hr>", Desc: "修改员工角色", Run: runChangeRole},
+	{Name: "deactivate-user", Usage: "deactivate-user --email <邮箱>", Desc: "停用员工账号", Run: runDeactivateUser},
+	{Name: "seed", Usage: "seed", Desc: "创建测试数据，已有数据时跳过", Run: runSeed},
+	{Name: "recompute-scores", Usage: "recompute-scores [--evaluation <ID,...>] [--dry-run]", Desc: "重新计算已完成评估的得分和等级", Run: runRecomputeScores},
+	{Name: "cleanup-exports", Usage: "cleanup-exports [--older-than 1h]", Desc: "删除过期的导出文件", Run: runCleanupExports},
+	{Name: "integrity-check", Usage: "integrity-check [--fix]", Desc: "检查孤立记录等数据完整性问题", Run: runIntegrityCheck},
+}
+
+// 执行命令行子命令，返回进程退出码
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" {
+		PrintUsage()
+		return 0
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name != args[0] {
+			continue
+		}
+		if err := cmd.Run(args[1:]); err != nil {
+			// 子命令的 -h 参数已输出用法
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			fmt.Fprintln(os.Stderr, "错误:", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", args[0])
+	PrintUsage()
+	return 2
+}
+
+// 输出命令用法
+func PrintUsage() {
+	fmt.Fprintln(os.Stderr, "用法: server [--seed | --no-seed] [命令]")
+	fmt.Fprintln(os.Stderr, "不带命令时启动HTTP服务。开发环境启动时默认创建测试数据，--no-seed 表示不创建；生产环境默认不创建，--seed 表示创建。可用命令：")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n      %s\n", cmd.Usage, cmd.Desc)
+	}
+}
+
+// 用法错误
+func usageError(usage string) error {
+	return fmt.Errorf("参数错误，用法: server %s", usage)
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"dootask-kpi-server/config"
+	"dootask-kpi-server/models"
+)
+
+const migrateUsage = "migrate status|up|down|to <版本>"
+
+// 管理数据库结构版本
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return usageError(migrateUsage)
+	}
+
+	models.OpenDB()
+
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return usageError(migrateUsage)
+		}
+		return printMigrateStatus()
+	case "up":
+		if len(args) != 1 {
+			return usageError(migrateUsage)
+		}
+		return migrateTo(models.LatestSchemaVersion())
+	case "down":
+		if len(args) != 1 {
+			return usageError(migrateUsage)
+		}
+		current, err := models.CurrentSchemaVersion(models.DB)
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			return fmt.Errorf("没有可回滚的迁移")
+		}
+		return migrateTo(current - 1)
+	case "to":
+		if len(args) != 2 {
+			return usageError(migrateUsage)
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("版本号格式错误: %s", args[1])
+		}
+		return migrateTo(target)
+	default:
+		return usageError(migrateUsage)
+	}
+}
+
+// 迁移到指定版本，SQLite数据库迁移前先备份
+func migrateTo(target int) error {
+	if err := models.ValidateSchemaVersion(target); err != nil {
+		return err
+	}
+	current, err := models.CurrentSchemaVersion(models.DB)
+	if err != nil {
+		return err
+	}
+	if target == current {
+		fmt.Printf("数据库结构已是版本 %d，无需迁移\n", current)
+		return nil
+	}
+
+	backup, err := models.BackupSQLite(models.DB, config.Current.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	if backup != "" {
+		fmt.Printf("已备份数据库到 %s\n", backup)
+	}
+
+	if err := models.MigrateTo(models.DB, target); err != nil {
+		return err
+	}
+	fmt.Printf("数据库结构已从版本 %d 迁移到版本 %d\n", current, target)
+	return nil
+}
+
+// 输出所有迁移的执行状态
+func printMigrateStatus() error {
+	statuses, err := models.SchemaStatus(models.DB)
+	if err != nil {
+		return err
+	}
+	current, err := models.CurrentSchemaVersion(models.DB)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "版本\t名称\t状态\t执行时间")
+	for _, status := range statuses {
+		state, appliedAt := "未执行", ""
+		if status.Applied {
+			state, appliedAt = "已执行", status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("当前版本 %d，最新版本 %d\n", current, models.LatestSchemaVersion())
+	return nil
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 运行环境
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// 默认JWT密钥，仅用于开发环境，生产环境必须修改
+const DefaultJWTSecret = "your-secret-key-change-in-production"
+
+// 生产环境JWT密钥的最小长度
+const minProductionSecretLength = 32
+
+// 默认配置文件，存在时自动加载
+const DefaultConfigFile = "config.yaml"
+
+// 支持的日志级别，对应SQL日志的输出级别
+var logLevels = []string{"silent", "error", "warn", "info"}
+
+// Config 服务配置
+// 加载顺序：默认值 → 配置文件 → 环境变量，后者覆盖前者
+type Config struct {
+	Env             string        `yaml:"env"`               // 运行环境：development, production
+	ListenAddr      string        `yaml:"listen_addr"`       // 监听地址，如 :8080
+	DatabaseDSN     string        `yaml:"database_dsn"`      // 数据库连接串：SQLite数据文件路径、postgres://... 或 mysql://...
+	LogLevel        string        `yaml:"log_level"`         // SQL日志级别：silent, error, warn, info
+	AllowedOrigins  []string      `yaml:"allowed_origins"`   // 允许跨域访问的来源，* 表示全部
+	JWTSecret       string        `yaml:"jwt_secret"`        // JWT签名密钥
+	TokenTTL        time.Duration `yaml:"token_ttl"`         // 访问token有效期，如 15m，过期后使用刷新token续期
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"` // 刷新token有效期，超过该时长未使用需要重新登录
+	ExportDir       string        `yaml:"export_dir"`        // 导出文件目录
+}
+
+// Current 当前生效的配置，启动时由 Load 设置
+var Current = Default()
+
+// 默认配置，与未引入配置前的行为保持一致
+func Default() *Config {
+	return &Config{
+		Env:             EnvDevelopment,
+		ListenAddr:      ":8080",
+		DatabaseDSN:     "db/kpi.db",
+		LogLevel:        "info",
+		AllowedOrigins:  []string{"*"},
+		JWTSecret:       DefaultJWTSecret,
+		TokenTTL:        15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		ExportDir:       "./public/exports",
+	}
+}
+
+// 加载配置并校验，成功后设置为当前配置
+// 配置文件路径由 KPI_CONFIG 指定，未指定时尝试加载当前目录的 config.yaml
+func Load() (*Config, error) {
+	cfg := Default()
+
+	path := os.Getenv("KPI_CONFIG")
+	if path == "" {
+		if _, err := os.Stat(DefaultConfigFile); err == nil {
+			path = DefaultConfigFile
+		}
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	Current = cfg
+	return cfg, nil
+}
+
+// 从YAML配置文件加载，未知的配置项视为错误，避免拼写错误被忽略
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+// 从环境变量加载
+func (c *Config) loadEnv() error {
+	strs := map[string]*string{
+		"KPI_ENV":          &c.Env,
+		"KPI_LISTEN_ADDR":  &c.ListenAddr,
+		"KPI_DATABASE_DSN": &c.DatabaseDSN,
+		"KPI_LOG_LEVEL":    &c.LogLevel,
+		"KPI_JWT_SECRET":   &c.JWTSecret,
+		"KPI_EXPORT_DIR":   &c.ExportDir,
+	}
+	for name, target := range strs {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = strings.TrimSpace(value)
+		}
+	}
+
+	// 多个来源以逗号分隔
+	if value, ok := os.LookupEnv("KPI_ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.AllowedOrigins = append(c.AllowedOrigins, origin)
+			}
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"KPI_TOKEN_TTL":         &c.TokenTTL,
+		"KPI_REFRESH_TOKEN_TTL": &c.RefreshTokenTTL,
+	}
+	for name, target := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			ttl, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 格式错误: %w", name, err)
+			}
+			*target = ttl
+		}
+	}
+	return nil
+}
+
+// 校验配置，返回第一个不合法的配置项
+func (c *Config) Validate() error {
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		return fmt.Errorf("env 必须为 %s 或 %s", EnvDevelopment, EnvProduction)
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("listen_addr 格式错误: %w", err)
+	}
+	if c.DatabaseDSN == "" {
+		return errors.New("database_dsn 不能为空")
+	}
+	if !slices.Contains(logLevels, c.LogLevel) {
+		return fmt.Errorf("log_level 必须为 %s 之一", strings.Join(logLevels, ", "))
+	}
+	if len(c.AllowedOrigins) == 0 {
+		return errors.New("allowed_origins 不能为空，允许全部来源请使用 *")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("allowed_origins 中的 %s 必须以 http:// 或 https:// 开头", origin)
+		}
+	}
+	if c.JWTSecret == "" {
+		return errors.New("jwt_secret 不能为空")
+	}
+	if c.TokenTTL <= 0 {
+		return errors.New("token_ttl 必须大于0")
+	}
+	if c.RefreshTokenTTL <= c.TokenTTL {
+		return errors.New("refresh_token_ttl 必须大于 token_ttl")
+	}
+	if c.ExportDir == "" {
+		return errors.New("export_dir 不能为空")
+	}
+
+	// 生产环境禁止使用默认密钥或过短的密钥
+	if c.IsProduction() {
+		if c.JWTSecret == DefaultJWTSecret {
+			return errors.New("生产环境不能使用默认的 jwt_secret，请通过 KPI_JWT_SECRET 或配置文件设置")
+		}
+		if len(c.JWTSecret) < minProductionSecretLength {
+			return fmt.Errorf("生产环境的 jwt_secret 长度不能少于 %d 个字符", minProductionSecretLength)
+		}
+	}
+	return nil
+}
+
+// 是否为生产环境
+func (c *Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+// 是否允许全部来源跨域访问
+func (c *Config) AllowAllOrigins() bool {
+	return slices.Contains(c.AllowedOrigins, "*")
+}
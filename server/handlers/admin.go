@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dootask-kpi-server/models"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 命令行管理任务使用的操作，不经过HTTP接口，审计日志记录为系统操作
+
+// 员工角色
+var employeeRoles = []string{"employee", "manager", "hr"}
+
+// 密码最小长度，与注册接口保持一致
+const minPasswordLength = 6
+
+// 创建HR管理员账号，部门不存在时自动创建
+func CreateAdmin(name, email, password, departmentName string) (*models.Employee, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil, errors.New("邮箱不能为空")
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("密码长度不能少于 %d 位", minPasswordLength)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("密码加密失败: %w", err)
+	}
+
+	var admin models.Employee
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Employee{}).Where("email = ?", email).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("邮箱已存在，可以使用 reset-password 或 change-role 修改已有账号")
+		}
+
+		var department models.Department
+		if err := tx.Where("name = ?", departmentName).First(&department).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			department = models.Department{Name: departmentName}
+			if err := tx.Create(&department).Error; err != nil {
+				return err
+			}
+			if err := auditCreate(tx, nil, AuditEntityDepartment, department.ID, department); err != nil {
+				return err
+			}
+		}
+
+		admin = models.Employee{
+			Name:         name,
+			Email:        email,
+			Password:     string(hashedPassword),
+			Position:     "管理员",
+			DepartmentID: department.ID,
+			Role:         "hr",
+			IsActive:     true,
+		}
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, nil, AuditEntityEmployee, admin.ID, admin)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// 重置员工密码
+func ResetPassword(email, password string) (*models.Employee, error) {
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("密码长度不能少于 %d 位", minPasswordLength)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("密码加密失败: %w", err)
+	}
+
+	employee, err := findEmployeeByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(employee).Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		// 密码不记录到审计日志，只记录发生了修改
+		if err := auditField(tx, nil, AuditEntityEmployee, employee.ID, "password", "", "已重置"); err != nil {
+			return err
+		}
+		return revokeEmployeeSessions(tx, employee.ID, SessionRevokePasswordReset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return employee, nil
+}
+
+// 修改员工角色
+func ChangeRole(email, role string) (*models.Employee, error) {
+	if !slices.Contains(employeeRoles, role) {
+		return nil, fmt.Errorf("角色必须为 %s 之一", strings.Join(employeeRoles, ", "))
+	}
+	employee, err := findEmployeeByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if employee.Role == role {
+		return employee, nil
+	}
+
+	previous := employee.Role
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(employee).Update("role", role).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, nil, AuditEntityEmployee, employee.ID, "role", previous, role); err != nil {
+			return err
+		}
+		return revokeEmployeeSessions(tx, employee.ID, SessionRevokeRoleChanged)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return employee, nil
+}
+
+// 停用员工账号，停用后不能登录
+func DeactivateUser(email string) (*models.Employee, error) {
+	employee, err := findEmployeeByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if !employee.IsActive {
+		return employee, nil
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(employee).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, nil, AuditEntityEmployee, employee.ID, "is_active", true, false); err != nil {
+			return err
+		}
+		return revokeEmployeeSessions(tx, employee.ID, SessionRevokeDeactivated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return employee, nil
+}
+
+// 根据邮箱查找员工
+func findEmployeeByEmail(email string) (*models.Employee, error) {
+	var employee models.Employee
+	if err := models.DB.Where("email = ?", strings.TrimSpace(email)).First(&employee).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("邮箱为 %s 的员工不存在", email)
+		}
+		return nil, err
+	}
+	return &employee, nil
+}
+
+// 数据完整性检查规则：记录的外键指向的记录不存在
+type integrityRule struct {
+	Name   string
+	Model  any    // 检查的记录
+	Column string // 外键字段
+	Parent any    // 外键指向的记录
+	Fix    string // 修复方式：delete 删除记录，clear 清空外键，为空表示不能自动修复
+}
+
+// 孤立记录的修复方式
+const (
+	integrityFixDelete = "delete"
+	integrityFixClear  = "clear"
+)
+
+var integrityRules = []integrityRule{
+	{Name: "评分对应的评估不存在", Model: &models.KPIScore{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "评分对应的考核项目不存在", Model: &models.KPIScore{}, Column: "item_id", Parent: &models.KPIItem{}, Fix: integrityFixDelete},
+	{Name: "得分明细对应的评估不存在", Model: &models.ScoreBreakdown{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "邀请对应的评估不存在", Model: &models.EvaluationInvitation{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "邀请评分对应的邀请不存在", Model: &models.InvitedScore{}, Column: "invitation_id", Parent: &models.EvaluationInvitation{}, Fix: integrityFixDelete},
+	{Name: "邀请评分对应的考核项目不存在", Model: &models.InvitedScore{}, Column: "item_id", Parent: &models.KPIItem{}, Fix: integrityFixDelete},
+	{Name: "评论对应的评估不存在", Model: &models.EvaluationComment{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "评审人对应的评估不存在", Model: &models.EvaluationReviewer{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "评审评分对应的评审人不存在", Model: &models.ReviewerScore{}, Column: "reviewer_record_id", Parent: &models.EvaluationReviewer{}, Fix: integrityFixDelete},
+	{Name: "申诉对应的评估不存在", Model: &models.EvaluationAppeal{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "申诉项目对应的申诉不存在", Model: &models.AppealItem{}, Column: "appeal_id", Parent: &models.EvaluationAppeal{}, Fix: integrityFixDelete},
+	{Name: "校准调整对应的评估不存在", Model: &models.CalibrationAdjustment{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixDelete},
+	{Name: "登录会话对应的员工不存在", Model: &models.UserSession{}, Column: "employee_id", Parent: &models.Employee{}, Fix: integrityFixDelete},
+	{Name: "关键结果对应的目标不存在", Model: &models.GoalKeyResult{}, Column: "goal_id", Parent: &models.EmployeeGoal{}, Fix: integrityFixDelete},
+	{Name: "目标关联的评估不存在", Model: &models.EmployeeGoal{}, Column: "evaluation_id", Parent: &models.KPIEvaluation{}, Fix: integrityFixClear},
+	{Name: "员工的直属上级不存在", Model: &models.Employee{}, Column: "manager_id", Parent: &models.Employee{}, Fix: integrityFixClear},
+	{Name: "员工所属部门不存在", Model: &models.Employee{}, Column: "department_id", Parent: &models.Department{}},
+	{Name: "评估对应的员工不存在", Model: &models.KPIEvaluation{}, Column: "employee_id", Parent: &models.Employee{}},
+	{Name: "评估对应的模板不存在", Model: &models.KPIEvaluation{}, Column: "template_id", Parent: &models.KPITemplate{}},
+	{Name: "考核项目对应的模板不存在", Model: &models.KPIItem{}, Column: "template_id", Parent: &models.KPITemplate{}},
+}
+
+// IntegrityIssue 数据完整性问题
+type IntegrityIssue struct {
+	Name     string
+	Table    string
+	IDs      []uint
+	Fixable  bool
+	Repaired bool
+}
+
+// 检查数据完整性，fix 为 true 时修复可以自动修复的问题
+func CheckIntegrity(fix bool) ([]IntegrityIssue, error) {
+	var issues []IntegrityIssue
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		for _, rule := range integrityRules {
+			table, err := tableName(tx, rule.Model)
+			if err != nil {
+				return err
+			}
+			parentTable, err := tableName(tx, rule.Parent)
+			if err != nil {
+				return err
+			}
+
+			// 外键为空或为0表示没有关联，不视为问题
+			var ids []uint
+			if err := tx.Table(table+" AS t").
+				Joins(fmt.Sprintf("LEFT JOIN %s AS p ON t.%s = p.id", parentTable, rule.Column)).
+				Where(fmt.Sprintf("t.%s IS NOT NULL AND t.%s <> 0 AND p.id IS NULL", rule.Column, rule.Column)).
+				Order("t.id").
+				Pluck("t.id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				continue
+			}
+
+			issue := IntegrityIssue{Name: rule.Name, Table: table, IDs: ids, Fixable: rule.Fix != ""}
+			if fix && issue.Fixable {
+				query := tx.Model(rule.Model).Where("id IN ?", ids)
+				if rule.Fix == integrityFixDelete {
+					err = query.Delete(rule.Model).Error
+				} else {
+					err = query.UpdateColumn(rule.Column, nil).Error
+				}
+				if err != nil {
+					return fmt.Errorf("修复「%s」失败: %w", rule.Name, err)
+				}
+				issue.Repaired = true
+			}
+			issues = append(issues, issue)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+// 模型对应的数据表名
+func tableName(db *gorm.DB, model any) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	return stmt.Schema.Table, nil
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 申诉状态
+const (
+	AppealStatusPending  = "pending"
+	AppealStatusResolved = "resolved"
+)
+
+// 申诉项目处理结果
+const (
+	AppealDecisionPending  = "pending"
+	AppealDecisionAdjusted = "adjusted" // 调整分数
+	AppealDecisionUpheld   = "upheld"   // 维持原分
+)
+
+// 发起申诉请求结构
+type CreateAppealRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	Items  []struct {
+		ScoreID uint   `json:"score_id" binding:"required"`
+		Reason  string `json:"reason"`
+	} `json:"items" binding:"required,min=1"`
+}
+
+// 处理申诉请求结构
+type ResolveAppealRequest struct {
+	Response string `json:"response" binding:"required"`
+	Items    []struct {
+		ID            uint     `json:"id" binding:"required"`
+		Decision      string   `json:"decision" binding:"required"`
+		AdjustedScore *float64 `json:"adjusted_score"`
+	} `json:"items" binding:"required,min=1"`
+}
+
+// 获取评估的申诉记录
+func GetEvaluationAppeals(c *gin.Context) {
+	id := c.Param("id")
+	evaluationId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评估ID",
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.First(&evaluation, evaluationId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
+
+	var appeals []models.EvaluationAppeal
+	result := models.DB.Preload("Employee").Preload("Resolver").Preload("Items.Item").
+		Where("evaluation_id = ?", evaluationId).
+		Order("created_at ASC").
+		Find(&appeals)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取申诉记录失败",
+			"message": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": appeals,
+	})
+}
+
+// 员工对待确认的评估发起申诉
+func CreateAppeal(c *gin.Context) {
+	id := c.Param("id")
+	evaluationId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评估ID",
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.Preload("Employee").Preload("Template").First(&evaluation, evaluationId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	var req CreateAppealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 申诉的原始得分与员工确认时最终得分的计算方式一致
+	currentScores, err := previewItemScores(models.DB, &evaluation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "计算当前得分失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	appeal := models.EvaluationAppeal{
+		EvaluationID: evaluation.ID,
+		EmployeeID:   userID,
+		Reason:       req.Reason,
+		Status:       AppealStatusPending,
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&appeal).Error; err != nil {
+			return err
+		}
+		if err := auditCreate(tx, c, AuditEntityAppeal, appeal.ID, appeal); err != nil {
+			return err
+		}
+
+		seen := make(map[uint]bool)
+		for _, reqItem := range req.Items {
+			if seen[reqItem.ScoreID] {
+				continue
+			}
+			seen[reqItem.ScoreID] = true
+
+			var score models.KPIScore
+			if err := tx.Where("id = ? AND evaluation_id = ?", reqItem.ScoreID, evaluation.ID).First(&score).Error; err != nil {
+				return &TransitionError{
+					StatusCode: http.StatusBadRequest,
+					Message:    fmt.Sprintf("评分记录 %d 不属于该评估", reqItem.ScoreID),
+				}
+			}
+
+			originalScore := currentScores[score.ID]
+			item := models.AppealItem{
+				AppealID:      appeal.ID,
+				ScoreID:       score.ID,
+				ItemID:        score.ItemID,
+				Reason:        reqItem.Reason,
+				OriginalScore: &originalScore,
+				Decision:      AppealDecisionPending,
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+
+		previousStatus := evaluation.Status
+		if err := TransitionEvaluation(tx, &evaluation, EvaluationStatusDisputed, userID, c.GetString("user_role")); err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "status", previousStatus, evaluation.Status)
+	})
+	if err != nil {
+		respondAppealError(c, err, "发起申诉失败")
+		return
+	}
+
+	// 通知处理人：直属上级，没有上级时通知HR
+	resolverIDs := GetNotificationService().GetAllHRUsers()
+	if evaluation.Employee.ManagerID != nil {
+		resolverIDs = []uint{*evaluation.Employee.ManagerID}
+	}
+	var employees []models.Employee
+	models.DB.Where("id IN ?", resolverIDs).Find(&employees)
+	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+	for _, employee := range employees {
+		dooTaskClient.SendBotMessage(employee.DooTaskUserID, fmt.Sprintf(
+			"### 📣 员工对考核结果提出申诉，请及时处理。\n\n- **申诉人：** %s\n- **考核模板：** %s\n- **考核周期：** %s\n- **申诉理由：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+			evaluation.Employee.Name,
+			evaluation.Template.Name,
+			utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter),
+			appeal.Reason,
+		))
+	}
+	GetNotificationService().SendNotification(userID, EventEvaluationStatusChange, &evaluation)
+
+	models.DB.Preload("Employee").Preload("Items.Item").First(&appeal, appeal.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "申诉提交成功",
+		"data":    appeal,
+	})
+}
+
+// 上级或HR处理申诉
+func ResolveAppeal(c *gin.Context) {
+	id := c.Param("id")
+	appealId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的申诉ID",
+		})
+		return
+	}
+
+	var appeal models.EvaluationAppeal
+	if err := models.DB.Preload("Items.Item").First(&appeal, appealId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "申诉不存在",
+		})
+		return
+	}
+
+	if appeal.Status != AppealStatusPending {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该申诉已处理",
+		})
+		return
+	}
+
+	var req ResolveAppealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.Preload("Employee").Preload("Template").First(&evaluation, appeal.EvaluationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	userRole := c.GetString("user_role")
+
+	decisions := make(map[uint]int)
+	for i, reqItem := range req.Items {
+		decisions[reqItem.ID] = i
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range appeal.Items {
+			i, ok := decisions[item.ID]
+			if !ok {
+				return &TransitionError{
+					StatusCode: http.StatusBadRequest,
+					Message:    fmt.Sprintf("请处理申诉项目「%s」", item.Item.Name),
+				}
+			}
+			reqItem := req.Items[i]
+
+			updates := map[string]interface{}{"decision": reqItem.Decision}
+			switch reqItem.Decision {
+			case AppealDecisionUpheld:
+				updates["adjusted_score"] = nil
+				// 维持原评分时撤销之前申诉调整的得分
+				if err := clearAdjustedScores(tx, c, "id = ?", item.ScoreID); err != nil {
+					return err
+				}
+			case AppealDecisionAdjusted:
+				if reqItem.AdjustedScore == nil {
+					return &TransitionError{
+						StatusCode: http.StatusBadRequest,
+						Message:    fmt.Sprintf("请填写「%s」的调整分数", item.Item.Name),
+					}
+				}
+				if err := checkScoreRange(&item.Item, *reqItem.AdjustedScore); err != nil {
+					return err
+				}
+				updates["adjusted_score"] = *reqItem.AdjustedScore
+				var score models.KPIScore
+				if err := tx.First(&score, item.ScoreID).Error; err != nil {
+					return err
+				}
+				// 调整后的得分在计算最终得分时直接使用，不受评分策略权重影响
+				// 按ID更新，保留 score 中的原值用于审计
+				if err := tx.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("adjusted_score", *reqItem.AdjustedScore).Error; err != nil {
+					return err
+				}
+				if err := auditField(tx, c, AuditEntityScore, score.ID, "adjusted_score", score.AdjustedScore, reqItem.AdjustedScore); err != nil {
+					return err
+				}
+			default:
+				return &TransitionError{
+					StatusCode: http.StatusBadRequest,
+					Message:    fmt.Sprintf("不支持的处理结果：%s", reqItem.Decision),
+				}
+			}
+
+			if err := tx.Model(&item).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if err := tx.Model(&appeal).Updates(map[string]interface{}{
+			"status":      AppealStatusResolved,
+			"response":    req.Response,
+			"resolver_id": userID,
+			"resolved_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityAppeal, appeal.ID, "status", AppealStatusPending, AppealStatusResolved); err != nil {
+			return err
+		}
+
+		previousStatus := evaluation.Status
+		if err := TransitionEvaluation(tx, &evaluation, EvaluationStatusPendingConfirm, userID, userRole); err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "status", previousStatus, evaluation.Status)
+	})
+	if err != nil {
+		respondAppealError(c, err, "处理申诉失败")
+		return
+	}
+
+	// 通知员工重新确认
+	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+	dooTaskClient.SendBotMessage(evaluation.Employee.DooTaskUserID, fmt.Sprintf(
+		"### ✅ 您的考核申诉已处理，请重新确认最终得分。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **处理人：** %s\n- **处理答复：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+		evaluation.Template.Name,
+		utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter),
+		c.GetString("user_name"),
+		req.Response,
+	))
+	GetNotificationService().SendNotification(userID, EventEvaluationStatusChange, &evaluation)
+
+	models.DB.Preload("Employee").Preload("Resolver").Preload("Items.Item").First(&appeal, appeal.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "申诉处理成功",
+		"data":    appeal,
+	})
+}
+
+// 清除评分中申诉调整的得分并记录审计日志，之后按各方评分重新计算最终得分
+// query 和 args 为限定评分范围的查询条件
+func clearAdjustedScores(tx *gorm.DB, c *gin.Context, query string, args ...interface{}) error {
+	var scores []models.KPIScore
+	if err := tx.Where(query, args...).Where("adjusted_score IS NOT NULL").Find(&scores).Error; err != nil {
+		return err
+	}
+	for _, score := range scores {
+		if err := tx.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("adjusted_score", nil).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityScore, score.ID, "adjusted_score", score.AdjustedScore, (*float64)(nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 前置条件：存在待处理的申诉
+func requireAppealPending(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	var count int64
+	if err := tx.Model(&models.EvaluationAppeal{}).
+		Where("evaluation_id = ? AND status = ?", evaluation.ID, AppealStatusPending).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "请通过申诉接口提交申诉",
+		}
+	}
+	return nil
+}
+
+// 前置条件：所有申诉都已处理
+func requireAppealsResolved(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	var count int64
+	if err := tx.Model(&models.EvaluationAppeal{}).
+		Where("evaluation_id = ? AND status = ?", evaluation.ID, AppealStatusPending).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "还有未处理的申诉",
+		}
+	}
+	return nil
+}
+
+// 返回申诉相关错误
+func respondAppealError(c *gin.Context, err error, message string) {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		c.JSON(transitionErr.StatusCode, gin.H{
+			"error": transitionErr.Message,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   message,
+		"message": err.Error(),
+	})
+}
+
+// 获取申诉状态文本
+func getAppealStatusText(status string) string {
+	switch status {
+	case AppealStatusPending:
+		return "待处理"
+	case AppealStatusResolved:
+		return "已处理"
+	default:
+		return "未知状态"
+	}
+}
+
+// 获取申诉处理结果文本
+func getAppealDecisionText(decision string) string {
+	switch decision {
+	case AppealDecisionPending:
+		return "待处理"
+	case AppealDecisionAdjusted:
+		return "调整分数"
+	case AppealDecisionUpheld:
+		return "维持原分"
+	default:
+		return "未知"
+	}
+}
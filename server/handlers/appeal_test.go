@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 创建以指定用户登录、携带JSON请求体的测试请求上下文
+func newTestContext(t *testing.T, user models.Employee, id uint, body any) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("序列化请求失败: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(id), 10)}}
+	c.Set("user_id", user.ID)
+	c.Set("user_role", user.Role)
+	c.Set("user_name", user.Name)
+	return c, recorder
+}
+
+// 查询评分字段最近一条审计记录，没有记录时返回空
+func findScoreAudit(t *testing.T, db *gorm.DB, scoreID uint, field string) *models.AuditLog {
+	t.Helper()
+
+	var logs []models.AuditLog
+	if err := db.Where("entity_type = ? AND entity_id = ? AND field = ?", AuditEntityScore, scoreID, field).
+		Order("id DESC").Limit(1).Find(&logs).Error; err != nil {
+		t.Fatalf("查询审计日志失败: %v", err)
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	return &logs[0]
+}
+
+func TestResolveAppeal(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name         string
+		prior        *float64 // 之前申诉调整的得分
+		decision     string
+		adjusted     *float64
+		actor        string // 处理申诉的用户：employee, manager, hr
+		wantCode     int
+		wantAdjusted *float64
+		wantTotal    float64
+		wantAudit    [2]string // adjusted_score 审计记录的原值和新值
+	}{
+		{name: "上级调整分数", decision: AppealDecisionAdjusted, adjusted: floatPtr(10), actor: "manager", wantCode: http.StatusOK, wantAdjusted: floatPtr(10), wantTotal: 26, wantAudit: [2]string{"", "10"}},
+		{name: "HR再次调整分数", prior: floatPtr(6), decision: AppealDecisionAdjusted, adjusted: floatPtr(9), actor: "hr", wantCode: http.StatusOK, wantAdjusted: floatPtr(9), wantTotal: 25, wantAudit: [2]string{"6", "9"}},
+		{name: "维持原分时撤销之前的调整", prior: floatPtr(6), decision: AppealDecisionUpheld, actor: "manager", wantCode: http.StatusOK, wantTotal: 24, wantAudit: [2]string{"6", ""}},
+		{name: "调整时必须填写分数", decision: AppealDecisionAdjusted, actor: "manager", wantCode: http.StatusBadRequest},
+		{name: "调整分数超过满分", decision: AppealDecisionAdjusted, adjusted: floatPtr(11), actor: "manager", wantCode: http.StatusBadRequest},
+		{name: "不支持的处理结果", decision: "rejected", actor: "manager", wantCode: http.StatusBadRequest},
+		{name: "员工不能处理自己的申诉", decision: AppealDecisionAdjusted, adjusted: floatPtr(10), actor: "employee", wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+			actors := map[string]models.Employee{"employee": f.Employee, "manager": f.Manager, "hr": f.HR}
+
+			// 上级评分为满分的80%，进入待确认时总分为24
+			evaluation := f.createEvaluation(t, db, f.Employee, EvaluationStatusManagerEvaluated)
+			fillScores(t, db, evaluation.ID, "manager_score", 0.8)
+			if err := TransitionEvaluation(db, &evaluation, EvaluationStatusPendingConfirm, f.HR.ID, f.HR.Role); err != nil {
+				t.Fatalf("HR审核失败: %v", err)
+			}
+			score := findScores(t, db, evaluation.ID)[0]
+			if tt.prior != nil {
+				if err := db.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("adjusted_score", *tt.prior).Error; err != nil {
+					t.Fatalf("设置调整分数失败: %v", err)
+				}
+			}
+			appeal := models.EvaluationAppeal{
+				EvaluationID: evaluation.ID,
+				EmployeeID:   f.Employee.ID,
+				Reason:       "不认可工作质量的评分",
+				Status:       AppealStatusPending,
+				Items:        []models.AppealItem{{ScoreID: score.ID, ItemID: score.ItemID, Reason: "已按时完成", OriginalScore: floatPtr(8)}},
+			}
+			if err := db.Create(&appeal).Error; err != nil {
+				t.Fatalf("创建申诉失败: %v", err)
+			}
+			if err := TransitionEvaluation(db, &evaluation, EvaluationStatusDisputed, f.Employee.ID, f.Employee.Role); err != nil {
+				t.Fatalf("提交申诉失败: %v", err)
+			}
+
+			c, recorder := newTestContext(t, actors[tt.actor], appeal.ID, gin.H{
+				"response": "已复核",
+				"items":    []gin.H{{"id": appeal.Items[0].ID, "decision": tt.decision, "adjusted_score": tt.adjusted}},
+			})
+			ResolveAppeal(c)
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("返回 %d（%s），期望 %d", recorder.Code, recorder.Body.String(), tt.wantCode)
+			}
+
+			var saved models.KPIEvaluation
+			if err := db.First(&saved, evaluation.ID).Error; err != nil {
+				t.Fatalf("查询评估失败: %v", err)
+			}
+			if err := db.First(&appeal, appeal.ID).Error; err != nil {
+				t.Fatalf("查询申诉失败: %v", err)
+			}
+			if tt.wantCode != http.StatusOK {
+				if saved.Status != EvaluationStatusDisputed || appeal.Status != AppealStatusPending {
+					t.Errorf("处理失败后评估状态为 %s、申诉状态为 %s，期望保持不变", saved.Status, appeal.Status)
+				}
+				return
+			}
+
+			if saved.Status != EvaluationStatusPendingConfirm || appeal.Status != AppealStatusResolved {
+				t.Errorf("处理后评估状态为 %s、申诉状态为 %s，期望 %s、%s", saved.Status, appeal.Status, EvaluationStatusPendingConfirm, AppealStatusResolved)
+			}
+			if saved.TotalScore != tt.wantTotal {
+				t.Errorf("处理后总分为 %g，期望 %g", saved.TotalScore, tt.wantTotal)
+			}
+			if err := db.First(&score, score.ID).Error; err != nil {
+				t.Fatalf("查询评分失败: %v", err)
+			}
+			if (score.AdjustedScore == nil) != (tt.wantAdjusted == nil) || (score.AdjustedScore != nil && *score.AdjustedScore != *tt.wantAdjusted) {
+				t.Errorf("调整分数为 %v，期望 %v", score.AdjustedScore, tt.wantAdjusted)
+			}
+			audit := findScoreAudit(t, db, score.ID, "adjusted_score")
+			if audit == nil || audit.OldValue != tt.wantAudit[0] || audit.NewValue != tt.wantAudit[1] || audit.ActorID != actors[tt.actor].ID {
+				t.Errorf("调整分数的审计记录为 %+v，期望 %q -> %q", audit, tt.wantAudit[0], tt.wantAudit[1])
+			}
+		})
+	}
+}
+
+// 退回或重新开放评估时撤销申诉调整的得分
+func TestReturnClearsAdjustedScores(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		handler    gin.HandlerFunc
+		wantStatus string
+	}{
+		{name: "HR退回上级评分", status: EvaluationStatusManagerEvaluated, handler: RejectEvaluation, wantStatus: EvaluationStatusSelfEvaluated},
+		{name: "HR重新开放已完成的评估", status: EvaluationStatusCompleted, handler: ReopenEvaluation, wantStatus: EvaluationStatusManagerEvaluated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+			evaluation := f.createEvaluation(t, db, f.Employee, tt.status)
+			fillScores(t, db, evaluation.ID, "manager_score", 0.8)
+			fillScores(t, db, evaluation.ID, "adjusted_score", 0.5)
+
+			c, recorder := newTestContext(t, f.HR, evaluation.ID, gin.H{"reason": "评分需要复核"})
+			tt.handler(c)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("返回 %d（%s），期望 200", recorder.Code, recorder.Body.String())
+			}
+
+			if err := db.First(&evaluation, evaluation.ID).Error; err != nil {
+				t.Fatalf("查询评估失败: %v", err)
+			}
+			if evaluation.Status != tt.wantStatus {
+				t.Errorf("评估状态为 %s，期望 %s", evaluation.Status, tt.wantStatus)
+			}
+			for _, score := range findScores(t, db, evaluation.ID) {
+				if score.AdjustedScore != nil {
+					t.Errorf("「%s」的调整分数为 %g，期望已撤销", score.Item.Name, *score.AdjustedScore)
+				}
+				audit := findScoreAudit(t, db, score.ID, "adjusted_score")
+				if audit == nil || audit.NewValue != "" || audit.ActorID != f.HR.ID {
+					t.Errorf("「%s」调整分数的审计记录为 %+v，期望由HR撤销", score.Item.Name, audit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 职位匹配方式
+const (
+	PositionMatchExact   = "exact"   // 完全一致
+	PositionMatchPattern = "pattern" // 通配符：* 匹配任意字符，? 匹配单个字符
+)
+
+// 模板来源
+const (
+	TemplateSourceCycleRule      = "cycle_rule"      // 考核周期的模板分配规则
+	TemplateSourceAssignmentRule = "assignment_rule" // 全局模板分配规则
+	TemplateSourceCycleDefault   = "cycle_default"   // 考核周期的默认模板
+	TemplateSourceNone           = "none"            // 没有匹配的模板
+)
+
+// 模板分配规则请求结构
+type TemplateAssignmentRuleRequest struct {
+	Name          string `json:"name"`
+	Period        string `json:"period" binding:"required"`
+	DepartmentID  *uint  `json:"department_id"`
+	Position      string `json:"position"`
+	PositionMatch string `json:"position_match"`
+	Role          string `json:"role"`
+	TemplateID    uint   `json:"template_id" binding:"required"`
+	Priority      int    `json:"priority"`
+	IsActive      *bool  `json:"is_active"`
+}
+
+// 参与模板匹配的规则
+type RuleCandidate struct {
+	Source     string `json:"source"` // cycle_rule, assignment_rule
+	RuleID     uint   `json:"rule_id"`
+	Name       string `json:"name,omitempty"`
+	Priority   int    `json:"priority"`
+	TemplateID uint   `json:"template_id"`
+	Matched    bool   `json:"matched"`
+	Selected   bool   `json:"selected"` // 是否为最终采用的规则
+	Reason     string `json:"reason"`   // 匹配或不匹配的原因
+}
+
+// 员工模板的确定结果
+type TemplateResolution struct {
+	TemplateID *uint               `json:"template_id"`
+	Template   *models.KPITemplate `json:"template,omitempty"`
+	Source     string              `json:"source"`
+	RuleID     *uint               `json:"rule_id,omitempty"`
+	Reason     string              `json:"reason"`
+	Candidates []RuleCandidate     `json:"candidates"`
+}
+
+// 获取模板分配规则列表
+func GetTemplateAssignmentRules(c *gin.Context) {
+	query := models.DB.Preload("Department").Preload("Template")
+	if period := c.Query("period"); period != "" {
+		query = query.Where("period = ?", period)
+	}
+
+	var rules []models.TemplateAssignmentRule
+	if err := query.Order("period, priority, id").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取模板分配规则失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": rules,
+	})
+}
+
+// 创建模板分配规则
+func CreateTemplateAssignmentRule(c *gin.Context) {
+	var req TemplateAssignmentRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	rule := models.TemplateAssignmentRule{IsActive: true}
+	if err := applyTemplateAssignmentRule(&rule, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		// is_active 默认值为 true，创建时会回填为 true，停用的规则需要单独更新
+		isActive := rule.IsActive
+		if err := tx.Omit("Department", "Template").Create(&rule).Error; err != nil {
+			return err
+		}
+		if !isActive {
+			if err := tx.Model(&rule).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		return auditCreate(tx, c, AuditEntityTemplateRule, rule.ID, rule)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建模板分配规则失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("Department").Preload("Template").First(&rule, rule.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "模板分配规则创建成功",
+		"data":    rule,
+	})
+}
+
+// 更新模板分配规则
+func UpdateTemplateAssignmentRule(c *gin.Context) {
+	rule, ok := loadTemplateAssignmentRule(c)
+	if !ok {
+		return
+	}
+
+	var req TemplateAssignmentRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	before := *rule
+	if err := applyTemplateAssignmentRule(rule, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Department", "Template").Save(rule).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityTemplateRule, rule.ID, before, *rule)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新模板分配规则失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("Department").Preload("Template").First(rule, rule.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "模板分配规则更新成功",
+		"data":    rule,
+	})
+}
+
+// 删除模板分配规则
+func DeleteTemplateAssignmentRule(c *gin.Context) {
+	rule, ok := loadTemplateAssignmentRule(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(rule).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityTemplateRule, rule.ID, *rule)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除模板分配规则失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "模板分配规则删除成功",
+	})
+}
+
+// 查看员工会分配到的模板及原因
+// 指定 cycle_id 时按考核周期发起评估的方式确定（周期规则 > 全局规则 > 周期默认模板），否则按 period 匹配全局规则
+func ResolveEmployeeTemplate(c *gin.Context) {
+	employeeID, err := strconv.ParseUint(c.Query("employee_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的员工ID",
+		})
+		return
+	}
+	if !requireEmployeeAccess(c, uint(employeeID)) {
+		return
+	}
+
+	var employee models.Employee
+	if err := models.DB.Preload("Department").First(&employee, employeeID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "员工不存在",
+		})
+		return
+	}
+
+	var cycle *models.ReviewCycle
+	period := c.Query("period")
+	if cycleID := c.Query("cycle_id"); cycleID != "" {
+		cycle = &models.ReviewCycle{}
+		if err := models.DB.Preload("TemplateRules").First(cycle, cycleID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "考核周期不存在",
+			})
+			return
+		}
+		period = cycle.Period
+	}
+	if err := validatePeriod(period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	rules, err := loadAssignmentRules(models.DB, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取模板分配规则失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	resolution := resolveEmployeeTemplate(cycle, rules, &employee)
+	if resolution.TemplateID != nil {
+		var template models.KPITemplate
+		if err := models.DB.First(&template, *resolution.TemplateID).Error; err == nil {
+			resolution.Template = &template
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":     resolution,
+		"employee": employee,
+		"period":   period,
+	})
+}
+
+// 确定员工使用的模板：考核周期的规则优先，其次为全局模板分配规则，最后为考核周期的默认模板
+// cycle 为空时只匹配全局规则，rules 为该周期类型的启用规则（按优先级排序）
+func resolveEmployeeTemplate(cycle *models.ReviewCycle, rules []models.TemplateAssignmentRule, employee *models.Employee) *TemplateResolution {
+	resolution := &TemplateResolution{
+		Source:     TemplateSourceNone,
+		Candidates: []RuleCandidate{},
+	}
+	selected := -1
+	selectCandidate := func(candidate RuleCandidate) {
+		resolution.Candidates = append(resolution.Candidates, candidate)
+		if candidate.Matched && selected < 0 {
+			selected = len(resolution.Candidates) - 1
+		}
+	}
+
+	if cycle != nil {
+		cycleRules := append([]models.ReviewCycleTemplateRule(nil), cycle.TemplateRules...)
+		sort.SliceStable(cycleRules, func(i, j int) bool {
+			return cycleRules[i].Priority < cycleRules[j].Priority
+		})
+		for _, rule := range cycleRules {
+			matched, reason := matchTemplateRule(rule.DepartmentID, rule.Position, PositionMatchExact, rule.Role, employee)
+			selectCandidate(RuleCandidate{
+				Source:     TemplateSourceCycleRule,
+				RuleID:     rule.ID,
+				Priority:   rule.Priority,
+				TemplateID: rule.TemplateID,
+				Matched:    matched,
+				Reason:     reason,
+			})
+		}
+	}
+
+	for _, rule := range rules {
+		matched, reason := matchTemplateRule(rule.DepartmentID, rule.Position, rule.PositionMatch, rule.Role, employee)
+		selectCandidate(RuleCandidate{
+			Source:     TemplateSourceAssignmentRule,
+			RuleID:     rule.ID,
+			Name:       rule.Name,
+			Priority:   rule.Priority,
+			TemplateID: rule.TemplateID,
+			Matched:    matched,
+			Reason:     reason,
+		})
+	}
+
+	if selected >= 0 {
+		candidate := &resolution.Candidates[selected]
+		candidate.Selected = true
+		templateID, ruleID := candidate.TemplateID, candidate.RuleID
+		resolution.TemplateID = &templateID
+		resolution.RuleID = &ruleID
+		resolution.Source = candidate.Source
+		label := "全局模板分配规则"
+		if candidate.Source == TemplateSourceCycleRule {
+			label = "考核周期的模板分配规则"
+		}
+		resolution.Reason = fmt.Sprintf("匹配%s #%d（优先级 %d）：%s", label, ruleID, candidate.Priority, candidate.Reason)
+		return resolution
+	}
+
+	if cycle != nil && cycle.DefaultTemplateID != nil {
+		templateID := *cycle.DefaultTemplateID
+		resolution.TemplateID = &templateID
+		resolution.Source = TemplateSourceCycleDefault
+		resolution.Reason = "没有匹配的分配规则，使用考核周期的默认模板"
+		return resolution
+	}
+
+	resolution.Reason = "没有匹配的分配规则"
+	return resolution
+}
+
+// 判断规则条件是否与员工匹配，返回匹配说明或第一个不匹配的条件
+func matchTemplateRule(departmentID *uint, position, positionMatch, role string, employee *models.Employee) (bool, string) {
+	var conditions []string
+	if departmentID != nil {
+		if *departmentID != employee.DepartmentID {
+			return false, fmt.Sprintf("部门不匹配（规则部门ID %d，员工部门ID %d）", *departmentID, employee.DepartmentID)
+		}
+		conditions = append(conditions, fmt.Sprintf("部门ID %d", *departmentID))
+	}
+	if position != "" {
+		if positionMatch == PositionMatchPattern {
+			// 规则保存时已校验通配符格式
+			if ok, _ := path.Match(position, employee.Position); !ok {
+				return false, fmt.Sprintf("职位「%s」不符合「%s」", employee.Position, position)
+			}
+			conditions = append(conditions, fmt.Sprintf("职位符合「%s」", position))
+		} else {
+			if position != employee.Position {
+				return false, fmt.Sprintf("职位「%s」不是「%s」", employee.Position, position)
+			}
+			conditions = append(conditions, fmt.Sprintf("职位为「%s」", position))
+		}
+	}
+	if role != "" {
+		if role != employee.Role {
+			return false, fmt.Sprintf("角色「%s」不是「%s」", employee.Role, role)
+		}
+		conditions = append(conditions, fmt.Sprintf("角色为「%s」", role))
+	}
+
+	if len(conditions) == 0 {
+		return true, "规则匹配所有员工"
+	}
+	return true, strings.Join(conditions, "，")
+}
+
+// 获取考核周期类型的启用规则，按优先级排序
+func loadAssignmentRules(tx *gorm.DB, period string) ([]models.TemplateAssignmentRule, error) {
+	var rules []models.TemplateAssignmentRule
+	err := tx.Where("period = ? AND is_active = ?", period, true).Order("priority, id").Find(&rules).Error
+	return rules, err
+}
+
+// 根据请求设置规则并校验
+func applyTemplateAssignmentRule(rule *models.TemplateAssignmentRule, req *TemplateAssignmentRuleRequest) error {
+	if err := validatePeriod(req.Period); err != nil {
+		return err
+	}
+
+	switch req.PositionMatch {
+	case "":
+		req.PositionMatch = PositionMatchExact
+	case PositionMatchExact:
+	case PositionMatchPattern:
+		if _, err := path.Match(req.Position, ""); err != nil {
+			return fmt.Errorf("职位通配符「%s」格式不正确", req.Position)
+		}
+	default:
+		return fmt.Errorf("不支持的职位匹配方式：%s", req.PositionMatch)
+	}
+
+	if req.DepartmentID != nil {
+		var department models.Department
+		if err := models.DB.First(&department, *req.DepartmentID).Error; err != nil {
+			return errors.New("部门不存在")
+		}
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.First(&template, req.TemplateID).Error; err != nil {
+		return errors.New("考核模板不存在")
+	}
+	if template.Period != req.Period {
+		return fmt.Errorf("模板「%s」为%s考核，与规则的周期类型不一致", template.Name, utils.GetPeriodLabel(template.Period))
+	}
+
+	rule.Name = req.Name
+	rule.Period = req.Period
+	rule.DepartmentID = req.DepartmentID
+	rule.Position = req.Position
+	rule.PositionMatch = req.PositionMatch
+	rule.Role = req.Role
+	rule.TemplateID = req.TemplateID
+	rule.Priority = req.Priority
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+// 校验考核周期类型
+func validatePeriod(period string) error {
+	switch period {
+	case "monthly", "quarterly", "yearly":
+		return nil
+	default:
+		return fmt.Errorf("不支持的考核周期类型：%s", period)
+	}
+}
+
+// 根据路由参数加载模板分配规则，不存在时返回错误响应
+func loadTemplateAssignmentRule(c *gin.Context) (*models.TemplateAssignmentRule, bool) {
+	id := c.Param("id")
+	ruleId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的规则ID",
+		})
+		return nil, false
+	}
+
+	var rule models.TemplateAssignmentRule
+	if err := models.DB.First(&rule, ruleId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板分配规则不存在",
+		})
+		return nil, false
+	}
+
+	return &rule, true
+}
@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"dootask-kpi-server/config"
+	"dootask-kpi-server/global"
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// 审计操作类型
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// 审计对象类型
+const (
+	AuditEntityDepartment    = "department"
+	AuditEntityEmployee      = "employee"
+	AuditEntityTemplate      = "template"
+	AuditEntityItem          = "item"
+	AuditEntityScoringPolicy = "scoring_policy"
+	AuditEntityTemplateRule  = "template_rule"
+	AuditEntityRatingScale   = "rating_scale"
+	AuditEntityGradeQuota    = "grade_quota"
+	AuditEntityCalibration   = "calibration"
+	AuditEntityNormalization = "normalization"
+	AuditEntityCycle         = "cycle"
+	AuditEntitySchedule      = "schedule"
+	AuditEntityEvaluation    = "evaluation"
+	AuditEntityScore         = "score"
+	AuditEntityComment       = "comment"
+	AuditEntityInvitation    = "invitation"
+	AuditEntityInvitedScore  = "invited_score"
+	AuditEntityAppeal        = "appeal"
+	AuditEntityGoal          = "goal"
+	AuditEntityReviewerChain = "reviewer_chain"
+	AuditEntityReviewer      = "reviewer"
+	AuditEntityReviewerScore = "reviewer_score"
+	AuditEntitySettings      = "settings"
+)
+
+// 不记录到审计日志的字段
+var auditIgnoredFields = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// 记录创建操作，新值为创建后的完整记录
+func auditCreate(db *gorm.DB, c *gin.Context, entityType string, entityID uint, value any) error {
+	return writeAuditLogs(db, c, []models.AuditLog{{
+		Action:     AuditActionCreate,
+		EntityType: entityType,
+		EntityID:   entityID,
+		NewValue:   marshalAuditSnapshot(value),
+	}})
+}
+
+// 记录删除操作，旧值为删除前的完整记录
+func auditDelete(db *gorm.DB, c *gin.Context, entityType string, entityID uint, value any) error {
+	return writeAuditLogs(db, c, []models.AuditLog{{
+		Action:     AuditActionDelete,
+		EntityType: entityType,
+		EntityID:   entityID,
+		OldValue:   marshalAuditSnapshot(value),
+	}})
+}
+
+// 比较修改前后的记录，每个变更的字段记录一条日志
+func auditUpdate(db *gorm.DB, c *gin.Context, entityType string, entityID uint, before, after any) error {
+	oldSnapshot := auditSnapshot(before)
+	newSnapshot := auditSnapshot(after)
+
+	var logs []models.AuditLog
+	for _, field := range auditSnapshotFields(after) {
+		if oldSnapshot[field] == newSnapshot[field] {
+			continue
+		}
+		logs = append(logs, models.AuditLog{
+			Action:     AuditActionUpdate,
+			EntityType: entityType,
+			EntityID:   entityID,
+			Field:      field,
+			OldValue:   oldSnapshot[field],
+			NewValue:   newSnapshot[field],
+		})
+	}
+	return writeAuditLogs(db, c, logs)
+}
+
+// 记录单个字段的变更，值相同时不记录
+func auditField(db *gorm.DB, c *gin.Context, entityType string, entityID uint, field string, oldValue, newValue any) error {
+	oldText := formatAuditValue(reflect.ValueOf(oldValue))
+	newText := formatAuditValue(reflect.ValueOf(newValue))
+	if oldText == newText {
+		return nil
+	}
+	return writeAuditLogs(db, c, []models.AuditLog{{
+		Action:     AuditActionUpdate,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Field:      field,
+		OldValue:   oldText,
+		NewValue:   newText,
+	}})
+}
+
+// 写入审计日志，c 为空表示系统任务
+func writeAuditLogs(db *gorm.DB, c *gin.Context, logs []models.AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	actorID, actorName, ip := uint(0), "系统", ""
+	if c != nil {
+		actorID = c.GetUint("user_id")
+		actorName = c.GetString("user_name")
+		ip = c.ClientIP()
+		// 注册、DooTask登录等公开接口没有登录用户
+		if actorID == 0 {
+			actorName = "未登录用户"
+		}
+	}
+	for i := range logs {
+		logs[i].ActorID = actorID
+		logs[i].ActorName = actorName
+		logs[i].IP = ip
+	}
+
+	if err := db.Create(&logs).Error; err != nil {
+		fmt.Printf("写入审计日志失败: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// 获取记录中需要审计的字段（按结构体字段顺序），关联对象和忽略字段除外
+func auditSnapshotFields(value any) []string {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || auditIgnoredFields[name] || !isAuditField(field) {
+			continue
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// 生成记录的字段快照（字段名 -> 文本值）
+func auditSnapshot(value any) map[string]string {
+	snapshot := make(map[string]string)
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if v.Kind() != reflect.Struct {
+		return snapshot
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || auditIgnoredFields[name] || !isAuditField(field) {
+			continue
+		}
+		snapshot[name] = formatAuditValue(v.Field(i))
+	}
+	return snapshot
+}
+
+// 将字段快照序列化为JSON文本
+func marshalAuditSnapshot(value any) string {
+	data, err := json.Marshal(auditSnapshot(value))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// 是否为需要审计的字段：基础类型字段，以及以JSON序列化存储的字段
+func isAuditField(field reflect.StructField) bool {
+	return isAuditScalarType(field.Type) || strings.Contains(field.Tag.Get("gorm"), "serializer:json")
+}
+
+// 是否为需要审计的字段类型：基础类型、时间及其指针，基础类型切片
+func isAuditScalarType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return t == reflect.TypeOf(time.Time{})
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Struct && t.Elem().Kind() != reflect.Ptr
+	case reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return false
+	}
+	return true
+}
+
+// 格式化字段值，空指针为空字符串
+func formatAuditValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format("2006-01-02 15:04:05")
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	}
+
+	if v.Kind() == reflect.Slice {
+		// 结构体切片（JSON序列化存储的字段）按JSON格式记录
+		if elem := v.Type().Elem(); elem.Kind() == reflect.Struct && elem != reflect.TypeOf(time.Time{}) {
+			if v.Len() == 0 {
+				return ""
+			}
+			data, _ := json.Marshal(v.Interface())
+			return string(data)
+		}
+		items := make([]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items[i] = formatAuditValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// 根据请求参数构建审计日志查询
+func buildAuditLogQuery(c *gin.Context) (*gorm.DB, error) {
+	query := models.DB.Model(&models.AuditLog{})
+
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if entityType := c.Query("entity_type"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		query = query.Where("entity_id = ?", entityID)
+	}
+	if field := c.Query("field"); field != "" {
+		query = query.Where("field = ?", field)
+	}
+	if search := c.Query("search"); search != "" {
+		searchPattern := "%" + search + "%"
+		query = query.Where("actor_name LIKE ? OR old_value LIKE ? OR new_value LIKE ?",
+			searchPattern, searchPattern, searchPattern)
+	}
+
+	// 时间范围筛选，格式 2006-01-02，结束日期包含当天
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("开始日期格式错误")
+		}
+		query = query.Where("created_at >= ?", start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("结束日期格式错误")
+		}
+		query = query.Where("created_at < ?", end.AddDate(0, 0, 1))
+	}
+
+	return query, nil
+}
+
+// 获取审计日志
+func GetAuditLogs(c *gin.Context) {
+	var logs []models.AuditLog
+
+	// 解析分页参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	// 验证分页参数
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query, err := buildAuditLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 获取总数
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取审计日志总数失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 分页查询，按时间倒序
+	offset := (page - 1) * pageSize
+	if err := query.Order("created_at DESC, id DESC").Offset(offset).Limit(pageSize).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取审计日志失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 计算分页信息
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       logs,
+		"total":      total,
+		"page":       page,
+		"pageSize":   pageSize,
+		"totalPages": totalPages,
+		"hasNext":    page < totalPages,
+		"hasPrev":    page > 1,
+	})
+}
+
+// 导出审计日志
+func ExportAuditLogs(c *gin.Context) {
+	query, err := buildAuditLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("created_at ASC, id ASC").Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取审计日志失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 创建Excel文件
+	f := excelize.NewFile()
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	sheetName := "审计日志"
+	f.SetSheetName("Sheet1", sheetName)
+
+	// 设置表头
+	headers := []string{"时间", "操作人ID", "操作人", "IP", "操作", "对象类型", "对象ID", "字段", "原值", "新值"}
+	for i, header := range headers {
+		cell := string(rune('A'+i)) + "1"
+		f.SetCellValue(sheetName, cell, header)
+	}
+
+	headerStyle, _ := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{"#E0E0E0"}, Pattern: 1},
+	})
+	f.SetCellStyle(sheetName, "A1", "J1", headerStyle)
+
+	// 填充数据
+	for i, entry := range logs {
+		row := i + 2
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), entry.CreatedAt.Format("2006-01-02 15:04:05"))
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), entry.ActorID)
+		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), entry.ActorName)
+		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), entry.IP)
+		f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), getAuditActionText(entry.Action))
+		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), entry.EntityType)
+		f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), entry.EntityID)
+		f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), entry.Field)
+		f.SetCellValue(sheetName, fmt.Sprintf("I%d", row), entry.OldValue)
+		f.SetCellValue(sheetName, fmt.Sprintf("J%d", row), entry.NewValue)
+	}
+
+	// 设置列宽
+	f.SetColWidth(sheetName, "A", "A", 20)
+	f.SetColWidth(sheetName, "B", "H", 12)
+	f.SetColWidth(sheetName, "I", "J", 40)
+
+	// 创建公共导出目录
+	if err := os.MkdirAll(config.Current.ExportDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "创建导出目录失败",
+		})
+		return
+	}
+
+	// 生成文件名
+	fileName := fmt.Sprintf("审计日志-%d.xlsx", time.Now().Unix())
+	filePath := filepath.Join(config.Current.ExportDir, fileName)
+
+	// 保存文件
+	if err := f.SaveAs(filePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存文件失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 获取文件大小
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "获取文件信息失败",
+		})
+		return
+	}
+
+	// 缓存到内存
+	randomKey := "export_" + uuid.New().String()
+	global.Cache.Set(randomKey, fileName, time.Minute*5)
+
+	// 返回下载URL
+	downloadURL := utils.GetFileURL(c.GetString("base_url"), fmt.Sprintf("/api/download/exports/%s", randomKey))
+
+	c.JSON(http.StatusOK, ExportResponse{
+		FileURL:  downloadURL,
+		FileName: fileName,
+		FileSize: fileInfo.Size(),
+		Message:  "导出成功",
+	})
+
+	// 30分钟后删除文件
+	go func() {
+		time.Sleep(time.Minute * 30)
+		os.Remove(filePath)
+	}()
+}
+
+// 获取审计操作文本
+func getAuditActionText(action string) string {
+	switch action {
+	case AuditActionCreate:
+		return "创建"
+	case AuditActionUpdate:
+		return "修改"
+	case AuditActionDelete:
+		return "删除"
+	default:
+		return action
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"slices"
 	"time"
@@ -8,19 +9,25 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
+	"dootask-kpi-server/config"
 	"dootask-kpi-server/models"
 	"dootask-kpi-server/utils"
 )
 
-// JWT密钥 - 生产环境中应该使用环境变量
-var jwtSecret = []byte("your-secret-key-change-in-production")
+// JWT密钥，通过配置的 jwt_secret 设置
+func jwtSecret() []byte {
+	return []byte(config.Current.JWTSecret)
+}
 
 // JWT Claims结构
+// 权限以数据库中的角色为准，Role 仅供客户端展示
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID uint   `json:"sid"` // 登录会话ID，会话失效后token立即失效
 	jwt.RegisteredClaims
 }
 
@@ -47,17 +54,20 @@ type RegisterRequest struct {
 
 // 登录响应结构
 type LoginResponse struct {
-	Token string           `json:"token"`
-	User  *models.Employee `json:"user"`
+	Token        string           `json:"token"`         // 访问token
+	RefreshToken string           `json:"refresh_token"` // 刷新token，访问token过期后用于续期
+	ExpiresIn    int64            `json:"expires_in"`    // 访问token有效期（秒）
+	User         *models.Employee `json:"user"`
 }
 
 // 生成JWT token
-func generateToken(user *models.Employee) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // 24小时过期
+func generateToken(user *models.Employee, sessionID uint) (string, error) {
+	expirationTime := time.Now().Add(config.Current.TokenTTL) // 有效期由配置的 token_ttl 决定
 	claims := &Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -66,14 +76,14 @@ func generateToken(user *models.Employee) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.SignedString(jwtSecret())
 }
 
 // 验证JWT token
 func verifyToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
+		return jwtSecret(), nil
 	})
 
 	if err != nil {
@@ -87,6 +97,31 @@ func verifyToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// 验证访问token，并检查会话和用户状态，返回的错误信息可直接提示用户
+func authenticate(tokenString string) (*Claims, *models.Employee, error) {
+	claims, err := verifyToken(tokenString)
+	if err != nil {
+		return nil, nil, errors.New("无效的token")
+	}
+
+	// 会话已退出、被下线或过期时token立即失效
+	var session models.UserSession
+	if err := models.DB.First(&session, claims.SessionID).Error; err != nil || session.EmployeeID != claims.UserID || !sessionActive(&session) {
+		return nil, nil, errors.New("登录已失效，请重新登录")
+	}
+
+	// 检查用户是否仍然存在且激活
+	var user models.Employee
+	if err := models.DB.First(&user, claims.UserID).Error; err != nil {
+		return nil, nil, errors.New("用户不存在")
+	}
+	if !user.IsActive {
+		return nil, nil, errors.New("账户已被禁用")
+	}
+
+	return claims, &user, nil
+}
+
 // Register 用户注册
 func Register(c *gin.Context) {
 	var req RegisterRequest
@@ -97,7 +132,7 @@ func Register(c *gin.Context) {
 
 	// 检查是否允许注册
 	var allowRegistrationSetting models.SystemSetting
-	if err := models.DB.Where("key = ?", "allow_registration").First(&allowRegistrationSetting).Error; err == nil {
+	if err := models.DB.Scopes(settingKey("allow_registration")).First(&allowRegistrationSetting).Error; err == nil {
 		if allowRegistrationSetting.Value != "true" {
 			c.JSON(http.StatusForbidden, gin.H{"error": "系统当前未开放注册"})
 			return
@@ -144,7 +179,13 @@ func Register(c *gin.Context) {
 		IsActive:     true,
 	}
 
-	if err := models.DB.Create(&user).Error; err != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityEmployee, user.ID, user)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户创建失败"})
 		return
 	}
@@ -155,17 +196,14 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token, err := generateToken(&user)
+	// 创建登录会话并生成token
+	response, err := newLoginResponse(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, LoginResponse{
-		Token: token,
-		User:  &user,
-	})
+	c.JSON(http.StatusCreated, response)
 }
 
 // Login 用户登录
@@ -195,17 +233,14 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token, err := generateToken(&user)
+	// 创建登录会话并生成token
+	response, err := newLoginResponse(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  &user,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 // LoginByDooTaskToken 用户登录（DooTaskToken）
@@ -248,16 +283,23 @@ func LoginByDooTaskToken(c *gin.Context) {
 		}
 
 		// 检测部门
-		if departments, err := dooTaskClient.Client.GetUserDepartments(); err == nil {
+		departments, err := dooTaskClient.Client.GetUserDepartments()
+		if err != nil {
+			departments = nil
+		}
+
+		err = models.DB.Transaction(func(tx *gorm.DB) error {
 			if len(departments) > 0 {
 				var existingDepartment models.Department
-				if err := models.DB.Where("name = ?", departments[0].Name).First(&existingDepartment).Error; err != nil {
+				if err := tx.Where("name = ?", departments[0].Name).First(&existingDepartment).Error; err != nil {
 					existingDepartment = models.Department{
 						Name: departments[0].Name,
 					}
-					if err := models.DB.Create(&existingDepartment).Error; err != nil {
-						c.JSON(http.StatusInternalServerError, gin.H{"error": "部门创建失败"})
-						return
+					if err := tx.Create(&existingDepartment).Error; err != nil {
+						return err
+					}
+					if err := auditCreate(tx, c, AuditEntityDepartment, existingDepartment.ID, existingDepartment); err != nil {
+						return err
 					}
 				}
 
@@ -269,19 +311,33 @@ func LoginByDooTaskToken(c *gin.Context) {
 					user.Role = "manager"
 				}
 			}
-		}
 
-		// 创建用户
-		if err := models.DB.Create(&user).Error; err != nil {
+			// 创建用户
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			return auditCreate(tx, c, AuditEntityEmployee, user.ID, user)
+		})
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "用户创建失败"})
 			return
 		}
 	} else {
 		// 更新用户信息
+		before := user
 		user.Name = dooTaskUser.Nickname
 		user.DooTaskUserID = &dooTaskUser.UserID
 		user.Position = dooTaskUser.Profession
-		models.DB.Save(&user)
+		err = models.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+			return auditUpdate(tx, c, AuditEntityEmployee, user.ID, before, user)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "用户信息更新失败"})
+			return
+		}
 	}
 
 	// 检查用户是否激活
@@ -290,17 +346,14 @@ func LoginByDooTaskToken(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token, err := generateToken(&user)
+	// 创建登录会话并生成token
+	response, err := newLoginResponse(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  &user,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 // GetCurrentUser 获取当前用户信息
@@ -321,49 +374,6 @@ func GetCurrentUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": user})
 }
 
-// RefreshToken 刷新token
-func RefreshToken(c *gin.Context) {
-	tokenString := c.GetHeader("Authorization")
-	if tokenString == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证token"})
-		return
-	}
-
-	// 移除Bearer前缀
-	if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
-		tokenString = tokenString[7:]
-	}
-
-	// 验证token（即使过期也要能解析）
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
-	if err != nil && !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
-		return
-	}
-
-	// 检查用户是否仍然存在
-	var user models.Employee
-	if err := models.DB.First(&user, claims.UserID).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
-		return
-	}
-
-	// 生成新的token
-	newToken, err := generateToken(&user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"token": newToken,
-	})
-}
-
 // AuthMiddleware JWT认证中间件
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -386,33 +396,20 @@ func AuthMiddleware() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
-		// 验证token
-		claims, err := verifyToken(tokenString)
+		// 验证token、会话和用户状态
+		claims, user, err := authenticate(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
-			c.Abort()
-			return
-		}
-
-		// 检查用户是否仍然存在且激活
-		var user models.Employee
-		if err := models.DB.First(&user, claims.UserID).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
-			c.Abort()
-			return
-		}
-
-		if !user.IsActive {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "账户已被禁用"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		// 将用户信息存储在context中
-		c.Set("user_id", claims.UserID)
-		c.Set("user_email", claims.Email)
-		c.Set("user_role", claims.Role)
+		// 将用户信息存储在context中，角色以数据库为准，变更后立即生效
+		c.Set("user_id", user.ID)
+		c.Set("user_email", user.Email)
+		c.Set("user_role", user.Role)
 		c.Set("user_name", user.Name)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	}
 }
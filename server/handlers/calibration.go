@@ -0,0 +1,966 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 校准会议状态
+const (
+	CalibrationStatusOpen   = "open"   // 进行中，可以调整评估结果
+	CalibrationStatusLocked = "locked" // 已锁定，评估结果不能再调整
+)
+
+// 配额使用状态
+const (
+	QuotaStatusOK    = "ok"    // 符合配额
+	QuotaStatusOver  = "over"  // 超过上限
+	QuotaStatusUnder = "under" // 低于下限
+)
+
+// 校准会议请求结构
+type CalibrationSessionRequest struct {
+	Name         string `json:"name" binding:"required"`
+	CycleID      uint   `json:"cycle_id" binding:"required"`
+	DepartmentID *uint  `json:"department_id"`
+	Description  string `json:"description"`
+}
+
+// 校准调整项
+type CalibrationAdjustItem struct {
+	EvaluationID uint     `json:"evaluation_id" binding:"required"`
+	TotalScore   *float64 `json:"total_score"` // 调整后的总分，只填写总分时按评级量表重新确定等级
+	Grade        *string  `json:"grade"`       // 调整后的等级
+}
+
+// 校准调整请求结构
+type CalibrationAdjustRequest struct {
+	Justification string                  `json:"justification" binding:"required"`
+	Items         []CalibrationAdjustItem `json:"items" binding:"required,min=1"`
+}
+
+// 锁定校准会议请求结构
+type CalibrationLockRequest struct {
+	Force bool `json:"force"` // 存在不符合配额的部门时仍然锁定
+}
+
+// 校准会议中的评估
+type CalibrationEntry struct {
+	EvaluationID   uint     `json:"evaluation_id"`
+	EmployeeID     uint     `json:"employee_id"`
+	EmployeeName   string   `json:"employee_name"`
+	DepartmentID   uint     `json:"department_id"`
+	DepartmentName string   `json:"department_name"`
+	TemplateName   string   `json:"template_name"`
+	Status         string   `json:"status"`
+	TotalScore     float64  `json:"total_score"`
+	Grade          string   `json:"grade"`
+	OriginalScore  *float64 `json:"original_score,omitempty"` // 校准前的总分，未调整过时为空
+	OriginalGrade  *string  `json:"original_grade,omitempty"` // 校准前的等级，未调整过时为空
+	Locked         bool     `json:"locked"`                   // 是否已被校准会议锁定
+}
+
+// 等级人数
+type GradeCount struct {
+	Grade   string  `json:"grade"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+// 等级配额的使用情况
+type QuotaUsage struct {
+	QuotaID    uint     `json:"quota_id"`
+	Grades     []string `json:"grades"`
+	MaxPercent *float64 `json:"max_percent,omitempty"`
+	MinPercent *float64 `json:"min_percent,omitempty"`
+	MaxCount   *int     `json:"max_count,omitempty"` // 按上限允许的最多人数
+	MinCount   *int     `json:"min_count,omitempty"` // 按下限要求的最少人数
+	Count      int      `json:"count"`
+	Percent    float64  `json:"percent"`
+	Status     string   `json:"status"` // ok, over, under
+}
+
+// 部门的等级分布和配额情况
+type CalibrationDepartment struct {
+	DepartmentID   uint         `json:"department_id"`
+	DepartmentName string       `json:"department_name"`
+	Total          int          `json:"total"` // 已完成的评估数
+	Distribution   []GradeCount `json:"distribution"`
+	Quotas         []QuotaUsage `json:"quotas"`
+}
+
+// 获取等级配额列表
+func GetGradeQuotas(c *gin.Context) {
+	var quotas []models.GradeQuota
+	query := models.DB.Preload("Department").Order("department_id, id")
+	if departmentID := c.Query("department_id"); departmentID != "" {
+		query = query.Where("department_id = ?", departmentID)
+	}
+	if err := query.Find(&quotas).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取等级配额列表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": quotas,
+	})
+}
+
+// 创建等级配额
+func CreateGradeQuota(c *gin.Context) {
+	var quota models.GradeQuota
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	quota.ID = 0
+	quota.Department = nil
+	if err := validateGradeQuota(models.DB, &quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&quota).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityGradeQuota, quota.ID, quota)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建等级配额失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "等级配额创建成功",
+		"data":    quota,
+	})
+}
+
+// 更新等级配额
+func UpdateGradeQuota(c *gin.Context) {
+	quota, ok := loadGradeQuota(c)
+	if !ok {
+		return
+	}
+
+	var updateData models.GradeQuota
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := validateGradeQuota(models.DB, &updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	before := *quota
+	quota.DepartmentID = updateData.DepartmentID
+	quota.Grades = updateData.Grades
+	quota.MaxPercent = updateData.MaxPercent
+	quota.MinPercent = updateData.MinPercent
+	quota.Description = updateData.Description
+	quota.Department = nil
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(quota).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityGradeQuota, quota.ID, &before, quota)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新等级配额失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "等级配额更新成功",
+		"data":    quota,
+	})
+}
+
+// 删除等级配额
+func DeleteGradeQuota(c *gin.Context) {
+	quota, ok := loadGradeQuota(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(quota).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityGradeQuota, quota.ID, quota)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除等级配额失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "等级配额删除成功",
+	})
+}
+
+// 获取校准会议列表
+func GetCalibrationSessions(c *gin.Context) {
+	var sessions []models.CalibrationSession
+	query := models.DB.Preload("Cycle").Preload("Department").Preload("Creator")
+	if cycleID := c.Query("cycle_id"); cycleID != "" {
+		query = query.Where("cycle_id = ?", cycleID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Order("created_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取校准会议列表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": sessions,
+	})
+}
+
+// 创建校准会议
+func CreateCalibrationSession(c *gin.Context) {
+	var req CalibrationSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var cycle models.ReviewCycle
+	if err := models.DB.First(&cycle, req.CycleID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "考核周期不存在",
+		})
+		return
+	}
+	if req.DepartmentID != nil {
+		var department models.Department
+		if err := models.DB.First(&department, *req.DepartmentID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "部门不存在",
+			})
+			return
+		}
+	}
+
+	// 同一范围内只能有一个进行中的校准会议，整个周期的会议与各部门的会议互相冲突
+	overlap := models.DB.Model(&models.CalibrationSession{}).Where("cycle_id = ? AND status = ?", req.CycleID, CalibrationStatusOpen)
+	if req.DepartmentID != nil {
+		overlap = overlap.Where("department_id IS NULL OR department_id = ?", *req.DepartmentID)
+	}
+	var overlapCount int64
+	overlap.Count(&overlapCount)
+	if overlapCount > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该范围内已有进行中的校准会议",
+		})
+		return
+	}
+
+	session := models.CalibrationSession{
+		Name:         req.Name,
+		CycleID:      req.CycleID,
+		DepartmentID: req.DepartmentID,
+		Description:  req.Description,
+		Status:       CalibrationStatusOpen,
+		CreatedBy:    c.GetUint("user_id"),
+	}
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityCalibration, session.ID, session)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建校准会议失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("Cycle").Preload("Department").Preload("Creator").First(&session, session.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "校准会议创建成功",
+		"data":    session,
+	})
+}
+
+// 获取校准会议，包括范围内的评估、各部门的等级分布及配额情况
+func GetCalibrationSession(c *gin.Context) {
+	session, ok := loadCalibrationSession(c)
+	if !ok {
+		return
+	}
+
+	entries, departments, err := buildCalibrationBoard(models.DB, session, getDataScope(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取校准数据失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":        session,
+		"evaluations": entries,
+		"departments": departments,
+	})
+}
+
+// 删除校准会议（只能删除没有调整记录的进行中会议）
+func DeleteCalibrationSession(c *gin.Context) {
+	session, ok := loadCalibrationSession(c)
+	if !ok {
+		return
+	}
+
+	if session.Status != CalibrationStatusOpen {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "已锁定的校准会议不能删除",
+		})
+		return
+	}
+	var adjustmentCount int64
+	models.DB.Model(&models.CalibrationAdjustment{}).Where("session_id = ?", session.ID).Count(&adjustmentCount)
+	if adjustmentCount > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该校准会议已有调整记录，无法删除",
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(session).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityCalibration, session.ID, session)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除校准会议失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "校准会议删除成功",
+	})
+}
+
+// 批量调整评估的总分或等级
+func AdjustCalibration(c *gin.Context) {
+	session, ok := loadCalibrationSession(c)
+	if !ok {
+		return
+	}
+
+	if session.Status != CalibrationStatusOpen {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "校准会议已锁定，不能再调整评估结果",
+		})
+		return
+	}
+
+	var req CalibrationAdjustRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+	req.Justification = strings.TrimSpace(req.Justification)
+	if req.Justification == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请填写调整理由",
+		})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	scope := getDataScope(c)
+	var adjustments []models.CalibrationAdjustment
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range req.Items {
+			adjustment, err := adjustCalibrationEvaluation(tx, c, session, scope, &item, req.Justification, userID)
+			if err != nil {
+				return err
+			}
+			if adjustment != nil {
+				adjustments = append(adjustments, *adjustment)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondCalibrationError(c, err, "调整评估结果失败")
+		return
+	}
+
+	// 发送实时通知
+	for _, adjustment := range adjustments {
+		var evaluation models.KPIEvaluation
+		if err := models.DB.First(&evaluation, adjustment.EvaluationID).Error; err == nil {
+			GetNotificationService().SendNotification(userID, EventEvaluationUpdated, &evaluation)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("已调整 %d 个评估", len(adjustments)),
+		"data":    adjustments,
+	})
+}
+
+// 获取校准会议的调整记录，用于报告校准前后的变化
+func GetCalibrationAdjustments(c *gin.Context) {
+	session, ok := loadCalibrationSession(c)
+	if !ok {
+		return
+	}
+
+	scope := getDataScope(c)
+	query := models.DB.Preload("Evaluation.Employee.Department").Preload("Adjuster").
+		Where("session_id = ?", session.ID)
+	if !scope.All {
+		query = query.Where("evaluation_id IN (?)", models.DB.Model(&models.KPIEvaluation{}).Select("id").Where("employee_id IN ?", scope.EmployeeIDs))
+	}
+
+	var adjustments []models.CalibrationAdjustment
+	if err := query.Order("id").Find(&adjustments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取调整记录失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": adjustments,
+	})
+}
+
+// 锁定校准会议，范围内已完成的评估结果随之锁定
+func LockCalibrationSession(c *gin.Context) {
+	session, ok := loadCalibrationSession(c)
+	if !ok {
+		return
+	}
+
+	if session.Status != CalibrationStatusOpen {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "校准会议已锁定",
+		})
+		return
+	}
+
+	var req CalibrationLockRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "请求参数错误",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	// 不符合配额时需要确认后才能锁定
+	_, departments, err := buildCalibrationBoard(models.DB, session, &DataScope{All: true})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取校准数据失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	var violations []string
+	for _, department := range departments {
+		for _, quota := range department.Quotas {
+			if quota.Status != QuotaStatusOK {
+				violations = append(violations, fmt.Sprintf("%s：%s 占比 %g%%", department.DepartmentName, strings.Join(quota.Grades, "/"), quota.Percent))
+			}
+		}
+	}
+	if len(violations) > 0 && !req.Force {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "部分部门的等级分布不符合配额，确认后请强制锁定",
+			"violations": violations,
+		})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	now := time.Now()
+	var locked int64
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(session).Updates(map[string]interface{}{
+			"status":    CalibrationStatusLocked,
+			"locked_by": userID,
+			"locked_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityCalibration, session.ID, "status", CalibrationStatusOpen, CalibrationStatusLocked); err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.KPIEvaluation{}).Scopes(calibrationScope(session)).
+			Where("status = ? AND calibration_id IS NULL", EvaluationStatusCompleted).
+			Update("calibration_id", session.ID)
+		locked = result.RowsAffected
+		return result.Error
+	})
+	session.Status = CalibrationStatusLocked
+	session.LockedBy = &userID
+	session.LockedAt = &now
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "锁定校准会议失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("校准会议已锁定，共锁定 %d 个评估结果", locked),
+		"data":    session,
+	})
+}
+
+// 校准单个评估，结果没有变化时不记录
+func adjustCalibrationEvaluation(tx *gorm.DB, c *gin.Context, session *models.CalibrationSession, scope *DataScope, item *CalibrationAdjustItem, justification string, userID uint) (*models.CalibrationAdjustment, error) {
+	var evaluation models.KPIEvaluation
+	if err := tx.Scopes(calibrationScope(session)).Where("kpi_evaluations.id = ?", item.EvaluationID).First(&evaluation).Error; err != nil {
+		return nil, &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估 %d 不在该校准会议范围内", item.EvaluationID),
+		}
+	}
+	if !scope.CanViewEmployee(evaluation.EmployeeID) {
+		return nil, &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    fmt.Sprintf("无权限校准评估 %d", evaluation.ID),
+		}
+	}
+	if evaluation.EmployeeID == userID {
+		return nil, &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    "不能校准本人的评估",
+		}
+	}
+	if evaluation.Status != EvaluationStatusCompleted {
+		return nil, &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估 %d 尚未完成，只能校准已完成的评估", evaluation.ID),
+		}
+	}
+	if evaluation.CalibrationID != nil {
+		return nil, &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估 %d 的结果已被校准会议锁定", evaluation.ID),
+		}
+	}
+	if item.TotalScore == nil && item.Grade == nil {
+		return nil, &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("请填写评估 %d 调整后的总分或等级", evaluation.ID),
+		}
+	}
+
+	newScore := evaluation.TotalScore
+	if item.TotalScore != nil {
+		if *item.TotalScore < 0 {
+			return nil, &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "总分不能为负数",
+			}
+		}
+		newScore = roundScore(*item.TotalScore)
+	}
+
+	scale, err := getEvaluationRatingScale(tx, &evaluation)
+	if err != nil {
+		return nil, err
+	}
+	newGrade := evaluation.Grade
+	if item.Grade != nil {
+		if scale == nil || !slices.ContainsFunc(scale.Grades, func(grade models.RatingGrade) bool { return grade.Label == *item.Grade }) {
+			return nil, &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("等级「%s」不在评估使用的评级量表中", *item.Grade),
+			}
+		}
+		newGrade = *item.Grade
+	} else if scale != nil {
+		newGrade = ""
+		if matched := models.MatchRatingGrade(scale.Grades, newScore); matched != nil {
+			newGrade = matched.Label
+		}
+	}
+
+	if newScore == evaluation.TotalScore && newGrade == evaluation.Grade {
+		return nil, nil
+	}
+
+	adjustment := models.CalibrationAdjustment{
+		SessionID:     session.ID,
+		EvaluationID:  evaluation.ID,
+		PreviousScore: evaluation.TotalScore,
+		PreviousGrade: evaluation.Grade,
+		NewScore:      newScore,
+		NewGrade:      newGrade,
+		Justification: justification,
+		AdjustedBy:    userID,
+	}
+
+	updates := map[string]interface{}{
+		"total_score": newScore,
+		"grade":       newGrade,
+	}
+	if scale != nil {
+		updates["rating_scale_id"] = scale.ID
+	}
+	if err := tx.Model(&evaluation).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&adjustment).Error; err != nil {
+		return nil, err
+	}
+
+	if err := auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "total_score", adjustment.PreviousScore, newScore); err != nil {
+		return nil, err
+	}
+	if err := auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "grade", adjustment.PreviousGrade, newGrade); err != nil {
+		return nil, err
+	}
+	return &adjustment, nil
+}
+
+// 生成校准看板：范围内当前用户可查看的评估，以及按部门统计的等级分布和配额情况
+func buildCalibrationBoard(tx *gorm.DB, session *models.CalibrationSession, scope *DataScope) ([]CalibrationEntry, []CalibrationDepartment, error) {
+	query := tx.Scopes(calibrationScope(session)).Preload("Employee.Department").Preload("Template")
+	if !scope.All {
+		query = query.Where("kpi_evaluations.employee_id IN ?", scope.EmployeeIDs)
+	}
+	var evaluations []models.KPIEvaluation
+	if err := query.Order("total_score DESC").Find(&evaluations).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// 校准前的结果取每个评估最早一次调整前的值
+	evaluationIDs := make([]uint, len(evaluations))
+	for i, evaluation := range evaluations {
+		evaluationIDs[i] = evaluation.ID
+	}
+	var adjustments []models.CalibrationAdjustment
+	if len(evaluationIDs) > 0 {
+		if err := tx.Where("evaluation_id IN ?", evaluationIDs).Order("id").Find(&adjustments).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	originals := make(map[uint]models.CalibrationAdjustment)
+	for _, adjustment := range adjustments {
+		if _, ok := originals[adjustment.EvaluationID]; !ok {
+			originals[adjustment.EvaluationID] = adjustment
+		}
+	}
+
+	var quotas []models.GradeQuota
+	if err := tx.Order("id").Find(&quotas).Error; err != nil {
+		return nil, nil, err
+	}
+	defaultGrades, err := getDefaultRatingGrades(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var gradeOrder []string
+	for _, grade := range defaultGrades {
+		gradeOrder = append(gradeOrder, grade.Label)
+	}
+
+	entries := make([]CalibrationEntry, 0, len(evaluations))
+	var departments []CalibrationDepartment
+	departmentIndex := make(map[uint]int)
+	gradeCounts := make(map[uint]map[string]int)
+	for _, evaluation := range evaluations {
+		entry := CalibrationEntry{
+			EvaluationID:   evaluation.ID,
+			EmployeeID:     evaluation.EmployeeID,
+			EmployeeName:   evaluation.Employee.Name,
+			DepartmentID:   evaluation.Employee.DepartmentID,
+			DepartmentName: evaluation.Employee.Department.Name,
+			TemplateName:   evaluation.Template.Name,
+			Status:         evaluation.Status,
+			TotalScore:     evaluation.TotalScore,
+			Grade:          evaluation.Grade,
+			Locked:         evaluation.CalibrationID != nil,
+		}
+		if original, ok := originals[evaluation.ID]; ok {
+			entry.OriginalScore = &original.PreviousScore
+			entry.OriginalGrade = &original.PreviousGrade
+		}
+		entries = append(entries, entry)
+
+		// 等级分布只统计已完成的评估
+		if evaluation.Status != EvaluationStatusCompleted {
+			continue
+		}
+		i, ok := departmentIndex[entry.DepartmentID]
+		if !ok {
+			i = len(departments)
+			departmentIndex[entry.DepartmentID] = i
+			departments = append(departments, CalibrationDepartment{
+				DepartmentID:   entry.DepartmentID,
+				DepartmentName: entry.DepartmentName,
+			})
+			gradeCounts[entry.DepartmentID] = make(map[string]int)
+		}
+		departments[i].Total++
+		if evaluation.Grade != "" {
+			gradeCounts[entry.DepartmentID][evaluation.Grade]++
+			if !slices.Contains(gradeOrder, evaluation.Grade) {
+				gradeOrder = append(gradeOrder, evaluation.Grade)
+			}
+		}
+	}
+
+	for i := range departments {
+		department := &departments[i]
+		counts := gradeCounts[department.DepartmentID]
+		for _, grade := range gradeOrder {
+			department.Distribution = append(department.Distribution, GradeCount{
+				Grade:   grade,
+				Count:   counts[grade],
+				Percent: percentOf(counts[grade], department.Total),
+			})
+		}
+		department.Quotas = []QuotaUsage{}
+		for _, quota := range getDepartmentQuotas(quotas, department.DepartmentID) {
+			department.Quotas = append(department.Quotas, computeQuotaUsage(&quota, counts, department.Total))
+		}
+	}
+	return entries, departments, nil
+}
+
+// 计算等级配额的使用情况
+func computeQuotaUsage(quota *models.GradeQuota, counts map[string]int, total int) QuotaUsage {
+	usage := QuotaUsage{
+		QuotaID:    quota.ID,
+		Grades:     quota.Grades,
+		MaxPercent: quota.MaxPercent,
+		MinPercent: quota.MinPercent,
+		Status:     QuotaStatusOK,
+	}
+	for _, grade := range quota.Grades {
+		usage.Count += counts[grade]
+	}
+	usage.Percent = percentOf(usage.Count, total)
+
+	if quota.MaxPercent != nil {
+		maxCount := int(math.Floor(float64(total) * *quota.MaxPercent / 100))
+		usage.MaxCount = &maxCount
+		if usage.Count > maxCount {
+			usage.Status = QuotaStatusOver
+		}
+	}
+	if quota.MinPercent != nil {
+		minCount := int(math.Ceil(float64(total) * *quota.MinPercent / 100))
+		usage.MinCount = &minCount
+		if usage.Count < minCount {
+			usage.Status = QuotaStatusUnder
+		}
+	}
+	return usage
+}
+
+// 获取部门适用的等级配额：部门单独设置的配额，没有时为通用配额
+func getDepartmentQuotas(quotas []models.GradeQuota, departmentID uint) []models.GradeQuota {
+	var own, common []models.GradeQuota
+	for _, quota := range quotas {
+		if quota.DepartmentID == nil {
+			common = append(common, quota)
+		} else if *quota.DepartmentID == departmentID {
+			own = append(own, quota)
+		}
+	}
+	if len(own) > 0 {
+		return own
+	}
+	return common
+}
+
+// 百分比，保留两位小数
+func percentOf(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return roundScore(float64(count) / float64(total) * 100)
+}
+
+// 限制评估查询为校准会议的范围，用于 db.Scopes(...)
+func calibrationScope(session *models.CalibrationSession) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("kpi_evaluations.cycle_id = ?", session.CycleID)
+		if session.DepartmentID != nil {
+			db = db.Where("kpi_evaluations.employee_id IN (SELECT id FROM employees WHERE department_id = ?)", *session.DepartmentID)
+		}
+		return db
+	}
+}
+
+// 前置条件：评估结果没有被校准会议锁定
+func requireNotCalibrated(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	if evaluation.CalibrationID != nil {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "评估结果已被校准会议锁定，不能重新开放",
+		}
+	}
+	return nil
+}
+
+// 校验等级配额，并整理等级列表
+func validateGradeQuota(tx *gorm.DB, quota *models.GradeQuota) error {
+	var grades []string
+	for _, grade := range quota.Grades {
+		grade = strings.TrimSpace(grade)
+		if grade != "" && !slices.Contains(grades, grade) {
+			grades = append(grades, grade)
+		}
+	}
+	if len(grades) == 0 {
+		return errors.New("等级配额至少需要包含一个等级")
+	}
+	quota.Grades = grades
+
+	if quota.MaxPercent == nil && quota.MinPercent == nil {
+		return errors.New("请设置人数占比上限或下限")
+	}
+	for _, percent := range []*float64{quota.MaxPercent, quota.MinPercent} {
+		if percent != nil && (*percent < 0 || *percent > 100) {
+			return errors.New("人数占比必须在 0 到 100 之间")
+		}
+	}
+	if quota.MaxPercent != nil && quota.MinPercent != nil && *quota.MinPercent > *quota.MaxPercent {
+		return errors.New("人数占比下限不能大于上限")
+	}
+
+	if quota.DepartmentID != nil {
+		var count int64
+		if err := tx.Model(&models.Department{}).Where("id = ?", *quota.DepartmentID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("部门不存在")
+		}
+	}
+	return nil
+}
+
+// 加载等级配额
+func loadGradeQuota(c *gin.Context) (*models.GradeQuota, bool) {
+	id := c.Param("id")
+	quotaId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的等级配额ID",
+		})
+		return nil, false
+	}
+
+	var quota models.GradeQuota
+	if err := models.DB.First(&quota, quotaId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "等级配额不存在",
+		})
+		return nil, false
+	}
+
+	return &quota, true
+}
+
+// 加载校准会议
+func loadCalibrationSession(c *gin.Context) (*models.CalibrationSession, bool) {
+	id := c.Param("id")
+	sessionId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的校准会议ID",
+		})
+		return nil, false
+	}
+
+	var session models.CalibrationSession
+	if err := models.DB.Preload("Cycle").Preload("Department").Preload("Creator").First(&session, sessionId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "校准会议不存在",
+		})
+		return nil, false
+	}
+
+	return &session, true
+}
+
+// 返回校准操作的错误，业务错误使用对应的状态码
+func respondCalibrationError(c *gin.Context, err error, message string) {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		c.JSON(transitionErr.StatusCode, gin.H{
+			"error": transitionErr.Message,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   message,
+		"message": err.Error(),
+	})
+}
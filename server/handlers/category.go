@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"fmt"
+
+	"dootask-kpi-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 分类小计
+type CategorySubtotal struct {
+	ItemID        uint     `json:"item_id"` // 分类项目ID
+	OriginID      uint     `json:"origin_id"`
+	Name          string   `json:"name"`
+	Order         int      `json:"order"`
+	Weight        float64  `json:"weight"`                   // 分类权重（百分比），为0表示子项目得分直接计入总分
+	ItemCount     int      `json:"item_count"`               // 子项目数量
+	MaxScore      float64  `json:"max_score"`                // 子项目满分之和
+	SelfScore     *float64 `json:"self_score,omitempty"`     // 子项目自评分数之和
+	ManagerScore  *float64 `json:"manager_score,omitempty"`  // 子项目上级评分之和
+	HRScore       *float64 `json:"hr_score,omitempty"`       // 子项目HR评分之和
+	FinalScore    *float64 `json:"final_score,omitempty"`    // 子项目最终得分之和
+	WeightedScore *float64 `json:"weighted_score,omitempty"` // 计入总分的得分
+}
+
+// 是否为分类项目
+func isCategoryItem(item *models.KPIItem) bool {
+	return item.Type == ItemTypeCategory
+}
+
+// 校验考核项目的层级关系：分类只能是顶级项目且同一版本的分类权重之和不超过100，
+// 子项目只能属于同一模板版本中的分类
+func validateItemHierarchy(tx *gorm.DB, item *models.KPIItem) error {
+	if isCategoryItem(item) {
+		if item.ParentID != nil {
+			return fmt.Errorf("分类不能属于其他分类")
+		}
+		if item.Weight < 0 || item.Weight > 100 {
+			return fmt.Errorf("分类权重必须在 0 到 100 之间")
+		}
+
+		var others float64
+		if err := tx.Model(&models.KPIItem{}).
+			Where("template_id = ? AND version = ? AND type = ? AND id <> ?", item.TemplateID, item.Version, ItemTypeCategory, item.ID).
+			Select("COALESCE(SUM(weight), 0)").Scan(&others).Error; err != nil {
+			return err
+		}
+		if others+item.Weight > 100 {
+			return fmt.Errorf("分类权重之和不能超过100，其他分类已占用 %g", others)
+		}
+		return nil
+	}
+
+	if item.Weight != 0 {
+		return fmt.Errorf("只有分类可以设置权重")
+	}
+
+	if item.ID != 0 {
+		var children int64
+		if err := tx.Model(&models.KPIItem{}).Where("parent_id = ?", item.ID).Count(&children).Error; err != nil {
+			return err
+		}
+		if children > 0 {
+			return fmt.Errorf("该项目下还有子项目，不能修改为非分类项目")
+		}
+	}
+
+	if item.ParentID == nil {
+		return nil
+	}
+	var parent models.KPIItem
+	if err := tx.First(&parent, *item.ParentID).Error; err != nil {
+		return fmt.Errorf("所属分类不存在")
+	}
+	if parent.TemplateID != item.TemplateID || parent.Version != item.Version || !isCategoryItem(&parent) {
+		return fmt.Errorf("所属分类必须是同一模板版本中的分类项目")
+	}
+	return nil
+}
+
+// 按分类汇总评分，scores 需要预加载 Item
+func getCategorySubtotals(tx *gorm.DB, scores []models.KPIScore) ([]CategorySubtotal, error) {
+	var categoryIDs []uint
+	for _, score := range scores {
+		if score.Item.ParentID != nil {
+			categoryIDs = append(categoryIDs, *score.Item.ParentID)
+		}
+	}
+	if len(categoryIDs) == 0 {
+		return []CategorySubtotal{}, nil
+	}
+
+	var categories []models.KPIItem
+	if err := tx.Where("id IN ?", categoryIDs).Scopes(orderedBySortOrder).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	return buildCategorySubtotals(categories, scores), nil
+}
+
+// 根据分类和评分计算分类小计
+func buildCategorySubtotals(categories []models.KPIItem, scores []models.KPIScore) []CategorySubtotal {
+	subtotals := make([]CategorySubtotal, len(categories))
+	index := make(map[uint]int, len(categories))
+	for i, category := range categories {
+		index[category.ID] = i
+		subtotals[i] = CategorySubtotal{
+			ItemID:   category.ID,
+			OriginID: category.OriginID,
+			Name:     category.Name,
+			Order:    category.Order,
+			Weight:   category.Weight,
+		}
+	}
+
+	for _, score := range scores {
+		if score.Item.ParentID == nil {
+			continue
+		}
+		i, ok := index[*score.Item.ParentID]
+		if !ok {
+			continue
+		}
+		subtotal := &subtotals[i]
+		subtotal.ItemCount++
+		subtotal.MaxScore += score.Item.MaxScore
+		subtotal.SelfScore = addScore(subtotal.SelfScore, score.SelfScore)
+		subtotal.ManagerScore = addScore(subtotal.ManagerScore, score.ManagerScore)
+		subtotal.HRScore = addScore(subtotal.HRScore, score.HRScore)
+		subtotal.FinalScore = addScore(subtotal.FinalScore, score.FinalScore)
+	}
+
+	for i := range subtotals {
+		subtotals[i].WeightedScore = weightCategoryScore(&subtotals[i])
+	}
+	return subtotals
+}
+
+// 分类计入总分的得分：设置了权重时为得分率乘以权重，否则为子项目最终得分之和
+func weightCategoryScore(subtotal *CategorySubtotal) *float64 {
+	if subtotal.FinalScore == nil {
+		return nil
+	}
+	score := *subtotal.FinalScore
+	if subtotal.Weight > 0 && subtotal.MaxScore != 0 {
+		score = score / subtotal.MaxScore * subtotal.Weight
+	}
+	score = roundScore(score)
+	return &score
+}
+
+// 计算总分：未分类项目的最终得分直接累加，分类按计入总分的得分累加
+func sumTotalScore(scores []models.KPIScore, subtotals []CategorySubtotal) float64 {
+	categorized := make(map[uint]bool, len(subtotals))
+	total := 0.0
+	for _, subtotal := range subtotals {
+		categorized[subtotal.ItemID] = true
+		if subtotal.WeightedScore != nil {
+			total += *subtotal.WeightedScore
+		}
+	}
+	for _, score := range scores {
+		if score.Item.ParentID != nil && categorized[*score.Item.ParentID] {
+			continue
+		}
+		if score.FinalScore != nil {
+			total += *score.FinalScore
+		}
+	}
+	return roundScore(total)
+}
+
+// 累加分数，两者都为空时返回空
+func addScore(sum, value *float64) *float64 {
+	if value == nil {
+		return sum
+	}
+	result := *value
+	if sum != nil {
+		result = roundScore(*sum + *value)
+	}
+	return &result
+}
+
+// 评分明细行：考核项目评分或分类小计
+type categoryScoreRow struct {
+	score    *models.KPIScore
+	subtotal *CategorySubtotal
+}
+
+// 按分类排列评分：未分类项目在前，每个分类的子项目之后为该分类的小计
+func orderScoresByCategory(scores []models.KPIScore, subtotals []CategorySubtotal) []categoryScoreRow {
+	index := make(map[uint]int, len(subtotals))
+	for i, subtotal := range subtotals {
+		index[subtotal.ItemID] = i
+	}
+
+	var rows []categoryScoreRow
+	children := make([][]categoryScoreRow, len(subtotals))
+	for i := range scores {
+		score := &scores[i]
+		if score.Item.ParentID != nil {
+			if j, ok := index[*score.Item.ParentID]; ok {
+				children[j] = append(children[j], categoryScoreRow{score: score})
+				continue
+			}
+		}
+		rows = append(rows, categoryScoreRow{score: score})
+	}
+
+	for i := range subtotals {
+		rows = append(rows, children[i]...)
+		rows = append(rows, categoryScoreRow{subtotal: &subtotals[i]})
+	}
+	return rows
+}
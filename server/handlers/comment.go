@@ -7,6 +7,7 @@ import (
 	"dootask-kpi-server/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 获取评估评论列表
@@ -32,6 +33,16 @@ func GetEvaluationComments(c *gin.Context) {
 		pageSize = 10
 	}
 
+	// 验证评估记录是否存在且有权限查看
+	var evaluation models.KPIEvaluation
+	if err := models.DB.First(&evaluation, evaluationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "评估记录不存在"})
+		return
+	}
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
+
 	var comments []models.EvaluationComment
 
 	// 构建查询条件
@@ -104,6 +115,9 @@ func CreateEvaluationComment(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "评估记录不存在"})
 		return
 	}
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
 
 	// 创建评论
 	evalID, _ := strconv.ParseUint(evaluationID, 10, 32)
@@ -114,7 +128,13 @@ func CreateEvaluationComment(c *gin.Context) {
 		IsPrivate:    req.IsPrivate,
 	}
 
-	if err := models.DB.Create(&comment).Error; err != nil {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityComment, comment.ID, comment)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建评论失败"})
 		return
 	}
@@ -157,6 +177,12 @@ func UpdateEvaluationComment(c *gin.Context) {
 		return
 	}
 
+	// 系统评论不可编辑
+	if comment.IsSystem {
+		c.JSON(http.StatusForbidden, gin.H{"error": "系统评论不能编辑"})
+		return
+	}
+
 	// 检查权限：只有评论作者可以编辑
 	if comment.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权限编辑此评论"})
@@ -164,10 +190,17 @@ func UpdateEvaluationComment(c *gin.Context) {
 	}
 
 	// 更新评论
+	before := comment
 	comment.Content = req.Content
 	comment.IsPrivate = req.IsPrivate
 
-	if err := models.DB.Save(&comment).Error; err != nil {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&comment).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityComment, comment.ID, before, comment)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新评论失败"})
 		return
 	}
@@ -200,6 +233,12 @@ func DeleteEvaluationComment(c *gin.Context) {
 		return
 	}
 
+	// 系统评论不可删除
+	if comment.IsSystem {
+		c.JSON(http.StatusForbidden, gin.H{"error": "系统评论不能删除"})
+		return
+	}
+
 	// 检查权限：只有评论作者可以删除
 	if comment.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权限删除此评论"})
@@ -207,7 +246,13 @@ func DeleteEvaluationComment(c *gin.Context) {
 	}
 
 	// 删除评论
-	if err := models.DB.Delete(&comment).Error; err != nil {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&comment).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityComment, comment.ID, comment)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除评论失败"})
 		return
 	}
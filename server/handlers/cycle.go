@@ -0,0 +1,646 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 考核周期状态
+const (
+	CycleStatusDraft    = "draft"    // 草稿
+	CycleStatusOpen     = "open"     // 已开启，可以发起评估
+	CycleStatusLaunched = "launched" // 已发起评估
+	CycleStatusClosed   = "closed"   // 已关闭，不再发起评估
+	CycleStatusArchived = "archived" // 已归档
+)
+
+// 考核周期状态流转：目标状态 => 允许的来源状态
+var cycleTransitions = map[string][]string{
+	CycleStatusOpen:     {CycleStatusDraft},
+	CycleStatusLaunched: {CycleStatusOpen, CycleStatusLaunched},
+	CycleStatusClosed:   {CycleStatusOpen, CycleStatusLaunched},
+	CycleStatusArchived: {CycleStatusClosed},
+}
+
+// 考核周期请求结构
+type ReviewCycleRequest struct {
+	Name              string                           `json:"name" binding:"required"`
+	Period            string                           `json:"period" binding:"required"`
+	Year              int                              `json:"year" binding:"required"`
+	Month             *int                             `json:"month"`
+	Quarter           *int                             `json:"quarter"`
+	StartDate         *time.Time                       `json:"start_date"`
+	EndDate           *time.Time                       `json:"end_date"`
+	DefaultTemplateID *uint                            `json:"default_template_id"`
+	SelfDueAt         *time.Time                       `json:"self_due_at"`
+	ManagerDueAt      *time.Time                       `json:"manager_due_at"`
+	HRDueAt           *time.Time                       `json:"hr_due_at"`
+	ConfirmDueAt      *time.Time                       `json:"confirm_due_at"`
+	DepartmentIDs     []uint                           `json:"department_ids"`
+	TemplateRules     []models.ReviewCycleTemplateRule `json:"template_rules"`
+}
+
+// 发起评估时跳过的员工
+type SkippedEmployee struct {
+	EmployeeID   uint   `json:"employee_id"`
+	EmployeeName string `json:"employee_name"`
+	Reason       string `json:"reason"`
+}
+
+// 发起评估结果
+type LaunchCycleResult struct {
+	Created []models.KPIEvaluation `json:"created"`
+	Skipped []SkippedEmployee      `json:"skipped"`
+}
+
+// 获取考核周期列表
+func GetCycles(c *gin.Context) {
+	var cycles []models.ReviewCycle
+
+	// 解析分页参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	status := c.Query("status")
+	period := c.Query("period")
+	year := c.Query("year")
+
+	// 验证分页参数
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	// 构建查询
+	query := models.DB.Model(&models.ReviewCycle{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if period != "" {
+		query = query.Where("period = ?", period)
+	}
+	if year != "" {
+		query = query.Where("year = ?", year)
+	}
+
+	// 获取总数
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取考核周期总数失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 分页查询
+	offset := (page - 1) * pageSize
+	result := query.Preload("DefaultTemplate").Preload("Departments").
+		Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&cycles)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取考核周期列表失败",
+			"message": result.Error.Error(),
+		})
+		return
+	}
+
+	// 计算分页信息
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       cycles,
+		"total":      total,
+		"page":       page,
+		"pageSize":   pageSize,
+		"totalPages": totalPages,
+		"hasNext":    page < totalPages,
+		"hasPrev":    page > 1,
+	})
+}
+
+// 获取单个考核周期
+func GetCycle(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	// 统计周期内的评估状态
+	var statusCounts []struct {
+		Status string `json:"status"`
+		Count  int64  `json:"count"`
+	}
+	models.DB.Model(&models.KPIEvaluation{}).
+		Select("status, COUNT(*) as count").
+		Where("cycle_id = ?", cycle.ID).
+		Group("status").
+		Scan(&statusCounts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":          cycle,
+		"status_counts": statusCounts,
+	})
+}
+
+// 创建考核周期
+func CreateCycle(c *gin.Context) {
+	var req ReviewCycleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	cycle := models.ReviewCycle{
+		Status:    CycleStatusDraft,
+		CreatedBy: c.GetUint("user_id"),
+	}
+	if err := applyCycleRequest(&cycle, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&cycle).Error; err != nil {
+			return err
+		}
+		if err := saveCycleAssociations(tx, &cycle, &req); err != nil {
+			return err
+		}
+		return auditCycleChanges(tx, c, &models.ReviewCycle{}, &cycle)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建考核周期失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("DefaultTemplate").Preload("Departments").Preload("TemplateRules.Template").First(&cycle, cycle.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "考核周期创建成功",
+		"data":    cycle,
+	})
+}
+
+// 更新考核周期
+func UpdateCycle(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	if cycle.Status != CycleStatusDraft && cycle.Status != CycleStatusOpen {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "只有草稿或已开启的考核周期可以修改",
+		})
+		return
+	}
+
+	var req ReviewCycleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	before := *cycle
+	if err := applyCycleRequest(cycle, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Departments", "TemplateRules", "DefaultTemplate").Save(cycle).Error; err != nil {
+			return err
+		}
+		if err := saveCycleAssociations(tx, cycle, &req); err != nil {
+			return err
+		}
+		return auditCycleChanges(tx, c, &before, cycle)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新考核周期失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("DefaultTemplate").Preload("Departments").Preload("TemplateRules.Template").First(cycle, cycle.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "考核周期更新成功",
+		"data":    cycle,
+	})
+}
+
+// 删除考核周期
+func DeleteCycle(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	// 检查是否有相关的评估记录
+	var evaluationCount int64
+	models.DB.Model(&models.KPIEvaluation{}).Where("cycle_id = ?", cycle.ID).Count(&evaluationCount)
+	if evaluationCount > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该考核周期已有评估记录，无法删除",
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(cycle).Association("Departments").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("cycle_id = ?", cycle.ID).Delete(&models.ReviewCycleTemplateRule{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(cycle).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityCycle, cycle.ID, cycle)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除考核周期失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "考核周期删除成功",
+	})
+}
+
+// 开启考核周期
+func OpenCycle(c *gin.Context) {
+	changeCycleStatus(c, CycleStatusOpen, "考核周期已开启")
+}
+
+// 关闭考核周期
+func CloseCycle(c *gin.Context) {
+	changeCycleStatus(c, CycleStatusClosed, "考核周期已关闭")
+}
+
+// 归档考核周期
+func ArchiveCycle(c *gin.Context) {
+	changeCycleStatus(c, CycleStatusArchived, "考核周期已归档")
+}
+
+// 发起考核周期：为所有符合条件的在职员工批量创建评估
+func LaunchCycle(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	if !slices.Contains(cycleTransitions[CycleStatusLaunched], cycle.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "只有已开启的考核周期可以发起评估",
+		})
+		return
+	}
+
+	var result LaunchCycleResult
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		launched, err := launchCycle(tx, c, cycle)
+		if err != nil {
+			return err
+		}
+		result = *launched
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "发起考核周期失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 发送通知
+	operatorID := c.GetUint("user_id")
+	operatorName := c.GetString("user_name")
+	for i := range result.Created {
+		notifyEvaluationCreated(&result.Created[i], c.GetHeader("DooTaskAuth"), operatorID, operatorName)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("考核周期发起成功，创建 %d 条评估，跳过 %d 名员工", len(result.Created), len(result.Skipped)),
+		"data":    result,
+	})
+}
+
+// 在事务中为考核周期批量创建评估
+// c 为空表示由系统任务发起
+func launchCycle(tx *gorm.DB, c *gin.Context, cycle *models.ReviewCycle) (*LaunchCycleResult, error) {
+	result := &LaunchCycleResult{
+		Created: []models.KPIEvaluation{},
+		Skipped: []SkippedEmployee{},
+	}
+
+	// 获取参与的在职员工
+	query := tx.Where("is_active = ?", true)
+	if len(cycle.Departments) > 0 {
+		var departmentIDs []uint
+		for _, department := range cycle.Departments {
+			departmentIDs = append(departmentIDs, department.ID)
+		}
+		query = query.Where("department_id IN ?", departmentIDs)
+	}
+	var employees []models.Employee
+	if err := query.Order("id").Find(&employees).Error; err != nil {
+		return nil, err
+	}
+
+	// 全局模板分配规则
+	rules, err := loadAssignmentRules(tx, cycle.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[uint]*models.KPITemplate)
+	for _, employee := range employees {
+		skip := func(reason string) {
+			result.Skipped = append(result.Skipped, SkippedEmployee{
+				EmployeeID:   employee.ID,
+				EmployeeName: employee.Name,
+				Reason:       reason,
+			})
+		}
+
+		templateID := resolveEmployeeTemplate(cycle, rules, &employee).TemplateID
+		if templateID == nil {
+			skip("没有匹配的考核模板")
+			continue
+		}
+
+		template, exists := templates[*templateID]
+		if !exists {
+			var t models.KPITemplate
+			if err := tx.First(&t, *templateID).Error; err == nil {
+				template = &t
+			}
+			templates[*templateID] = template
+		}
+		if template == nil {
+			skip("考核模板不存在")
+			continue
+		}
+		if !template.IsActive {
+			skip(fmt.Sprintf("考核模板「%s」已停用", template.Name))
+			continue
+		}
+
+		evaluation := models.KPIEvaluation{
+			EmployeeID: employee.ID,
+			TemplateID: template.ID,
+			Period:     cycle.Period,
+			Year:       cycle.Year,
+			Month:      cycle.Month,
+			Quarter:    cycle.Quarter,
+			CycleID:    &cycle.ID,
+			Status:     EvaluationStatusPending,
+		}
+
+		existing, err := findExistingEvaluation(tx, &evaluation)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			skip("评估记录已存在")
+			continue
+		}
+
+		if err := createEvaluationWithScores(tx, c, &evaluation); err != nil {
+			return nil, err
+		}
+
+		evaluation.Employee = employee
+		evaluation.Template = *template
+		result.Created = append(result.Created, evaluation)
+	}
+
+	now := time.Now()
+	previousStatus := cycle.Status
+	if err := tx.Model(cycle).Updates(map[string]interface{}{
+		"status":      CycleStatusLaunched,
+		"launched_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := auditField(tx, c, AuditEntityCycle, cycle.ID, "status", previousStatus, CycleStatusLaunched); err != nil {
+		return nil, err
+	}
+	cycle.Status = CycleStatusLaunched
+	cycle.LaunchedAt = &now
+
+	return result, nil
+}
+
+// 加载考核周期及其关联数据
+func loadCycle(c *gin.Context) (*models.ReviewCycle, bool) {
+	id := c.Param("id")
+	cycleId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的考核周期ID",
+		})
+		return nil, false
+	}
+
+	var cycle models.ReviewCycle
+	result := models.DB.Preload("DefaultTemplate").Preload("Departments").Preload("TemplateRules.Template").First(&cycle, cycleId)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "考核周期不存在",
+		})
+		return nil, false
+	}
+
+	return &cycle, true
+}
+
+// 变更考核周期状态
+func changeCycleStatus(c *gin.Context, target string, message string) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	if !slices.Contains(cycleTransitions[target], cycle.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("考核周期状态不能从「%s」变更为「%s」", getCycleStatusText(cycle.Status), getCycleStatusText(target)),
+		})
+		return
+	}
+
+	previousStatus := cycle.Status
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(cycle).Update("status", target).Error; err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityCycle, cycle.ID, "status", previousStatus, target)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新考核周期状态失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"data":    cycle,
+	})
+}
+
+// 校验请求并写入考核周期基本信息
+func applyCycleRequest(cycle *models.ReviewCycle, req *ReviewCycleRequest) error {
+	switch req.Period {
+	case "monthly":
+		if req.Month == nil || *req.Month < 1 || *req.Month > 12 {
+			return errors.New("月度考核周期需要指定有效的月份")
+		}
+		req.Quarter = nil
+	case "quarterly":
+		if req.Quarter == nil || *req.Quarter < 1 || *req.Quarter > 4 {
+			return errors.New("季度考核周期需要指定有效的季度")
+		}
+		req.Month = nil
+	case "yearly":
+		req.Month = nil
+		req.Quarter = nil
+	default:
+		return fmt.Errorf("不支持的考核周期类型：%s", req.Period)
+	}
+
+	if req.StartDate != nil && req.EndDate != nil && req.EndDate.Before(*req.StartDate) {
+		return errors.New("截止日期不能早于开始日期")
+	}
+
+	cycle.Name = req.Name
+	cycle.Period = req.Period
+	cycle.Year = req.Year
+	cycle.Month = req.Month
+	cycle.Quarter = req.Quarter
+	cycle.StartDate = req.StartDate
+	cycle.EndDate = req.EndDate
+	cycle.DefaultTemplateID = req.DefaultTemplateID
+	cycle.SelfDueAt = req.SelfDueAt
+	cycle.ManagerDueAt = req.ManagerDueAt
+	cycle.HRDueAt = req.HRDueAt
+	cycle.ConfirmDueAt = req.ConfirmDueAt
+	return nil
+}
+
+// 保存考核周期的参与部门和模板分配规则
+func saveCycleAssociations(tx *gorm.DB, cycle *models.ReviewCycle, req *ReviewCycleRequest) error {
+	var departments []models.Department
+	if len(req.DepartmentIDs) > 0 {
+		if err := tx.Where("id IN ?", req.DepartmentIDs).Find(&departments).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(cycle).Association("Departments").Replace(departments); err != nil {
+		return err
+	}
+
+	if err := tx.Where("cycle_id = ?", cycle.ID).Delete(&models.ReviewCycleTemplateRule{}).Error; err != nil {
+		return err
+	}
+	for _, rule := range req.TemplateRules {
+		rule.ID = 0
+		rule.CycleID = cycle.ID
+		if err := tx.Omit("Template").Create(&rule).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 记录考核周期的变更，包括参与部门和模板分配规则（before 为空记录时视为创建）
+func auditCycleChanges(tx *gorm.DB, c *gin.Context, before, after *models.ReviewCycle) error {
+	if before.ID == 0 {
+		if err := auditCreate(tx, c, AuditEntityCycle, after.ID, after); err != nil {
+			return err
+		}
+	} else if err := auditUpdate(tx, c, AuditEntityCycle, after.ID, before, after); err != nil {
+		return err
+	}
+
+	// 关联配置按保存后的数据记录
+	var departmentIDs []uint
+	if err := tx.Table("review_cycle_departments").Where("review_cycle_id = ?", after.ID).Order("department_id").Pluck("department_id", &departmentIDs).Error; err != nil {
+		return err
+	}
+	var rules []models.ReviewCycleTemplateRule
+	if err := tx.Where("cycle_id = ?", after.ID).Order("priority").Find(&rules).Error; err != nil {
+		return err
+	}
+
+	var oldDepartmentIDs []uint
+	for _, department := range before.Departments {
+		oldDepartmentIDs = append(oldDepartmentIDs, department.ID)
+	}
+	slices.Sort(oldDepartmentIDs)
+	if err := auditField(tx, c, AuditEntityCycle, after.ID, "department_ids", oldDepartmentIDs, departmentIDs); err != nil {
+		return err
+	}
+	return auditField(tx, c, AuditEntityCycle, after.ID, "template_rules", formatCycleTemplateRules(before.TemplateRules), formatCycleTemplateRules(rules))
+}
+
+// 格式化模板分配规则用于审计记录
+func formatCycleTemplateRules(rules []models.ReviewCycleTemplateRule) string {
+	var parts []string
+	for _, rule := range rules {
+		parts = append(parts, marshalAuditSnapshot(rule))
+	}
+	return strings.Join(parts, ";")
+}
+
+// 获取考核周期状态文本
+func getCycleStatusText(status string) string {
+	switch status {
+	case CycleStatusDraft:
+		return "草稿"
+	case CycleStatusOpen:
+		return "已开启"
+	case CycleStatusLaunched:
+		return "已发起"
+	case CycleStatusClosed:
+		return "已关闭"
+	case CycleStatusArchived:
+		return "已归档"
+	default:
+		return "未知状态"
+	}
+}
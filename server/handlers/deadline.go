@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 评估阶段
+const (
+	DeadlineStageSelf    = "self"    // 员工自评
+	DeadlineStageManager = "manager" // 上级评分
+	DeadlineStageHR      = "hr"      // HR审核
+	DeadlineStageConfirm = "confirm" // 员工确认
+)
+
+// 提醒类型
+const (
+	ReminderKindReminder   = "reminder"   // 到期前提醒
+	ReminderKindEscalation = "escalation" // 逾期升级
+)
+
+// 截止提醒设置项
+const (
+	SettingDeadlineReminderHours   = "deadline_reminder_hours"   // 到期前多少小时提醒，逗号分隔，如 "72,24"
+	SettingDeadlineEscalationHours = "deadline_escalation_hours" // 逾期多少小时后升级
+)
+
+// 截止提醒检查间隔
+const deadlineCheckInterval = 1 * time.Hour
+
+// 评估截止时间请求结构（为空表示继承考核周期）
+type EvaluationDeadlinesRequest struct {
+	SelfDueAt    *time.Time `json:"self_due_at"`
+	ManagerDueAt *time.Time `json:"manager_due_at"`
+	HRDueAt      *time.Time `json:"hr_due_at"`
+	ConfirmDueAt *time.Time `json:"confirm_due_at"`
+}
+
+// 更新评估的各阶段截止时间
+func UpdateEvaluationDeadlines(c *gin.Context) {
+	id := c.Param("id")
+	evaluationId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评估ID",
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.First(&evaluation, evaluationId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	var req EvaluationDeadlinesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	before := evaluation
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&evaluation).Updates(map[string]interface{}{
+			"self_due_at":    req.SelfDueAt,
+			"manager_due_at": req.ManagerDueAt,
+			"hr_due_at":      req.HRDueAt,
+			"confirm_due_at": req.ConfirmDueAt,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&evaluation, evaluation.ID).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityEvaluation, evaluation.ID, before, evaluation)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新截止时间失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("Employee.Department").Preload("Template").Preload("Cycle").First(&evaluation, evaluation.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "截止时间更新成功",
+		"data":    evaluation,
+	})
+}
+
+// 获取评估当前阶段及截止时间（评估未设置时继承考核周期，evaluation 需要预加载 Cycle）
+func getStageDeadline(evaluation *models.KPIEvaluation) (string, *time.Time) {
+	var stage string
+	var due *time.Time
+	var cycleDue *time.Time
+
+	switch evaluation.Status {
+	case EvaluationStatusPending:
+		stage, due = DeadlineStageSelf, evaluation.SelfDueAt
+		if evaluation.Cycle != nil {
+			cycleDue = evaluation.Cycle.SelfDueAt
+		}
+	case EvaluationStatusSelfEvaluated:
+		stage, due = DeadlineStageManager, evaluation.ManagerDueAt
+		if evaluation.Cycle != nil {
+			cycleDue = evaluation.Cycle.ManagerDueAt
+		}
+	case EvaluationStatusManagerEvaluated:
+		stage, due = DeadlineStageHR, evaluation.HRDueAt
+		if evaluation.Cycle != nil {
+			cycleDue = evaluation.Cycle.HRDueAt
+		}
+	case EvaluationStatusPendingConfirm:
+		stage, due = DeadlineStageConfirm, evaluation.ConfirmDueAt
+		if evaluation.Cycle != nil {
+			cycleDue = evaluation.Cycle.ConfirmDueAt
+		}
+	default:
+		return "", nil
+	}
+
+	if due == nil {
+		due = cycleDue
+	}
+	return stage, due
+}
+
+// 评估当前阶段是否已逾期
+func isEvaluationOverdue(evaluation *models.KPIEvaluation, now time.Time) bool {
+	_, due := getStageDeadline(evaluation)
+	return due != nil && now.After(*due)
+}
+
+// 启动截止提醒的后台任务
+func StartDeadlineReminderTask() {
+	ticker := time.NewTicker(deadlineCheckInterval)
+	go func() {
+		RunDeadlineReminders(time.Now())
+		for range ticker.C {
+			RunDeadlineReminders(time.Now())
+		}
+	}()
+}
+
+// RunDeadlineReminders 发送到期提醒并升级逾期评估
+func RunDeadlineReminders(now time.Time) {
+	reminderHours := getDeadlineReminderHours()
+	escalationHours := getDeadlineEscalationHours()
+
+	var evaluations []models.KPIEvaluation
+	if err := models.DB.Preload("Employee.Manager").Preload("Template").Preload("Cycle").
+		Where("status IN ?", []string{
+			EvaluationStatusPending,
+			EvaluationStatusSelfEvaluated,
+			EvaluationStatusManagerEvaluated,
+			EvaluationStatusPendingConfirm,
+		}).
+		Find(&evaluations).Error; err != nil {
+		fmt.Printf("获取待处理评估失败: %v\n", err)
+		return
+	}
+
+	for i := range evaluations {
+		evaluation := &evaluations[i]
+		stage, due := getStageDeadline(evaluation)
+		if due == nil {
+			continue
+		}
+
+		if now.Before(*due) {
+			// 只发送已到达的最近一次提醒，停机期间错过的更早提醒不再补发
+			for _, hours := range reminderHours {
+				if now.Before(due.Add(-time.Duration(hours) * time.Hour)) {
+					continue
+				}
+				if !reminderSent(evaluation.ID, stage, ReminderKindReminder, hours, *due) {
+					sendDeadlineReminder(evaluation, stage, *due, hours)
+				}
+				break
+			}
+			continue
+		}
+
+		if now.Before(due.Add(time.Duration(escalationHours) * time.Hour)) {
+			continue
+		}
+		if !reminderSent(evaluation.ID, stage, ReminderKindEscalation, escalationHours, *due) {
+			escalateOverdueEvaluation(evaluation, stage, *due, escalationHours)
+		}
+	}
+}
+
+// 发送到期前提醒给当前阶段负责人
+func sendDeadlineReminder(evaluation *models.KPIEvaluation, stage string, due time.Time, hours int) {
+	recipients := getStageResponsibles(evaluation, stage)
+	message := fmt.Sprintf("%s的绩效评估「%s」%s阶段将于 %s 截止，请及时处理",
+		evaluation.Employee.Name, evaluation.Template.Name, getDeadlineStageText(stage), due.Format("2006-01-02 15:04"))
+
+	sendDeadlineBotMessages(recipients, fmt.Sprintf(
+		"### ⏰ 考核即将截止，请及时处理。\n\n- **被考核人：** %s\n- **考核模板：** %s\n- **考核周期：** %s\n- **当前阶段：** %s\n- **截止时间：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+		evaluation.Employee.Name,
+		evaluation.Template.Name,
+		utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter),
+		getDeadlineStageText(stage),
+		due.Format("2006-01-02 15:04"),
+	))
+	GetNotificationService().SendSystemNotification(recipients, EventEvaluationDeadlineReminder, message, evaluation)
+
+	recordReminder(evaluation.ID, stage, ReminderKindReminder, hours, due, recipients)
+}
+
+// 逾期升级：通知当前阶段负责人及其上级，没有上级时通知HR
+func escalateOverdueEvaluation(evaluation *models.KPIEvaluation, stage string, due time.Time, hours int) {
+	responsibles := getStageResponsibles(evaluation, stage)
+	escalations := getStageEscalations(evaluation, stage)
+	recipients := GetNotificationService().DeduplicateUsers(append(append([]uint{}, responsibles...), escalations...))
+	message := fmt.Sprintf("%s的绩效评估「%s」%s阶段已于 %s 逾期",
+		evaluation.Employee.Name, evaluation.Template.Name, getDeadlineStageText(stage), due.Format("2006-01-02 15:04"))
+
+	sendDeadlineBotMessages(recipients, fmt.Sprintf(
+		"### 🚨 考核已逾期，请尽快处理。\n\n- **被考核人：** %s\n- **考核模板：** %s\n- **考核周期：** %s\n- **当前阶段：** %s\n- **截止时间：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+		evaluation.Employee.Name,
+		evaluation.Template.Name,
+		utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter),
+		getDeadlineStageText(stage),
+		due.Format("2006-01-02 15:04"),
+	))
+	GetNotificationService().SendSystemNotification(recipients, EventEvaluationOverdue, message, evaluation)
+
+	recordReminder(evaluation.ID, stage, ReminderKindEscalation, hours, due, recipients)
+}
+
+// 获取当前阶段负责人（evaluation 需要预加载 Employee.Manager）
+func getStageResponsibles(evaluation *models.KPIEvaluation, stage string) []uint {
+	switch stage {
+	case DeadlineStageSelf, DeadlineStageConfirm:
+		return []uint{evaluation.EmployeeID}
+	case DeadlineStageManager:
+		if evaluation.Employee.ManagerID != nil {
+			return []uint{*evaluation.Employee.ManagerID}
+		}
+	}
+	return GetNotificationService().GetAllHRUsers()
+}
+
+// 获取逾期升级对象：员工阶段升级到直属上级，上级阶段升级到上级的上级，都没有时升级到HR
+func getStageEscalations(evaluation *models.KPIEvaluation, stage string) []uint {
+	switch stage {
+	case DeadlineStageSelf, DeadlineStageConfirm:
+		if evaluation.Employee.ManagerID != nil {
+			return []uint{*evaluation.Employee.ManagerID}
+		}
+	case DeadlineStageManager:
+		if evaluation.Employee.Manager != nil && evaluation.Employee.Manager.ManagerID != nil {
+			return []uint{*evaluation.Employee.Manager.ManagerID}
+		}
+	}
+	return GetNotificationService().GetAllHRUsers()
+}
+
+// 发送DooTask机器人消息
+func sendDeadlineBotMessages(userIDs []uint, message string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	var employees []models.Employee
+	models.DB.Where("id IN ?", userIDs).Find(&employees)
+
+	dooTaskClient := utils.NewDooTaskClient("")
+	for _, employee := range employees {
+		dooTaskClient.SendBotMessage(employee.DooTaskUserID, message)
+	}
+}
+
+// 检查提醒是否已发送
+func reminderSent(evaluationID uint, stage, kind string, hours int, due time.Time) bool {
+	var count int64
+	models.DB.Model(&models.EvaluationReminder{}).
+		Where("evaluation_id = ? AND stage = ? AND kind = ? AND offset_hours = ? AND due_at = ?", evaluationID, stage, kind, hours, due).
+		Count(&count)
+	return count > 0
+}
+
+// 记录已发送的提醒
+func recordReminder(evaluationID uint, stage, kind string, hours int, due time.Time, recipients []uint) {
+	var ids []string
+	for _, id := range recipients {
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+	reminder := models.EvaluationReminder{
+		EvaluationID: evaluationID,
+		Stage:        stage,
+		Kind:         kind,
+		OffsetHours:  hours,
+		DueAt:        due,
+		RecipientIDs: strings.Join(ids, ","),
+		SentAt:       time.Now(),
+	}
+	if err := models.DB.Create(&reminder).Error; err != nil {
+		fmt.Printf("保存截止提醒记录失败: %v\n", err)
+	}
+}
+
+// 获取到期前提醒的小时数（从小到大排序）
+func getDeadlineReminderHours() []int {
+	value, err := GetSetting(SettingDeadlineReminderHours)
+	if err != nil {
+		value = "72,24"
+	}
+
+	var hours []int
+	for _, part := range strings.Split(value, ",") {
+		h, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || h <= 0 {
+			continue
+		}
+		hours = append(hours, h)
+	}
+	sort.Ints(hours)
+	return hours
+}
+
+// 获取逾期升级的小时数
+func getDeadlineEscalationHours() int {
+	value, err := GetSetting(SettingDeadlineEscalationHours)
+	if err != nil {
+		return 0
+	}
+	hours, err := strconv.Atoi(value)
+	if err != nil || hours < 0 {
+		return 0
+	}
+	return hours
+}
+
+// 获取评估阶段文本
+func getDeadlineStageText(stage string) string {
+	switch stage {
+	case DeadlineStageSelf:
+		return "员工自评"
+	case DeadlineStageManager:
+		return "上级评分"
+	case DeadlineStageHR:
+		return "HR审核"
+	case DeadlineStageConfirm:
+		return "员工确认"
+	default:
+		return "未知阶段"
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"dootask-kpi-server/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 获取所有部门
@@ -90,11 +91,16 @@ func CreateDepartment(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Create(&department)
-	if result.Error != nil {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&department).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityDepartment, department.ID, department)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "创建部门失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -159,11 +165,17 @@ func UpdateDepartment(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&department).Updates(updateData)
-	if result.Error != nil {
+	before := department
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&department).Updates(updateData).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityDepartment, department.ID, before, department)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新部门失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -185,6 +197,14 @@ func DeleteDepartment(c *gin.Context) {
 		return
 	}
 
+	var department models.Department
+	if err := models.DB.First(&department, departmentId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "部门不存在",
+		})
+		return
+	}
+
 	// 检查是否有员工在该部门
 	var employeeCount int64
 	models.DB.Model(&models.Employee{}).Where("department_id = ?", departmentId).Count(&employeeCount)
@@ -195,11 +215,16 @@ func DeleteDepartment(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Delete(&models.Department{}, departmentId)
-	if result.Error != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Department{}, departmentId).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityDepartment, department.ID, department)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "删除部门失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
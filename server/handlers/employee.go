@@ -7,6 +7,7 @@ import (
 	"dootask-kpi-server/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 获取所有员工
@@ -116,11 +117,16 @@ func CreateEmployee(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Create(&employee)
-	if result.Error != nil {
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&employee).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityEmployee, employee.ID, employee)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "创建员工失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -159,6 +165,12 @@ func GetEmployee(c *gin.Context) {
 	})
 }
 
+// 更新员工请求结构，IsActive 为空表示不修改启用状态
+type UpdateEmployeeRequest struct {
+	models.Employee
+	IsActive *bool `json:"is_active"`
+}
+
 // 更新员工
 func UpdateEmployee(c *gin.Context) {
 	id := c.Param("id")
@@ -179,8 +191,8 @@ func UpdateEmployee(c *gin.Context) {
 		return
 	}
 
-	var updateData models.Employee
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	var req UpdateEmployeeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "请求参数错误",
 			"message": err.Error(),
@@ -188,11 +200,34 @@ func UpdateEmployee(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&employee).Updates(updateData)
-	if result.Error != nil {
+	before := employee
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&employee).Updates(req.Employee).Error; err != nil {
+			return err
+		}
+		// 结构体更新会忽略 false，停用账号需要单独写入
+		if req.IsActive != nil {
+			if err := tx.Model(&employee).Update("is_active", *req.IsActive).Error; err != nil {
+				return err
+			}
+		}
+		// 角色变更或账号停用后，已登录的会话立即失效
+		if before.Role != employee.Role {
+			if err := revokeEmployeeSessions(tx, employee.ID, SessionRevokeRoleChanged); err != nil {
+				return err
+			}
+		} else if before.IsActive && !employee.IsActive {
+			if err := revokeEmployeeSessions(tx, employee.ID, SessionRevokeDeactivated); err != nil {
+				return err
+			}
+		}
+		// 记录角色、上级、部门等字段的变更
+		return auditUpdate(tx, c, AuditEntityEmployee, employee.ID, before, employee)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新员工失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -217,6 +252,14 @@ func DeleteEmployee(c *gin.Context) {
 		return
 	}
 
+	var employee models.Employee
+	if err := models.DB.First(&employee, employeeId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "员工不存在",
+		})
+		return
+	}
+
 	// 检查是否有下属员工
 	var subordinateCount int64
 	models.DB.Model(&models.Employee{}).Where("manager_id = ?", employeeId).Count(&subordinateCount)
@@ -237,15 +280,23 @@ func DeleteEmployee(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Delete(&models.Employee{}, employeeId)
-	if result.Error != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Employee{}, employeeId).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityEmployee, employee.ID, employee)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "删除员工失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
 
+	// 删除员工的登录会话
+	models.DB.Where("employee_id = ?", employee.ID).Delete(&models.UserSession{})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "员工删除成功",
 	})
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"dootask-kpi-server/config"
 	"dootask-kpi-server/global"
 	"dootask-kpi-server/models"
 	"dootask-kpi-server/utils"
@@ -15,11 +16,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/xuri/excelize/v2"
-)
-
-const (
-	// 导出文件目录
-	ExportDir = "./public/exports"
+	"gorm.io/gorm"
 )
 
 // 格式化周期显示
@@ -74,6 +71,10 @@ func ExportEvaluationToExcel(c *gin.Context) {
 		return
 	}
 
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
+
 	// 创建Excel文件
 	f := excelize.NewFile()
 	defer func() {
@@ -125,7 +126,7 @@ func ExportEvaluationToExcel(c *gin.Context) {
 
 	// 设置表头
 	row += 2
-	headers := []string{"考核项目", "满分", "自评分", "自评说明", "主管评分", "主管说明", "最终得分"}
+	headers := []string{"考核项目", "满分", "自评分", "自评说明", "主管评分", "主管说明", "最终得分", "目标值", "实际值", "计算得分"}
 	for i, header := range headers {
 		cell := string(rune('A'+i)) + strconv.Itoa(row)
 		f.SetCellValue(sheetName, cell, header)
@@ -173,6 +174,15 @@ func ExportEvaluationToExcel(c *gin.Context) {
 			f.SetCellValue(sheetName, "G"+strconv.Itoa(row), *score.FinalScore)
 		}
 
+		// 量化指标
+		if isQuantitativeItem(&score.Item) {
+			f.SetCellValue(sheetName, "H"+strconv.Itoa(row), formatMetricValue(&score.Item, score.Item.Target))
+			f.SetCellValue(sheetName, "I"+strconv.Itoa(row), formatMetricValue(&score.Item, score.ActualValue))
+			if score.ComputedScore != nil {
+				f.SetCellValue(sheetName, "J"+strconv.Itoa(row), *score.ComputedScore)
+			}
+		}
+
 		// 设置数据行样式
 		dataStyle, _ := f.NewStyle(&excelize.Style{
 			Border: []excelize.Border{
@@ -206,9 +216,10 @@ func ExportEvaluationToExcel(c *gin.Context) {
 	f.SetColWidth(sheetName, "E", "E", 10)
 	f.SetColWidth(sheetName, "F", "F", 25)
 	f.SetColWidth(sheetName, "G", "G", 10)
+	f.SetColWidth(sheetName, "H", "J", 12)
 
 	// 创建公共导出目录
-	if err := os.MkdirAll(ExportDir, 0755); err != nil {
+	if err := os.MkdirAll(config.Current.ExportDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "创建导出目录失败",
 		})
@@ -221,7 +232,7 @@ func ExportEvaluationToExcel(c *gin.Context) {
 		evaluation.Employee.Name,
 		periodForFileName,
 		time.Now().Unix())
-	filePath := filepath.Join(ExportDir, fileName)
+	filePath := filepath.Join(config.Current.ExportDir, fileName)
 
 	// 保存文件
 	if err := f.SaveAs(filePath); err != nil {
@@ -281,7 +292,7 @@ func ExportDepartmentToExcel(c *gin.Context) {
 	}
 
 	var evaluations []models.KPIEvaluation
-	result := models.DB.Preload("Employee.Department").Preload("Template").
+	result := models.DB.Scopes(getDataScope(c).Evaluations).Preload("Employee.Department").Preload("Template").
 		Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 		Where("employees.department_id = ?", departmentId).
 		Find(&evaluations)
@@ -389,7 +400,7 @@ func ExportDepartmentToExcel(c *gin.Context) {
 	f.SetColWidth(sheetName, "H", "H", 20)
 
 	// 创建公共导出目录
-	if err := os.MkdirAll(ExportDir, 0755); err != nil {
+	if err := os.MkdirAll(config.Current.ExportDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "创建导出目录失败",
 		})
@@ -400,7 +411,7 @@ func ExportDepartmentToExcel(c *gin.Context) {
 	fileName := fmt.Sprintf("部门评估汇总-%s-%d.xlsx",
 		department.Name,
 		time.Now().Unix())
-	filePath := filepath.Join(ExportDir, fileName)
+	filePath := filepath.Join(config.Current.ExportDir, fileName)
 
 	// 保存文件
 	if err := f.SaveAs(filePath); err != nil {
@@ -446,9 +457,12 @@ func ExportPeriodToExcel(c *gin.Context) {
 	year := c.DefaultQuery("year", strconv.Itoa(time.Now().Year()))
 	month := c.DefaultQuery("month", "")
 	quarter := c.DefaultQuery("quarter", "")
+	cycleID := c.Query("cycle_id")
 
 	var evaluations []models.KPIEvaluation
-	query := models.DB.Preload("Employee.Department").Preload("Template").Preload("Scores.Item")
+	query := models.DB.Scopes(getDataScope(c).Evaluations).Preload("Employee.Department").Preload("Template").Preload("Scores.Item").
+		Preload("Appeals", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Preload("Appeals.Employee").Preload("Appeals.Resolver").Preload("Appeals.Items.Item")
 
 	// 根据周期类型筛选（与统计页面查询逻辑保持一致）
 	if period == "monthly" && month != "" {
@@ -459,6 +473,9 @@ func ExportPeriodToExcel(c *gin.Context) {
 		// 兼容历史数据格式，支持 period="yearly" 和 period="年份"
 		query = query.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
 	}
+	if cycleID != "" {
+		query = query.Where("cycle_id = ?", cycleID)
+	}
 
 	result := query.Find(&evaluations)
 	if result.Error != nil {
@@ -536,7 +553,7 @@ func ExportPeriodToExcel(c *gin.Context) {
 	f.SetActiveSheet(0)
 
 	// 创建公共导出目录
-	if err := os.MkdirAll(ExportDir, 0755); err != nil {
+	if err := os.MkdirAll(config.Current.ExportDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "创建导出目录失败",
 		})
@@ -547,7 +564,7 @@ func ExportPeriodToExcel(c *gin.Context) {
 	fileName := fmt.Sprintf("综合评估报告-%s-%d.xlsx",
 		fileNamePeriod,
 		time.Now().Unix())
-	filePath := filepath.Join(ExportDir, fileName)
+	filePath := filepath.Join(config.Current.ExportDir, fileName)
 
 	// 保存文件
 	if err := f.SaveAs(filePath); err != nil {
@@ -598,7 +615,7 @@ func DownloadFile(c *gin.Context) {
 		})
 		return
 	}
-	filePath := filepath.Join(ExportDir, fileName.(string))
+	filePath := filepath.Join(config.Current.ExportDir, fileName.(string))
 
 	// 检查文件是否存在
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -611,7 +628,14 @@ func DownloadFile(c *gin.Context) {
 	// 设置响应头
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Disposition", "attachment; filename="+fileName.(string))
-	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	switch filepath.Ext(fileName.(string)) {
+	case ".json":
+		c.Header("Content-Type", "application/json")
+	case ".yaml":
+		c.Header("Content-Type", "application/yaml")
+	default:
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	}
 
 	// 返回文件
 	c.File(filePath)
@@ -810,7 +834,7 @@ func createDetailSheet(f *excelize.File, sheetName string, evaluation models.KPI
 	currentRow++
 
 	// 考核指标表头
-	headers := []string{"考核项目", "满分", "自评分", "自评说明", "主管评分", "主管说明", "HR评分", "HR说明", "最终得分"}
+	headers := []string{"考核项目", "满分", "自评分", "自评说明", "主管评分", "主管说明", "HR评分", "HR说明", "最终得分", "目标值", "实际值", "计算得分"}
 	for i, header := range headers {
 		cell := string(rune('A'+i)) + strconv.Itoa(currentRow)
 		f.SetCellValue(sheetName, cell, header)
@@ -839,9 +863,22 @@ func createDetailSheet(f *excelize.File, sheetName string, evaluation models.KPI
 	}
 	currentRow++
 
-	// 考核指标数据
+	// 分类小计
+	subtotals, err := getCategorySubtotals(models.DB, evaluation.Scores)
+	if err != nil {
+		fmt.Printf("获取分类小计失败: %v\n", err)
+	}
+
+	// 考核指标数据（分类的子项目之后为分类小计）
 	var totalMaxScore, totalSelfScore, totalManagerScore, totalHRScore, totalFinalScore float64
-	for _, score := range evaluation.Scores {
+	for _, row := range orderScoresByCategory(evaluation.Scores, subtotals) {
+		if row.subtotal != nil {
+			writeCategorySubtotalRow(f, sheetName, currentRow, row.subtotal, len(headers))
+			currentRow++
+			continue
+		}
+
+		score := *row.score
 		f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), score.Item.Name)
 		f.SetCellValue(sheetName, "B"+strconv.Itoa(currentRow), score.Item.MaxScore)
 		totalMaxScore += score.Item.MaxScore
@@ -869,6 +906,15 @@ func createDetailSheet(f *excelize.File, sheetName string, evaluation models.KPI
 			totalFinalScore += *score.FinalScore
 		}
 
+		// 量化指标
+		if isQuantitativeItem(&score.Item) {
+			f.SetCellValue(sheetName, "J"+strconv.Itoa(currentRow), formatMetricValue(&score.Item, score.Item.Target))
+			f.SetCellValue(sheetName, "K"+strconv.Itoa(currentRow), formatMetricValue(&score.Item, score.ActualValue))
+			if score.ComputedScore != nil {
+				f.SetCellValue(sheetName, "L"+strconv.Itoa(currentRow), *score.ComputedScore)
+			}
+		}
+
 		// 设置数据行样式
 		dataStyle, _ := f.NewStyle(&excelize.Style{
 			Border: []excelize.Border{
@@ -885,6 +931,11 @@ func createDetailSheet(f *excelize.File, sheetName string, evaluation models.KPI
 		currentRow++
 	}
 
+	// 有分类时最终得分按分类权重汇总
+	if len(subtotals) > 0 {
+		totalFinalScore = sumTotalScore(evaluation.Scores, subtotals)
+	}
+
 	// 添加总分行
 	f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), "总分")
 	f.SetCellValue(sheetName, "B"+strconv.Itoa(currentRow), totalMaxScore)
@@ -1018,6 +1069,82 @@ func createDetailSheet(f *excelize.File, sheetName string, evaluation models.KPI
 		}
 	}
 
+	// 申诉记录部分
+	if len(evaluation.Appeals) > 0 {
+		currentRow += 2
+		f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), "申诉记录")
+		f.MergeCell(sheetName, "A"+strconv.Itoa(currentRow), "I"+strconv.Itoa(currentRow))
+		f.SetCellStyle(sheetName, "A"+strconv.Itoa(currentRow), "I"+strconv.Itoa(currentRow), sectionStyle)
+		currentRow++
+
+		for appealIdx, appeal := range evaluation.Appeals {
+			// 申诉基本信息
+			f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), fmt.Sprintf("申诉 %d: %s", appealIdx+1, appeal.CreatedAt.Format("2006-01-02 15:04")))
+			f.SetCellValue(sheetName, "C"+strconv.Itoa(currentRow), "状态:")
+			f.SetCellValue(sheetName, "D"+strconv.Itoa(currentRow), getAppealStatusText(appeal.Status))
+			if appeal.Resolver != nil {
+				f.SetCellValue(sheetName, "E"+strconv.Itoa(currentRow), "处理人:")
+				f.SetCellValue(sheetName, "F"+strconv.Itoa(currentRow), appeal.Resolver.Name)
+			}
+			if appeal.ResolvedAt != nil {
+				f.SetCellValue(sheetName, "G"+strconv.Itoa(currentRow), "处理时间:")
+				f.SetCellValue(sheetName, "H"+strconv.Itoa(currentRow), appeal.ResolvedAt.Format("2006-01-02 15:04"))
+			}
+			currentRow++
+
+			f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), "申诉理由:")
+			f.SetCellValue(sheetName, "B"+strconv.Itoa(currentRow), appeal.Reason)
+			f.MergeCell(sheetName, "B"+strconv.Itoa(currentRow), "I"+strconv.Itoa(currentRow))
+			currentRow++
+
+			if appeal.Response != "" {
+				f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), "处理答复:")
+				f.SetCellValue(sheetName, "B"+strconv.Itoa(currentRow), appeal.Response)
+				f.MergeCell(sheetName, "B"+strconv.Itoa(currentRow), "I"+strconv.Itoa(currentRow))
+				currentRow++
+			}
+
+			// 申诉项目表头
+			appealHeaders := []string{"考核项目", "满分", "原得分", "申诉说明", "处理结果", "调整后得分"}
+			for i, header := range appealHeaders {
+				cell := string(rune('A'+i)) + strconv.Itoa(currentRow)
+				f.SetCellValue(sheetName, cell, header)
+				f.SetCellStyle(sheetName, cell, cell, headerStyle)
+			}
+			currentRow++
+
+			// 申诉项目数据
+			for _, item := range appeal.Items {
+				f.SetCellValue(sheetName, "A"+strconv.Itoa(currentRow), item.Item.Name)
+				f.SetCellValue(sheetName, "B"+strconv.Itoa(currentRow), item.Item.MaxScore)
+				if item.OriginalScore != nil {
+					f.SetCellValue(sheetName, "C"+strconv.Itoa(currentRow), *item.OriginalScore)
+				}
+				f.SetCellValue(sheetName, "D"+strconv.Itoa(currentRow), item.Reason)
+				f.SetCellValue(sheetName, "E"+strconv.Itoa(currentRow), getAppealDecisionText(item.Decision))
+				if item.AdjustedScore != nil {
+					f.SetCellValue(sheetName, "F"+strconv.Itoa(currentRow), *item.AdjustedScore)
+				}
+
+				// 设置数据行样式
+				dataStyle, _ := f.NewStyle(&excelize.Style{
+					Border: []excelize.Border{
+						{Type: "left", Color: "000000", Style: 1},
+						{Type: "top", Color: "000000", Style: 1},
+						{Type: "bottom", Color: "000000", Style: 1},
+						{Type: "right", Color: "000000", Style: 1},
+					},
+				})
+				for i := 0; i < len(appealHeaders); i++ {
+					cell := string(rune('A'+i)) + strconv.Itoa(currentRow)
+					f.SetCellStyle(sheetName, cell, cell, dataStyle)
+				}
+				currentRow++
+			}
+			currentRow++
+		}
+	}
+
 	// 总结评价
 	if evaluation.FinalComment != "" {
 		currentRow += 1
@@ -1039,6 +1166,55 @@ func createDetailSheet(f *excelize.File, sheetName string, evaluation models.KPI
 	f.SetColWidth(sheetName, "G", "G", 10)
 	f.SetColWidth(sheetName, "H", "H", 25)
 	f.SetColWidth(sheetName, "I", "I", 10)
+	f.SetColWidth(sheetName, "J", "L", 12)
+}
+
+// 写入分类小计行
+func writeCategorySubtotalRow(f *excelize.File, sheetName string, row int, subtotal *CategorySubtotal, columns int) {
+	rowText := strconv.Itoa(row)
+	f.SetCellValue(sheetName, "A"+rowText, fmt.Sprintf("「%s」小计", subtotal.Name))
+	f.SetCellValue(sheetName, "B"+rowText, subtotal.MaxScore)
+	if subtotal.SelfScore != nil {
+		f.SetCellValue(sheetName, "C"+rowText, *subtotal.SelfScore)
+	}
+	if subtotal.ManagerScore != nil {
+		f.SetCellValue(sheetName, "E"+rowText, *subtotal.ManagerScore)
+	}
+	if subtotal.HRScore != nil {
+		f.SetCellValue(sheetName, "G"+rowText, *subtotal.HRScore)
+	}
+	if subtotal.FinalScore != nil {
+		f.SetCellValue(sheetName, "I"+rowText, *subtotal.FinalScore)
+	}
+	// 设置了权重的分类在说明列显示计入总分的得分
+	if subtotal.Weight > 0 {
+		note := fmt.Sprintf("权重 %g%%", subtotal.Weight)
+		if subtotal.WeightedScore != nil {
+			note += fmt.Sprintf("，计入总分 %g", *subtotal.WeightedScore)
+		}
+		f.SetCellValue(sheetName, "H"+rowText, note)
+	}
+
+	subtotalStyle, _ := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{
+			Bold: true,
+		},
+		Fill: excelize.Fill{
+			Type:    "pattern",
+			Color:   []string{"#F5F5F5"},
+			Pattern: 1,
+		},
+		Border: []excelize.Border{
+			{Type: "left", Color: "000000", Style: 1},
+			{Type: "top", Color: "000000", Style: 1},
+			{Type: "bottom", Color: "000000", Style: 1},
+			{Type: "right", Color: "000000", Style: 1},
+		},
+	})
+	for i := 0; i < columns; i++ {
+		cell := string(rune('A'+i)) + rowText
+		f.SetCellStyle(sheetName, cell, cell, subtotalStyle)
+	}
 }
 
 // 获取邀请状态文本
@@ -1068,6 +1244,8 @@ func getStatusText(status string) string {
 		return "待HR审核"
 	case "pending_confirm":
 		return "待确认"
+	case "disputed":
+		return "申诉中"
 	case "completed":
 		return "已完成"
 	default:
@@ -1080,22 +1258,33 @@ func CleanupExportFiles() {
 	ticker := time.NewTicker(1 * time.Hour)
 	go func() {
 		for range ticker.C {
-			// 清理导出文件
-			files, err := os.ReadDir(ExportDir)
-			if err != nil {
-				continue
-			}
+			RemoveExpiredExports(1 * time.Hour)
+		}
+	}()
+}
 
-			cutoffTime := time.Now().Add(-1 * time.Hour)
+// 删除超过指定时长的导出文件，返回删除的文件数量
+func RemoveExpiredExports(maxAge time.Duration) (int, error) {
+	files, err := os.ReadDir(config.Current.ExportDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
 
-			for _, file := range files {
-				filePath := filepath.Join(ExportDir, file.Name())
-				if info, err := file.Info(); err == nil {
-					if info.ModTime().Before(cutoffTime) {
-						os.Remove(filePath)
-					}
+	cutoffTime := time.Now().Add(-maxAge)
+
+	removed := 0
+	for _, file := range files {
+		filePath := filepath.Join(config.Current.ExportDir, file.Name())
+		if info, err := file.Info(); err == nil {
+			if info.ModTime().Before(cutoffTime) {
+				if err := os.Remove(filePath); err == nil {
+					removed++
 				}
 			}
 		}
-	}()
+	}
+	return removed, nil
 }
@@ -0,0 +1,894 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 个人目标状态
+const (
+	GoalStatusDraft     = "draft"     // 草稿
+	GoalStatusSubmitted = "submitted" // 已提交，等待上级审批
+	GoalStatusApproved  = "approved"  // 已通过，评估时评分
+	GoalStatusRejected  = "rejected"  // 已退回，修改后重新提交
+)
+
+// 个人目标评分上限（目标按百分制评分）
+const goalMaxScore = 100.0
+
+// 个人目标请求结构
+type GoalRequest struct {
+	EmployeeID   uint                   `json:"employee_id"` // 为空表示本人
+	CycleID      *uint                  `json:"cycle_id"`
+	EvaluationID *uint                  `json:"evaluation_id"`
+	Objective    string                 `json:"objective" binding:"required"`
+	Description  string                 `json:"description"`
+	Weight       float64                `json:"weight"`
+	KeyResults   []GoalKeyResultRequest `json:"key_results"`
+}
+
+// 关键结果请求结构
+type GoalKeyResultRequest struct {
+	Title  string `json:"title" binding:"required"`
+	Target string `json:"target"`
+}
+
+// 目标审批请求结构
+type GoalReviewRequest struct {
+	Reason string `json:"reason"` // 退回时必填
+}
+
+// 目标进度更新请求结构
+type GoalProgressRequest struct {
+	KeyResultID *uint    `json:"key_result_id"`
+	Progress    *float64 `json:"progress" binding:"required"`
+	Note        string   `json:"note"`
+}
+
+// 目标评分请求结构
+type GoalScoreRequest struct {
+	Score   *float64 `json:"score"`
+	Comment string   `json:"comment"`
+}
+
+// 获取个人目标列表
+func GetGoals(c *gin.Context) {
+	query := models.DB.Model(&models.EmployeeGoal{})
+
+	scope := getDataScope(c)
+	if !scope.All {
+		query = query.Where("employee_id IN ?", scope.EmployeeIDs)
+	}
+	if employeeID := c.Query("employee_id"); employeeID != "" {
+		query = query.Where("employee_id = ?", employeeID)
+	}
+	if cycleID := c.Query("cycle_id"); cycleID != "" {
+		query = query.Where("cycle_id = ?", cycleID)
+	}
+	if evaluationID := c.Query("evaluation_id"); evaluationID != "" {
+		query = query.Where("evaluation_id = ?", evaluationID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var goals []models.EmployeeGoal
+	if err := query.Preload("Employee").Preload("KeyResults", orderedKeyResults).
+		Order("employee_id, created_at").
+		Find(&goals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取个人目标失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  goals,
+		"total": len(goals),
+	})
+}
+
+// 获取单个个人目标（包含进度更新记录）
+func GetGoal(c *gin.Context) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	if !requireEmployeeAccess(c, goal.EmployeeID) {
+		return
+	}
+
+	models.DB.Preload("Employee").Preload("Approver").Preload("KeyResults", orderedKeyResults).
+		Preload("Updates", func(db *gorm.DB) *gorm.DB { return db.Order("created_at DESC") }).
+		Preload("Updates.Updater").
+		First(goal, goal.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": goal,
+	})
+}
+
+// 创建个人目标：员工为自己设定目标，上级和HR可以为下属设定
+func CreateGoal(c *gin.Context) {
+	var req GoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if req.EmployeeID == 0 {
+		req.EmployeeID = c.GetUint("user_id")
+	}
+	if !requireEmployeeAccess(c, req.EmployeeID) {
+		return
+	}
+
+	goal := models.EmployeeGoal{
+		EmployeeID:   req.EmployeeID,
+		CycleID:      req.CycleID,
+		EvaluationID: req.EvaluationID,
+		Status:       GoalStatusDraft,
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := resolveGoalEvaluation(tx, &goal); err != nil {
+			return err
+		}
+		if err := applyGoalRequest(&goal, &req); err != nil {
+			return err
+		}
+		if err := tx.Omit("KeyResults").Create(&goal).Error; err != nil {
+			return err
+		}
+		if err := saveGoalKeyResults(tx, &goal, req.KeyResults); err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityGoal, goal.ID, goal)
+	})
+	if err != nil {
+		respondGoalError(c, err, "创建个人目标失败")
+		return
+	}
+
+	models.DB.Preload("Employee").Preload("KeyResults", orderedKeyResults).First(&goal, goal.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "个人目标创建成功",
+		"data":    goal,
+	})
+}
+
+// 更新个人目标：只能修改草稿或已退回的目标，关键结果整体替换
+func UpdateGoal(c *gin.Context) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	var req GoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := requireGoalActor(goal, c, ActorSelf, ActorManager, ActorHR); err != nil {
+			return err
+		}
+		if goal.Status != GoalStatusDraft && goal.Status != GoalStatusRejected {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("「%s」的目标不能修改", getGoalStatusText(goal.Status)),
+			}
+		}
+		if err := requireGoalSettingOpen(tx, goal); err != nil {
+			return err
+		}
+
+		before := *goal
+		if err := applyGoalRequest(goal, &req); err != nil {
+			return err
+		}
+		if err := tx.Model(goal).Updates(map[string]interface{}{
+			"objective":   goal.Objective,
+			"description": goal.Description,
+			"weight":      goal.Weight,
+			"progress":    0,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("goal_id = ?", goal.ID).Delete(&models.GoalKeyResult{}).Error; err != nil {
+			return err
+		}
+		if err := saveGoalKeyResults(tx, goal, req.KeyResults); err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityGoal, goal.ID, before, *goal)
+	})
+	if err != nil {
+		respondGoalError(c, err, "更新个人目标失败")
+		return
+	}
+
+	models.DB.Preload("Employee").Preload("KeyResults", orderedKeyResults).First(goal, goal.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "个人目标更新成功",
+		"data":    goal,
+	})
+}
+
+// 删除个人目标：已通过的目标只能由HR在评分前删除
+func DeleteGoal(c *gin.Context) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		actors := []string{ActorSelf, ActorManager, ActorHR}
+		if goal.Status == GoalStatusApproved {
+			actors = []string{ActorHR}
+		}
+		if err := requireGoalActor(goal, c, actors...); err != nil {
+			return err
+		}
+		if err := requireGoalSettingOpen(tx, goal); err != nil {
+			return err
+		}
+
+		if err := tx.Where("goal_id = ?", goal.ID).Delete(&models.GoalProgressUpdate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("goal_id = ?", goal.ID).Delete(&models.GoalKeyResult{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(goal).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityGoal, goal.ID, *goal)
+	})
+	if err != nil {
+		respondGoalError(c, err, "删除个人目标失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "个人目标删除成功",
+	})
+}
+
+// 提交个人目标，等待上级审批
+func SubmitGoal(c *gin.Context) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := requireGoalActor(goal, c, ActorSelf, ActorManager, ActorHR); err != nil {
+			return err
+		}
+		if goal.Status != GoalStatusDraft && goal.Status != GoalStatusRejected {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("「%s」的目标不能提交", getGoalStatusText(goal.Status)),
+			}
+		}
+		if err := requireGoalSettingOpen(tx, goal); err != nil {
+			return err
+		}
+		return changeGoalStatus(tx, c, goal, GoalStatusSubmitted, nil)
+	})
+	if err != nil {
+		respondGoalError(c, err, "提交个人目标失败")
+		return
+	}
+
+	// 通知审批人：直属上级，没有上级时通知HR
+	approverIDs := GetNotificationService().GetAllHRUsers()
+	if goal.Employee.ManagerID != nil {
+		approverIDs = []uint{*goal.Employee.ManagerID}
+	}
+	var approvers []models.Employee
+	models.DB.Where("id IN ?", approverIDs).Find(&approvers)
+	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+	for _, approver := range approvers {
+		dooTaskClient.SendBotMessage(approver.DooTaskUserID, fmt.Sprintf(
+			"### 🎯 员工提交了个人目标，请及时审批。\n\n- **员工：** %s\n- **目标：** %s\n- **权重：** %g\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+			goal.Employee.Name,
+			goal.Objective,
+			goal.Weight,
+		))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "个人目标已提交审批",
+		"data":    goal,
+	})
+}
+
+// 上级或HR审批通过个人目标
+func ApproveGoal(c *gin.Context) {
+	reviewGoal(c, GoalStatusApproved)
+}
+
+// 上级或HR退回个人目标
+func RejectGoal(c *gin.Context) {
+	reviewGoal(c, GoalStatusRejected)
+}
+
+// 更新目标进度：指定关键结果时更新关键结果进度，目标进度为关键结果进度的平均值
+func UpdateGoalProgress(c *gin.Context) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	var req GoalProgressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var update models.GoalProgressUpdate
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := requireGoalActor(goal, c, ActorSelf, ActorManager, ActorHR); err != nil {
+			return err
+		}
+		if goal.Status != GoalStatusApproved {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "目标审批通过后才能更新进度",
+			}
+		}
+		evaluation, err := getGoalEvaluation(tx, goal)
+		if err != nil {
+			return err
+		}
+		if evaluation != nil && evaluation.Status == EvaluationStatusCompleted {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "评估已完成，不能再更新目标进度",
+			}
+		}
+		progress := *req.Progress
+		if math.IsNaN(progress) || progress < 0 || progress > 100 {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "进度必须在 0 到 100 之间",
+			}
+		}
+
+		var keyResults []models.GoalKeyResult
+		if err := tx.Where("goal_id = ?", goal.ID).Find(&keyResults).Error; err != nil {
+			return err
+		}
+		if len(keyResults) > 0 && req.KeyResultID == nil {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "请选择要更新进度的关键结果",
+			}
+		}
+
+		goalProgress := progress
+		if req.KeyResultID != nil {
+			i := slices.IndexFunc(keyResults, func(kr models.GoalKeyResult) bool { return kr.ID == *req.KeyResultID })
+			if i < 0 {
+				return &TransitionError{
+					StatusCode: http.StatusBadRequest,
+					Message:    "关键结果不属于该目标",
+				}
+			}
+			if err := tx.Model(&keyResults[i]).Update("progress", progress).Error; err != nil {
+				return err
+			}
+			sum := 0.0
+			for _, kr := range keyResults {
+				sum += kr.Progress
+			}
+			goalProgress = roundScore(sum / float64(len(keyResults)))
+		}
+
+		previous := goal.Progress
+		if err := tx.Model(goal).Update("progress", goalProgress).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityGoal, goal.ID, "progress", previous, goalProgress); err != nil {
+			return err
+		}
+
+		update = models.GoalProgressUpdate{
+			GoalID:      goal.ID,
+			KeyResultID: req.KeyResultID,
+			Progress:    progress,
+			Note:        req.Note,
+			UpdatedBy:   c.GetUint("user_id"),
+		}
+		return tx.Create(&update).Error
+	})
+	if err != nil {
+		respondGoalError(c, err, "更新目标进度失败")
+		return
+	}
+
+	models.DB.Preload("KeyResults", orderedKeyResults).First(goal, goal.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "目标进度更新成功",
+		"data":    goal,
+		"update":  update,
+	})
+}
+
+// 更新目标自评分数
+func UpdateGoalSelfScore(c *gin.Context) {
+	updateGoalScore(c, ScoreFieldSelf)
+}
+
+// 更新目标上级评分
+func UpdateGoalManagerScore(c *gin.Context) {
+	updateGoalScore(c, ScoreFieldManager)
+}
+
+// 审批个人目标：通过或退回
+func reviewGoal(c *gin.Context, target string) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	var req GoalReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		// 没有直属上级的员工由HR审批
+		if err := requireGoalActor(goal, c, ActorManager, ActorHR); err != nil {
+			return err
+		}
+		if goal.Status != GoalStatusSubmitted {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("「%s」的目标不能审批", getGoalStatusText(goal.Status)),
+			}
+		}
+		if err := requireGoalSettingOpen(tx, goal); err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if target == GoalStatusApproved {
+			updates["approver_id"] = c.GetUint("user_id")
+			updates["approved_at"] = time.Now()
+			updates["reject_reason"] = ""
+		} else {
+			if req.Reason == "" {
+				return &TransitionError{
+					StatusCode: http.StatusBadRequest,
+					Message:    "请填写退回原因",
+				}
+			}
+			updates["reject_reason"] = req.Reason
+		}
+		return changeGoalStatus(tx, c, goal, target, updates)
+	})
+	if err != nil {
+		respondGoalError(c, err, "审批个人目标失败")
+		return
+	}
+
+	// 通知员工审批结果
+	result := "✅ 您的个人目标已审批通过"
+	if target == GoalStatusRejected {
+		result = "↩️ 您的个人目标已被退回，请修改后重新提交"
+	}
+	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+	dooTaskClient.SendBotMessage(goal.Employee.DooTaskUserID, fmt.Sprintf(
+		"### %s。\n\n- **目标：** %s\n- **审批人：** %s\n- **说明：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+		result,
+		goal.Objective,
+		c.GetString("user_name"),
+		req.Reason,
+	))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("个人目标%s", getGoalStatusText(target)),
+		"data":    goal,
+	})
+}
+
+// 在评估的对应阶段填写目标评分，阶段和身份与考核项目的评分规则相同
+func updateGoalScore(c *gin.Context, field string) {
+	goal, ok := loadGoal(c)
+	if !ok {
+		return
+	}
+
+	var req GoalScoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	rule := scoreWriteRules[field]
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if goal.Status != GoalStatusApproved {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "只有审批通过的目标可以评分",
+			}
+		}
+		evaluation, err := getGoalEvaluation(tx, goal)
+		if err != nil {
+			return err
+		}
+		if evaluation == nil {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "目标尚未关联评估，评估发起后才能评分",
+			}
+		}
+		if err := requireGoalActor(goal, c, rule.Actors...); err != nil {
+			return err
+		}
+		if evaluation.Status != rule.Status {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("评估当前处于「%s」阶段，不能填写目标%s", getStatusText(evaluation.Status), rule.Label),
+			}
+		}
+		if req.Score != nil && (math.IsNaN(*req.Score) || *req.Score < 0 || *req.Score > goalMaxScore) {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("目标评分必须在 0 到 %g 之间", goalMaxScore),
+			}
+		}
+
+		before := *goal
+		if err := tx.Model(goal).Updates(map[string]interface{}{
+			field + "_score":   req.Score,
+			field + "_comment": req.Comment,
+		}).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityGoal, goal.ID, before, *goal)
+	})
+	if err != nil {
+		respondGoalError(c, err, "更新目标评分失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("目标%s更新成功", rule.Label),
+		"data":    goal,
+	})
+}
+
+// 变更目标状态并记录审计
+func changeGoalStatus(tx *gorm.DB, c *gin.Context, goal *models.EmployeeGoal, target string, updates map[string]interface{}) error {
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["status"] = target
+
+	before := *goal
+	if err := tx.Model(goal).Updates(updates).Error; err != nil {
+		return err
+	}
+	return auditUpdate(tx, c, AuditEntityGoal, goal.ID, before, *goal)
+}
+
+// 根据请求设置目标内容并校验
+func applyGoalRequest(goal *models.EmployeeGoal, req *GoalRequest) error {
+	if req.Weight <= 0 || req.Weight > 100 {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "目标权重必须大于0且不超过100",
+		}
+	}
+	for i, kr := range req.KeyResults {
+		if kr.Title == "" {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("第 %d 个关键结果缺少标题", i+1),
+			}
+		}
+	}
+
+	goal.Objective = req.Objective
+	goal.Description = req.Description
+	goal.Weight = req.Weight
+	goal.Progress = 0
+	return nil
+}
+
+// 按请求顺序创建关键结果
+func saveGoalKeyResults(tx *gorm.DB, goal *models.EmployeeGoal, requests []GoalKeyResultRequest) error {
+	goal.KeyResults = nil
+	for i, req := range requests {
+		kr := models.GoalKeyResult{
+			GoalID: goal.ID,
+			Title:  req.Title,
+			Target: req.Target,
+			Order:  i + 1,
+		}
+		if err := tx.Create(&kr).Error; err != nil {
+			return err
+		}
+		goal.KeyResults = append(goal.KeyResults, kr)
+	}
+	return nil
+}
+
+// 确定新目标关联的考核周期和评估：指定评估时使用评估的周期，只指定周期时关联该周期中已发起的评估
+func resolveGoalEvaluation(tx *gorm.DB, goal *models.EmployeeGoal) error {
+	if goal.EvaluationID != nil {
+		var evaluation models.KPIEvaluation
+		if err := tx.First(&evaluation, *goal.EvaluationID).Error; err != nil || evaluation.EmployeeID != goal.EmployeeID {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "评估不存在或不属于该员工",
+			}
+		}
+		goal.CycleID = evaluation.CycleID
+	} else if goal.CycleID != nil {
+		var cycle models.ReviewCycle
+		if err := tx.First(&cycle, *goal.CycleID).Error; err != nil {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "考核周期不存在",
+			}
+		}
+		var evaluation models.KPIEvaluation
+		err := tx.Where("cycle_id = ? AND employee_id = ?", cycle.ID, goal.EmployeeID).First(&evaluation).Error
+		if err == nil {
+			goal.EvaluationID = &evaluation.ID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	} else {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "请指定考核周期或评估",
+		}
+	}
+
+	return requireGoalSettingOpen(tx, goal)
+}
+
+// 检查目标设定阶段未结束：考核周期未关闭，关联的评估尚未提交自评
+func requireGoalSettingOpen(tx *gorm.DB, goal *models.EmployeeGoal) error {
+	if goal.CycleID != nil {
+		var cycle models.ReviewCycle
+		if err := tx.First(&cycle, *goal.CycleID).Error; err == nil &&
+			(cycle.Status == CycleStatusClosed || cycle.Status == CycleStatusArchived) {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("考核周期「%s」%s，不能再设定目标", cycle.Name, getCycleStatusText(cycle.Status)),
+			}
+		}
+	}
+
+	evaluation, err := getGoalEvaluation(tx, goal)
+	if err != nil {
+		return err
+	}
+	if evaluation != nil && evaluation.Status != EvaluationStatusPending {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估已进入「%s」阶段，不能再设定目标", getStatusText(evaluation.Status)),
+		}
+	}
+	return nil
+}
+
+// 获取目标关联的评估，未关联时返回空
+func getGoalEvaluation(tx *gorm.DB, goal *models.EmployeeGoal) (*models.KPIEvaluation, error) {
+	if goal.EvaluationID == nil {
+		return nil, nil
+	}
+	var evaluation models.KPIEvaluation
+	if err := tx.First(&evaluation, *goal.EvaluationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &evaluation, nil
+}
+
+// 检查当前用户相对于目标所属员工的身份
+// goal 需要预加载 Employee
+func requireGoalActor(goal *models.EmployeeGoal, c *gin.Context, allowed ...string) error {
+	evaluation := models.KPIEvaluation{EmployeeID: goal.EmployeeID, Employee: goal.Employee}
+	actors := getEvaluationActors(&evaluation, c.GetUint("user_id"), c.GetString("user_role"))
+	// 审批、上级评分等不允许员工本人执行的操作，本人同时是HR时也不能操作自己的目标
+	if !slices.Contains(allowed, ActorSelf) && slices.Contains(actors, ActorSelf) {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    "不能审批或评价自己的目标",
+		}
+	}
+	if !slices.ContainsFunc(allowed, func(actor string) bool { return slices.Contains(actors, actor) }) {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    "无权限操作该目标",
+		}
+	}
+	return nil
+}
+
+// 周期发起评估后，将员工在该周期设定的目标关联到评估
+func linkCycleGoals(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	if evaluation.CycleID == nil {
+		return nil
+	}
+	return tx.Model(&models.EmployeeGoal{}).
+		Where("cycle_id = ? AND employee_id = ? AND evaluation_id IS NULL", *evaluation.CycleID, evaluation.EmployeeID).
+		Update("evaluation_id", evaluation.ID).Error
+}
+
+// 获取评估关联的个人目标
+func getEvaluationGoals(tx *gorm.DB, evaluationID uint) ([]models.EmployeeGoal, error) {
+	var goals []models.EmployeeGoal
+	err := tx.Preload("KeyResults", orderedKeyResults).Preload("Approver").
+		Where("evaluation_id = ?", evaluationID).
+		Order("created_at").
+		Find(&goals).Error
+	return goals, err
+}
+
+// 确定评估中已通过目标的最终得分（上级评分 > 自评分数），返回按权重加权的目标得分，没有已评分的目标时返回空
+func scoreEvaluationGoals(tx *gorm.DB, evaluation *models.KPIEvaluation) (*float64, error) {
+	var goals []models.EmployeeGoal
+	if err := tx.Where("evaluation_id = ? AND status = ?", evaluation.ID, GoalStatusApproved).Find(&goals).Error; err != nil {
+		return nil, err
+	}
+
+	sum, weights := 0.0, 0.0
+	for _, goal := range goals {
+		final := goal.ManagerScore
+		if final == nil {
+			final = goal.SelfScore
+		}
+		if err := tx.Model(&models.EmployeeGoal{}).Where("id = ?", goal.ID).Update("final_score", final).Error; err != nil {
+			return nil, err
+		}
+		// 计算得出的分数由系统记录
+		if err := auditField(tx, nil, AuditEntityGoal, goal.ID, "final_score", goal.FinalScore, final); err != nil {
+			return nil, err
+		}
+		if final == nil || goal.Weight <= 0 {
+			continue
+		}
+		sum += *final * goal.Weight
+		weights += goal.Weight
+	}
+
+	if weights == 0 {
+		return nil, nil
+	}
+	score := roundScore(sum / weights)
+	return &score, nil
+}
+
+// 前置条件：评估关联的目标已完成审批，且已通过的目标都填写了指定评分
+func requireGoalsScored(column, label string) func(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	return func(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+		var submitted int64
+		if err := tx.Model(&models.EmployeeGoal{}).
+			Where("evaluation_id = ? AND status = ?", evaluation.ID, GoalStatusSubmitted).
+			Count(&submitted).Error; err != nil {
+			return err
+		}
+		if submitted > 0 {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("还有 %d 个个人目标等待上级审批", submitted),
+			}
+		}
+
+		var missing int64
+		if err := tx.Model(&models.EmployeeGoal{}).
+			Where("evaluation_id = ? AND status = ? AND "+column+" IS NULL", evaluation.ID, GoalStatusApproved).
+			Count(&missing).Error; err != nil {
+			return err
+		}
+		if missing > 0 {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("还有 %d 个个人目标未填写%s", missing, label),
+			}
+		}
+		return nil
+	}
+}
+
+// 关键结果按顺序排列
+func orderedKeyResults(db *gorm.DB) *gorm.DB {
+	return orderedBySortOrder(db)
+}
+
+// 根据路由参数加载个人目标，不存在时返回错误响应
+func loadGoal(c *gin.Context) (*models.EmployeeGoal, bool) {
+	id := c.Param("id")
+	goalId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的目标ID",
+		})
+		return nil, false
+	}
+
+	var goal models.EmployeeGoal
+	if err := models.DB.Preload("Employee").First(&goal, goalId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "个人目标不存在",
+		})
+		return nil, false
+	}
+
+	return &goal, true
+}
+
+// 返回个人目标操作的错误，业务错误使用对应的状态码
+func respondGoalError(c *gin.Context, err error, message string) {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		c.JSON(transitionErr.StatusCode, gin.H{
+			"error": transitionErr.Message,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   message,
+		"message": err.Error(),
+	})
+}
+
+// 获取个人目标状态文本
+func getGoalStatusText(status string) string {
+	switch status {
+	case GoalStatusDraft:
+		return "草稿"
+	case GoalStatusSubmitted:
+		return "待审批"
+	case GoalStatusApproved:
+		return "已通过"
+	case GoalStatusRejected:
+		return "已退回"
+	default:
+		return "未知状态"
+	}
+}
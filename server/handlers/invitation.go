@@ -9,6 +9,7 @@ import (
 	"dootask-kpi-server/utils"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 邀请评分相关API
@@ -66,9 +67,9 @@ func CreateInvitation(c *gin.Context) {
 		return
 	}
 
-	// 获取评估的KPI项目
+	// 获取评估所用模板版本的KPI项目
 	var items []models.KPIItem
-	if err := models.DB.Where("template_id = ?", evaluation.TemplateID).Find(&items).Error; err != nil {
+	if err := models.DB.Where("template_id = ? AND version = ?", evaluation.TemplateID, evaluation.TemplateVersion).Find(&items).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取评估项目失败"})
 		return
 	}
@@ -106,9 +107,18 @@ func CreateInvitation(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建邀请失败"})
 			return
 		}
+		if err := auditCreate(tx, c, AuditEntityInvitation, invitation.ID, invitation); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "记录审计日志失败"})
+			return
+		}
 
 		// 为每个KPI项目创建评分记录
 		for _, item := range items {
+			// 分类不直接评分
+			if isCategoryItem(&item) {
+				continue
+			}
 			invitedScore := models.InvitedScore{
 				InvitationID: invitation.ID,
 				ItemID:       item.ID,
@@ -385,8 +395,15 @@ func AcceptInvitation(c *gin.Context) {
 	}
 
 	// 更新邀请状态
+	previousStatus := invitation.Status
 	invitation.Status = "accepted"
-	if err := models.DB.Save(&invitation).Error; err != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&invitation).Error; err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityInvitation, invitation.ID, "status", previousStatus, invitation.Status)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新邀请状态失败"})
 		return
 	}
@@ -436,8 +453,15 @@ func DeclineInvitation(c *gin.Context) {
 	}
 
 	// 更新邀请状态
+	previousStatus := invitation.Status
 	invitation.Status = "declined"
-	if err := models.DB.Save(&invitation).Error; err != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&invitation).Error; err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityInvitation, invitation.ID, "status", previousStatus, invitation.Status)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新邀请状态失败"})
 		return
 	}
@@ -519,7 +543,7 @@ func UpdateInvitedScore(c *gin.Context) {
 
 	// 验证评分记录是否存在
 	var score models.InvitedScore
-	if err := models.DB.Preload("Invitation").First(&score, scoreIDUint).Error; err != nil {
+	if err := models.DB.Preload("Invitation.Evaluation").Preload("Item").First(&score, scoreIDUint).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "评分记录不存在"})
 		return
 	}
@@ -536,6 +560,12 @@ func UpdateInvitedScore(c *gin.Context) {
 		return
 	}
 
+	// 邀请评分计入最终得分，只能在HR审核阶段填写，评估完成后不能修改
+	if status := score.Invitation.Evaluation.Status; status != EvaluationStatusManagerEvaluated {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("评估当前处于「%s」阶段，不能填写邀请评分", getStatusText(status))})
+		return
+	}
+
 	var updateData struct {
 		Score   *float64 `json:"score"`
 		Comment string   `json:"comment"`
@@ -546,11 +576,25 @@ func UpdateInvitedScore(c *gin.Context) {
 		return
 	}
 
+	if updateData.Score != nil {
+		if err := checkScoreRange(&score.Item, *updateData.Score); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	before := score
 	// 更新评分
-	if err := models.DB.Model(&score).Updates(map[string]interface{}{
-		"score":   updateData.Score,
-		"comment": updateData.Comment,
-	}).Error; err != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"score":   updateData.Score,
+			"comment": updateData.Comment,
+		}).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityInvitedScore, score.ID, before, score)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新评分失败"})
 		return
 	}
@@ -613,8 +657,15 @@ func CompleteInvitation(c *gin.Context) {
 	}
 
 	// 更新邀请状态为已完成
+	previousStatus := invitation.Status
 	invitation.Status = "completed"
-	if err := models.DB.Save(&invitation).Error; err != nil {
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&invitation).Error; err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityInvitation, invitation.ID, "status", previousStatus, invitation.Status)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新邀请状态失败"})
 		return
 	}
@@ -724,7 +775,14 @@ func CancelInvitation(c *gin.Context) {
 	}
 
 	// 更新邀请状态为已撤销
-	if err := models.DB.Model(&invitation).Update("status", "cancelled").Error; err != nil {
+	previousStatus := invitation.Status
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&invitation).Update("status", "cancelled").Error; err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityInvitation, invitation.ID, "status", previousStatus, "cancelled")
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销邀请失败"})
 		return
 	}
@@ -783,7 +841,14 @@ func ReinviteInvitation(c *gin.Context) {
 	}
 
 	// 更新邀请状态为待接受
-	if err := models.DB.Model(&invitation).Update("status", "pending").Error; err != nil {
+	previousStatus := invitation.Status
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&invitation).Update("status", "pending").Error; err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntityInvitation, invitation.ID, "status", previousStatus, "pending")
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新邀请失败"})
 		return
 	}
@@ -865,6 +930,11 @@ func DeleteInvitation(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除邀请失败"})
 		return
 	}
+	if err := auditDelete(tx, c, AuditEntityInvitation, invitation.ID, invitation); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "记录审计日志失败"})
+		return
+	}
 
 	// 提交事务
 	if err := tx.Commit().Error; err != nil {
@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"dootask-kpi-server/models"
 	"dootask-kpi-server/utils"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // KPI项目管理
@@ -25,8 +28,55 @@ func CreateItem(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Create(&item)
+	if err := validateItemMetric(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "考核项目配置错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.First(&template, item.TemplateID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	tx := models.DB.Begin()
+
+	// 模板当前版本已被评估使用时，在新版本中添加项目
+	itemMap, err := prepareTemplateEdit(tx, c, &template, fmt.Sprintf("新增考核项目「%s」", item.Name))
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建模板新版本失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if item.ParentID != nil {
+		if newID, ok := itemMap[*item.ParentID]; ok {
+			item.ParentID = &newID
+		}
+	}
+
+	item.ID = 0
+	item.Version = template.Version
+	item.OriginID = 0
+	if err := validateItemHierarchy(tx, &item); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "考核项目配置错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result := tx.Create(&item)
 	if result.Error != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "创建KPI项目失败",
 			"message": result.Error.Error(),
@@ -34,6 +84,17 @@ func CreateItem(c *gin.Context) {
 		return
 	}
 
+	if err := auditCreate(tx, c, AuditEntityItem, item.ID, item); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "记录审计日志失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx.Commit()
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "KPI项目创建成功",
 		"data":    item,
@@ -65,6 +126,13 @@ func GetItem(c *gin.Context) {
 	})
 }
 
+// 更新KPI项目请求结构，MaxScore、Weight 为空表示不修改满分和权重
+type UpdateItemRequest struct {
+	models.KPIItem
+	MaxScore *float64 `json:"max_score"`
+	Weight   *float64 `json:"weight"`
+}
+
 // 更新KPI项目
 func UpdateItem(c *gin.Context) {
 	id := c.Param("id")
@@ -85,17 +153,69 @@ func UpdateItem(c *gin.Context) {
 		return
 	}
 
-	var updateData models.KPIItem
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	var template models.KPITemplate
+	if err := models.DB.First(&template, item.TemplateID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	// 历史版本的项目不允许修改
+	if item.Version != template.Version {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "只能修改模板当前版本的考核项目",
+		})
+		return
+	}
+
+	var req UpdateItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "请求参数错误",
 			"message": err.Error(),
 		})
 		return
 	}
+	updateData := req.KPIItem
+
+	// 所属模板和版本信息不允许修改
+	updateData.TemplateID = 0
+	updateData.Version = 0
+	updateData.OriginID = 0
+
+	// parent_id 为 0 表示移出分类
+	moveToTop := updateData.ParentID != nil && *updateData.ParentID == 0
+	if moveToTop {
+		updateData.ParentID = nil
+	}
+
+	tx := models.DB.Begin()
 
-	result = models.DB.Model(&item).Updates(updateData)
+	// 模板当前版本已被评估使用时，修改新版本中对应的项目
+	itemMap, err := prepareTemplateEdit(tx, c, &template, fmt.Sprintf("修改考核项目「%s」", item.Name))
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建模板新版本失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if newID, ok := itemMap[item.ID]; ok {
+		item = models.KPIItem{}
+		tx.First(&item, newID)
+	}
+	if updateData.ParentID != nil {
+		if newID, ok := itemMap[*updateData.ParentID]; ok {
+			updateData.ParentID = &newID
+		}
+	}
+
+	before := item
+	result = tx.Model(&item).Updates(updateData)
 	if result.Error != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新KPI项目失败",
 			"message": result.Error.Error(),
@@ -103,16 +223,76 @@ func UpdateItem(c *gin.Context) {
 		return
 	}
 
-	if updateData.MaxScore == 0 {
-		result = models.DB.Model(&item).Update("max_score", 0)
-		if result.Error != nil {
+	// 满分、权重（包括设为0）和移出分类需要单独写入，未传满分或权重时保持原值
+	if req.MaxScore != nil {
+		if err := tx.Model(&item).Update("max_score", *req.MaxScore).Error; err != nil {
+			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "更新MaxScore失败",
-				"message": result.Error.Error(),
+				"error":   "更新满分失败",
+				"message": err.Error(),
 			})
 			return
 		}
 	}
+	if req.Weight != nil {
+		if err := tx.Model(&item).Update("weight", *req.Weight).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "更新权重失败",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	if moveToTop {
+		if err := tx.Model(&item).Update("parent_id", nil).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "更新所属分类失败",
+				"message": err.Error(),
+			})
+			return
+		}
+		item.ParentID = nil
+	}
+
+	// 改为其他计分曲线时清除封顶比例
+	if updateData.ScoringCurve != "" && updateData.ScoringCurve != ScoringCurveCapped && item.CapPercent != nil {
+		if err := tx.Model(&item).Update("cap_percent", nil).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "更新封顶比例失败",
+				"message": err.Error(),
+			})
+			return
+		}
+		item.CapPercent = nil
+	}
+
+	// 校验修改后的考核项目配置
+	err = validateItemMetric(&item)
+	if err == nil {
+		err = validateItemHierarchy(tx, &item)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "考核项目配置错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := auditUpdate(tx, c, AuditEntityItem, item.ID, before, item); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "记录审计日志失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx.Commit()
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "KPI项目更新成功",
@@ -131,8 +311,61 @@ func DeleteItem(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Delete(&models.KPIItem{}, itemId)
+	var item models.KPIItem
+	if err := models.DB.First(&item, itemId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "KPI项目不存在",
+		})
+		return
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.First(&template, item.TemplateID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	// 历史版本的项目不允许删除
+	if item.Version != template.Version {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "只能删除模板当前版本的考核项目",
+		})
+		return
+	}
+
+	tx := models.DB.Begin()
+
+	// 模板当前版本已被评估使用时，从新版本中删除项目，历史版本和评分保持不变
+	itemMap, err := prepareTemplateEdit(tx, c, &template, fmt.Sprintf("删除考核项目「%s」", item.Name))
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建模板新版本失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if newID, ok := itemMap[item.ID]; ok {
+		item = models.KPIItem{}
+		tx.First(&item, newID)
+	}
+
+	// 分类下还有子项目时不允许删除
+	var children int64
+	tx.Model(&models.KPIItem{}).Where("parent_id = ?", item.ID).Count(&children)
+	if children > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请先删除或移出该分类下的考核项目",
+		})
+		return
+	}
+
+	result := tx.Delete(&models.KPIItem{}, item.ID)
 	if result.Error != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "删除KPI项目失败",
 			"message": result.Error.Error(),
@@ -140,6 +373,17 @@ func DeleteItem(c *gin.Context) {
 		return
 	}
 
+	if err := auditDelete(tx, c, AuditEntityItem, item.ID, item); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "记录审计日志失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx.Commit()
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "KPI项目删除成功",
 	})
@@ -162,6 +406,8 @@ func GetEvaluations(c *gin.Context) {
 	yearStr := c.Query("year")
 	monthStr := c.Query("month")
 	quarterStr := c.Query("quarter")
+	cycleID := c.Query("cycle_id")
+	grade := c.Query("grade")
 
 	// 验证分页参数
 	if page < 1 {
@@ -171,8 +417,9 @@ func GetEvaluations(c *gin.Context) {
 		pageSize = 10
 	}
 
-	// 构建查询
-	query := models.DB.Preload("Employee.Department").Preload("Template").Preload("Scores").Preload("Scores.Item")
+	// 构建查询（只返回当前用户可查看的评估）
+	scope := getDataScope(c)
+	query := models.DB.Scopes(scope.Evaluations).Preload("Employee.Department").Preload("Template").Preload("Scores").Preload("Scores.Item")
 
 	// 添加筛选条件
 	if status != "" {
@@ -201,10 +448,16 @@ func GetEvaluations(c *gin.Context) {
 		quarter, _ := strconv.Atoi(quarterStr)
 		query = query.Where("quarter = ?", quarter)
 	}
+	if cycleID != "" {
+		query = query.Where("cycle_id = ?", cycleID)
+	}
+	if grade != "" {
+		query = query.Where("grade = ?", grade)
+	}
 
 	// 获取总数
 	var total int64
-	countQuery := models.DB.Model(&models.KPIEvaluation{})
+	countQuery := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations)
 	if status != "" {
 		countQuery = countQuery.Where("status = ?", status)
 	}
@@ -231,6 +484,12 @@ func GetEvaluations(c *gin.Context) {
 		quarter, _ := strconv.Atoi(quarterStr)
 		countQuery = countQuery.Where("quarter = ?", quarter)
 	}
+	if cycleID != "" {
+		countQuery = countQuery.Where("cycle_id = ?", cycleID)
+	}
+	if grade != "" {
+		countQuery = countQuery.Where("grade = ?", grade)
+	}
 	if err := countQuery.Count(&total).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "获取评估总数失败",
@@ -264,11 +523,23 @@ func GetEvaluations(c *gin.Context) {
 	})
 }
 
+// 创建评估请求结构
+// 新评估总是从待自评开始，状态、总分、等级、校准和标准化结果由各自的流程维护
+type CreateEvaluationRequest struct {
+	EmployeeID uint   `json:"employee_id"`
+	TemplateID uint   `json:"template_id"` // 为空时按模板分配规则确定
+	Period     string `json:"period"`      // monthly, quarterly, yearly
+	Year       int    `json:"year"`
+	Month      *int   `json:"month"`
+	Quarter    *int   `json:"quarter"`
+	CycleID    *uint  `json:"cycle_id"` // 所属考核周期
+}
+
 // 创建评估
 func CreateEvaluation(c *gin.Context) {
-	var evaluation models.KPIEvaluation
+	var req CreateEvaluationRequest
 
-	if err := c.ShouldBindJSON(&evaluation); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "请求参数错误",
 			"message": err.Error(),
@@ -276,83 +547,199 @@ func CreateEvaluation(c *gin.Context) {
 		return
 	}
 
+	if req.CycleID != nil {
+		var cycle models.ReviewCycle
+		if err := models.DB.First(&cycle, *req.CycleID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "考核周期不存在",
+			})
+			return
+		}
+	}
+
+	evaluation := models.KPIEvaluation{
+		EmployeeID: req.EmployeeID,
+		TemplateID: req.TemplateID,
+		Period:     req.Period,
+		Year:       req.Year,
+		Month:      req.Month,
+		Quarter:    req.Quarter,
+		CycleID:    req.CycleID,
+		Status:     EvaluationStatusPending,
+	}
+
+	// 未指定模板时按模板分配规则确定
+	var resolution *TemplateResolution
+	if evaluation.TemplateID == 0 {
+		var employee models.Employee
+		if err := models.DB.First(&employee, evaluation.EmployeeID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "员工不存在",
+			})
+			return
+		}
+		rules, err := loadAssignmentRules(models.DB, evaluation.Period)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "获取模板分配规则失败",
+				"message": err.Error(),
+			})
+			return
+		}
+		resolution = resolveEmployeeTemplate(nil, rules, &employee)
+		if resolution.TemplateID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("员工【%s】没有匹配的模板分配规则，请指定考核模板", employee.Name),
+			})
+			return
+		}
+		evaluation.TemplateID = *resolution.TemplateID
+	}
+
+	// 已停用的模板不能用于新的评估
+	var template models.KPITemplate
+	if err := models.DB.First(&template, evaluation.TemplateID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "考核模板不存在",
+		})
+		return
+	}
+	if !template.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("考核模板「%s」已停用", template.Name),
+		})
+		return
+	}
+
 	// 开始数据库事务
 	tx := models.DB.Begin()
 
-	row := models.KPIEvaluation{}
-	result := tx.Preload("Employee").
-		Where("employee_id = ? AND template_id = ? AND period = ? AND year = ? AND month = ?",
-			evaluation.EmployeeID, evaluation.TemplateID, evaluation.Period, evaluation.Year, evaluation.Month).
-		Find(&row).Limit(1)
-	if result.Error != nil {
+	existing, err := findExistingEvaluation(tx, &evaluation)
+	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "创建评估失败",
 		})
 		return
 	}
-	if row.ID > 0 {
+	if existing != nil {
 		tx.Rollback()
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("员工【%s】评估记录已存在", row.Employee.Name),
+			"error": fmt.Sprintf("员工【%s】评估记录已存在", existing.Employee.Name),
 		})
 		return
 	}
 
-	// 创建评估记录
-	result = tx.Create(&evaluation)
-	if result.Error != nil {
+	// 创建评估记录及评分记录
+	if err := createEvaluationWithScores(tx, c, &evaluation); err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "创建评估失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
 
-	// 获取模板的所有KPI项目
-	var items []models.KPIItem
-	tx.Where("template_id = ?", evaluation.TemplateID).Find(&items)
+	tx.Commit()
+
+	// 获取完整的评估信息
+	models.DB.Preload("Employee.Department").Preload("Template").Preload("Scores").First(&evaluation, evaluation.ID)
+
+	// 发送通知
+	notifyEvaluationCreated(&evaluation, c.GetHeader("DooTaskAuth"), c.GetUint("user_id"), c.GetString("user_name"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "评估创建成功",
+		"data":       evaluation,
+		"assignment": resolution,
+	})
+}
+
+// 查找同一员工、模板、考核周期的已有评估
+func findExistingEvaluation(tx *gorm.DB, evaluation *models.KPIEvaluation) (*models.KPIEvaluation, error) {
+	query := tx.Preload("Employee").
+		Where("employee_id = ? AND template_id = ?", evaluation.EmployeeID, evaluation.TemplateID)
+	query = wherePeriod(query, evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter)
+
+	var row models.KPIEvaluation
+	if err := query.Limit(1).Find(&row).Error; err != nil {
+		return nil, err
+	}
+	if row.ID == 0 {
+		return nil, nil
+	}
+	return &row, nil
+}
+
+// 精确匹配考核周期（月份、季度为空时匹配空值）
+func wherePeriod(query *gorm.DB, period string, year int, month, quarter *int) *gorm.DB {
+	query = query.Where("period = ? AND year = ?", period, year)
+	if month != nil {
+		query = query.Where("month = ?", *month)
+	} else {
+		query = query.Where("month IS NULL")
+	}
+	if quarter != nil {
+		query = query.Where("quarter = ?", *quarter)
+	} else {
+		query = query.Where("quarter IS NULL")
+	}
+	return query
+}
+
+// 创建评估记录，并为模板的每个KPI项目创建评分记录
+// c 为空表示由系统任务创建
+func createEvaluationWithScores(tx *gorm.DB, c *gin.Context, evaluation *models.KPIEvaluation) error {
+	// 评估固定使用创建时的模板版本
+	var template models.KPITemplate
+	if err := tx.First(&template, evaluation.TemplateID).Error; err != nil {
+		return err
+	}
+	evaluation.TemplateVersion = template.Version
+
+	if err := tx.Create(evaluation).Error; err != nil {
+		return err
+	}
+	if err := auditCreate(tx, c, AuditEntityEvaluation, evaluation.ID, evaluation); err != nil {
+		return err
+	}
+
+	items, err := getTemplateVersionItems(tx, template.ID, template.Version)
+	if err != nil {
+		return err
+	}
 
-	// 为每个KPI项目创建评分记录
 	for _, item := range items {
+		// 分类不直接评分
+		if isCategoryItem(&item) {
+			continue
+		}
 		score := models.KPIScore{
 			EvaluationID: evaluation.ID,
 			ItemID:       item.ID,
 		}
 		if err := tx.Create(&score).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "创建评分记录失败",
-				"message": err.Error(),
-			})
-			return
+			return fmt.Errorf("创建评分记录失败: %w", err)
 		}
 	}
 
-	tx.Commit()
-
-	// 获取完整的评估信息
-	models.DB.Preload("Employee.Department").Preload("Template").Preload("Scores").First(&evaluation, evaluation.ID)
+	// 关联员工在考核周期中设定的个人目标
+	return linkCycleGoals(tx, evaluation)
+}
 
-	// 发送 DooTask 机器人通知
-	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+// 发送评估创建通知（DooTask 机器人通知和实时通知）
+// evaluation 需要预加载 Employee 和 Template
+func notifyEvaluationCreated(evaluation *models.KPIEvaluation, dooTaskToken string, operatorID uint, operatorName string) {
+	dooTaskClient := utils.NewDooTaskClient(dooTaskToken)
 	dooTaskClient.SendBotMessage(evaluation.Employee.DooTaskUserID, fmt.Sprintf(
 		"### 📋 您有新的考核任务，请及时处理。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **考核时间：** %s\n- **发起人：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
 		evaluation.Template.Name,
 		utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter),
 		evaluation.CreatedAt.Format("2006-01-02"),
-		c.GetString("user_name"),
+		operatorName,
 	))
 
-	// 发送实时通知
-	operatorID := c.GetUint("user_id")
-	GetNotificationService().SendNotification(operatorID, EventEvaluationCreated, &evaluation)
-
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "评估创建成功",
-		"data":    evaluation,
-	})
+	GetNotificationService().SendNotification(operatorID, EventEvaluationCreated, evaluation)
 }
 
 // 获取单个评估
@@ -367,7 +754,10 @@ func GetEvaluation(c *gin.Context) {
 	}
 
 	var evaluation models.KPIEvaluation
-	result := models.DB.Preload("Employee.Department").Preload("Template").Preload("Scores.Item").First(&evaluation, evaluationId)
+	result := models.DB.Preload("Employee.Department").Preload("Template.ScoringPolicy").Preload("Cycle").Preload("Scores.Item").Preload("Breakdowns").
+		Preload("Appeals", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Preload("Appeals.Employee").Preload("Appeals.Resolver").Preload("Appeals.Items.Item").
+		First(&evaluation, evaluationId)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "评估不存在",
@@ -375,143 +765,449 @@ func GetEvaluation(c *gin.Context) {
 		return
 	}
 
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
+
+	// 分类小计
+	categories, err := getCategorySubtotals(models.DB, evaluation.Scores)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取分类小计失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 评级量表及各考核项目的评分标准
+	scale, err := getEvaluationRatingScale(models.DB, &evaluation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评级量表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 个人目标
+	goals, err := getEvaluationGoals(models.DB, evaluation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取个人目标失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 评审链的评审人
+	reviewers, err := getEvaluationReviewers(models.DB, evaluation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评审人失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"data": evaluation,
+		"data":         evaluation,
+		"categories":   categories,
+		"rating_scale": scale,
+		"rubric":       buildRubric(scale, evaluation.Scores),
+		"goals":        goals,
+		"reviewers":    reviewers,
 	})
 }
 
+// 更新评估请求结构
+// 总分、模板版本、截止时间等字段由各自的流程维护，不能通过该接口修改
+type UpdateEvaluationRequest struct {
+	Status       string  `json:"status"`        // 目标状态，按状态流转规则变更
+	FinalComment *string `json:"final_comment"` // 最终评语
+}
+
 // 更新评估
 func UpdateEvaluation(c *gin.Context) {
 	id := c.Param("id")
 	evaluationId, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的评估ID",
+			"error": "无效的评估ID",
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	result := models.DB.Preload("Employee").First(&evaluation, evaluationId)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
+
+	var req UpdateEvaluationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 已完成的评估锁定，需要HR重新开放后才能修改
+	if evaluation.Status == EvaluationStatusCompleted {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "评估已完成，如需修改请由HR重新开放评估",
+		})
+		return
+	}
+
+	// 最终评语由上级或HR填写
+	if req.FinalComment != nil {
+		actors := getEvaluationActors(&evaluation, c.GetUint("user_id"), c.GetString("user_role"))
+		if !slices.Contains(actors, ActorManager) && !slices.Contains(actors, ActorHR) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "只有上级或HR可以填写最终评语",
+			})
+			return
+		}
+	}
+
+	before := evaluation
+	statusChanged := req.Status != "" && req.Status != evaluation.Status
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if req.FinalComment != nil {
+			if err := tx.Model(&evaluation).Update("final_comment", *req.FinalComment).Error; err != nil {
+				return err
+			}
+		}
+
+		// 状态变更必须经过状态流转规则
+		if statusChanged {
+			if err := TransitionEvaluation(tx, &evaluation, req.Status, c.GetUint("user_id"), c.GetString("user_role")); err != nil {
+				return err
+			}
+		}
+
+		return auditUpdate(tx, c, AuditEntityEvaluation, evaluation.ID, &before, &evaluation)
+	})
+	if err != nil {
+		if transitionErr, ok := err.(*TransitionError); ok {
+			c.JSON(transitionErr.StatusCode, gin.H{
+				"error": transitionErr.Message,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新评估失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 重新加载更新后的数据
+	models.DB.Preload("Employee.Manager").Preload("Template").First(&evaluation, evaluationId)
+
+	// 发送DooTask机器人通知（根据状态变更）
+	if statusChanged {
+		dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+		periodValue := utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter)
+
+		switch evaluation.Status {
+		case EvaluationStatusSelfEvaluated:
+			// 完成自评：配置了评审链时通知第一阶段的评审人，否则通知主管
+			if hasReviewChain(models.DB, evaluation.ID) {
+				notifyCurrentReviewers(c, &evaluation)
+			} else if evaluation.Employee.Manager != nil && evaluation.Employee.Manager.DooTaskUserID != nil {
+				message := fmt.Sprintf(
+					"### 📋 「%s」已完成自评，请您进行主管评估。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **员工姓名：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+					evaluation.Employee.Name,
+					evaluation.Template.Name,
+					periodValue,
+					evaluation.Employee.Name,
+				)
+				dooTaskClient.SendBotMessage(evaluation.Employee.Manager.DooTaskUserID, message)
+			}
+
+		case EvaluationStatusPendingConfirm:
+			// 完成HR审核：通知员工确认
+			if evaluation.Employee.DooTaskUserID != nil {
+				message := fmt.Sprintf(
+					"### 📋 您的考核已完成HR审核，请确认最终得分。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **总分：** %.1f\n\n> 请前往「应用 - 绩效考核」中查看详情并确认。",
+					evaluation.Template.Name,
+					periodValue,
+					evaluation.TotalScore,
+				)
+				dooTaskClient.SendBotMessage(evaluation.Employee.DooTaskUserID, message)
+			}
+		}
+	}
+
+	// 发送实时通知
+	operatorID := c.GetUint("user_id")
+	if statusChanged {
+		// 状态变更通知
+		GetNotificationService().SendNotification(operatorID, EventEvaluationStatusChange, &evaluation)
+	} else {
+		// 一般更新通知
+		GetNotificationService().SendNotification(operatorID, EventEvaluationUpdated, &evaluation)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评估更新成功",
+		"data":    evaluation,
+	})
+}
+
+// 退回评估到上一阶段
+func RejectEvaluation(c *gin.Context) {
+	id := c.Param("id")
+	evaluationId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评估ID",
+		})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请填写退回原因",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.Preload("Employee").First(&evaluation, evaluationId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	operatorID := c.GetUint("user_id")
+	previousStatus := evaluation.Status
+
+	tx := models.DB.Begin()
+
+	if err := ReturnEvaluation(tx, &evaluation, operatorID, c.GetString("user_role")); err != nil {
+		tx.Rollback()
+		if transitionErr, ok := err.(*TransitionError); ok {
+			c.JSON(transitionErr.StatusCode, gin.H{
+				"error": transitionErr.Message,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "退回评估失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 退回后重新评分，之前申诉调整的得分不再有效
+	if err := clearAdjustedScores(tx, c, "evaluation_id = ?", evaluation.ID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "退回评估失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 退回原因记录为系统评论，保留在评估的讨论记录中
+	comment := models.EvaluationComment{
+		EvaluationID: evaluation.ID,
+		UserID:       operatorID,
+		Content:      fmt.Sprintf("【退回】%s → %s：%s", getStatusText(previousStatus), getStatusText(evaluation.Status), req.Reason),
+		IsSystem:     true,
+	}
+	if err := tx.Create(&comment).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "记录退回原因失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	err = auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "status", previousStatus, evaluation.Status)
+	if err == nil {
+		err = auditCreate(tx, c, AuditEntityComment, comment.ID, comment)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "记录审计日志失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx.Commit()
+
+	// 重新加载更新后的数据
+	models.DB.Preload("Employee.Manager").Preload("Template").First(&evaluation, evaluationId)
+
+	// 发送DooTask机器人通知：通知需要重新处理的一方
+	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+	periodValue := utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter)
+
+	switch evaluation.Status {
+	case EvaluationStatusPending:
+		// 退回自评：通知员工
+		if evaluation.Employee.DooTaskUserID != nil {
+			message := fmt.Sprintf(
+				"### ↩️ 您的考核已被退回，请重新进行自评。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **退回原因：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+				evaluation.Template.Name,
+				periodValue,
+				req.Reason,
+			)
+			dooTaskClient.SendBotMessage(evaluation.Employee.DooTaskUserID, message)
+		}
+
+	case EvaluationStatusSelfEvaluated:
+		// 退回上级评分：通知主管
+		if evaluation.Employee.Manager != nil && evaluation.Employee.Manager.DooTaskUserID != nil {
+			message := fmt.Sprintf(
+				"### ↩️ 「%s」的考核已被退回，请重新进行主管评估。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **退回原因：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+				evaluation.Employee.Name,
+				evaluation.Template.Name,
+				periodValue,
+				req.Reason,
+			)
+			dooTaskClient.SendBotMessage(evaluation.Employee.Manager.DooTaskUserID, message)
+		}
+	}
+
+	// 发送实时通知
+	GetNotificationService().SendNotification(operatorID, EventEvaluationStatusChange, &evaluation)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评估已退回",
+		"data":    evaluation,
+	})
+}
+
+// 重新开放已完成的评估（HR）
+func ReopenEvaluation(c *gin.Context) {
+	id := c.Param("id")
+	evaluationId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评估ID",
+		})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请填写重新开放原因",
+			"message": err.Error(),
 		})
 		return
 	}
 
 	var evaluation models.KPIEvaluation
-	result := models.DB.Preload("Employee").First(&evaluation, evaluationId)
-	if result.Error != nil {
+	if err := models.DB.Preload("Employee").First(&evaluation, evaluationId).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "评估不存在",
 		})
 		return
 	}
 
-	var updateData models.KPIEvaluation
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "请求参数错误",
+	operatorID := c.GetUint("user_id")
+	previousStatus := evaluation.Status
+
+	tx := models.DB.Begin()
+
+	if err := ReopenCompletedEvaluation(tx, &evaluation, operatorID, c.GetString("user_role")); err != nil {
+		tx.Rollback()
+		if transitionErr, ok := err.(*TransitionError); ok {
+			c.JSON(transitionErr.StatusCode, gin.H{
+				"error": transitionErr.Message,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "重新开放评估失败",
 			"message": err.Error(),
 		})
 		return
 	}
 
-	// 特殊处理：如果状态是self_evaluated，检查员工是否有主管
-	if updateData.Status == "self_evaluated" {
-		// 检查员工是否有直属主管
-		if evaluation.Employee.ManagerID == nil {
-			// 如果没有主管，直接将状态改为manager_evaluated
-			updateData.Status = "manager_evaluated"
-
-			// 自动填入主管评分：将自评分数复制到主管评分
-			var scores []models.KPIScore
-			if err := models.DB.Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err == nil {
-				for _, score := range scores {
-					if score.SelfScore != nil {
-						// 将自评分数复制到主管评分
-						comment := "（自评分数）"
-						models.DB.Model(&score).Updates(map[string]interface{}{
-							"manager_score":   *score.SelfScore,
-							"manager_comment": comment,
-							"manager_auto":    true,
-						})
-					}
-				}
-			}
-		}
+	// 重新开放后可以修改评分，之前申诉调整的得分不再有效
+	if err := clearAdjustedScores(tx, c, "evaluation_id = ?", evaluation.ID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "重新开放评估失败",
+			"message": err.Error(),
+		})
+		return
 	}
 
-	result = models.DB.Model(&evaluation).Updates(updateData)
-	if result.Error != nil {
+	// 重新开放原因记录为系统评论
+	comment := models.EvaluationComment{
+		EvaluationID: evaluation.ID,
+		UserID:       operatorID,
+		Content:      fmt.Sprintf("【重新开放】%s → %s：%s", getStatusText(previousStatus), getStatusText(evaluation.Status), req.Reason),
+		IsSystem:     true,
+	}
+	if err := tx.Create(&comment).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "更新评估失败",
-			"message": result.Error.Error(),
+			"error":   "记录重新开放原因失败",
+			"message": err.Error(),
 		})
 		return
 	}
 
-	// 如果状态变为completed，自动计算最终得分
-	if updateData.Status == "completed" {
-		var scores []models.KPIScore
-		if err := models.DB.Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err == nil {
-			total := 0.0
-			for _, s := range scores {
-				var final float64
-				if s.HRScore != nil {
-					final = *s.HRScore
-				} else if s.ManagerScore != nil {
-					final = *s.ManagerScore
-				} else if s.SelfScore != nil {
-					final = *s.SelfScore
-				}
-				models.DB.Model(&s).Update("final_score", final)
-				total += final
-			}
-			models.DB.Model(&evaluation).Update("total_score", total)
-		}
+	err = auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "status", previousStatus, evaluation.Status)
+	if err == nil {
+		err = auditCreate(tx, c, AuditEntityComment, comment.ID, comment)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "记录审计日志失败",
+			"message": err.Error(),
+		})
+		return
 	}
 
+	tx.Commit()
+
 	// 重新加载更新后的数据
-	models.DB.Preload("Employee.Manager").Preload("Template").First(&evaluation, evaluationId)
+	models.DB.Preload("Employee").Preload("Template").First(&evaluation, evaluationId)
 
-	// 发送DooTask机器人通知（根据状态变更）
-	if updateData.Status != "" {
+	// 发送DooTask机器人通知：通知员工评估已重新开放
+	if evaluation.Employee.DooTaskUserID != nil {
 		dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
-		periodValue := utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter)
-
-		switch updateData.Status {
-		case "self_evaluated":
-			// 完成自评：通知主管
-			if evaluation.Employee.Manager != nil && evaluation.Employee.Manager.DooTaskUserID != nil {
-				message := fmt.Sprintf(
-					"### 📋 「%s」已完成自评，请您进行主管评估。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **员工姓名：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
-					evaluation.Employee.Name,
-					evaluation.Template.Name,
-					periodValue,
-					evaluation.Employee.Name,
-				)
-				dooTaskClient.SendBotMessage(evaluation.Employee.Manager.DooTaskUserID, message)
-			}
-
-		case "pending_confirm":
-			// 完成HR审核：通知员工确认
-			if evaluation.Employee.DooTaskUserID != nil {
-				message := fmt.Sprintf(
-					"### 📋 您的考核已完成HR审核，请确认最终得分。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **总分：** %.1f\n\n> 请前往「应用 - 绩效考核」中查看详情并确认。",
-					evaluation.Template.Name,
-					periodValue,
-					evaluation.TotalScore,
-				)
-				dooTaskClient.SendBotMessage(evaluation.Employee.DooTaskUserID, message)
-			}
-		}
+		message := fmt.Sprintf(
+			"### 🔓 您已完成的考核已被HR重新开放。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **重新开放原因：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+			evaluation.Template.Name,
+			utils.GetPeriodValue(evaluation.Period, evaluation.Year, evaluation.Month, evaluation.Quarter),
+			req.Reason,
+		)
+		dooTaskClient.SendBotMessage(evaluation.Employee.DooTaskUserID, message)
 	}
 
 	// 发送实时通知
-	operatorID := c.GetUint("user_id")
-	if updateData.Status != "" {
-		// 状态变更通知
-		GetNotificationService().SendNotification(operatorID, EventEvaluationStatusChange, &evaluation)
-	} else {
-		// 一般更新通知
-		GetNotificationService().SendNotification(operatorID, EventEvaluationUpdated, &evaluation)
-	}
+	GetNotificationService().SendNotification(operatorID, EventEvaluationStatusChange, &evaluation)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "评估更新成功",
+		"message": "评估已重新开放",
 		"data":    evaluation,
 	})
 }
@@ -527,26 +1223,89 @@ func DeleteEvaluation(c *gin.Context) {
 		return
 	}
 
-	// 在删除前获取评估信息用于通知
+	// 在删除前获取评估信息用于通知和审计
 	var evaluation models.KPIEvaluation
-	if err := models.DB.Preload("Employee").First(&evaluation, evaluationId).Error; err != nil {
+	if err := models.DB.Preload("Employee").Preload("Scores").First(&evaluation, evaluationId).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "评估不存在",
 		})
 		return
 	}
 
-	// 删除相关的评分记录
-	models.DB.Where("evaluation_id = ?", evaluationId).Delete(&models.KPIScore{})
+	// 校准会议锁定或正在调整的评估结果不能删除
+	if evaluation.CalibrationID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "评估结果已被校准会议锁定，不能删除",
+		})
+		return
+	}
+	var calibrating int64
+	if err := models.DB.Model(&models.CalibrationAdjustment{}).
+		Joins("JOIN calibration_sessions ON calibration_sessions.id = calibration_adjustments.session_id").
+		Where("calibration_adjustments.evaluation_id = ? AND calibration_sessions.status = ?", evaluation.ID, CalibrationStatusOpen).
+		Count(&calibrating).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除评估失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if calibrating > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "评估正在校准会议中调整，请在校准会议结束后再删除",
+		})
+		return
+	}
+
+	// 删除评估及其关联记录，任何一步失败时全部回滚
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		deletions := []struct {
+			query string
+			model interface{}
+		}{
+			// 评分记录和得分明细
+			{"evaluation_id = ?", &models.KPIScore{}},
+			{"evaluation_id = ?", &models.ScoreBreakdown{}},
+			// 邀请记录和被邀请人的评分
+			{"invitation_id IN (SELECT id FROM evaluation_invitations WHERE evaluation_id = ?)", &models.InvitedScore{}},
+			{"evaluation_id = ?", &models.EvaluationInvitation{}},
+			// 申诉记录和截止提醒记录
+			{"appeal_id IN (SELECT id FROM evaluation_appeals WHERE evaluation_id = ?)", &models.AppealItem{}},
+			{"evaluation_id = ?", &models.EvaluationAppeal{}},
+			{"evaluation_id = ?", &models.EvaluationReminder{}},
+			// 评审链的评审记录和评审人评分
+			{"evaluation_id = ?", &models.ReviewerScore{}},
+			{"evaluation_id = ?", &models.EvaluationReviewer{}},
+			// 讨论记录和已结束校准会议中的调整记录
+			{"evaluation_id = ?", &models.EvaluationComment{}},
+			{"evaluation_id = ?", &models.CalibrationAdjustment{}},
+		}
+		for _, deletion := range deletions {
+			if err := tx.Where(deletion.query, evaluationId).Delete(deletion.model).Error; err != nil {
+				return err
+			}
+		}
+
+		// 个人目标保留在考核周期中，解除与评估的关联
+		if err := tx.Model(&models.EmployeeGoal{}).Where("evaluation_id = ?", evaluationId).Update("evaluation_id", nil).Error; err != nil {
+			return err
+		}
 
-	// 删除相关的邀请记录
-	models.DB.Where("evaluation_id = ?", evaluationId).Delete(&models.EvaluationInvitation{})
+		if err := tx.Delete(&models.KPIEvaluation{}, evaluationId).Error; err != nil {
+			return err
+		}
 
-	result := models.DB.Delete(&models.KPIEvaluation{}, evaluationId)
-	if result.Error != nil {
+		for _, score := range evaluation.Scores {
+			if err := auditDelete(tx, c, AuditEntityScore, score.ID, score); err != nil {
+				return err
+			}
+		}
+		return auditDelete(tx, c, AuditEntityEvaluation, evaluation.ID, evaluation)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "删除评估失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -571,6 +1330,10 @@ func GetEmployeeEvaluations(c *gin.Context) {
 		return
 	}
 
+	if !requireEmployeeAccess(c, uint(empId)) {
+		return
+	}
+
 	var evaluations []models.KPIEvaluation
 	result := models.DB.Preload("Template").Preload("Scores").Where("employee_id = ?", empId).Find(&evaluations)
 	if result.Error != nil {
@@ -598,6 +1361,10 @@ func GetPendingEvaluations(c *gin.Context) {
 		return
 	}
 
+	if !requireEmployeeAccess(c, uint(empId)) {
+		return
+	}
+
 	var evaluations []models.KPIEvaluation
 
 	// 获取需要当前员工处理的评估
@@ -644,6 +1411,18 @@ func GetEvaluationScores(c *gin.Context) {
 		return
 	}
 
+	var evaluation models.KPIEvaluation
+	if err := models.DB.First(&evaluation, evalId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	if !requireEvaluationAccess(c, &evaluation) {
+		return
+	}
+
 	var scores []models.KPIScore
 	result := models.DB.Preload("Item").Where("evaluation_id = ?", evalId).Find(&scores)
 	if result.Error != nil {
@@ -660,6 +1439,41 @@ func GetEvaluationScores(c *gin.Context) {
 	})
 }
 
+// 校验当前用户能否在评估当前阶段写入评分，不能写入时返回错误响应
+func authorizeScoreWrite(c *gin.Context, score *models.KPIScore, field string, value *float64) bool {
+	var evaluation models.KPIEvaluation
+	if err := models.DB.Preload("Employee").First(&evaluation, score.EvaluationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return false
+	}
+
+	var item models.KPIItem
+	if err := models.DB.First(&item, score.ItemID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "考核项目不存在",
+		})
+		return false
+	}
+
+	if err := CheckScoreWrite(&evaluation, &item, field, value, c.GetUint("user_id"), c.GetString("user_role")); err != nil {
+		if transitionErr, ok := err.(*TransitionError); ok {
+			c.JSON(transitionErr.StatusCode, gin.H{
+				"error": transitionErr.Message,
+			})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "校验评分权限失败",
+			"message": err.Error(),
+		})
+		return false
+	}
+
+	return true
+}
+
 // 更新自评分数
 func UpdateSelfScore(c *gin.Context) {
 	id := c.Param("id")
@@ -693,15 +1507,24 @@ func UpdateSelfScore(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&score).Updates(map[string]interface{}{
-		"self_score":   updateData.SelfScore,
-		"self_comment": updateData.SelfComment,
-	})
+	if !authorizeScoreWrite(c, &score, ScoreFieldSelf, updateData.SelfScore) {
+		return
+	}
 
-	if result.Error != nil {
+	before := score
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"self_score":   updateData.SelfScore,
+			"self_comment": updateData.SelfComment,
+		}).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityScore, score.ID, before, score)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新自评分数失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -749,15 +1572,32 @@ func UpdateManagerScore(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&score).Updates(map[string]interface{}{
-		"manager_score":   updateData.ManagerScore,
-		"manager_comment": updateData.ManagerComment,
-	})
+	if !authorizeScoreWrite(c, &score, ScoreFieldManager, updateData.ManagerScore) {
+		return
+	}
 
-	if result.Error != nil {
+	// 记录实际评分的上级，评分人分析和标准化按评分人分组
+	var scorerID *uint
+	if updateData.ManagerScore != nil {
+		operatorID := c.GetUint("user_id")
+		scorerID = &operatorID
+	}
+
+	before := score
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"manager_score":     updateData.ManagerScore,
+			"manager_comment":   updateData.ManagerComment,
+			"manager_scorer_id": scorerID,
+		}).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityScore, score.ID, before, score)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新上级评分失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -805,15 +1645,24 @@ func UpdateHRScore(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&score).Updates(map[string]interface{}{
-		"hr_score":   updateData.HRScore,
-		"hr_comment": updateData.HRComment,
-	})
+	if !authorizeScoreWrite(c, &score, ScoreFieldHR, updateData.HRScore) {
+		return
+	}
 
-	if result.Error != nil {
+	before := score
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"hr_score":   updateData.HRScore,
+			"hr_comment": updateData.HRComment,
+		}).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityScore, score.ID, before, score)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新HR评分失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -828,7 +1677,7 @@ func UpdateHRScore(c *gin.Context) {
 	})
 }
 
-// 更新最终得分
+// HR指定最终得分，计算最终得分时代替按评分策略计算的结果，final_score 为空表示取消指定
 func UpdateFinalScore(c *gin.Context) {
 	id := c.Param("id")
 	scoreId, err := strconv.ParseUint(id, 10, 32)
@@ -861,15 +1710,27 @@ func UpdateFinalScore(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&score).Updates(map[string]interface{}{
-		"final_score":   updateData.FinalScore,
-		"final_comment": updateData.FinalComment,
-	})
+	if !authorizeScoreWrite(c, &score, ScoreFieldFinal, updateData.FinalScore) {
+		return
+	}
 
-	if result.Error != nil {
+	before := score
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		// 最终得分在进入待确认时计算，这里只记录HR指定的分数
+		if err := tx.Model(&models.KPIScore{}).Where("id = ?", score.ID).Updates(map[string]interface{}{
+			"override_score": updateData.FinalScore,
+			"final_comment":  updateData.FinalComment,
+		}).Error; err != nil {
+			return err
+		}
+		score.OverrideScore = updateData.FinalScore
+		score.FinalComment = updateData.FinalComment
+		return auditUpdate(tx, c, AuditEntityScore, score.ID, before, score)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新最终得分失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -879,3 +1740,108 @@ func UpdateFinalScore(c *gin.Context) {
 		"data":    score,
 	})
 }
+
+// 更新量化指标实际值，并根据实际值自动计算得分
+func UpdateActualValue(c *gin.Context) {
+	id := c.Param("id")
+	scoreId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评分ID",
+		})
+		return
+	}
+
+	var score models.KPIScore
+	result := models.DB.First(&score, scoreId)
+	if result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评分记录不存在",
+		})
+		return
+	}
+
+	var updateData struct {
+		ActualValue   *float64 `json:"actual_value"`
+		ActualComment string   `json:"actual_comment"`
+	}
+
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.Preload("Employee").First(&evaluation, score.EvaluationID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return
+	}
+
+	var item models.KPIItem
+	if err := models.DB.First(&item, score.ItemID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "考核项目不存在",
+		})
+		return
+	}
+
+	if err := CheckActualValueWrite(&evaluation, &item, c.GetUint("user_id"), c.GetString("user_role")); err != nil {
+		if transitionErr, ok := err.(*TransitionError); ok {
+			c.JSON(transitionErr.StatusCode, gin.H{
+				"error": transitionErr.Message,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "校验评分权限失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if updateData.ActualValue != nil && (math.IsNaN(*updateData.ActualValue) || math.IsInf(*updateData.ActualValue, 0)) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的实际值",
+		})
+		return
+	}
+
+	var computedScore *float64
+	if updateData.ActualValue != nil {
+		computed := computeMetricScore(&item, *updateData.ActualValue)
+		computedScore = &computed
+	}
+
+	before := score
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"actual_value":   updateData.ActualValue,
+			"actual_comment": updateData.ActualComment,
+			"computed_score": computedScore,
+		}).Error; err != nil {
+			return err
+		}
+		return auditUpdate(tx, c, AuditEntityScore, score.ID, before, score)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新实际值失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 发送实时通知
+	operatorID := c.GetUint("user_id")
+	GetNotificationService().SendNotification(operatorID, EventActualValueUpdated, &score)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "实际值更新成功",
+		"data":    score,
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"dootask-kpi-server/models"
+)
+
+// 考核项目类型
+const (
+	ItemTypeRating       = "rating"       // 主观评分
+	ItemTypeQuantitative = "quantitative" // 量化指标
+	ItemTypeCategory     = "category"     // 分类，得分由子项目汇总
+)
+
+// 量化指标计分曲线
+const (
+	ScoringCurveLinear = "linear" // 线性：按完成进度计分，不超过满分
+	ScoringCurveStep   = "step"   // 分段：按达成率所在分段计分
+	ScoringCurveCapped = "capped" // 封顶：按完成进度计分，超额部分最高计算到封顶比例（设置挑战值时同时不超过挑战值）
+)
+
+// 是否为量化指标
+func isQuantitativeItem(item *models.KPIItem) bool {
+	return item.Type == ItemTypeQuantitative
+}
+
+// 校验考核项目的类型和量化指标配置，并为空字段填充默认值
+func validateItemMetric(item *models.KPIItem) error {
+	switch item.Type {
+	case "":
+		item.Type = ItemTypeRating
+		return nil
+	case ItemTypeRating, ItemTypeCategory:
+		return nil
+	case ItemTypeQuantitative:
+	default:
+		return fmt.Errorf("不支持的考核项目类型：%s", item.Type)
+	}
+
+	if item.MaxScore <= 0 {
+		return fmt.Errorf("量化指标的满分必须大于0")
+	}
+	if item.Target == nil {
+		return fmt.Errorf("量化指标必须设置目标值")
+	}
+	if *item.Target == metricThreshold(item) {
+		return fmt.Errorf("量化指标的目标值不能等于门槛值")
+	}
+	if item.Stretch != nil && metricProgress(item, *item.Stretch) <= 1 {
+		return fmt.Errorf("量化指标的挑战值必须优于目标值")
+	}
+
+	if item.CapPercent != nil && item.ScoringCurve != ScoringCurveCapped {
+		return fmt.Errorf("只有封顶计分可以设置封顶比例")
+	}
+
+	switch item.ScoringCurve {
+	case "":
+		item.ScoringCurve = ScoringCurveLinear
+	case ScoringCurveLinear:
+	case ScoringCurveCapped:
+		if item.CapPercent == nil || *item.CapPercent <= 100 {
+			return fmt.Errorf("封顶计分必须设置大于100的封顶比例")
+		}
+	case ScoringCurveStep:
+		if len(item.ScoreBands) == 0 {
+			return fmt.Errorf("分段计分必须设置得分分段")
+		}
+		for i, band := range item.ScoreBands {
+			if i > 0 && band.Min <= item.ScoreBands[i-1].Min {
+				return fmt.Errorf("得分分段的达成率必须从低到高排列且不能重复")
+			}
+			if band.Score < 0 {
+				return fmt.Errorf("得分分段的得分不能为负数")
+			}
+		}
+	default:
+		return fmt.Errorf("不支持的计分曲线：%s", item.ScoringCurve)
+	}
+
+	return nil
+}
+
+// 量化指标的门槛值，未设置时为0
+func metricThreshold(item *models.KPIItem) float64 {
+	if item.Threshold == nil {
+		return 0
+	}
+	return *item.Threshold
+}
+
+// 量化指标的完成进度：达到门槛值为0，达到目标值为1
+// 门槛值大于目标值时表示指标越低越好（如故障次数）
+func metricProgress(item *models.KPIItem, actual float64) float64 {
+	threshold := metricThreshold(item)
+	return (actual - threshold) / (*item.Target - threshold)
+}
+
+// 根据实际值计算量化指标得分
+func computeMetricScore(item *models.KPIItem, actual float64) float64 {
+	progress := metricProgress(item, actual)
+
+	var ratio float64
+	switch item.ScoringCurve {
+	case ScoringCurveStep:
+		// 取达成率不低于分段下限的最高分段
+		achievement := progress * 100
+		for _, band := range item.ScoreBands {
+			if achievement >= band.Min {
+				ratio = band.Score / 100
+			}
+		}
+	case ScoringCurveCapped:
+		// 未设置封顶比例的旧数据按满分封顶
+		limit := 1.0
+		if item.CapPercent != nil {
+			limit = *item.CapPercent / 100
+		}
+		if item.Stretch != nil {
+			limit = math.Min(limit, metricProgress(item, *item.Stretch))
+		}
+		ratio = math.Min(progress, limit)
+	default:
+		ratio = math.Min(progress, 1)
+	}
+
+	if ratio < 0 {
+		ratio = 0
+	}
+	return roundScore(item.MaxScore * ratio)
+}
+
+// 格式化量化指标的数值（带单位），用于导出
+func formatMetricValue(item *models.KPIItem, value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64) + item.Unit
+}
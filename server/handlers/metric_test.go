@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"testing"
+
+	"dootask-kpi-server/models"
+)
+
+func TestComputeMetricScore(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+	metric := func(curve string, configure func(item *models.KPIItem)) models.KPIItem {
+		item := models.KPIItem{Name: "销售额", MaxScore: 30, Type: ItemTypeQuantitative, Target: floatPtr(100), Threshold: floatPtr(50), ScoringCurve: curve}
+		if configure != nil {
+			configure(&item)
+		}
+		return item
+	}
+	bands := func(item *models.KPIItem) {
+		item.Threshold = nil
+		item.ScoreBands = models.ScoreBands{{Min: 0, Score: 0}, {Min: 50, Score: 60}, {Min: 100, Score: 100}}
+	}
+	lowerIsBetter := func(item *models.KPIItem) {
+		item.Target, item.Threshold = floatPtr(0), floatPtr(10)
+	}
+
+	tests := []struct {
+		name   string
+		item   models.KPIItem
+		actual float64
+		want   float64
+	}{
+		{name: "线性计分按完成进度", item: metric(ScoringCurveLinear, nil), actual: 75, want: 15},
+		{name: "线性计分不超过满分", item: metric(ScoringCurveLinear, nil), actual: 130, want: 30},
+		{name: "未达到门槛值得0分", item: metric(ScoringCurveLinear, nil), actual: 40, want: 0},
+		{name: "封顶计分按封顶比例", item: metric(ScoringCurveCapped, func(item *models.KPIItem) { item.CapPercent = floatPtr(150) }), actual: 200, want: 45},
+		{name: "封顶计分不超过挑战值", item: metric(ScoringCurveCapped, func(item *models.KPIItem) { item.CapPercent, item.Stretch = floatPtr(150), floatPtr(110) }), actual: 200, want: 36},
+		{name: "未设置封顶比例的旧数据按满分封顶", item: metric(ScoringCurveCapped, nil), actual: 200, want: 30},
+		{name: "分段计分取所在分段", item: metric(ScoringCurveStep, bands), actual: 85, want: 18},
+		{name: "分段计分达到目标值", item: metric(ScoringCurveStep, bands), actual: 100, want: 30},
+		{name: "分段计分低于第二段", item: metric(ScoringCurveStep, bands), actual: 40, want: 0},
+		{name: "越低越好的指标", item: metric(ScoringCurveLinear, lowerIsBetter), actual: 4, want: 18},
+		{name: "越低越好的指标超过门槛值", item: metric(ScoringCurveLinear, lowerIsBetter), actual: 12, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeMetricScore(&tt.item, tt.actual); got != tt.want {
+				t.Errorf("实际值 %g 的得分为 %g，期望 %g", tt.actual, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateItemMetric(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+	metric := func(configure func(item *models.KPIItem)) models.KPIItem {
+		item := models.KPIItem{Name: "销售额", MaxScore: 30, Type: ItemTypeQuantitative, Target: floatPtr(100)}
+		configure(&item)
+		return item
+	}
+
+	tests := []struct {
+		name      string
+		item      models.KPIItem
+		wantErr   bool
+		wantType  string
+		wantCurve string
+	}{
+		{name: "未设置类型时为主观评分", item: models.KPIItem{Name: "工作质量", MaxScore: 10}, wantType: ItemTypeRating},
+		{name: "不支持的类型", item: models.KPIItem{Name: "工作质量", MaxScore: 10, Type: "survey"}, wantErr: true},
+		{name: "量化指标默认线性计分", item: metric(func(item *models.KPIItem) {}), wantType: ItemTypeQuantitative, wantCurve: ScoringCurveLinear},
+		{name: "满分必须大于0", item: metric(func(item *models.KPIItem) { item.MaxScore = 0 }), wantErr: true},
+		{name: "必须设置目标值", item: metric(func(item *models.KPIItem) { item.Target = nil }), wantErr: true},
+		{name: "目标值不能等于门槛值", item: metric(func(item *models.KPIItem) { item.Threshold = floatPtr(100) }), wantErr: true},
+		{name: "挑战值必须优于目标值", item: metric(func(item *models.KPIItem) { item.Stretch = floatPtr(90) }), wantErr: true},
+		{name: "越低越好时挑战值低于目标值", item: metric(func(item *models.KPIItem) {
+			item.Target, item.Threshold, item.Stretch = floatPtr(2), floatPtr(10), floatPtr(0)
+		}), wantType: ItemTypeQuantitative, wantCurve: ScoringCurveLinear},
+		{name: "线性计分不能设置封顶比例", item: metric(func(item *models.KPIItem) { item.ScoringCurve, item.CapPercent = ScoringCurveLinear, floatPtr(150) }), wantErr: true},
+		{name: "封顶计分必须设置封顶比例", item: metric(func(item *models.KPIItem) { item.ScoringCurve = ScoringCurveCapped }), wantErr: true},
+		{name: "封顶比例必须大于100", item: metric(func(item *models.KPIItem) { item.ScoringCurve, item.CapPercent = ScoringCurveCapped, floatPtr(100) }), wantErr: true},
+		{name: "封顶计分", item: metric(func(item *models.KPIItem) { item.ScoringCurve, item.CapPercent = ScoringCurveCapped, floatPtr(120) }), wantType: ItemTypeQuantitative, wantCurve: ScoringCurveCapped},
+		{name: "分段计分必须设置分段", item: metric(func(item *models.KPIItem) { item.ScoringCurve = ScoringCurveStep }), wantErr: true},
+		{name: "分段必须从低到高排列", item: metric(func(item *models.KPIItem) {
+			item.ScoringCurve, item.ScoreBands = ScoringCurveStep, models.ScoreBands{{Min: 80, Score: 60}, {Min: 80, Score: 100}}
+		}), wantErr: true},
+		{name: "分段得分不能为负数", item: metric(func(item *models.KPIItem) {
+			item.ScoringCurve, item.ScoreBands = ScoringCurveStep, models.ScoreBands{{Min: 0, Score: -10}}
+		}), wantErr: true},
+		{name: "不支持的计分曲线", item: metric(func(item *models.KPIItem) { item.ScoringCurve = "log" }), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateItemMetric(&tt.item)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望校验失败，实际通过")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("校验失败: %v", err)
+			}
+			if tt.item.Type != tt.wantType || tt.item.ScoringCurve != tt.wantCurve {
+				t.Errorf("校验后类型为 %q、计分曲线为 %q，期望 %q、%q", tt.item.Type, tt.item.ScoringCurve, tt.wantType, tt.wantCurve)
+			}
+		})
+	}
+}
@@ -0,0 +1,638 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 分数标准化方式
+const (
+	NormalizationZScore     = "zscore"     // 标准分：按组内标准分换算到全体的均值和标准差
+	NormalizationPercentile = "percentile" // 百分位：按组内百分位换算为全体分数的同一百分位
+)
+
+// 标准化分组方式
+const (
+	NormalizationByReviewer   = "reviewer"   // 按评分人（实际填写上级评分的人）
+	NormalizationByDepartment = "department" // 按部门
+)
+
+// 评分倾向
+const (
+	TendencyLenient = "lenient" // 偏宽松
+	TendencySevere  = "severe"  // 偏严格
+	TendencyNeutral = "neutral" // 正常
+)
+
+// 评分人平均分偏离全体平均分超过该倍数的标准差时视为偏宽松或偏严格
+const leniencyThreshold = 0.5
+
+// 少于该数量的分组不做标准化，保留原始总分
+const normalizationMinGroupSize = 2
+
+// 已有上级评分的评估状态
+var reviewedEvaluationStatuses = []string{
+	EvaluationStatusManagerEvaluated,
+	EvaluationStatusPendingConfirm,
+	EvaluationStatusDisputed,
+	EvaluationStatusCompleted,
+}
+
+// 分数分布统计
+type ScoreSummary struct {
+	Count        int          `json:"count"`
+	Mean         float64      `json:"mean"`
+	StdDev       float64      `json:"std_dev"`
+	Median       float64      `json:"median"`
+	Min          float64      `json:"min"`
+	Max          float64      `json:"max"`
+	Distribution []GradeCount `json:"distribution,omitempty"` // 按默认评级量表划分的分布
+}
+
+// 评分人的评分倾向
+type ReviewerStat struct {
+	ReviewerID     uint   `json:"reviewer_id"`
+	ReviewerName   string `json:"reviewer_name"`
+	DepartmentName string `json:"department_name"`
+	ScoreSummary
+	Deviation     float64 `json:"deviation"`      // 平均分与全体平均分之差
+	LeniencyIndex float64 `json:"leniency_index"` // 偏差除以全体标准差
+	Tendency      string  `json:"tendency"`       // lenient, severe, neutral
+}
+
+// 标准化分组
+type NormalizationGroup struct {
+	GroupID   uint   `json:"group_id"` // 为0表示没有评分人记录的评估
+	GroupName string `json:"group_name"`
+	ScoreSummary
+	Normalized bool `json:"normalized"` // 样本不足或没有分组时不做标准化
+}
+
+// 评估的标准化结果
+type NormalizationEntry struct {
+	EvaluationID    uint    `json:"evaluation_id"`
+	EmployeeID      uint    `json:"employee_id"`
+	EmployeeName    string  `json:"employee_name"`
+	GroupID         uint    `json:"group_id"`
+	GroupName       string  `json:"group_name"`
+	RawScore        float64 `json:"raw_score"`
+	NormalizedScore float64 `json:"normalized_score"`
+	Normalized      bool    `json:"normalized"` // 为 false 时标准化总分等于原始总分
+}
+
+// 应用标准化请求结构
+type NormalizationRequest struct {
+	Method  string `json:"method"`
+	GroupBy string `json:"group_by"`
+}
+
+// 评分人的单个评估样本
+type reviewerSample struct {
+	EvaluationID uint
+	ReviewerID   uint
+	Score        float64
+	MaxScore     float64
+}
+
+// 获取评分人宽严分析：按评分人比较上级评分得分率与全体的分布
+// 评分记在实际评分的人名下：上级直接评分按记录的评分人，评审链按每位评审人自己的评分
+func GetReviewerAnalysis(c *gin.Context) {
+	filter := func(query *gorm.DB) *gorm.DB {
+		query = query.Joins("JOIN employees ON employees.id = kpi_evaluations.employee_id").
+			Where("kpi_evaluations.status IN ?", reviewedEvaluationStatuses)
+		if cycleID := c.Query("cycle_id"); cycleID != "" {
+			query = query.Where("kpi_evaluations.cycle_id = ?", cycleID)
+		}
+		if year := c.Query("year"); year != "" {
+			query = query.Where("kpi_evaluations.year = ?", year)
+		}
+		if departmentID := c.Query("department_id"); departmentID != "" {
+			query = query.Where("employees.department_id = ?", departmentID)
+		}
+		return query
+	}
+
+	var samples []reviewerSample
+	err := models.DB.Table("kpi_scores").
+		Select("kpi_evaluations.id AS evaluation_id, kpi_scores.manager_scorer_id AS reviewer_id, SUM(kpi_scores.manager_score) AS score, SUM(kpi_items.max_score) AS max_score").
+		Joins("JOIN kpi_evaluations ON kpi_evaluations.id = kpi_scores.evaluation_id").
+		Joins("JOIN kpi_items ON kpi_items.id = kpi_scores.item_id").
+		Scopes(filter).
+		Where("kpi_scores.manager_score IS NOT NULL AND kpi_scores.manager_auto = ? AND kpi_scores.manager_scorer_id IS NOT NULL", false).
+		Group("kpi_evaluations.id, kpi_scores.manager_scorer_id").
+		Scan(&samples).Error
+	if err == nil {
+		var chainSamples []reviewerSample
+		err = models.DB.Table("reviewer_scores").
+			Select("kpi_evaluations.id AS evaluation_id, evaluation_reviewers.reviewer_id AS reviewer_id, SUM(reviewer_scores.score) AS score, SUM(kpi_items.max_score) AS max_score").
+			Joins("JOIN evaluation_reviewers ON evaluation_reviewers.id = reviewer_scores.reviewer_record_id").
+			Joins("JOIN kpi_evaluations ON kpi_evaluations.id = reviewer_scores.evaluation_id").
+			Joins("JOIN kpi_items ON kpi_items.id = reviewer_scores.item_id").
+			Scopes(filter).
+			Where("reviewer_scores.score IS NOT NULL AND evaluation_reviewers.role = ?", ReviewerRoleScorer).
+			Group("kpi_evaluations.id, evaluation_reviewers.reviewer_id").
+			Scan(&chainSamples).Error
+		samples = append(samples, chainSamples...)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取上级评分失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	grades, err := getDefaultRatingGrades(models.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评级量表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 按得分率比较，消除不同模板满分的差异
+	var all []float64
+	byReviewer := make(map[uint][]float64)
+	var reviewerIDs []uint
+	for _, sample := range samples {
+		if sample.MaxScore <= 0 {
+			continue
+		}
+		percent := sample.Score / sample.MaxScore * 100
+		all = append(all, percent)
+		if _, ok := byReviewer[sample.ReviewerID]; !ok {
+			reviewerIDs = append(reviewerIDs, sample.ReviewerID)
+		}
+		byReviewer[sample.ReviewerID] = append(byReviewer[sample.ReviewerID], percent)
+	}
+	organization := summarizeScores(all, grades)
+
+	var reviewers []models.Employee
+	if len(reviewerIDs) > 0 {
+		models.DB.Preload("Department").Where("id IN ?", reviewerIDs).Find(&reviewers)
+	}
+	reviewerMap := make(map[uint]models.Employee, len(reviewers))
+	for _, reviewer := range reviewers {
+		reviewerMap[reviewer.ID] = reviewer
+	}
+
+	stats := make([]ReviewerStat, 0, len(reviewerIDs))
+	for _, reviewerID := range reviewerIDs {
+		stat := ReviewerStat{
+			ReviewerID:     reviewerID,
+			ReviewerName:   reviewerMap[reviewerID].Name,
+			DepartmentName: reviewerMap[reviewerID].Department.Name,
+			ScoreSummary:   summarizeScores(byReviewer[reviewerID], grades),
+			Tendency:       TendencyNeutral,
+		}
+		stat.Deviation = roundScore(stat.Mean - organization.Mean)
+		if organization.StdDev > 0 {
+			stat.LeniencyIndex = roundScore(stat.Deviation / organization.StdDev)
+		}
+		if stat.LeniencyIndex > leniencyThreshold {
+			stat.Tendency = TendencyLenient
+		} else if stat.LeniencyIndex < -leniencyThreshold {
+			stat.Tendency = TendencySevere
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].LeniencyIndex > stats[j].LeniencyIndex
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"organization": organization,
+			"reviewers":    stats,
+		},
+	})
+}
+
+// 预览考核周期的分数标准化结果
+func PreviewNormalization(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	req := NormalizationRequest{
+		Method:  c.DefaultQuery("method", NormalizationZScore),
+		GroupBy: c.DefaultQuery("group_by", NormalizationByReviewer),
+	}
+	if err := validateNormalizationRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	entries, groups, organization, err := computeNormalization(models.DB, cycle.ID, req.Method, req.GroupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "计算标准化分数失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 当前生效的标准化
+	var current *models.ScoreNormalization
+	var normalization models.ScoreNormalization
+	if err := models.DB.Preload("Applier").Where("cycle_id = ? AND revoked_at IS NULL", cycle.ID).Order("id DESC").First(&normalization).Error; err == nil {
+		current = &normalization
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"method":       req.Method,
+			"group_by":     req.GroupBy,
+			"organization": organization,
+			"groups":       groups,
+			"evaluations":  entries,
+			"current":      current,
+		},
+	})
+}
+
+// 应用考核周期的分数标准化，写入已完成评估的标准化总分（之后完成的评估需要重新应用）
+func ApplyNormalization(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	var req NormalizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := validateNormalizationRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	entries, _, _, err := computeNormalization(models.DB, cycle.ID, req.Method, req.GroupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "计算标准化分数失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该考核周期没有已完成的评估",
+		})
+		return
+	}
+
+	normalization := models.ScoreNormalization{
+		CycleID:         cycle.ID,
+		Method:          req.Method,
+		GroupBy:         req.GroupBy,
+		EvaluationCount: len(entries),
+		AppliedBy:       c.GetUint("user_id"),
+		AppliedAt:       time.Now(),
+	}
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		// 新的标准化取代之前生效的标准化
+		if err := revokeCycleNormalization(tx, c, cycle.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(&normalization).Error; err != nil {
+			return err
+		}
+		if err := auditCreate(tx, c, AuditEntityNormalization, normalization.ID, normalization); err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := tx.Model(&models.KPIEvaluation{}).Where("id = ?", entry.EvaluationID).Updates(map[string]interface{}{
+				"normalized_score": entry.NormalizedScore,
+				"normalization_id": normalization.ID,
+			}).Error; err != nil {
+				return err
+			}
+			if err := auditField(tx, c, AuditEntityEvaluation, entry.EvaluationID, "normalized_score", nil, entry.NormalizedScore); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "应用标准化失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("已为 %d 个评估生成标准化总分", len(entries)),
+		"data":    normalization,
+	})
+}
+
+// 撤销考核周期的分数标准化，清除评估的标准化总分
+func RevokeNormalization(c *gin.Context) {
+	cycle, ok := loadCycle(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		return revokeCycleNormalization(tx, c, cycle.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "撤销标准化失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "标准化已撤销",
+	})
+}
+
+// 撤销考核周期当前生效的标准化
+func revokeCycleNormalization(tx *gorm.DB, c *gin.Context, cycleID uint) error {
+	var normalizations []models.ScoreNormalization
+	if err := tx.Where("cycle_id = ? AND revoked_at IS NULL", cycleID).Find(&normalizations).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, normalization := range normalizations {
+		if err := tx.Model(&normalization).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityNormalization, normalization.ID, "revoked_at", nil, now); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.KPIEvaluation{}).Where("normalization_id = ?", normalization.ID).Updates(map[string]interface{}{
+			"normalized_score": nil,
+			"normalization_id": nil,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 计算考核周期已完成评估的标准化总分
+func computeNormalization(tx *gorm.DB, cycleID uint, method, groupBy string) ([]NormalizationEntry, []NormalizationGroup, ScoreSummary, error) {
+	var evaluations []models.KPIEvaluation
+	if err := tx.Preload("Employee.Department").
+		Where("cycle_id = ? AND status = ?", cycleID, EvaluationStatusCompleted).
+		Order("id").Find(&evaluations).Error; err != nil {
+		return nil, nil, ScoreSummary{}, err
+	}
+
+	var scorers map[uint]*models.Employee
+	if groupBy != NormalizationByDepartment {
+		var err error
+		if scorers, err = loadEvaluationScorers(tx, evaluations); err != nil {
+			return nil, nil, ScoreSummary{}, err
+		}
+	}
+
+	entries := make([]NormalizationEntry, len(evaluations))
+	all := make([]float64, len(evaluations))
+	groupIndex := make(map[uint]int)
+	var groups []NormalizationGroup
+	var groupScores [][]float64
+	for i, evaluation := range evaluations {
+		entry := NormalizationEntry{
+			EvaluationID: evaluation.ID,
+			EmployeeID:   evaluation.EmployeeID,
+			EmployeeName: evaluation.Employee.Name,
+			RawScore:     evaluation.TotalScore,
+		}
+		switch groupBy {
+		case NormalizationByDepartment:
+			entry.GroupID = evaluation.Employee.DepartmentID
+			entry.GroupName = evaluation.Employee.Department.Name
+		default:
+			if scorer := scorers[evaluation.ID]; scorer != nil {
+				entry.GroupID = scorer.ID
+				entry.GroupName = scorer.Name
+			} else {
+				entry.GroupName = "无评分人"
+			}
+		}
+		entries[i] = entry
+		all[i] = evaluation.TotalScore
+
+		if _, ok := groupIndex[entry.GroupID]; !ok {
+			groupIndex[entry.GroupID] = len(groups)
+			groups = append(groups, NormalizationGroup{GroupID: entry.GroupID, GroupName: entry.GroupName})
+			groupScores = append(groupScores, nil)
+		}
+		j := groupIndex[entry.GroupID]
+		groupScores[j] = append(groupScores[j], evaluation.TotalScore)
+	}
+
+	organization := summarizeScores(all, nil)
+	sortedAll := append([]float64(nil), all...)
+	sort.Float64s(sortedAll)
+
+	for j := range groups {
+		groups[j].ScoreSummary = summarizeScores(groupScores[j], nil)
+		groups[j].Normalized = groups[j].GroupID != 0 && groups[j].Count >= normalizationMinGroupSize
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		group := &groups[groupIndex[entry.GroupID]]
+		entry.NormalizedScore = entry.RawScore
+		if !group.Normalized {
+			continue
+		}
+		entry.Normalized = true
+
+		var normalized float64
+		switch method {
+		case NormalizationPercentile:
+			normalized = quantile(sortedAll, percentileRank(groupScores[groupIndex[entry.GroupID]], entry.RawScore))
+		default:
+			z := 0.0
+			if group.StdDev > 0 {
+				z = (entry.RawScore - group.Mean) / group.StdDev
+			}
+			normalized = organization.Mean + z*organization.StdDev
+		}
+		entry.NormalizedScore = roundScore(math.Max(normalized, 0))
+	}
+
+	return entries, groups, organization, nil
+}
+
+// 确定每个评估的评分人：上级直接评分时为填写最多项目的评分人，评审链为权重最高的评审人
+func loadEvaluationScorers(tx *gorm.DB, evaluations []models.KPIEvaluation) (map[uint]*models.Employee, error) {
+	evaluationIDs := make([]uint, len(evaluations))
+	for i, evaluation := range evaluations {
+		evaluationIDs[i] = evaluation.ID
+	}
+
+	scorerIDs := make(map[uint]uint)
+	var counts []struct {
+		EvaluationID uint
+		ScorerID     uint
+		ScoreCount   int
+	}
+	if err := tx.Model(&models.KPIScore{}).
+		Select("evaluation_id, manager_scorer_id AS scorer_id, COUNT(*) AS score_count").
+		Where("evaluation_id IN ? AND manager_scorer_id IS NOT NULL AND manager_auto = ?", evaluationIDs, false).
+		Group("evaluation_id, manager_scorer_id").
+		Order("score_count DESC, scorer_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, count := range counts {
+		if _, ok := scorerIDs[count.EvaluationID]; !ok {
+			scorerIDs[count.EvaluationID] = count.ScorerID
+		}
+	}
+
+	var records []models.EvaluationReviewer
+	if err := tx.Where("evaluation_id IN ? AND role = ?", evaluationIDs, ReviewerRoleScorer).
+		Order("weight DESC, stage, id").
+		Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if _, ok := scorerIDs[record.EvaluationID]; !ok {
+			scorerIDs[record.EvaluationID] = record.ReviewerID
+		}
+	}
+
+	ids := make([]uint, 0, len(scorerIDs))
+	for _, id := range scorerIDs {
+		ids = append(ids, id)
+	}
+	var employees []models.Employee
+	if len(ids) > 0 {
+		if err := tx.Where("id IN ?", ids).Find(&employees).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[uint]*models.Employee, len(employees))
+	for i := range employees {
+		byID[employees[i].ID] = &employees[i]
+	}
+
+	scorers := make(map[uint]*models.Employee, len(scorerIDs))
+	for evaluationID, scorerID := range scorerIDs {
+		scorers[evaluationID] = byID[scorerID]
+	}
+	return scorers, nil
+}
+
+// 统计分数分布，grades 为空时不统计等级分布
+func summarizeScores(values []float64, grades []models.RatingGrade) ScoreSummary {
+	summary := ScoreSummary{Count: len(values)}
+	if len(values) == 0 {
+		return summary
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	avg := mean(sorted)
+	variance := 0.0
+	for _, value := range sorted {
+		variance += (value - avg) * (value - avg)
+	}
+
+	summary.Mean = roundScore(avg)
+	summary.StdDev = roundScore(math.Sqrt(variance / float64(len(sorted))))
+	summary.Median = roundScore(quantile(sorted, 0.5))
+	summary.Min = roundScore(sorted[0])
+	summary.Max = roundScore(sorted[len(sorted)-1])
+
+	if len(grades) > 0 {
+		counts := make(map[string]int)
+		for _, value := range sorted {
+			if grade := models.MatchRatingGrade(grades, value); grade != nil {
+				counts[grade.Label]++
+			}
+		}
+		for _, grade := range grades {
+			summary.Distribution = append(summary.Distribution, GradeCount{
+				Grade:   grade.Label,
+				Count:   counts[grade.Label],
+				Percent: percentOf(counts[grade.Label], len(sorted)),
+			})
+		}
+	}
+	return summary
+}
+
+// 分数在组内的百分位（0到1），相同分数取中间位置
+func percentileRank(values []float64, value float64) float64 {
+	below, equal := 0, 0
+	for _, v := range values {
+		if v < value {
+			below++
+		} else if v == value {
+			equal++
+		}
+	}
+	return (float64(below) + float64(equal)/2) / float64(len(values))
+}
+
+// 已排序分数在指定百分位（0到1）的值，按线性插值计算
+func quantile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	position := p * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+// 默认评级量表的等级，按下限从高到低排列
+func getDefaultRatingGrades(tx *gorm.DB) ([]models.RatingGrade, error) {
+	var grades []models.RatingGrade
+	err := tx.Joins("JOIN rating_scales ON rating_scales.id = rating_grades.scale_id").
+		Where("rating_scales.is_default = ?", true).
+		Order("rating_grades.min_score DESC").
+		Find(&grades).Error
+	return grades, err
+}
+
+// 校验标准化参数，并为空字段填充默认值
+func validateNormalizationRequest(req *NormalizationRequest) error {
+	switch req.Method {
+	case "":
+		req.Method = NormalizationZScore
+	case NormalizationZScore, NormalizationPercentile:
+	default:
+		return fmt.Errorf("不支持的标准化方式：%s", req.Method)
+	}
+
+	switch req.GroupBy {
+	case "":
+		req.GroupBy = NormalizationByReviewer
+	case NormalizationByReviewer, NormalizationByDepartment:
+	default:
+		return fmt.Errorf("不支持的标准化分组方式：%s", req.GroupBy)
+	}
+	return nil
+}
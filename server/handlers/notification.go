@@ -26,6 +26,11 @@ const (
 	EventSelfScoreUpdated    = "self_score_updated"
 	EventManagerScoreUpdated = "manager_score_updated"
 	EventHRScoreUpdated      = "hr_score_updated"
+	EventActualValueUpdated  = "actual_value_updated"
+
+	// 截止时间相关事件
+	EventEvaluationDeadlineReminder = "evaluation_deadline_reminder"
+	EventEvaluationOverdue          = "evaluation_overdue"
 )
 
 // 通知服务
@@ -131,7 +136,7 @@ func (n *NotificationService) GetRelatedUsers(eventType string, data interface{}
 		// 邀请发起人
 		relatedUsers = append(relatedUsers, score.Invitation.InviterID)
 
-	case EventSelfScoreUpdated, EventManagerScoreUpdated, EventHRScoreUpdated:
+	case EventSelfScoreUpdated, EventManagerScoreUpdated, EventHRScoreUpdated, EventActualValueUpdated:
 		score := data.(*models.KPIScore)
 
 		// 预加载相关数据
@@ -281,6 +286,8 @@ func (n *NotificationService) getStatusText(status string) string {
 		return "待HR审核"
 	case "pending_confirm":
 		return "待确认"
+	case "disputed":
+		return "申诉中"
 	case "completed":
 		return "已完成"
 	default:
@@ -351,6 +358,27 @@ func (n *NotificationService) SendNotification(operatorID uint, eventType string
 	}
 }
 
+// 发送系统通知（没有操作者，如后台定时任务）
+func (n *NotificationService) SendSystemNotification(userIDs []uint, eventType string, message string, data interface{}) {
+	for _, userID := range n.DeduplicateUsers(userIDs) {
+		sseMessage := SSEMessage{
+			Type: eventType,
+			Data: SSEEventData{
+				ID:           n.getDataID(data),
+				EmployeeID:   n.getEmployeeID(data),
+				OperatorName: "系统",
+				Message:      message,
+				Timestamp:    time.Now().Format(time.RFC3339),
+				Payload:      data,
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			ID:        fmt.Sprintf("%s-%d", eventType, time.Now().UnixNano()),
+		}
+
+		sseManager.SendToUser(userID, sseMessage)
+	}
+}
+
 // 获取数据ID
 func (n *NotificationService) getDataID(data interface{}) uint {
 	switch v := data.(type) {
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 评级量表请求结构
+type RatingScaleRequest struct {
+	Name        string                `json:"name" binding:"required"`
+	Description string                `json:"description"`
+	IsDefault   bool                  `json:"is_default"`
+	Grades      []models.RatingGrade  `json:"grades"`
+	Anchors     []models.RatingAnchor `json:"anchors"`
+}
+
+// 评分标准中的一个水平，分数区间按考核项目满分换算
+type RubricLevel struct {
+	Label       string  `json:"label"`
+	Description string  `json:"description"`
+	MinScore    float64 `json:"min_score"` // 分数下限（含）
+	MaxScore    float64 `json:"max_score"` // 分数上限，最高水平为满分
+}
+
+// 获取评级量表列表
+func GetRatingScales(c *gin.Context) {
+	var scales []models.RatingScale
+	result := models.DB.Scopes(models.PreloadRatingScale).Order("is_default DESC, id").Find(&scales)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评级量表列表失败",
+			"message": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": scales,
+	})
+}
+
+// 获取单个评级量表
+func GetRatingScale(c *gin.Context) {
+	scale, ok := loadRatingScale(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": scale,
+	})
+}
+
+// 创建评级量表
+func CreateRatingScale(c *gin.Context) {
+	var req RatingScaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := validateRatingScale(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	scale := models.RatingScale{}
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		return saveRatingScale(tx, c, &scale, &req)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建评级量表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Scopes(models.PreloadRatingScale).First(&scale, scale.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "评级量表创建成功",
+		"data":    scale,
+	})
+}
+
+// 更新评级量表，等级和锚点整体替换；已完成评估的等级不会重新计算
+func UpdateRatingScale(c *gin.Context) {
+	scale, ok := loadRatingScale(c)
+	if !ok {
+		return
+	}
+
+	var req RatingScaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := validateRatingScale(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if scale.IsDefault && !req.IsDefault {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "不能取消默认评级量表，请将其他量表设为默认量表",
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		return saveRatingScale(tx, c, scale, &req)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新评级量表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Scopes(models.PreloadRatingScale).First(scale, scale.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评级量表更新成功",
+		"data":    scale,
+	})
+}
+
+// 删除评级量表
+func DeleteRatingScale(c *gin.Context) {
+	scale, ok := loadRatingScale(c)
+	if !ok {
+		return
+	}
+
+	if scale.IsDefault {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "默认评级量表不能删除，请先将其他量表设为默认量表",
+		})
+		return
+	}
+
+	// 检查是否有模板使用该量表
+	var templateCount int64
+	models.DB.Model(&models.KPITemplate{}).Where("rating_scale_id = ?", scale.ID).Count(&templateCount)
+	if templateCount > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该评级量表正在被模板使用，无法删除",
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("scale_id = ?", scale.ID).Delete(&models.RatingGrade{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("scale_id = ?", scale.ID).Delete(&models.RatingAnchor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(scale).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityRatingScale, scale.ID, scale)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除评级量表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评级量表删除成功",
+	})
+}
+
+// 加载评级量表及其等级和锚点
+func loadRatingScale(c *gin.Context) (*models.RatingScale, bool) {
+	id := c.Param("id")
+	scaleId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评级量表ID",
+		})
+		return nil, false
+	}
+
+	var scale models.RatingScale
+	if err := models.DB.Scopes(models.PreloadRatingScale).First(&scale, scaleId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评级量表不存在",
+		})
+		return nil, false
+	}
+
+	return &scale, true
+}
+
+// 校验评级量表：至少一个等级，等级名称和分数下限不能重复，锚点的得分率在0到100之间且不能重复
+func validateRatingScale(req *RatingScaleRequest) error {
+	if len(req.Grades) == 0 {
+		return errors.New("评级量表至少需要一个等级")
+	}
+
+	labels := make(map[string]bool)
+	minScores := make(map[float64]bool)
+	for i := range req.Grades {
+		grade := &req.Grades[i]
+		grade.Label = strings.TrimSpace(grade.Label)
+		if grade.Label == "" {
+			return errors.New("等级名称不能为空")
+		}
+		if labels[grade.Label] {
+			return fmt.Errorf("等级名称「%s」重复", grade.Label)
+		}
+		if minScores[grade.MinScore] {
+			return fmt.Errorf("等级的分数下限 %g 重复", grade.MinScore)
+		}
+		labels[grade.Label] = true
+		minScores[grade.MinScore] = true
+	}
+
+	minPercents := make(map[float64]bool)
+	for i := range req.Anchors {
+		anchor := &req.Anchors[i]
+		anchor.Label = strings.TrimSpace(anchor.Label)
+		if anchor.Label == "" {
+			return errors.New("评分锚点名称不能为空")
+		}
+		if anchor.MinPercent < 0 || anchor.MinPercent > 100 {
+			return errors.New("评分锚点的得分率必须在 0 到 100 之间")
+		}
+		if minPercents[anchor.MinPercent] {
+			return fmt.Errorf("评分锚点的得分率 %g 重复", anchor.MinPercent)
+		}
+		minPercents[anchor.MinPercent] = true
+	}
+
+	return nil
+}
+
+// 保存评级量表并替换等级和锚点（scale 为空记录时视为创建）
+func saveRatingScale(tx *gorm.DB, c *gin.Context, scale *models.RatingScale, req *RatingScaleRequest) error {
+	before := *scale
+
+	// 只能有一个默认量表
+	if req.IsDefault && !scale.IsDefault {
+		var previous []models.RatingScale
+		if err := tx.Where("is_default = ?", true).Find(&previous).Error; err != nil {
+			return err
+		}
+		for _, other := range previous {
+			if err := tx.Model(&other).Update("is_default", false).Error; err != nil {
+				return err
+			}
+			if err := auditField(tx, c, AuditEntityRatingScale, other.ID, "is_default", true, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	scale.Name = req.Name
+	scale.Description = req.Description
+	scale.IsDefault = req.IsDefault
+	if err := tx.Omit("Grades", "Anchors").Save(scale).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("scale_id = ?", scale.ID).Delete(&models.RatingGrade{}).Error; err != nil {
+		return err
+	}
+	for _, grade := range req.Grades {
+		grade.ID = 0
+		grade.ScaleID = scale.ID
+		if err := tx.Create(&grade).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("scale_id = ?", scale.ID).Delete(&models.RatingAnchor{}).Error; err != nil {
+		return err
+	}
+	for _, anchor := range req.Anchors {
+		anchor.ID = 0
+		anchor.ScaleID = scale.ID
+		if err := tx.Create(&anchor).Error; err != nil {
+			return err
+		}
+	}
+
+	if before.ID == 0 {
+		if err := auditCreate(tx, c, AuditEntityRatingScale, scale.ID, scale); err != nil {
+			return err
+		}
+	} else if err := auditUpdate(tx, c, AuditEntityRatingScale, scale.ID, &before, scale); err != nil {
+		return err
+	}
+
+	var saved models.RatingScale
+	if err := tx.Scopes(models.PreloadRatingScale).First(&saved, scale.ID).Error; err != nil {
+		return err
+	}
+	if err := auditField(tx, c, AuditEntityRatingScale, scale.ID, "grades", formatRatingGrades(before.Grades), formatRatingGrades(saved.Grades)); err != nil {
+		return err
+	}
+	return auditField(tx, c, AuditEntityRatingScale, scale.ID, "anchors", formatRatingAnchors(before.Anchors), formatRatingAnchors(saved.Anchors))
+}
+
+// 格式化评级等级用于审计记录
+func formatRatingGrades(grades []models.RatingGrade) string {
+	var parts []string
+	for _, grade := range grades {
+		parts = append(parts, fmt.Sprintf("%s>=%g", grade.Label, grade.MinScore))
+	}
+	return strings.Join(parts, ";")
+}
+
+// 格式化评分锚点用于审计记录
+func formatRatingAnchors(anchors []models.RatingAnchor) string {
+	var parts []string
+	for _, anchor := range anchors {
+		parts = append(parts, fmt.Sprintf("%s>=%g%%:%s", anchor.Label, anchor.MinPercent, anchor.Description))
+	}
+	return strings.Join(parts, ";")
+}
+
+// 校验模板引用的评级量表是否存在，为 0 时清空表示使用默认量表
+func validateTemplateRatingScale(tx *gorm.DB, scaleID **uint) error {
+	if *scaleID == nil {
+		return nil
+	}
+	if **scaleID == 0 {
+		*scaleID = nil
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.RatingScale{}).Where("id = ?", **scaleID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("评级量表不存在")
+	}
+	return nil
+}
+
+// 根据总分确定评估等级，没有可用的评级量表或总分低于所有等级时等级为空
+func assignEvaluationGrade(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	scale, err := models.FindRatingScale(tx, evaluation.TemplateID)
+	if err != nil {
+		return err
+	}
+
+	grade := ""
+	var scaleID *uint
+	if scale != nil {
+		if matched := models.MatchRatingGrade(scale.Grades, evaluation.TotalScore); matched != nil {
+			grade = matched.Label
+			scaleID = &scale.ID
+		}
+	}
+
+	if err := tx.Model(evaluation).Updates(map[string]interface{}{
+		"grade":           grade,
+		"rating_scale_id": scaleID,
+	}).Error; err != nil {
+		return err
+	}
+	evaluation.Grade = grade
+	evaluation.RatingScaleID = scaleID
+	return nil
+}
+
+// 获取评估使用的评级量表：已确定等级的评估使用当时的量表，否则使用模板当前的量表
+func getEvaluationRatingScale(tx *gorm.DB, evaluation *models.KPIEvaluation) (*models.RatingScale, error) {
+	if evaluation.RatingScaleID != nil {
+		var scale models.RatingScale
+		if err := tx.Scopes(models.PreloadRatingScale).First(&scale, *evaluation.RatingScaleID).Error; err == nil {
+			return &scale, nil
+		}
+	}
+	return models.FindRatingScale(tx, evaluation.TemplateID)
+}
+
+// 按评级量表的锚点生成各考核项目的评分标准，分类项目不直接评分
+func buildRubric(scale *models.RatingScale, scores []models.KPIScore) map[uint][]RubricLevel {
+	rubric := make(map[uint][]RubricLevel)
+	if scale == nil || len(scale.Anchors) == 0 {
+		return rubric
+	}
+
+	for _, score := range scores {
+		if isCategoryItem(&score.Item) {
+			continue
+		}
+		levels := make([]RubricLevel, 0, len(scale.Anchors))
+		upper := score.Item.MaxScore
+		for _, anchor := range scale.Anchors {
+			lower := roundScore(score.Item.MaxScore * anchor.MinPercent / 100)
+			levels = append(levels, RubricLevel{
+				Label:       anchor.Label,
+				Description: anchor.Description,
+				MinScore:    lower,
+				MaxScore:    upper,
+			})
+			upper = lower
+		}
+		rubric[score.ItemID] = levels
+	}
+	return rubric
+}
+
+// 格式化评级等级对应的总分区间，grades 需要按下限从高到低排列
+func formatGradeRange(grades []models.RatingGrade, i int) string {
+	if i == 0 {
+		return fmt.Sprintf("%g以上", grades[i].MinScore)
+	}
+	if grades[i].MinScore <= 0 {
+		return fmt.Sprintf("%g以下", grades[i-1].MinScore)
+	}
+	return fmt.Sprintf("%g-%g", grades[i].MinScore, grades[i-1].MinScore)
+}
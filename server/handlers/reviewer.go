@@ -0,0 +1,1045 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 评审人类型
+const (
+	ReviewerTypeDirectManager = "direct_manager" // 直属上级
+	ReviewerTypeSkipLevel     = "skip_level"     // 隔级上级
+	ReviewerTypeEmployee      = "employee"       // 指定员工
+)
+
+// 评审记录角色
+const (
+	ReviewerRoleScorer   = "scorer"   // 评分
+	ReviewerRoleApprover = "approver" // 最终审批
+)
+
+// 评审记录状态
+const (
+	ReviewerStatusPending   = "pending"   // 待评审
+	ReviewerStatusSubmitted = "submitted" // 已提交评分
+	ReviewerStatusApproved  = "approved"  // 已审批
+)
+
+// ReviewerChainRequest 评审链请求
+type ReviewerChainRequest struct {
+	Name          string                     `json:"name" binding:"required"`
+	EmployeeID    *uint                      `json:"employee_id"`
+	TemplateID    *uint                      `json:"template_id"`
+	FinalApproval bool                       `json:"final_approval"`
+	Description   string                     `json:"description"`
+	Steps         []ReviewerChainStepRequest `json:"steps"`
+}
+
+// ReviewerChainStepRequest 评审链步骤请求
+type ReviewerChainStepRequest struct {
+	Stage        int     `json:"stage"`
+	ReviewerType string  `json:"reviewer_type"`
+	ReviewerID   *uint   `json:"reviewer_id"`
+	Weight       float64 `json:"weight"`
+	Label        string  `json:"label"`
+}
+
+// ReviewRequest 评审人评分请求，submit 为 true 时提交评分，否则只保存草稿
+type ReviewRequest struct {
+	Scores  []ReviewScoreRequest `json:"scores"`
+	Comment string               `json:"comment"`
+	Submit  bool                 `json:"submit"`
+}
+
+// ReviewScoreRequest 评审人对单个考核项目的评分
+type ReviewScoreRequest struct {
+	ScoreID uint     `json:"score_id" binding:"required"`
+	Score   *float64 `json:"score"`
+	Comment string   `json:"comment"`
+}
+
+// 评审链步骤按阶段排序
+func orderedChainSteps(db *gorm.DB) *gorm.DB {
+	return db.Order("stage, id")
+}
+
+// 获取评审链列表
+func GetReviewerChains(c *gin.Context) {
+	query := models.DB.Preload("Steps", orderedChainSteps).Preload("Steps.Reviewer").Preload("Employee").Preload("Template")
+	if employeeID := c.Query("employee_id"); employeeID != "" {
+		query = query.Where("employee_id = ?", employeeID)
+	}
+	if templateID := c.Query("template_id"); templateID != "" {
+		query = query.Where("template_id = ?", templateID)
+	}
+
+	var chains []models.ReviewerChain
+	if err := query.Order("id").Find(&chains).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评审链列表失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  chains,
+		"total": len(chains),
+	})
+}
+
+// 获取单个评审链
+func GetReviewerChain(c *gin.Context) {
+	chain, ok := loadReviewerChain(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": chain,
+	})
+}
+
+// 创建评审链
+func CreateReviewerChain(c *gin.Context) {
+	var req ReviewerChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	chain := models.ReviewerChain{
+		Name:          req.Name,
+		EmployeeID:    req.EmployeeID,
+		TemplateID:    req.TemplateID,
+		FinalApproval: req.FinalApproval,
+		Description:   req.Description,
+		Steps:         buildChainSteps(req.Steps),
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateReviewerChain(tx, &req, 0); err != nil {
+			return err
+		}
+		if err := tx.Create(&chain).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityReviewerChain, chain.ID, chain)
+	})
+	if err != nil {
+		respondReviewError(c, err, "创建评审链失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "评审链创建成功",
+		"data":    chain,
+	})
+}
+
+// 更新评审链，评审步骤整体替换
+// 已进入上级评分阶段的评估按生成时的评审人继续评审，不受修改影响
+func UpdateReviewerChain(c *gin.Context) {
+	chain, ok := loadReviewerChain(c)
+	if !ok {
+		return
+	}
+
+	var req ReviewerChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	before := *chain
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := validateReviewerChain(tx, &req, chain.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(chain).Select("name", "employee_id", "template_id", "final_approval", "description").Updates(map[string]interface{}{
+			"name":           req.Name,
+			"employee_id":    req.EmployeeID,
+			"template_id":    req.TemplateID,
+			"final_approval": req.FinalApproval,
+			"description":    req.Description,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("chain_id = ?", chain.ID).Delete(&models.ReviewerChainStep{}).Error; err != nil {
+			return err
+		}
+		steps := buildChainSteps(req.Steps)
+		for i := range steps {
+			steps[i].ChainID = chain.ID
+		}
+		if err := tx.Create(&steps).Error; err != nil {
+			return err
+		}
+		chain.EmployeeID = req.EmployeeID
+		chain.TemplateID = req.TemplateID
+		chain.Steps = steps
+		chain.Employee = nil
+		chain.Template = nil
+		return auditUpdate(tx, c, AuditEntityReviewerChain, chain.ID, before, *chain)
+	})
+	if err != nil {
+		respondReviewError(c, err, "更新评审链失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评审链更新成功",
+		"data":    chain,
+	})
+}
+
+// 删除评审链
+func DeleteReviewerChain(c *gin.Context) {
+	chain, ok := loadReviewerChain(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("chain_id = ?", chain.ID).Delete(&models.ReviewerChainStep{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(chain).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityReviewerChain, chain.ID, *chain)
+	})
+	if err != nil {
+		respondReviewError(c, err, "删除评审链失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评审链删除成功",
+	})
+}
+
+// 获取评估的评审人及评分
+func GetEvaluationReviewers(c *gin.Context) {
+	evaluation, ok := loadReviewEvaluation(c)
+	if !ok {
+		return
+	}
+	if !requireEvaluationAccess(c, evaluation) {
+		return
+	}
+
+	records, err := getEvaluationReviewers(models.DB, evaluation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评审人失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":          records,
+		"total":         len(records),
+		"current_stage": currentReviewStage(records),
+	})
+}
+
+// 获取当前用户待处理的评审
+func GetMyReviews(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var records []models.EvaluationReviewer
+	if err := models.DB.
+		Joins("JOIN kpi_evaluations ON kpi_evaluations.id = evaluation_reviewers.evaluation_id").
+		Where("evaluation_reviewers.reviewer_id = ? AND evaluation_reviewers.status = ?", userID, ReviewerStatusPending).
+		Where("kpi_evaluations.status = ?", EvaluationStatusSelfEvaluated).
+		Order("evaluation_reviewers.id").
+		Find(&records).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取待处理评审失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 只返回已轮到当前用户的评审
+	var evaluationIDs []uint
+	for _, record := range records {
+		var earlier int64
+		models.DB.Model(&models.EvaluationReviewer{}).
+			Where("evaluation_id = ? AND status = ? AND stage < ?", record.EvaluationID, ReviewerStatusPending, record.Stage).
+			Count(&earlier)
+		if earlier == 0 {
+			evaluationIDs = append(evaluationIDs, record.EvaluationID)
+		}
+	}
+
+	var evaluations []models.KPIEvaluation
+	if len(evaluationIDs) > 0 {
+		if err := models.DB.Preload("Employee.Department").Preload("Template").
+			Where("id IN ?", evaluationIDs).Order("id").Find(&evaluations).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "获取待处理评审失败",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  evaluations,
+		"total": len(evaluations),
+	})
+}
+
+// 评审人保存或提交评分
+func SubmitReview(c *gin.Context) {
+	evaluation, ok := loadReviewEvaluation(c)
+	if !ok {
+		return
+	}
+
+	var req ReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	previousStatus := evaluation.Status
+	var record *models.EvaluationReviewer
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		record, err = requireCurrentReviewer(tx, evaluation, c.GetUint("user_id"), ReviewerRoleScorer)
+		if err != nil {
+			return err
+		}
+		if err := saveReviewerScores(tx, c, evaluation, record, req.Scores); err != nil {
+			return err
+		}
+
+		before := *record
+		updates := map[string]interface{}{"comment": req.Comment}
+		if req.Submit {
+			if err := requireReviewerScoresFilled(tx, evaluation, record); err != nil {
+				return err
+			}
+			updates["status"] = ReviewerStatusSubmitted
+			updates["submitted_at"] = time.Now()
+		}
+		if err := tx.Model(record).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := auditUpdate(tx, c, AuditEntityReviewer, record.ID, before, *record); err != nil {
+			return err
+		}
+		return advanceReviewChain(tx, c, evaluation)
+	})
+	if err != nil {
+		respondReviewError(c, err, "保存评审评分失败")
+		return
+	}
+
+	models.DB.Preload("Reviewer").Preload("Scores").First(record, record.ID)
+
+	message := "评审评分已保存"
+	if req.Submit {
+		message = "评审评分已提交"
+		notifyReviewProgress(c, evaluation, previousStatus)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"data":    record,
+	})
+}
+
+// 隔级上级审批通过评审结果
+func ApproveReview(c *gin.Context) {
+	evaluation, ok := loadReviewEvaluation(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	previousStatus := evaluation.Status
+	var record *models.EvaluationReviewer
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		record, err = requireCurrentReviewer(tx, evaluation, c.GetUint("user_id"), ReviewerRoleApprover)
+		if err != nil {
+			return err
+		}
+
+		before := *record
+		if err := tx.Model(record).Updates(map[string]interface{}{
+			"status":       ReviewerStatusApproved,
+			"comment":      req.Comment,
+			"submitted_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := auditUpdate(tx, c, AuditEntityReviewer, record.ID, before, *record); err != nil {
+			return err
+		}
+		return advanceReviewChain(tx, c, evaluation)
+	})
+	if err != nil {
+		respondReviewError(c, err, "审批评审结果失败")
+		return
+	}
+
+	models.DB.Preload("Reviewer").First(record, record.ID)
+	notifyReviewProgress(c, evaluation, previousStatus)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评审结果已审批通过",
+		"data":    record,
+	})
+}
+
+// 隔级上级退回评审结果，评分人重新评分
+func ReturnReview(c *gin.Context) {
+	evaluation, ok := loadReviewEvaluation(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请填写退回原因",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	operatorID := c.GetUint("user_id")
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		record, err := requireCurrentReviewer(tx, evaluation, operatorID, ReviewerRoleApprover)
+		if err != nil {
+			return err
+		}
+
+		before := *record
+		if err := tx.Model(record).Update("comment", req.Reason).Error; err != nil {
+			return err
+		}
+		if err := auditUpdate(tx, c, AuditEntityReviewer, record.ID, before, *record); err != nil {
+			return err
+		}
+		if err := resetEvaluationReviewers(tx, c, evaluation.ID, ReviewerRoleScorer); err != nil {
+			return err
+		}
+
+		// 退回原因记录为系统评论，保留在评估的讨论记录中
+		comment := models.EvaluationComment{
+			EvaluationID: evaluation.ID,
+			UserID:       operatorID,
+			Content:      fmt.Sprintf("【评审退回】%s", req.Reason),
+			IsSystem:     true,
+		}
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		return auditCreate(tx, c, AuditEntityComment, comment.ID, comment)
+	})
+	if err != nil {
+		respondReviewError(c, err, "退回评审结果失败")
+		return
+	}
+
+	notifyCurrentReviewers(c, evaluation)
+	GetNotificationService().SendNotification(operatorID, EventEvaluationUpdated, evaluation)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评审结果已退回，评审人需重新评分",
+	})
+}
+
+// 校验评审链配置
+func validateReviewerChain(tx *gorm.DB, req *ReviewerChainRequest, chainID uint) error {
+	invalid := func(message string) error {
+		return &TransitionError{StatusCode: http.StatusBadRequest, Message: message}
+	}
+
+	if (req.EmployeeID == nil) == (req.TemplateID == nil) {
+		return invalid("评审链需要且只能指定员工或模板其中之一")
+	}
+	if req.EmployeeID != nil {
+		var count int64
+		tx.Model(&models.Employee{}).Where("id = ?", *req.EmployeeID).Count(&count)
+		if count == 0 {
+			return invalid("员工不存在")
+		}
+		tx.Model(&models.ReviewerChain{}).Where("employee_id = ? AND id <> ?", *req.EmployeeID, chainID).Count(&count)
+		if count > 0 {
+			return invalid("该员工已配置评审链")
+		}
+	}
+	if req.TemplateID != nil {
+		var count int64
+		tx.Model(&models.KPITemplate{}).Where("id = ?", *req.TemplateID).Count(&count)
+		if count == 0 {
+			return invalid("模板不存在")
+		}
+		tx.Model(&models.ReviewerChain{}).Where("template_id = ? AND id <> ?", *req.TemplateID, chainID).Count(&count)
+		if count > 0 {
+			return invalid("该模板已配置评审链")
+		}
+	}
+
+	if len(req.Steps) == 0 {
+		return invalid("评审链至少需要一个评审步骤")
+	}
+	for i, step := range req.Steps {
+		if step.Stage < 1 {
+			return invalid(fmt.Sprintf("第 %d 个评审步骤的阶段必须从1开始", i+1))
+		}
+		if math.IsNaN(step.Weight) || step.Weight <= 0 {
+			return invalid(fmt.Sprintf("第 %d 个评审步骤的权重必须大于0", i+1))
+		}
+		switch step.ReviewerType {
+		case ReviewerTypeDirectManager, ReviewerTypeSkipLevel:
+		case ReviewerTypeEmployee:
+			if step.ReviewerID == nil {
+				return invalid(fmt.Sprintf("第 %d 个评审步骤需要指定评审人", i+1))
+			}
+			if req.EmployeeID != nil && *step.ReviewerID == *req.EmployeeID {
+				return invalid("员工不能评审自己")
+			}
+			var count int64
+			tx.Model(&models.Employee{}).Where("id = ? AND is_active = ?", *step.ReviewerID, true).Count(&count)
+			if count == 0 {
+				return invalid(fmt.Sprintf("第 %d 个评审步骤的评审人不存在或已离职", i+1))
+			}
+		default:
+			return invalid(fmt.Sprintf("不支持的评审人类型: %s", step.ReviewerType))
+		}
+	}
+	return nil
+}
+
+// 根据请求生成评审链步骤
+func buildChainSteps(steps []ReviewerChainStepRequest) []models.ReviewerChainStep {
+	result := make([]models.ReviewerChainStep, 0, len(steps))
+	for _, step := range steps {
+		reviewerID := step.ReviewerID
+		if step.ReviewerType != ReviewerTypeEmployee {
+			reviewerID = nil
+		}
+		result = append(result, models.ReviewerChainStep{
+			Stage:        step.Stage,
+			ReviewerType: step.ReviewerType,
+			ReviewerID:   reviewerID,
+			Weight:       step.Weight,
+			Label:        step.Label,
+		})
+	}
+	return result
+}
+
+// 查找评估适用的评审链：员工的评审链优先于模板的评审链，都没有配置时返回nil
+func findReviewerChain(tx *gorm.DB, evaluation *models.KPIEvaluation) (*models.ReviewerChain, error) {
+	conditions := []struct {
+		column string
+		value  uint
+	}{
+		{"employee_id", evaluation.EmployeeID},
+		{"template_id", evaluation.TemplateID},
+	}
+	for _, condition := range conditions {
+		var chain models.ReviewerChain
+		err := tx.Preload("Steps", orderedChainSteps).Where(condition.column+" = ?", condition.value).First(&chain).Error
+		if err == nil {
+			return &chain, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// 评估是否由评审链评分
+func hasReviewChain(db *gorm.DB, evaluationID uint) bool {
+	var count int64
+	db.Model(&models.EvaluationReviewer{}).Where("evaluation_id = ?", evaluationID).Count(&count)
+	return count > 0
+}
+
+// 获取评估的评审记录，按阶段排序
+func getEvaluationReviewers(db *gorm.DB, evaluationID uint) ([]models.EvaluationReviewer, error) {
+	var records []models.EvaluationReviewer
+	err := db.Preload("Reviewer").Preload("Scores").
+		Where("evaluation_id = ?", evaluationID).
+		Order("stage, id").
+		Find(&records).Error
+	return records, err
+}
+
+// 当前评审阶段：尚未完成的评审记录中最小的阶段，全部完成时返回0
+func currentReviewStage(records []models.EvaluationReviewer) int {
+	stage := 0
+	for _, record := range records {
+		if record.Status == ReviewerStatusPending && (stage == 0 || record.Stage < stage) {
+			stage = record.Stage
+		}
+	}
+	return stage
+}
+
+// 员工完成自评时根据评审链生成评审记录；退回后重新自评时沿用已生成的评审人，已填写的评分保留为草稿
+func startReviewChain(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	if hasReviewChain(tx, evaluation.ID) {
+		return resetEvaluationReviewers(tx, nil, evaluation.ID)
+	}
+
+	chain, err := findReviewerChain(tx, evaluation)
+	if err != nil || chain == nil {
+		return err
+	}
+
+	var employee models.Employee
+	if err := tx.First(&employee, evaluation.EmployeeID).Error; err != nil {
+		return err
+	}
+	var skipLevelID *uint
+	if employee.ManagerID != nil {
+		var manager models.Employee
+		if err := tx.First(&manager, *employee.ManagerID).Error; err != nil {
+			return err
+		}
+		skipLevelID = manager.ManagerID
+	}
+
+	// 评审人无法确定的步骤跳过，同一评审人只评分一次
+	seen := map[uint]bool{employee.ID: true}
+	var records []models.EvaluationReviewer
+	lastStage := 0
+	for _, step := range chain.Steps {
+		var reviewerID *uint
+		switch step.ReviewerType {
+		case ReviewerTypeDirectManager:
+			reviewerID = employee.ManagerID
+		case ReviewerTypeSkipLevel:
+			reviewerID = skipLevelID
+		default:
+			reviewerID = step.ReviewerID
+		}
+		if reviewerID == nil || seen[*reviewerID] {
+			continue
+		}
+		seen[*reviewerID] = true
+		records = append(records, models.EvaluationReviewer{
+			EvaluationID: evaluation.ID,
+			ReviewerID:   *reviewerID,
+			Role:         ReviewerRoleScorer,
+			Stage:        step.Stage,
+			Label:        step.Label,
+			Weight:       step.Weight,
+			Status:       ReviewerStatusPending,
+		})
+		lastStage = max(lastStage, step.Stage)
+	}
+
+	// 没有可用的评审人时按直属上级评分处理
+	if len(records) == 0 {
+		return nil
+	}
+	if chain.FinalApproval && skipLevelID != nil && *skipLevelID != employee.ID {
+		records = append(records, models.EvaluationReviewer{
+			EvaluationID: evaluation.ID,
+			ReviewerID:   *skipLevelID,
+			Role:         ReviewerRoleApprover,
+			Stage:        lastStage + 1,
+			Label:        "隔级上级审批",
+			Status:       ReviewerStatusPending,
+		})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := auditCreate(tx, nil, AuditEntityReviewer, record.ID, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HR退回上级评分时，评审链的所有评审人重新评审
+func reopenReviewChain(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	return resetEvaluationReviewers(tx, nil, evaluation.ID)
+}
+
+// 将评审记录重置为待评审，roles 为空时重置全部评审记录
+func resetEvaluationReviewers(tx *gorm.DB, c *gin.Context, evaluationID uint, roles ...string) error {
+	query := tx.Where("evaluation_id = ? AND status <> ?", evaluationID, ReviewerStatusPending)
+	if len(roles) > 0 {
+		query = query.Where("role IN ?", roles)
+	}
+	var records []models.EvaluationReviewer
+	if err := query.Find(&records).Error; err != nil {
+		return err
+	}
+	for _, record := range records {
+		previous := record.Status
+		if err := tx.Model(&record).Updates(map[string]interface{}{
+			"status":       ReviewerStatusPending,
+			"submitted_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityReviewer, record.ID, "status", previous, ReviewerStatusPending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 校验当前用户是当前阶段待处理的评审人，返回其评审记录
+func requireCurrentReviewer(tx *gorm.DB, evaluation *models.KPIEvaluation, userID uint, role string) (*models.EvaluationReviewer, error) {
+	if evaluation.Status != EvaluationStatusSelfEvaluated {
+		return nil, &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估当前处于「%s」阶段，不能进行评审", getStatusText(evaluation.Status)),
+		}
+	}
+
+	var records []models.EvaluationReviewer
+	if err := tx.Where("evaluation_id = ?", evaluation.ID).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	stage := currentReviewStage(records)
+	for i := range records {
+		record := &records[i]
+		if record.ReviewerID != userID || record.Role != role {
+			continue
+		}
+		if record.Status != ReviewerStatusPending {
+			return nil, &TransitionError{StatusCode: http.StatusBadRequest, Message: "您已完成该评估的评审"}
+		}
+		if record.Stage != stage {
+			return nil, &TransitionError{StatusCode: http.StatusBadRequest, Message: "尚未轮到您评审，请等待前序评审人完成"}
+		}
+		return record, nil
+	}
+	return nil, &TransitionError{StatusCode: http.StatusForbidden, Message: "您不是该评估的评审人"}
+}
+
+// 保存评审人的项目评分，量化指标由实际完成值计算，不需要评审人评分
+func saveReviewerScores(tx *gorm.DB, c *gin.Context, evaluation *models.KPIEvaluation, record *models.EvaluationReviewer, scores []ReviewScoreRequest) error {
+	for _, input := range scores {
+		var score models.KPIScore
+		if err := tx.Preload("Item").Where("id = ? AND evaluation_id = ?", input.ScoreID, evaluation.ID).First(&score).Error; err != nil {
+			return &TransitionError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("评分记录 %d 不属于该评估", input.ScoreID)}
+		}
+		if score.Item.Type == ItemTypeQuantitative {
+			return &TransitionError{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("「%s」为量化指标，得分由实际完成值计算", score.Item.Name)}
+		}
+		if input.Score != nil {
+			if err := checkScoreRange(&score.Item, *input.Score); err != nil {
+				return err
+			}
+		}
+
+		var reviewerScore models.ReviewerScore
+		err := tx.Where("reviewer_record_id = ? AND score_id = ?", record.ID, score.ID).First(&reviewerScore).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			reviewerScore = models.ReviewerScore{
+				ReviewerRecordID: record.ID,
+				EvaluationID:     evaluation.ID,
+				ScoreID:          score.ID,
+				ItemID:           score.ItemID,
+				Score:            input.Score,
+				Comment:          input.Comment,
+			}
+			if err := tx.Create(&reviewerScore).Error; err != nil {
+				return err
+			}
+			if err := auditCreate(tx, c, AuditEntityReviewerScore, reviewerScore.ID, reviewerScore); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		before := reviewerScore
+		if err := tx.Model(&reviewerScore).Updates(map[string]interface{}{
+			"score":   input.Score,
+			"comment": input.Comment,
+		}).Error; err != nil {
+			return err
+		}
+		if err := auditUpdate(tx, c, AuditEntityReviewerScore, reviewerScore.ID, before, reviewerScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 提交前检查评审人已为所有非量化项目评分
+func requireReviewerScoresFilled(tx *gorm.DB, evaluation *models.KPIEvaluation, record *models.EvaluationReviewer) error {
+	var missing int64
+	if err := tx.Model(&models.KPIScore{}).
+		Joins("JOIN kpi_items ON kpi_scores.item_id = kpi_items.id").
+		Where("kpi_scores.evaluation_id = ? AND kpi_items.type <> ?", evaluation.ID, ItemTypeQuantitative).
+		Where("kpi_scores.id NOT IN (?)", tx.Model(&models.ReviewerScore{}).Select("score_id").
+			Where("reviewer_record_id = ? AND score IS NOT NULL", record.ID)).
+		Count(&missing).Error; err != nil {
+		return err
+	}
+	if missing > 0 {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("还有 %d 个考核项目未评分", missing),
+		}
+	}
+	return nil
+}
+
+// 评审人全部完成后汇总评分并进入HR审核
+func advanceReviewChain(tx *gorm.DB, c *gin.Context, evaluation *models.KPIEvaluation) error {
+	var pending int64
+	if err := tx.Model(&models.EvaluationReviewer{}).
+		Where("evaluation_id = ? AND status = ?", evaluation.ID, ReviewerStatusPending).
+		Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending > 0 {
+		return nil
+	}
+
+	rule := findSystemTransitionRule(evaluation.Status, EvaluationStatusManagerEvaluated)
+	if rule == nil {
+		return nil
+	}
+	previousStatus := evaluation.Status
+	if err := applyTransitionRule(tx, evaluation, rule); err != nil {
+		return err
+	}
+	return auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "status", previousStatus, evaluation.Status)
+}
+
+// 汇总评审人评分：每个项目的上级评分为评审人评分的加权平均，评语按评审人合并
+func completeReviewChain(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	var records []models.EvaluationReviewer
+	if err := tx.Preload("Reviewer").Preload("Scores").
+		Where("evaluation_id = ? AND role = ?", evaluation.ID, ReviewerRoleScorer).
+		Order("stage, id").
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	var scores []models.KPIScore
+	if err := tx.Preload("Item").Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err != nil {
+		return err
+	}
+
+	for _, score := range scores {
+		if score.Item.Type == ItemTypeQuantitative {
+			continue
+		}
+
+		sum, weights := 0.0, 0.0
+		var comments []string
+		for _, record := range records {
+			for _, reviewerScore := range record.Scores {
+				if reviewerScore.ScoreID != score.ID {
+					continue
+				}
+				if reviewerScore.Score != nil {
+					sum += *reviewerScore.Score * record.Weight
+					weights += record.Weight
+				}
+				if reviewerScore.Comment != "" {
+					comments = append(comments, fmt.Sprintf("%s：%s", getReviewerName(&record), reviewerScore.Comment))
+				}
+			}
+		}
+		if weights == 0 {
+			continue
+		}
+
+		value := roundScore(sum / weights)
+		previous := score.ManagerScore
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"manager_score":     value,
+			"manager_comment":   strings.Join(comments, "\n"),
+			"manager_auto":      false,
+			"manager_scorer_id": nil, // 评审链的评分按评审人记录统计
+		}).Error; err != nil {
+			return err
+		}
+		// 汇总得出的分数由系统记录
+		if err := auditField(tx, nil, AuditEntityScore, score.ID, "manager_score", previous, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 配置了评审链的评估不能由直属上级直接完成上级评分
+func requireNoReviewChain(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	if hasReviewChain(tx, evaluation.ID) {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "该评估由评审链评分，评审人全部完成后自动进入HR审核",
+		}
+	}
+	return nil
+}
+
+// 评审进展通知：评审链完成时通知HR，否则通知下一阶段的评审人
+func notifyReviewProgress(c *gin.Context, evaluation *models.KPIEvaluation, previousStatus string) {
+	operatorID := c.GetUint("user_id")
+	if evaluation.Status != previousStatus {
+		GetNotificationService().SendNotification(operatorID, EventEvaluationStatusChange, evaluation)
+		return
+	}
+	notifyCurrentReviewers(c, evaluation)
+	GetNotificationService().SendNotification(operatorID, EventEvaluationUpdated, evaluation)
+}
+
+// 通过DooTask机器人通知当前阶段的评审人
+func notifyCurrentReviewers(c *gin.Context, evaluation *models.KPIEvaluation) {
+	records, err := getEvaluationReviewers(models.DB, evaluation.ID)
+	if err != nil {
+		return
+	}
+	stage := currentReviewStage(records)
+	if stage == 0 {
+		return
+	}
+
+	var full models.KPIEvaluation
+	if err := models.DB.Preload("Employee").Preload("Template").First(&full, evaluation.ID).Error; err != nil {
+		return
+	}
+	dooTaskClient := utils.NewDooTaskClient(c.GetHeader("DooTaskAuth"))
+	periodValue := utils.GetPeriodValue(full.Period, full.Year, full.Month, full.Quarter)
+
+	for _, record := range records {
+		if record.Stage != stage || record.Status != ReviewerStatusPending || record.Reviewer.DooTaskUserID == nil {
+			continue
+		}
+		action := "评分"
+		if record.Role == ReviewerRoleApprover {
+			action = "审批评审结果"
+		}
+		message := fmt.Sprintf(
+			"### 📋 「%s」的绩效考核已轮到您%s。\n\n- **考核模板：** %s\n- **考核周期：** %s\n- **评审角色：** %s\n\n> 请前往「应用 - 绩效考核」中查看详情。",
+			full.Employee.Name,
+			action,
+			full.Template.Name,
+			periodValue,
+			getReviewerName(&record),
+		)
+		dooTaskClient.SendBotMessage(record.Reviewer.DooTaskUserID, message)
+	}
+}
+
+// 评审人显示名称：有评审角色时显示「角色（姓名）」
+func getReviewerName(record *models.EvaluationReviewer) string {
+	if record.Label == "" {
+		return record.Reviewer.Name
+	}
+	return fmt.Sprintf("%s（%s）", record.Label, record.Reviewer.Name)
+}
+
+// 根据路由参数加载评审链
+func loadReviewerChain(c *gin.Context) (*models.ReviewerChain, bool) {
+	id := c.Param("id")
+	chainId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评审链ID",
+		})
+		return nil, false
+	}
+
+	var chain models.ReviewerChain
+	if err := models.DB.Preload("Steps", orderedChainSteps).Preload("Steps.Reviewer").
+		Preload("Employee").Preload("Template").First(&chain, chainId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评审链不存在",
+		})
+		return nil, false
+	}
+
+	return &chain, true
+}
+
+// 根据路由参数加载评审的评估
+func loadReviewEvaluation(c *gin.Context) (*models.KPIEvaluation, bool) {
+	id := c.Param("id")
+	evaluationId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的评估ID",
+		})
+		return nil, false
+	}
+
+	var evaluation models.KPIEvaluation
+	if err := models.DB.Preload("Employee").First(&evaluation, evaluationId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "评估不存在",
+		})
+		return nil, false
+	}
+
+	return &evaluation, true
+}
+
+// 返回评审相关错误
+func respondReviewError(c *gin.Context, err error, message string) {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		c.JSON(transitionErr.StatusCode, gin.H{
+			"error": transitionErr.Message,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   message,
+		"message": err.Error(),
+	})
+}
@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 定时发起触发方式
+const (
+	ScheduleTriggerScheduled = "scheduled" // 到达发起日自动发起
+	ScheduleTriggerCatchUp   = "catch_up"  // 停机期间错过的周期补发
+	ScheduleTriggerManual    = "manual"    // HR手动发起
+)
+
+// 定时发起运行状态
+const (
+	ScheduleRunSuccess = "success"
+	ScheduleRunFailed  = "failed"
+)
+
+// 定时发起检查间隔
+const scheduleCheckInterval = 1 * time.Hour
+
+// 防止定时任务与手动发起同时运行
+var scheduleMutex sync.Mutex
+
+// 定时发起计划请求结构
+type EvaluationScheduleRequest struct {
+	TemplateID    uint       `json:"template_id" binding:"required"`
+	Enabled       *bool      `json:"enabled"`
+	RunDay        int        `json:"run_day"`
+	StartDate     *time.Time `json:"start_date"`
+	DepartmentIDs []uint     `json:"department_ids"`
+}
+
+// 考核周期（年份 + 月份/季度）
+type schedulePeriod struct {
+	Year    int
+	Month   *int
+	Quarter *int
+	Start   time.Time // 周期开始日期
+}
+
+// 获取定时发起计划列表
+func GetSchedules(c *gin.Context) {
+	var schedules []models.EvaluationSchedule
+	result := models.DB.Preload("Template").Preload("Departments").Order("id").Find(&schedules)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取定时发起计划失败",
+			"message": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": schedules,
+	})
+}
+
+// 创建定时发起计划
+func CreateSchedule(c *gin.Context) {
+	var req EvaluationScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var count int64
+	models.DB.Model(&models.EvaluationSchedule{}).Where("template_id = ?", req.TemplateID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该模板已配置定时发起计划",
+		})
+		return
+	}
+
+	schedule := models.EvaluationSchedule{
+		Enabled:   true,
+		CreatedBy: c.GetUint("user_id"),
+	}
+	if err := applyScheduleRequest(&schedule, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Template", "Departments").Create(&schedule).Error; err != nil {
+			return err
+		}
+		if err := saveScheduleDepartments(tx, &schedule, req.DepartmentIDs); err != nil {
+			return err
+		}
+		if err := auditCreate(tx, c, AuditEntitySchedule, schedule.ID, schedule); err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntitySchedule, schedule.ID, "department_ids", nil, req.DepartmentIDs)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "创建定时发起计划失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("Template").Preload("Departments").First(&schedule, schedule.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "定时发起计划创建成功",
+		"data":    schedule,
+	})
+}
+
+// 更新定时发起计划
+func UpdateSchedule(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+
+	var req EvaluationScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if req.TemplateID != schedule.TemplateID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "定时发起计划不能更换模板",
+		})
+		return
+	}
+
+	before := *schedule
+	var oldDepartmentIDs []uint
+	for _, department := range schedule.Departments {
+		oldDepartmentIDs = append(oldDepartmentIDs, department.ID)
+	}
+
+	if err := applyScheduleRequest(schedule, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Template", "Departments").Save(schedule).Error; err != nil {
+			return err
+		}
+		if err := saveScheduleDepartments(tx, schedule, req.DepartmentIDs); err != nil {
+			return err
+		}
+		if err := auditUpdate(tx, c, AuditEntitySchedule, schedule.ID, &before, schedule); err != nil {
+			return err
+		}
+		return auditField(tx, c, AuditEntitySchedule, schedule.ID, "department_ids", oldDepartmentIDs, req.DepartmentIDs)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新定时发起计划失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	models.DB.Preload("Template").Preload("Departments").First(schedule, schedule.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "定时发起计划更新成功",
+		"data":    schedule,
+	})
+}
+
+// 删除定时发起计划（保留运行记录）
+func DeleteSchedule(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(schedule).Association("Departments").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(schedule).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntitySchedule, schedule.ID, schedule)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除定时发起计划失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "定时发起计划删除成功",
+	})
+}
+
+// 立即执行定时发起计划（补发错过的周期，并发起当前周期）
+func RunSchedule(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+
+	if !schedule.Template.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "考核模板已停用，无法发起评估",
+		})
+		return
+	}
+
+	scheduleMutex.Lock()
+	runs := runSchedule(c, schedule, time.Now())
+	scheduleMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("定时发起计划执行完成，共处理 %d 个周期", len(runs)),
+		"data":    runs,
+	})
+}
+
+// 获取定时发起运行记录
+func GetScheduleRuns(c *gin.Context) {
+	var runs []models.ScheduleRun
+
+	// 解析分页参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	scheduleID := c.Query("schedule_id")
+	templateID := c.Query("template_id")
+	status := c.Query("status")
+
+	// 验证分页参数
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	// 构建查询
+	query := models.DB.Model(&models.ScheduleRun{})
+	if scheduleID != "" {
+		query = query.Where("schedule_id = ?", scheduleID)
+	}
+	if templateID != "" {
+		query = query.Where("template_id = ?", templateID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	// 获取总数
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取运行记录总数失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 分页查询
+	offset := (page - 1) * pageSize
+	result := query.Preload("Template").Order("started_at DESC, id DESC").Offset(offset).Limit(pageSize).Find(&runs)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取运行记录失败",
+			"message": result.Error.Error(),
+		})
+		return
+	}
+
+	// 计算分页信息
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       runs,
+		"total":      total,
+		"page":       page,
+		"pageSize":   pageSize,
+		"totalPages": totalPages,
+		"hasNext":    page < totalPages,
+		"hasPrev":    page > 1,
+	})
+}
+
+// 启动定时发起评估的后台任务
+func StartEvaluationScheduleTask() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	go func() {
+		// 启动时立即检查一次，补发停机期间错过的周期
+		RunDueSchedules(time.Now())
+		for range ticker.C {
+			RunDueSchedules(time.Now())
+		}
+	}()
+}
+
+// RunDueSchedules 执行所有到期的定时发起计划
+func RunDueSchedules(now time.Time) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	var schedules []models.EvaluationSchedule
+	if err := models.DB.Preload("Template").Preload("Departments").Where("enabled = ?", true).Find(&schedules).Error; err != nil {
+		fmt.Printf("获取定时发起计划失败: %v\n", err)
+		return
+	}
+
+	for i := range schedules {
+		if !schedules[i].Template.IsActive {
+			continue
+		}
+		runSchedule(nil, &schedules[i], now)
+	}
+}
+
+// 执行单个定时发起计划，返回本次产生的运行记录
+// c 为空表示后台任务执行，否则为HR手动执行：当前周期不受发起日限制，且已发起过也会再次检查新增员工
+func runSchedule(c *gin.Context, schedule *models.EvaluationSchedule, now time.Time) []models.ScheduleRun {
+	runs := []models.ScheduleRun{}
+	manual := c != nil
+	period := schedule.Template.Period
+	current := schedulePeriodIndex(period, now)
+
+	for index := schedulePeriodIndex(period, schedule.StartDate); index <= current; index++ {
+		p := schedulePeriodFromIndex(period, index)
+
+		trigger := ScheduleTriggerScheduled
+		if index < current {
+			trigger = ScheduleTriggerCatchUp
+		}
+		if manual && index == current {
+			trigger = ScheduleTriggerManual
+		} else {
+			// 未到发起日
+			if p.Start.AddDate(0, 0, schedule.RunDay-1).After(now) {
+				continue
+			}
+			// 已成功发起过的周期不再重复处理
+			var succeeded int64
+			wherePeriod(models.DB.Model(&models.ScheduleRun{}), period, p.Year, p.Month, p.Quarter).
+				Where("schedule_id = ? AND status = ?", schedule.ID, ScheduleRunSuccess).
+				Count(&succeeded)
+			if succeeded > 0 {
+				continue
+			}
+		}
+
+		runs = append(runs, executeScheduleRun(c, schedule, p, trigger))
+	}
+
+	return runs
+}
+
+// 为指定周期发起评估并记录运行结果
+func executeScheduleRun(c *gin.Context, schedule *models.EvaluationSchedule, p schedulePeriod, trigger string) models.ScheduleRun {
+	run := models.ScheduleRun{
+		ScheduleID: schedule.ID,
+		TemplateID: schedule.TemplateID,
+		Period:     schedule.Template.Period,
+		Year:       p.Year,
+		Month:      p.Month,
+		Quarter:    p.Quarter,
+		Trigger:    trigger,
+		StartedAt:  time.Now(),
+	}
+	periodValue := utils.GetPeriodValue(run.Period, run.Year, run.Month, run.Quarter)
+
+	var result *LaunchCycleResult
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		templateID := schedule.TemplateID
+		cycle := models.ReviewCycle{
+			Name:              fmt.Sprintf("%s %s", schedule.Template.Name, periodValue),
+			Period:            run.Period,
+			Year:              run.Year,
+			Month:             run.Month,
+			Quarter:           run.Quarter,
+			DefaultTemplateID: &templateID,
+			Status:            CycleStatusOpen,
+			CreatedBy:         schedule.CreatedBy,
+		}
+		if err := tx.Omit("Departments", "TemplateRules", "DefaultTemplate").Create(&cycle).Error; err != nil {
+			return err
+		}
+		if err := auditCreate(tx, c, AuditEntityCycle, cycle.ID, cycle); err != nil {
+			return err
+		}
+		if len(schedule.Departments) > 0 {
+			if err := tx.Model(&cycle).Association("Departments").Replace(schedule.Departments); err != nil {
+				return err
+			}
+		}
+		cycle.Departments = schedule.Departments
+
+		launched, err := launchCycle(tx, c, &cycle)
+		if err != nil {
+			return err
+		}
+		result = launched
+
+		// 没有新建评估时不保留空的考核周期
+		if len(launched.Created) == 0 {
+			if err := tx.Model(&cycle).Association("Departments").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Delete(&cycle).Error; err != nil {
+				return err
+			}
+			return auditDelete(tx, c, AuditEntityCycle, cycle.ID, cycle)
+		}
+		run.CycleID = &cycle.ID
+		return nil
+	})
+
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Status = ScheduleRunFailed
+		run.Message = err.Error()
+	} else {
+		run.Status = ScheduleRunSuccess
+		run.CreatedCount = len(result.Created)
+		run.SkippedCount = len(result.Skipped)
+		run.Message = fmt.Sprintf("创建 %d 条评估，跳过 %d 名员工", run.CreatedCount, run.SkippedCount)
+		if len(result.Skipped) > 0 {
+			var skipped []string
+			for _, s := range result.Skipped {
+				skipped = append(skipped, fmt.Sprintf("%s（%s）", s.EmployeeName, s.Reason))
+			}
+			run.Message += "：" + strings.Join(skipped, "、")
+		}
+	}
+
+	if err := models.DB.Create(&run).Error; err != nil {
+		fmt.Printf("保存定时发起运行记录失败: %v\n", err)
+	}
+	run.Template = schedule.Template
+	models.DB.Model(schedule).Update("last_run_at", run.FinishedAt)
+	fmt.Printf("定时发起「%s」%s：%s\n", schedule.Template.Name, periodValue, run.Message)
+
+	// 发送通知
+	if result != nil {
+		for i := range result.Created {
+			notifyEvaluationCreated(&result.Created[i], "", 0, "系统")
+		}
+	}
+
+	return run
+}
+
+// 获取时间所在周期的序号（月度：年*12+月，季度：年*4+季度，年度：年）
+func schedulePeriodIndex(period string, t time.Time) int {
+	switch period {
+	case "monthly":
+		return t.Year()*12 + int(t.Month()) - 1
+	case "quarterly":
+		return t.Year()*4 + (int(t.Month())-1)/3
+	default:
+		return t.Year()
+	}
+}
+
+// 根据周期序号还原周期
+func schedulePeriodFromIndex(period string, index int) schedulePeriod {
+	switch period {
+	case "monthly":
+		year, month := index/12, index%12+1
+		return schedulePeriod{
+			Year:  year,
+			Month: &month,
+			Start: time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local),
+		}
+	case "quarterly":
+		year, quarter := index/4, index%4+1
+		return schedulePeriod{
+			Year:    year,
+			Quarter: &quarter,
+			Start:   time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.Local),
+		}
+	default:
+		return schedulePeriod{
+			Year:  index,
+			Start: time.Date(index, time.January, 1, 0, 0, 0, 0, time.Local),
+		}
+	}
+}
+
+// 加载定时发起计划及其关联数据
+func loadSchedule(c *gin.Context) (*models.EvaluationSchedule, bool) {
+	id := c.Param("id")
+	scheduleId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的定时发起计划ID",
+		})
+		return nil, false
+	}
+
+	var schedule models.EvaluationSchedule
+	if err := models.DB.Preload("Template").Preload("Departments").First(&schedule, scheduleId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "定时发起计划不存在",
+		})
+		return nil, false
+	}
+
+	return &schedule, true
+}
+
+// 校验请求并写入定时发起计划
+func applyScheduleRequest(schedule *models.EvaluationSchedule, req *EvaluationScheduleRequest) error {
+	var template models.KPITemplate
+	if err := models.DB.First(&template, req.TemplateID).Error; err != nil {
+		return fmt.Errorf("模板不存在")
+	}
+	switch template.Period {
+	case "monthly", "quarterly", "yearly":
+	default:
+		return fmt.Errorf("模板周期「%s」不支持定时发起", template.Period)
+	}
+
+	if req.RunDay == 0 {
+		req.RunDay = 1
+	}
+	if req.RunDay < 1 || req.RunDay > 28 {
+		return fmt.Errorf("发起日必须在1-28之间")
+	}
+
+	schedule.TemplateID = template.ID
+	schedule.RunDay = req.RunDay
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+	if req.StartDate != nil {
+		schedule.StartDate = *req.StartDate
+	} else if schedule.StartDate.IsZero() {
+		schedule.StartDate = time.Now()
+	}
+	return nil
+}
+
+// 保存定时发起计划的参与部门
+func saveScheduleDepartments(tx *gorm.DB, schedule *models.EvaluationSchedule, departmentIDs []uint) error {
+	var departments []models.Department
+	if len(departmentIDs) > 0 {
+		if err := tx.Where("id IN ?", departmentIDs).Find(&departments).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(schedule).Association("Departments").Replace(departments)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 邀请评分人可以查看的邀请状态（已拒绝和已撤销的邀请不再授予查看权限）
+var visibleInvitationStatuses = []string{"pending", "accepted", "completed"}
+
+// DataScope 当前用户的数据查看范围
+// HR可以查看全部数据；其他用户可以查看本人及下属（按直属上级递归）的数据，以及受邀评分和担任评审人的评估
+type DataScope struct {
+	All         bool
+	UserID      uint
+	EmployeeIDs []uint
+}
+
+// 获取当前请求用户的数据范围（同一请求内只计算一次）
+func getDataScope(c *gin.Context) *DataScope {
+	if scope, ok := c.Get("data_scope"); ok {
+		return scope.(*DataScope)
+	}
+
+	userID := c.GetUint("user_id")
+	scope := &DataScope{UserID: userID}
+	if c.GetString("user_role") == "hr" {
+		scope.All = true
+	} else {
+		scope.EmployeeIDs = getSubordinateTreeIDs(userID)
+	}
+
+	c.Set("data_scope", scope)
+	return scope
+}
+
+// 获取员工本人及所有下属（递归）的ID
+func getSubordinateTreeIDs(employeeID uint) []uint {
+	ids := []uint{employeeID}
+	visited := map[uint]bool{employeeID: true}
+	current := []uint{employeeID}
+
+	for len(current) > 0 {
+		var subordinates []uint
+		if err := models.DB.Model(&models.Employee{}).Where("manager_id IN ?", current).Pluck("id", &subordinates).Error; err != nil {
+			break
+		}
+
+		current = nil
+		for _, id := range subordinates {
+			// 防止上级关系成环
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			ids = append(ids, id)
+			current = append(current, id)
+		}
+	}
+
+	return ids
+}
+
+// CanViewEmployee 是否可以查看员工的考核数据
+func (s *DataScope) CanViewEmployee(employeeID uint) bool {
+	return s.All || slices.Contains(s.EmployeeIDs, employeeID)
+}
+
+// CanViewEvaluation 是否可以查看评估
+func (s *DataScope) CanViewEvaluation(evaluation *models.KPIEvaluation) bool {
+	if s.CanViewEmployee(evaluation.EmployeeID) {
+		return true
+	}
+
+	var count int64
+	models.DB.Model(&models.EvaluationInvitation{}).
+		Where("evaluation_id = ? AND invitee_id = ? AND status IN ?", evaluation.ID, s.UserID, visibleInvitationStatuses).
+		Count(&count)
+	if count > 0 {
+		return true
+	}
+
+	// 评审链的评审人可以查看其评审的评估
+	models.DB.Model(&models.EvaluationReviewer{}).
+		Where("evaluation_id = ? AND reviewer_id = ?", evaluation.ID, s.UserID).
+		Count(&count)
+	return count > 0
+}
+
+// Evaluations 限制评估查询的范围，用于 db.Scopes(...)
+// 查询中可能关联 employees 等表，条件使用完整的表名
+func (s *DataScope) Evaluations(db *gorm.DB) *gorm.DB {
+	if s.All {
+		return db
+	}
+	return db.Where("(kpi_evaluations.employee_id IN ? OR kpi_evaluations.id IN (?) OR kpi_evaluations.id IN (?))",
+		s.EmployeeIDs,
+		models.DB.Model(&models.EvaluationInvitation{}).Select("evaluation_id").
+			Where("invitee_id = ? AND status IN ?", s.UserID, visibleInvitationStatuses),
+		models.DB.Model(&models.EvaluationReviewer{}).Select("evaluation_id").
+			Where("reviewer_id = ?", s.UserID),
+	)
+}
+
+// Employees 限制员工查询的范围，用于 db.Scopes(...)
+func (s *DataScope) Employees(db *gorm.DB) *gorm.DB {
+	if s.All {
+		return db
+	}
+	return db.Where("employees.id IN ?", s.EmployeeIDs)
+}
+
+// 检查当前用户是否可以查看评估，无权限时返回403
+func requireEvaluationAccess(c *gin.Context, evaluation *models.KPIEvaluation) bool {
+	if getDataScope(c).CanViewEvaluation(evaluation) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "无权限查看该评估",
+	})
+	return false
+}
+
+// 检查当前用户是否可以查看员工的考核数据，无权限时返回403
+func requireEmployeeAccess(c *gin.Context, employeeID uint) bool {
+	if getDataScope(c).CanViewEmployee(employeeID) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "无权限查看该员工的考核数据",
+	})
+	return false
+}
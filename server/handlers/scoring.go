@@ -0,0 +1,607 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 评分角色
+const (
+	ScoreRoleSelf     = "self"
+	ScoreRoleManager  = "manager"
+	ScoreRolePeer     = "peer"
+	ScoreRoleHR       = "hr"
+	ScoreRoleMetric   = "metric"   // 量化指标根据实际值计算的得分
+	ScoreRoleAppeal   = "appeal"   // 申诉处理时调整的得分
+	ScoreRoleOverride = "override" // HR审核时指定的最终得分
+)
+
+// 邀请评分聚合方式
+const (
+	PeerAggregationMean        = "mean"         // 平均值
+	PeerAggregationTrimmedMean = "trimmed_mean" // 去掉一个最高分和一个最低分后取平均（不少于3个评分时生效）
+	PeerAggregationMedian      = "median"       // 中位数
+)
+
+// 角色评分缺失处理方式
+const (
+	MissingStrategyRedistribute = "redistribute" // 缺失角色的权重按比例分摊给其他角色
+	MissingStrategyZero         = "zero"         // 缺失角色按0分计算
+)
+
+// 获取模板评分策略
+func GetScoringPolicy(c *gin.Context) {
+	id := c.Param("id")
+	templateId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的模板ID",
+		})
+		return
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.Preload("ScoringPolicy").First(&template, templateId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": template.ScoringPolicy,
+	})
+}
+
+// 更新模板评分策略
+func UpdateScoringPolicy(c *gin.Context) {
+	id := c.Param("id")
+	templateId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的模板ID",
+		})
+		return
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.First(&template, templateId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	var updateData models.ScoringPolicy
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := validateScoringPolicy(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var policy models.ScoringPolicy
+	if err := models.DB.Where("template_id = ?", template.ID).First(&policy).Error; err != nil {
+		policy = models.ScoringPolicy{TemplateID: template.ID}
+	}
+	before := policy
+	policy.SelfWeight = updateData.SelfWeight
+	policy.ManagerWeight = updateData.ManagerWeight
+	policy.PeerWeight = updateData.PeerWeight
+	policy.HRWeight = updateData.HRWeight
+	policy.GoalWeight = updateData.GoalWeight
+	policy.PeerAggregation = updateData.PeerAggregation
+	policy.MissingStrategy = updateData.MissingStrategy
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&policy).Error; err != nil {
+			return err
+		}
+		if before.ID == 0 {
+			return auditCreate(tx, c, AuditEntityScoringPolicy, policy.ID, policy)
+		}
+		return auditUpdate(tx, c, AuditEntityScoringPolicy, policy.ID, before, policy)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新评分策略失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评分策略更新成功",
+		"data":    policy,
+	})
+}
+
+// 删除模板评分策略（恢复为 HR > 上级 > 自评 的优先级取分）
+func DeleteScoringPolicy(c *gin.Context) {
+	id := c.Param("id")
+	templateId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的模板ID",
+		})
+		return
+	}
+
+	var policy models.ScoringPolicy
+	if err := models.DB.Where("template_id = ?", templateId).First(&policy).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "评分策略删除成功",
+		})
+		return
+	}
+
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&policy).Error; err != nil {
+			return err
+		}
+		return auditDelete(tx, c, AuditEntityScoringPolicy, policy.ID, policy)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "删除评分策略失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "评分策略删除成功",
+	})
+}
+
+// 校验评分策略，并为空字段填充默认值
+func validateScoringPolicy(policy *models.ScoringPolicy) error {
+	weights := []float64{policy.SelfWeight, policy.ManagerWeight, policy.PeerWeight, policy.HRWeight}
+	sum := 0.0
+	for _, weight := range weights {
+		if weight < 0 {
+			return errors.New("评分权重不能为负数")
+		}
+		sum += weight
+	}
+	if math.Abs(sum-100) > 0.01 {
+		return fmt.Errorf("评分权重之和必须为100，当前为%.2f", sum)
+	}
+	if policy.GoalWeight < 0 || policy.GoalWeight > 100 {
+		return errors.New("个人目标权重必须在0到100之间")
+	}
+
+	switch policy.PeerAggregation {
+	case "":
+		policy.PeerAggregation = PeerAggregationMean
+	case PeerAggregationMean, PeerAggregationTrimmedMean, PeerAggregationMedian:
+	default:
+		return fmt.Errorf("不支持的邀请评分聚合方式：%s", policy.PeerAggregation)
+	}
+
+	switch policy.MissingStrategy {
+	case "":
+		policy.MissingStrategy = MissingStrategyRedistribute
+	case MissingStrategyRedistribute, MissingStrategyZero:
+	default:
+		return fmt.Errorf("不支持的评分缺失处理方式：%s", policy.MissingStrategy)
+	}
+
+	return nil
+}
+
+// 获取模板的评分策略，未配置时返回空
+func findScoringPolicy(tx *gorm.DB, templateID uint) *models.ScoringPolicy {
+	var policy models.ScoringPolicy
+	if err := tx.Where("template_id = ?", templateID).First(&policy).Error; err != nil {
+		return nil
+	}
+	return &policy
+}
+
+// 按最终得分的计算方式计算评估各考核项目当前的得分，按评分记录ID返回，不保存
+func previewItemScores(tx *gorm.DB, evaluation *models.KPIEvaluation) (map[uint]float64, error) {
+	policy := findScoringPolicy(tx, evaluation.TemplateID)
+
+	var scores []models.KPIScore
+	if err := tx.Preload("Item").Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err != nil {
+		return nil, err
+	}
+	peerScores, err := getPeerScores(tx, evaluation.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint]float64, len(scores))
+	for _, s := range scores {
+		result[s.ID], _ = computeItemScore(policy, s, peerScores[s.ItemID])
+	}
+	return result, nil
+}
+
+// 员工确认时，进入待确认后各项得分有变化（如邀请评分晚于HR审核完成）才重新计算，否则沿用进入待确认时计算的结果
+func confirmFinalScores(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	current, err := previewItemScores(tx, evaluation)
+	if err != nil {
+		return err
+	}
+
+	var scores []models.KPIScore
+	if err := tx.Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err != nil {
+		return err
+	}
+	for _, s := range scores {
+		if s.FinalScore == nil || *s.FinalScore != current[s.ID] {
+			return calculateFinalScores(tx, evaluation)
+		}
+	}
+	return nil
+}
+
+// 计算最终得分并记录计算明细
+func calculateFinalScores(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	policy := findScoringPolicy(tx, evaluation.TemplateID)
+
+	var scores []models.KPIScore
+	if err := tx.Preload("Item").Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err != nil {
+		return err
+	}
+
+	peerScores, err := getPeerScores(tx, evaluation.ID)
+	if err != nil {
+		return err
+	}
+
+	// 重新计算时清除旧的明细
+	if err := tx.Where("evaluation_id = ?", evaluation.ID).Delete(&models.ScoreBreakdown{}).Error; err != nil {
+		return err
+	}
+
+	for i, s := range scores {
+		final, breakdowns := computeItemScore(policy, s, peerScores[s.ItemID])
+		previous := s.FinalScore
+		if err := tx.Model(&models.KPIScore{}).Where("id = ?", s.ID).Update("final_score", final).Error; err != nil {
+			return err
+		}
+		// 计算得出的分数由系统记录
+		if err := auditField(tx, nil, AuditEntityScore, s.ID, "final_score", previous, final); err != nil {
+			return err
+		}
+		for _, breakdown := range breakdowns {
+			breakdown.EvaluationID = evaluation.ID
+			if err := tx.Create(&breakdown).Error; err != nil {
+				return err
+			}
+		}
+		scores[i].FinalScore = &final
+	}
+
+	// 按分类汇总后计算总分
+	subtotals, err := getCategorySubtotals(tx, scores)
+	if err != nil {
+		return err
+	}
+	total := sumTotalScore(scores, subtotals)
+
+	// 个人目标得分按评分策略的目标权重计入总分，没有已评分的目标时总分全部来自考核项目
+	goalScore, err := scoreEvaluationGoals(tx, evaluation)
+	if err != nil {
+		return err
+	}
+	if goalScore != nil && policy != nil && policy.GoalWeight > 0 {
+		total = roundScore(total*(100-policy.GoalWeight)/100 + *goalScore*policy.GoalWeight/100)
+	}
+	if err := tx.Model(evaluation).Updates(map[string]interface{}{
+		"total_score": total,
+		"goal_score":  goalScore,
+	}).Error; err != nil {
+		return err
+	}
+	evaluation.TotalScore = total
+	evaluation.GoalScore = goalScore
+
+	// 根据评级量表确定等级
+	return assignEvaluationGrade(tx, evaluation)
+}
+
+// ScoreRecompute 重新计算得分的结果
+type ScoreRecompute struct {
+	EvaluationID         uint
+	PreviousScore        float64
+	TotalScore           float64
+	PreviousGrade        string
+	Grade                string
+	NormalizationRevoked bool // 所在考核周期的标准化因得分变化被撤销，需要重新应用
+}
+
+// 重新计算已完成评估的最终得分和等级，用于修正评分策略或评级量表调整前的历史结果
+// evaluationIDs 为空时处理全部已完成的评估，校准会议锁定或正在调整的评估保持不变
+// 得分发生变化时撤销所在考核周期的标准化，避免标准化总分与新的总分不一致
+// dryRun 为 true 时只计算不保存
+func RecomputeScores(evaluationIDs []uint, dryRun bool) ([]ScoreRecompute, error) {
+	var results []ScoreRecompute
+	errDryRun := errors.New("dry run")
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("status = ? AND calibration_id IS NULL", EvaluationStatusCompleted).
+			Where("id NOT IN (?)", tx.Model(&models.CalibrationAdjustment{}).
+				Select("calibration_adjustments.evaluation_id").
+				Joins("JOIN calibration_sessions ON calibration_sessions.id = calibration_adjustments.session_id").
+				Where("calibration_sessions.status = ?", CalibrationStatusOpen))
+		if len(evaluationIDs) > 0 {
+			query = query.Where("id IN ?", evaluationIDs)
+		}
+		var evaluations []models.KPIEvaluation
+		if err := query.Order("id").Find(&evaluations).Error; err != nil {
+			return err
+		}
+
+		var staleCycles []uint
+		for i := range evaluations {
+			evaluation := &evaluations[i]
+			result := ScoreRecompute{
+				EvaluationID:  evaluation.ID,
+				PreviousScore: evaluation.TotalScore,
+				PreviousGrade: evaluation.Grade,
+			}
+			if err := calculateFinalScores(tx, evaluation); err != nil {
+				return fmt.Errorf("评估 %d 计算失败: %w", evaluation.ID, err)
+			}
+			if err := auditField(tx, nil, AuditEntityEvaluation, evaluation.ID, "total_score", result.PreviousScore, evaluation.TotalScore); err != nil {
+				return err
+			}
+			if err := auditField(tx, nil, AuditEntityEvaluation, evaluation.ID, "grade", result.PreviousGrade, evaluation.Grade); err != nil {
+				return err
+			}
+			result.TotalScore = evaluation.TotalScore
+			result.Grade = evaluation.Grade
+			results = append(results, result)
+
+			if evaluation.NormalizationID != nil && evaluation.CycleID != nil && result.TotalScore != result.PreviousScore &&
+				!slices.Contains(staleCycles, *evaluation.CycleID) {
+				staleCycles = append(staleCycles, *evaluation.CycleID)
+			}
+		}
+
+		// 标准化总分基于原来的总分计算，得分变化后撤销所在考核周期的标准化
+		for _, cycleID := range staleCycles {
+			var stale []uint
+			if err := tx.Model(&models.KPIEvaluation{}).
+				Joins("JOIN score_normalizations ON score_normalizations.id = kpi_evaluations.normalization_id").
+				Where("score_normalizations.cycle_id = ? AND score_normalizations.revoked_at IS NULL", cycleID).
+				Pluck("kpi_evaluations.id", &stale).Error; err != nil {
+				return err
+			}
+			for i := range results {
+				if slices.Contains(stale, results[i].EvaluationID) {
+					results[i].NormalizationRevoked = true
+				}
+			}
+			if err := revokeCycleNormalization(tx, nil, cycleID); err != nil {
+				return err
+			}
+		}
+
+		// 试算时回滚全部修改
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return results, nil
+}
+
+// 获取评估已完成邀请的评分，按考核项目分组
+func getPeerScores(tx *gorm.DB, evaluationID uint) (map[uint][]float64, error) {
+	var invitedScores []models.InvitedScore
+	if err := tx.Joins("JOIN evaluation_invitations ON invited_scores.invitation_id = evaluation_invitations.id").
+		Where("evaluation_invitations.evaluation_id = ? AND evaluation_invitations.status = ? AND invited_scores.score IS NOT NULL", evaluationID, "completed").
+		Find(&invitedScores).Error; err != nil {
+		return nil, err
+	}
+
+	peerScores := make(map[uint][]float64)
+	for _, invitedScore := range invitedScores {
+		peerScores[invitedScore.ItemID] = append(peerScores[invitedScore.ItemID], *invitedScore.Score)
+	}
+	return peerScores, nil
+}
+
+// 计算单个考核项目的最终得分，score 需要预加载 Item
+func computeItemScore(policy *models.ScoringPolicy, score models.KPIScore, peers []float64) (float64, []models.ScoreBreakdown) {
+	// 申诉调整的得分直接作为最终得分，不再按评分策略加权
+	if score.AdjustedScore != nil {
+		return *score.AdjustedScore, []models.ScoreBreakdown{{
+			ScoreID:      score.ID,
+			ItemID:       score.ItemID,
+			Role:         ScoreRoleAppeal,
+			Score:        score.AdjustedScore,
+			SampleCount:  1,
+			Weight:       100,
+			Contribution: *score.AdjustedScore,
+		}}
+	}
+
+	// HR审核时指定的最终得分代替计算结果，申诉调整的得分优先
+	if score.OverrideScore != nil {
+		return *score.OverrideScore, []models.ScoreBreakdown{{
+			ScoreID:      score.ID,
+			ItemID:       score.ItemID,
+			Role:         ScoreRoleOverride,
+			Score:        score.OverrideScore,
+			SampleCount:  1,
+			Weight:       100,
+			Contribution: *score.OverrideScore,
+		}}
+	}
+
+	// 量化指标直接使用根据实际值计算的得分，不参与评分策略加权
+	if isQuantitativeItem(&score.Item) {
+		if score.ComputedScore == nil {
+			return 0, nil
+		}
+		return *score.ComputedScore, []models.ScoreBreakdown{{
+			ScoreID:      score.ID,
+			ItemID:       score.ItemID,
+			Role:         ScoreRoleMetric,
+			Score:        score.ComputedScore,
+			SampleCount:  1,
+			Weight:       100,
+			Contribution: *score.ComputedScore,
+		}}
+	}
+
+	// 未配置评分策略：HR评分 > 上级评分 > 自评分数
+	if policy == nil {
+		candidates := []struct {
+			role  string
+			score *float64
+		}{
+			{ScoreRoleHR, score.HRScore},
+			{ScoreRoleManager, score.ManagerScore},
+			{ScoreRoleSelf, score.SelfScore},
+		}
+		for _, candidate := range candidates {
+			if candidate.score != nil {
+				return *candidate.score, []models.ScoreBreakdown{{
+					ScoreID:      score.ID,
+					ItemID:       score.ItemID,
+					Role:         candidate.role,
+					Score:        candidate.score,
+					SampleCount:  1,
+					Weight:       100,
+					Contribution: *candidate.score,
+				}}
+			}
+		}
+		return 0, nil
+	}
+
+	entries := []struct {
+		role    string
+		score   *float64
+		samples int
+		weight  float64
+	}{
+		{ScoreRoleSelf, score.SelfScore, countScore(score.SelfScore), policy.SelfWeight},
+		{ScoreRoleManager, score.ManagerScore, countScore(score.ManagerScore), policy.ManagerWeight},
+		{ScoreRolePeer, aggregatePeerScores(peers, policy.PeerAggregation), len(peers), policy.PeerWeight},
+		{ScoreRoleHR, score.HRScore, countScore(score.HRScore), policy.HRWeight},
+	}
+
+	// 已有评分的角色权重之和
+	presentWeight := 0.0
+	for _, entry := range entries {
+		if entry.score != nil {
+			presentWeight += entry.weight
+		}
+	}
+
+	final := 0.0
+	var breakdowns []models.ScoreBreakdown
+	for _, entry := range entries {
+		if entry.weight <= 0 {
+			continue
+		}
+
+		weight := entry.weight
+		contribution := 0.0
+		if entry.score == nil {
+			if policy.MissingStrategy == MissingStrategyRedistribute {
+				weight = 0
+			}
+		} else {
+			if policy.MissingStrategy == MissingStrategyRedistribute && presentWeight > 0 {
+				weight = entry.weight / presentWeight * 100
+			}
+			contribution = *entry.score * weight / 100
+		}
+		final += contribution
+
+		breakdowns = append(breakdowns, models.ScoreBreakdown{
+			ScoreID:      score.ID,
+			ItemID:       score.ItemID,
+			Role:         entry.role,
+			Score:        entry.score,
+			SampleCount:  entry.samples,
+			Weight:       roundScore(weight),
+			Contribution: roundScore(contribution),
+		})
+	}
+
+	return roundScore(final), breakdowns
+}
+
+// 聚合多个邀请评分
+func aggregatePeerScores(values []float64, method string) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var result float64
+	switch method {
+	case PeerAggregationMedian:
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			result = (sorted[mid-1] + sorted[mid]) / 2
+		} else {
+			result = sorted[mid]
+		}
+	case PeerAggregationTrimmedMean:
+		if len(sorted) >= 3 {
+			sorted = sorted[1 : len(sorted)-1]
+		}
+		result = mean(sorted)
+	default:
+		result = mean(sorted)
+	}
+
+	result = roundScore(result)
+	return &result
+}
+
+// 计算平均值
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// 统计评分数量
+func countScore(score *float64) int {
+	if score == nil {
+		return 0
+	}
+	return 1
+}
+
+// 分数保留两位小数
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"dootask-kpi-server/models"
+
+	"gorm.io/gorm"
+)
+
+func TestComputeItemScore(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+	target := 100.0
+	rating := models.KPIItem{Name: "工作质量", MaxScore: 10, Type: ItemTypeRating}
+	quantitative := models.KPIItem{Name: "销售额", MaxScore: 10, Type: ItemTypeQuantitative, Target: &target}
+	weighted := func(missing string) *models.ScoringPolicy {
+		return &models.ScoringPolicy{SelfWeight: 20, ManagerWeight: 80, MissingStrategy: missing}
+	}
+
+	tests := []struct {
+		name     string
+		policy   *models.ScoringPolicy
+		score    models.KPIScore
+		peers    []float64
+		want     float64
+		wantRole string // 第一条计算明细的角色，为空表示没有明细
+	}{
+		{name: "未配置策略时HR评分优先", score: models.KPIScore{Item: rating, SelfScore: floatPtr(5), ManagerScore: floatPtr(8), HRScore: floatPtr(9)}, want: 9, wantRole: ScoreRoleHR},
+		{name: "未配置策略时上级评分优先于自评", score: models.KPIScore{Item: rating, SelfScore: floatPtr(5), ManagerScore: floatPtr(8)}, want: 8, wantRole: ScoreRoleManager},
+		{name: "未配置策略时只有自评", score: models.KPIScore{Item: rating, SelfScore: floatPtr(5)}, want: 5, wantRole: ScoreRoleSelf},
+		{name: "没有任何评分", score: models.KPIScore{Item: rating}, want: 0},
+		{name: "按策略加权", policy: weighted(MissingStrategyRedistribute), score: models.KPIScore{Item: rating, SelfScore: floatPtr(5), ManagerScore: floatPtr(10)}, want: 9, wantRole: ScoreRoleSelf},
+		{name: "缺失评分的权重分摊给其他角色", policy: weighted(MissingStrategyRedistribute), score: models.KPIScore{Item: rating, SelfScore: floatPtr(5)}, want: 5, wantRole: ScoreRoleSelf},
+		{name: "缺失评分按0分计算", policy: weighted(MissingStrategyZero), score: models.KPIScore{Item: rating, SelfScore: floatPtr(5)}, want: 1, wantRole: ScoreRoleSelf},
+		{name: "邀请评分取中位数", policy: &models.ScoringPolicy{PeerWeight: 100, PeerAggregation: PeerAggregationMedian, MissingStrategy: MissingStrategyRedistribute}, score: models.KPIScore{Item: rating}, peers: []float64{2, 9, 8}, want: 8, wantRole: ScoreRolePeer},
+		{name: "量化指标使用计算得分", policy: weighted(MissingStrategyRedistribute), score: models.KPIScore{Item: quantitative, ManagerScore: floatPtr(10), ComputedScore: floatPtr(7)}, want: 7, wantRole: ScoreRoleMetric},
+		{name: "量化指标未填写实际值", score: models.KPIScore{Item: quantitative, ManagerScore: floatPtr(10)}, want: 0},
+		{name: "HR指定的得分代替计算结果", policy: weighted(MissingStrategyRedistribute), score: models.KPIScore{Item: rating, SelfScore: floatPtr(5), ManagerScore: floatPtr(10), OverrideScore: floatPtr(3)}, want: 3, wantRole: ScoreRoleOverride},
+		{name: "HR可以指定量化指标的得分", score: models.KPIScore{Item: quantitative, ComputedScore: floatPtr(7), OverrideScore: floatPtr(4)}, want: 4, wantRole: ScoreRoleOverride},
+		{name: "申诉调整的得分优先于HR指定", score: models.KPIScore{Item: rating, ManagerScore: floatPtr(10), OverrideScore: floatPtr(3), AdjustedScore: floatPtr(6)}, want: 6, wantRole: ScoreRoleAppeal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, breakdowns := computeItemScore(tt.policy, tt.score, tt.peers)
+			if got != tt.want {
+				t.Errorf("得分为 %g，期望 %g", got, tt.want)
+			}
+			role := ""
+			if len(breakdowns) > 0 {
+				role = breakdowns[0].Role
+			}
+			if role != tt.wantRole {
+				t.Errorf("计算明细的角色为 %q，期望 %q", role, tt.wantRole)
+			}
+		})
+	}
+}
+
+// 员工确认时只在进入待确认后得分有变化时重新计算
+func TestConfirmFinalScores(t *testing.T) {
+	tests := []struct {
+		name       string
+		changed    bool // 进入待确认后修改上级评分
+		wantTotal  float64
+		wantAudits int64 // 确认时新增的最终得分审计记录数
+	}{
+		{name: "得分未变化时沿用原结果", wantTotal: 24},
+		{name: "得分变化时重新计算", changed: true, wantTotal: 26, wantAudits: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+			evaluation := f.createEvaluation(t, db, f.Employee, EvaluationStatusManagerEvaluated)
+			fillScores(t, db, evaluation.ID, "manager_score", 0.8)
+			if err := TransitionEvaluation(db, &evaluation, EvaluationStatusPendingConfirm, f.HR.ID, f.HR.Role); err != nil {
+				t.Fatalf("HR审核失败: %v", err)
+			}
+			if evaluation.TotalScore != 24 {
+				t.Fatalf("进入待确认时总分为 %g，期望 24", evaluation.TotalScore)
+			}
+
+			if tt.changed {
+				score := findScores(t, db, evaluation.ID)[0]
+				if err := db.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("manager_score", score.Item.MaxScore).Error; err != nil {
+					t.Fatalf("修改上级评分失败: %v", err)
+				}
+			}
+			auditQuery := db.Model(&models.AuditLog{}).Where("entity_type = ? AND field = ?", AuditEntityScore, "final_score")
+			var before, after int64
+			if err := auditQuery.Session(&gorm.Session{}).Count(&before).Error; err != nil {
+				t.Fatalf("统计审计日志失败: %v", err)
+			}
+
+			if err := TransitionEvaluation(db, &evaluation, EvaluationStatusCompleted, f.Employee.ID, f.Employee.Role); err != nil {
+				t.Fatalf("员工确认失败: %v", err)
+			}
+			if evaluation.TotalScore != tt.wantTotal {
+				t.Errorf("确认后总分为 %g，期望 %g", evaluation.TotalScore, tt.wantTotal)
+			}
+			if err := auditQuery.Session(&gorm.Session{}).Count(&after).Error; err != nil {
+				t.Fatalf("统计审计日志失败: %v", err)
+			}
+			if after-before != tt.wantAudits {
+				t.Errorf("确认时新增 %d 条最终得分审计记录，期望 %d 条", after-before, tt.wantAudits)
+			}
+		})
+	}
+}
+
+// 重新计算得分后，得分变化的评估所在考核周期的标准化被撤销
+func TestRecomputeScoresRevokesNormalization(t *testing.T) {
+	tests := []struct {
+		name         string
+		changed      bool // 重新计算前修改第一个评估的上级评分
+		calibrating  bool // 第一个评估正在校准会议中调整
+		dryRun       bool
+		wantSkipped  bool // 第一个评估不参与重新计算
+		wantRevoked  bool // 返回结果标记标准化被撤销
+		wantReverted bool // 数据库中的标准化已撤销
+	}{
+		{name: "得分未变化时保留标准化"},
+		{name: "得分变化时撤销标准化", changed: true, wantRevoked: true, wantReverted: true},
+		{name: "试算时不撤销标准化", changed: true, dryRun: true, wantRevoked: true},
+		{name: "校准中的评估保持不变", changed: true, calibrating: true, wantSkipped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+
+			cycle := models.ReviewCycle{Name: "2026年6月", Period: "monthly", Year: 2026, Status: "launched"}
+			if err := db.Create(&cycle).Error; err != nil {
+				t.Fatalf("创建考核周期失败: %v", err)
+			}
+			normalization := models.ScoreNormalization{CycleID: cycle.ID, Method: "zscore", GroupBy: "reviewer", EvaluationCount: 2, AppliedBy: f.HR.ID, AppliedAt: time.Now()}
+			if err := db.Create(&normalization).Error; err != nil {
+				t.Fatalf("创建标准化记录失败: %v", err)
+			}
+
+			var evaluations []models.KPIEvaluation
+			for _, employee := range []models.Employee{f.Employee, f.Solo} {
+				evaluation := f.createEvaluation(t, db, employee, EvaluationStatusCompleted)
+				fillScores(t, db, evaluation.ID, "manager_score", 0.8)
+				if err := calculateFinalScores(db, &evaluation); err != nil {
+					t.Fatalf("计算最终得分失败: %v", err)
+				}
+				if err := db.Model(&evaluation).Updates(map[string]interface{}{
+					"cycle_id":         cycle.ID,
+					"normalization_id": normalization.ID,
+					"normalized_score": 50,
+				}).Error; err != nil {
+					t.Fatalf("应用标准化失败: %v", err)
+				}
+				evaluations = append(evaluations, evaluation)
+			}
+
+			if tt.changed {
+				score := findScores(t, db, evaluations[0].ID)[0]
+				if err := db.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("manager_score", score.Item.MaxScore).Error; err != nil {
+					t.Fatalf("修改上级评分失败: %v", err)
+				}
+			}
+			if tt.calibrating {
+				session := models.CalibrationSession{Name: "6月校准", CycleID: cycle.ID, Status: CalibrationStatusOpen, CreatedBy: f.HR.ID}
+				if err := db.Create(&session).Error; err != nil {
+					t.Fatalf("创建校准会议失败: %v", err)
+				}
+				adjustment := models.CalibrationAdjustment{SessionID: session.ID, EvaluationID: evaluations[0].ID, PreviousScore: 24, NewScore: 25, AdjustedBy: f.HR.ID}
+				if err := db.Create(&adjustment).Error; err != nil {
+					t.Fatalf("创建校准调整失败: %v", err)
+				}
+			}
+
+			results, err := RecomputeScores(nil, tt.dryRun)
+			if err != nil {
+				t.Fatalf("重新计算失败: %v", err)
+			}
+			found := make(map[uint]ScoreRecompute)
+			for _, result := range results {
+				found[result.EvaluationID] = result
+			}
+			if _, ok := found[evaluations[0].ID]; ok == tt.wantSkipped {
+				t.Errorf("第一个评估参与重新计算为 %v，期望 %v", ok, !tt.wantSkipped)
+			}
+			for _, evaluation := range evaluations {
+				if result, ok := found[evaluation.ID]; ok && result.NormalizationRevoked != tt.wantRevoked {
+					t.Errorf("评估 %d 标记标准化撤销为 %v，期望 %v", evaluation.ID, result.NormalizationRevoked, tt.wantRevoked)
+				}
+			}
+
+			if err := db.First(&normalization, normalization.ID).Error; err != nil {
+				t.Fatalf("查询标准化记录失败: %v", err)
+			}
+			if (normalization.RevokedAt != nil) != tt.wantReverted {
+				t.Errorf("标准化撤销时间为 %v，期望已撤销 %v", normalization.RevokedAt, tt.wantReverted)
+			}
+			for _, evaluation := range evaluations {
+				var saved models.KPIEvaluation
+				if err := db.First(&saved, evaluation.ID).Error; err != nil {
+					t.Fatalf("查询评估失败: %v", err)
+				}
+				if (saved.NormalizationID == nil) != tt.wantReverted || (saved.NormalizedScore == nil) != tt.wantReverted {
+					t.Errorf("评估 %d 的标准化总分为 %v（标准化记录 %v），期望已清除 %v", saved.ID, saved.NormalizedScore, saved.NormalizationID, tt.wantReverted)
+				}
+			}
+		})
+	}
+}
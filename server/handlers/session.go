@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dootask-kpi-server/config"
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 会话失效原因
+const (
+	SessionRevokeLogout        = "logout"         // 退出登录
+	SessionRevokeLogoutAll     = "logout_all"     // 退出所有设备
+	SessionRevokeRevoked       = "revoked"        // 本人或HR下线指定会话
+	SessionRevokeRoleChanged   = "role_changed"   // 角色变更
+	SessionRevokeDeactivated   = "deactivated"    // 账号停用
+	SessionRevokePasswordReset = "password_reset" // 密码重置
+	SessionRevokeTokenReuse    = "token_reuse"    // 已更换的刷新token被再次使用
+)
+
+// 刷新token请求结构
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// 会话列表项
+type SessionResponse struct {
+	models.UserSession
+	Current bool `json:"current"` // 是否为当前请求使用的会话
+}
+
+// 登录成功后创建会话，返回访问token和刷新token
+func newLoginResponse(c *gin.Context, user *models.Employee) (*LoginResponse, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.UserSession{
+		EmployeeID:       user.ID,
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		UserAgent:        c.Request.UserAgent(),
+		IP:               c.ClientIP(),
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(config.Current.RefreshTokenTTL),
+	}
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		// 清理该用户已过期或已失效的会话
+		if err := tx.Where("employee_id = ? AND (expires_at < ? OR revoked_at IS NOT NULL)", user.ID, now).Delete(&models.UserSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(config.Current.TokenTTL.Seconds()),
+		User:         user,
+	}, nil
+}
+
+// 生成随机的刷新token
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// 刷新token的摘要，数据库中只保存摘要
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 会话是否仍然有效
+func sessionActive(session *models.UserSession) bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(time.Now())
+}
+
+// 使会话失效
+func revokeSessions(tx *gorm.DB, reason string) error {
+	now := time.Now()
+	return tx.Model(&models.UserSession{}).Where("revoked_at IS NULL").Updates(map[string]interface{}{
+		"revoked_at":    &now,
+		"revoke_reason": reason,
+	}).Error
+}
+
+// 使员工的全部会话失效，角色变更、账号停用和密码重置后调用
+func revokeEmployeeSessions(tx *gorm.DB, employeeID uint, reason string) error {
+	return revokeSessions(tx.Where("employee_id = ?", employeeID), reason)
+}
+
+// RefreshToken 使用刷新token换取新的访问token，刷新token同时更换
+// 已更换的刷新token再次使用说明可能已泄露，对应会话立即失效
+func RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少刷新token", "message": err.Error()})
+		return
+	}
+	hash := hashRefreshToken(req.RefreshToken)
+
+	var session models.UserSession
+	if err := models.DB.Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		if err := models.DB.Where("previous_token_hash = ?", hash).First(&session).Error; err == nil && session.RevokedAt == nil {
+			revokeSessions(models.DB.Where("id = ?", session.ID), SessionRevokeTokenReuse)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+	if !sessionActive(&session) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+
+	// 检查用户是否仍然存在且激活
+	var user models.Employee
+	if err := models.DB.First(&user, session.EmployeeID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !user.IsActive {
+		revokeSessions(models.DB.Where("id = ?", session.ID), SessionRevokeDeactivated)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "账户已被禁用"})
+		return
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
+		return
+	}
+
+	// 按旧token条件更新，并发续期时只有一个请求成功
+	now := time.Now()
+	result := models.DB.Model(&models.UserSession{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  hashRefreshToken(refreshToken),
+			"previous_token_hash": hash,
+			"user_agent":          c.Request.UserAgent(),
+			"ip":                  c.ClientIP(),
+			"last_used_at":        now,
+			"expires_at":          now.Add(config.Current.RefreshTokenTTL),
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token刷新失败", "message": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+
+	token, err := generateToken(&user, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int64(config.Current.TokenTTL.Seconds()),
+	})
+}
+
+// Logout 退出登录，使刷新token对应的会话失效
+// 访问token过期后也可以退出，因此通过刷新token确定会话
+func Logout(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少刷新token", "message": err.Error()})
+		return
+	}
+
+	if err := revokeSessions(models.DB.Where("refresh_token_hash = ?", hashRefreshToken(req.RefreshToken)), SessionRevokeLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// LogoutAll 退出所有设备，使当前用户的全部会话失效
+func LogoutAll(c *gin.Context) {
+	if err := revokeEmployeeSessions(models.DB, c.GetUint("user_id"), SessionRevokeLogoutAll); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已退出所有设备"})
+}
+
+// GetMySessions 获取当前用户的有效会话
+func GetMySessions(c *gin.Context) {
+	respondEmployeeSessions(c, c.GetUint("user_id"))
+}
+
+// RevokeMySession 下线当前用户的指定会话
+func RevokeMySession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+
+	var session models.UserSession
+	if err := models.DB.Where("id = ? AND employee_id = ?", id, c.GetUint("user_id")).First(&session).Error; err != nil || !sessionActive(&session) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return
+	}
+
+	if err := revokeSessions(models.DB.Where("id = ?", session.ID), SessionRevokeRevoked); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "会话下线失败", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "会话已下线"})
+}
+
+// GetEmployeeSessions 获取员工的有效会话（HR）
+func GetEmployeeSessions(c *gin.Context) {
+	employeeID, ok := parseEmployeeID(c)
+	if !ok {
+		return
+	}
+	respondEmployeeSessions(c, employeeID)
+}
+
+// RevokeEmployeeSessions 使员工的全部会话失效（HR）
+func RevokeEmployeeSessions(c *gin.Context) {
+	employeeID, ok := parseEmployeeID(c)
+	if !ok {
+		return
+	}
+
+	if err := revokeEmployeeSessions(models.DB, employeeID, SessionRevokeRevoked); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "会话下线失败", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "员工已在所有设备下线"})
+}
+
+// 解析并检查员工ID，失败时已返回错误响应
+func parseEmployeeID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的员工ID"})
+		return 0, false
+	}
+	var employee models.Employee
+	if err := models.DB.First(&employee, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "员工不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询员工失败", "message": err.Error()})
+		}
+		return 0, false
+	}
+	return employee.ID, true
+}
+
+// 返回员工的有效会话，按最近使用时间排序
+func respondEmployeeSessions(c *gin.Context, employeeID uint) {
+	var sessions []models.UserSession
+	if err := models.DB.Where("employee_id = ? AND revoked_at IS NULL AND expires_at > ?", employeeID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取会话列表失败", "message": err.Error()})
+		return
+	}
+
+	currentID := c.GetUint("session_id")
+	data := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		data = append(data, SessionResponse{UserSession: session, Current: session.ID == currentID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
@@ -3,31 +3,46 @@ package handlers
 import (
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 
 	"dootask-kpi-server/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // 系统设置响应结构
 type SystemSettingsResponse struct {
-	AllowRegistration bool   `json:"allow_registration"`
-	SystemMode        string `json:"system_mode"` // 系统模式，独立模式: standalone，集成模式: integrated
+	AllowRegistration       bool   `json:"allow_registration"`
+	SystemMode              string `json:"system_mode"`               // 系统模式，独立模式: standalone，集成模式: integrated
+	DeadlineReminderHours   []int  `json:"deadline_reminder_hours"`   // 到期前多少小时提醒
+	DeadlineEscalationHours int    `json:"deadline_escalation_hours"` // 逾期多少小时后升级
 }
 
 // 设置更新请求结构
 type UpdateSettingsRequest struct {
-	AllowRegistration bool `json:"allow_registration"`
+	AllowRegistration       bool  `json:"allow_registration"`
+	DeadlineReminderHours   []int `json:"deadline_reminder_hours"`
+	DeadlineEscalationHours *int  `json:"deadline_escalation_hours"`
 }
 
 // 获取系统设置
 func GetSystemSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"data": loadSystemSettings(),
+	})
+}
+
+// 读取当前系统设置
+func loadSystemSettings() SystemSettingsResponse {
 	var settings SystemSettingsResponse
 
 	// 获取注册设置
 	var allowRegistrationSetting models.SystemSetting
-	if err := models.DB.Where("key = ?", "allow_registration").First(&allowRegistrationSetting).Error; err == nil {
+	if err := models.DB.Scopes(settingKey("allow_registration")).First(&allowRegistrationSetting).Error; err == nil {
 		settings.AllowRegistration = allowRegistrationSetting.Value == "true"
 	} else {
 		// 如果设置不存在，默认为true
@@ -37,9 +52,11 @@ func GetSystemSettings(c *gin.Context) {
 	// 获取系统模式
 	settings.SystemMode = getSystemMode()
 
-	c.JSON(http.StatusOK, gin.H{
-		"data": settings,
-	})
+	// 获取截止提醒设置
+	settings.DeadlineReminderHours = getDeadlineReminderHours()
+	settings.DeadlineEscalationHours = getDeadlineEscalationHours()
+
+	return settings
 }
 
 // 更新系统设置
@@ -50,69 +67,101 @@ func UpdateSystemSettings(c *gin.Context) {
 		return
 	}
 
-	// 更新注册设置
-	allowRegistrationValue := strconv.FormatBool(req.AllowRegistration)
-	var allowRegistrationSetting models.SystemSetting
+	// 校验截止提醒设置
+	var reminderHours []string
+	for _, h := range req.DeadlineReminderHours {
+		if h <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "提醒时间必须大于0"})
+			return
+		}
+		reminderHours = append(reminderHours, strconv.Itoa(h))
+	}
+	if req.DeadlineEscalationHours != nil && *req.DeadlineEscalationHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "升级时间不能小于0"})
+		return
+	}
 
-	// 先查找是否存在
-	if err := models.DB.Where("key = ?", "allow_registration").First(&allowRegistrationSetting).Error; err != nil {
-		// 如果不存在，创建新的设置
-		allowRegistrationSetting = models.SystemSetting{
-			Key:   "allow_registration",
-			Value: allowRegistrationValue,
-			Type:  "boolean",
+	before := loadSystemSettings()
+	after := before
+	after.AllowRegistration = req.AllowRegistration
+	if req.DeadlineReminderHours != nil {
+		after.DeadlineReminderHours = nil
+		if len(req.DeadlineReminderHours) > 0 {
+			after.DeadlineReminderHours = append([]int(nil), req.DeadlineReminderHours...)
+			sort.Ints(after.DeadlineReminderHours)
 		}
-		if err := models.DB.Create(&allowRegistrationSetting).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建设置失败"})
-			return
+	}
+	if req.DeadlineEscalationHours != nil {
+		after.DeadlineEscalationHours = *req.DeadlineEscalationHours
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		// 更新注册设置
+		if err := SetSetting(tx, "allow_registration", strconv.FormatBool(req.AllowRegistration), "boolean"); err != nil {
+			return err
 		}
-	} else {
-		// 如果存在，更新值
-		allowRegistrationSetting.Value = allowRegistrationValue
-		if err := models.DB.Save(&allowRegistrationSetting).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新设置失败"})
-			return
+		// 更新截止提醒设置
+		if req.DeadlineReminderHours != nil {
+			if err := SetSetting(tx, SettingDeadlineReminderHours, strings.Join(reminderHours, ","), "string"); err != nil {
+				return err
+			}
 		}
+		if req.DeadlineEscalationHours != nil {
+			if err := SetSetting(tx, SettingDeadlineEscalationHours, strconv.Itoa(*req.DeadlineEscalationHours), "number"); err != nil {
+				return err
+			}
+		}
+		return auditUpdate(tx, c, AuditEntitySettings, 0, before, after)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新设置失败"})
+		return
 	}
 
+	after = loadSystemSettings()
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "设置更新成功",
-		"data": SystemSettingsResponse{
-			AllowRegistration: req.AllowRegistration,
-			SystemMode:        getSystemMode(),
-		},
+		"data":    after,
 	})
 }
 
+// 按键名查询设置项，key 在MySQL中是保留字，由数据库方言负责加引号
+func settingKey(key string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key})
+	}
+}
+
 // 获取单个设置项（供其他组件使用）
 func GetSetting(key string) (string, error) {
 	var setting models.SystemSetting
-	if err := models.DB.Where("key = ?", key).First(&setting).Error; err != nil {
+	if err := models.DB.Scopes(settingKey(key)).First(&setting).Error; err != nil {
 		return "", err
 	}
 	return setting.Value, nil
 }
 
 // 设置单个设置项（供其他组件使用）
-func SetSetting(key, value, settingType string) error {
+func SetSetting(db *gorm.DB, key, value, settingType string) error {
 	var setting models.SystemSetting
 
 	// 先查找是否存在
-	if err := models.DB.Where("key = ?", key).First(&setting).Error; err != nil {
+	if err := db.Scopes(settingKey(key)).First(&setting).Error; err != nil {
 		// 如果不存在，创建新的设置
 		setting = models.SystemSetting{
 			Key:   key,
 			Value: value,
 			Type:  settingType,
 		}
-		return models.DB.Create(&setting).Error
+		return db.Create(&setting).Error
 	} else {
 		// 如果存在，更新值
 		setting.Value = value
 		if settingType != "" {
 			setting.Type = settingType
 		}
-		return models.DB.Save(&setting).Error
+		return db.Save(&setting).Error
 	}
 }
 
@@ -7,30 +7,18 @@ import (
 	"sync"
 	"time"
 
-	"dootask-kpi-server/models"
-
 	"github.com/gin-gonic/gin"
 )
 
 // ValidateTokenAndGetUserID 验证token并获取用户ID
 func ValidateTokenAndGetUserID(tokenString string) (uint, error) {
-	// 验证token
-	claims, err := verifyToken(tokenString)
+	// 验证token、会话和用户状态
+	_, user, err := authenticate(tokenString)
 	if err != nil {
 		return 0, err
 	}
 
-	// 检查用户是否存在且激活
-	var user models.Employee
-	if err := models.DB.First(&user, claims.UserID).Error; err != nil {
-		return 0, err
-	}
-
-	if !user.IsActive {
-		return 0, fmt.Errorf("用户账户已被禁用")
-	}
-
-	return claims.UserID, nil
+	return user.ID, nil
 }
 
 // SSE消息结构
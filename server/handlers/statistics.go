@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"dootask-kpi-server/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 统计分析
@@ -39,11 +41,15 @@ type RecentDepartment struct {
 
 // 获取仪表板统计数据
 func GetDashboardStats(c *gin.Context) {
+	// 统计数据只包含当前用户可查看的评估
+	scope := getDataScope(c)
+
 	// 获取查询参数
 	year := c.DefaultQuery("year", strconv.Itoa(time.Now().Year()))
 	period := c.DefaultQuery("period", "")
 	month := c.DefaultQuery("month", "")
 	quarter := c.DefaultQuery("quarter", "")
+	cycleID := c.Query("cycle_id")
 
 	var stats struct {
 		TotalEmployees       int64              `json:"total_employees"`
@@ -51,6 +57,7 @@ func GetDashboardStats(c *gin.Context) {
 		TotalEvaluations     int64              `json:"total_evaluations"`
 		PendingEvaluations   int64              `json:"pending_evaluations"`
 		CompletedEvaluations int64              `json:"completed_evaluations"`
+		OverdueEvaluations   int64              `json:"overdue_evaluations"`
 		AverageScore         float64            `json:"average_score"`
 		RecentEvaluations    []RecentEvaluation `json:"recent_evaluations"`
 	}
@@ -60,7 +67,7 @@ func GetDashboardStats(c *gin.Context) {
 	models.DB.Model(&models.Department{}).Count(&stats.TotalDepartments)
 
 	// 构建评估数据的时间筛选查询
-	baseQuery := models.DB.Model(&models.KPIEvaluation{})
+	baseQuery := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations)
 
 	// 根据时间筛选条件构建查询
 	if period != "" {
@@ -74,13 +81,19 @@ func GetDashboardStats(c *gin.Context) {
 		}
 	}
 
+	// 按考核周期筛选
+	if cycleID != "" {
+		baseQuery = baseQuery.Where("cycle_id = ?", cycleID)
+	}
+
 	// 获取筛选后的评估统计数据
 	baseQuery.Count(&stats.TotalEvaluations)
 
 	// 创建baseQuery的副本来分别查询不同状态的数据
-	pendingQuery := models.DB.Model(&models.KPIEvaluation{})
-	completedQuery := models.DB.Model(&models.KPIEvaluation{})
-	avgQuery := models.DB.Model(&models.KPIEvaluation{})
+	pendingQuery := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations)
+	completedQuery := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations)
+	avgQuery := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations)
+	overdueQuery := models.DB.Scopes(scope.Evaluations).Preload("Cycle")
 
 	// 应用相同的时间筛选条件
 	if period != "" {
@@ -88,21 +101,41 @@ func GetDashboardStats(c *gin.Context) {
 			pendingQuery = pendingQuery.Where("period = ? AND year = ? AND month = ?", "monthly", year, month)
 			completedQuery = completedQuery.Where("period = ? AND year = ? AND month = ?", "monthly", year, month)
 			avgQuery = avgQuery.Where("period = ? AND year = ? AND month = ?", "monthly", year, month)
+			overdueQuery = overdueQuery.Where("period = ? AND year = ? AND month = ?", "monthly", year, month)
 		} else if period == "quarterly" && quarter != "" {
 			pendingQuery = pendingQuery.Where("period = ? AND year = ? AND quarter = ?", "quarterly", year, quarter)
 			completedQuery = completedQuery.Where("period = ? AND year = ? AND quarter = ?", "quarterly", year, quarter)
 			avgQuery = avgQuery.Where("period = ? AND year = ? AND quarter = ?", "quarterly", year, quarter)
+			overdueQuery = overdueQuery.Where("period = ? AND year = ? AND quarter = ?", "quarterly", year, quarter)
 		} else if period == "yearly" {
 			pendingQuery = pendingQuery.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
 			completedQuery = completedQuery.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
 			avgQuery = avgQuery.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
+			overdueQuery = overdueQuery.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
 		}
 	}
 
+	if cycleID != "" {
+		pendingQuery = pendingQuery.Where("cycle_id = ?", cycleID)
+		completedQuery = completedQuery.Where("cycle_id = ?", cycleID)
+		avgQuery = avgQuery.Where("cycle_id = ?", cycleID)
+		overdueQuery = overdueQuery.Where("cycle_id = ?", cycleID)
+	}
+
 	// 分别查询不同状态的数据
 	pendingQuery.Where("status = ?", "pending").Count(&stats.PendingEvaluations)
 	completedQuery.Where("status = ?", "completed").Count(&stats.CompletedEvaluations)
 
+	// 统计当前阶段已逾期的评估
+	var openEvaluations []models.KPIEvaluation
+	overdueQuery.Where("status <> ?", "completed").Find(&openEvaluations)
+	now := time.Now()
+	for i := range openEvaluations {
+		if isEvaluationOverdue(&openEvaluations[i], now) {
+			stats.OverdueEvaluations++
+		}
+	}
+
 	// 计算筛选后的平均得分（只计算已完成的评估）
 	var avgResult struct {
 		AvgScore float64
@@ -112,7 +145,7 @@ func GetDashboardStats(c *gin.Context) {
 
 	// 获取最近的评估记录（应用相同的时间筛选）
 	var recentEvals []models.KPIEvaluation
-	recentQuery := models.DB.Preload("Employee.Department").Preload("Template")
+	recentQuery := models.DB.Scopes(scope.Evaluations).Preload("Employee.Department").Preload("Template")
 	if period != "" {
 		if period == "monthly" && month != "" {
 			recentQuery = recentQuery.Where("period = ? AND year = ? AND month = ?", "monthly", year, month)
@@ -123,6 +156,9 @@ func GetDashboardStats(c *gin.Context) {
 			recentQuery = recentQuery.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
 		}
 	}
+	if cycleID != "" {
+		recentQuery = recentQuery.Where("cycle_id = ?", cycleID)
+	}
 	recentQuery.Order("created_at DESC").Limit(10).Find(&recentEvals)
 
 	// 构建RecentEvaluation结构体
@@ -152,6 +188,9 @@ func GetDashboardStats(c *gin.Context) {
 
 // 获取部门统计数据
 func GetDepartmentStats(c *gin.Context) {
+	// 统计数据只包含当前用户可查看的评估
+	scope := getDataScope(c)
+
 	id := c.Param("id")
 	departmentId, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
@@ -189,7 +228,7 @@ func GetDepartmentStats(c *gin.Context) {
 	models.DB.Model(&models.Employee{}).Where("department_id = ?", departmentId).Count(&stats.EmployeeCount)
 
 	// 获取评估数量和平均分
-	models.DB.Model(&models.KPIEvaluation{}).
+	models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 		Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 		Where("employees.department_id = ?", departmentId).
 		Count(&stats.EvaluationCount)
@@ -197,7 +236,7 @@ func GetDepartmentStats(c *gin.Context) {
 	var avgResult struct {
 		AvgScore float64
 	}
-	models.DB.Model(&models.KPIEvaluation{}).
+	models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 		Select("AVG(total_score) as avg_score").
 		Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 		Where("employees.department_id = ? AND kpi_evaluations.status = ?", departmentId, "completed").
@@ -214,18 +253,19 @@ func GetDepartmentStats(c *gin.Context) {
 			AverageScore    float64
 		}
 
-		models.DB.Model(&models.KPIEvaluation{}).
+		monthScope := evaluationMonthScope(date.Year(), int(date.Month()))
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations, monthScope).
 			Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
-			Where("employees.department_id = ? AND kpi_evaluations.period LIKE ?", departmentId, month+"%").
+			Where("employees.department_id = ?", departmentId).
 			Count(&monthStats.EvaluationCount)
 
 		var monthAvg struct {
 			AvgScore float64
 		}
-		models.DB.Model(&models.KPIEvaluation{}).
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations, monthScope).
 			Select("AVG(total_score) as avg_score").
 			Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
-			Where("employees.department_id = ? AND kpi_evaluations.period LIKE ? AND kpi_evaluations.status = ?", departmentId, month+"%", "completed").
+			Where("employees.department_id = ? AND kpi_evaluations.status = ?", departmentId, "completed").
 			Scan(&monthAvg)
 
 		stats.MonthlyStats = append(stats.MonthlyStats, struct {
@@ -245,7 +285,7 @@ func GetDepartmentStats(c *gin.Context) {
 		AverageScore float64
 	}
 
-	models.DB.Model(&models.KPIEvaluation{}).
+	models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 		Select("employee_id, AVG(total_score) as average_score").
 		Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 		Where("employees.department_id = ? AND kpi_evaluations.status = ?", departmentId, "completed").
@@ -293,9 +333,20 @@ func GetEmployeeStats(c *gin.Context) {
 		} `json:"score_trend"`
 		KPIBreakdown []struct {
 			ItemName     string  `json:"item_name"`
+			CategoryName string  `json:"category_name,omitempty"`
 			AverageScore float64 `json:"average_score"`
 			MaxScore     float64 `json:"max_score"`
 		} `json:"kpi_breakdown"`
+		CategoryBreakdown []struct {
+			CategoryName string  `json:"category_name"`
+			Weight       float64 `json:"weight"`
+			AverageScore float64 `json:"average_score"` // 每次评估分类小计的平均值
+			MaxScore     float64 `json:"max_score"`
+		} `json:"category_breakdown"`
+	}
+
+	if !requireEmployeeAccess(c, uint(employeeId)) {
+		return
 	}
 
 	// 获取员工信息
@@ -343,30 +394,67 @@ func GetEmployeeStats(c *gin.Context) {
 	// 获取KPI项目分析
 	var kpiBreakdown []struct {
 		ItemName     string
+		CategoryName string
 		AverageScore float64
 		MaxScore     float64
 	}
 
 	models.DB.Model(&models.KPIScore{}).
-		Select("kpi_items.name as item_name, AVG(kpi_scores.final_score) as average_score, kpi_items.max_score").
+		Select("kpi_items.name as item_name, COALESCE(categories.name, '') as category_name, AVG(kpi_scores.final_score) as average_score, kpi_items.max_score").
 		Joins("JOIN kpi_items ON kpi_scores.item_id = kpi_items.id").
+		Joins("LEFT JOIN kpi_items AS categories ON kpi_items.parent_id = categories.id").
 		Joins("JOIN kpi_evaluations ON kpi_scores.evaluation_id = kpi_evaluations.id").
 		Where("kpi_evaluations.employee_id = ? AND kpi_scores.final_score IS NOT NULL", employeeId).
-		Group("kpi_items.id, kpi_items.name, kpi_items.max_score").
+		Group("kpi_items.id, kpi_items.name, categories.name, kpi_items.max_score").
 		Scan(&kpiBreakdown)
 
 	for _, item := range kpiBreakdown {
 		stats.KPIBreakdown = append(stats.KPIBreakdown, struct {
 			ItemName     string  `json:"item_name"`
+			CategoryName string  `json:"category_name,omitempty"`
 			AverageScore float64 `json:"average_score"`
 			MaxScore     float64 `json:"max_score"`
 		}{
 			ItemName:     item.ItemName,
+			CategoryName: item.CategoryName,
 			AverageScore: item.AverageScore,
 			MaxScore:     item.MaxScore,
 		})
 	}
 
+	// 获取分类小计分析：按分类的最初版本ID汇总，跨模板版本统计同一分类
+	var categoryBreakdown []struct {
+		CategoryName string
+		Weight       float64
+		AverageScore float64
+		MaxScore     float64
+	}
+
+	models.DB.Model(&models.KPIScore{}).
+		Select("MAX(categories.name) as category_name, MAX(categories.weight) as weight, "+
+			"SUM(kpi_scores.final_score) / COUNT(DISTINCT kpi_scores.evaluation_id) as average_score, "+
+			"SUM(kpi_items.max_score) / COUNT(DISTINCT kpi_scores.evaluation_id) as max_score").
+		Joins("JOIN kpi_items ON kpi_scores.item_id = kpi_items.id").
+		Joins("JOIN kpi_items AS categories ON kpi_items.parent_id = categories.id").
+		Joins("JOIN kpi_evaluations ON kpi_scores.evaluation_id = kpi_evaluations.id").
+		Where("kpi_evaluations.employee_id = ? AND kpi_scores.final_score IS NOT NULL", employeeId).
+		Group("categories.origin_id").
+		Scan(&categoryBreakdown)
+
+	for _, category := range categoryBreakdown {
+		stats.CategoryBreakdown = append(stats.CategoryBreakdown, struct {
+			CategoryName string  `json:"category_name"`
+			Weight       float64 `json:"weight"`
+			AverageScore float64 `json:"average_score"`
+			MaxScore     float64 `json:"max_score"`
+		}{
+			CategoryName: category.CategoryName,
+			Weight:       category.Weight,
+			AverageScore: roundScore(category.AverageScore),
+			MaxScore:     roundScore(category.MaxScore),
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"data": stats,
 	})
@@ -374,6 +462,9 @@ func GetEmployeeStats(c *gin.Context) {
 
 // 获取趋势分析
 func GetTrends(c *gin.Context) {
+	// 统计数据只包含当前用户可查看的评估
+	scope := getDataScope(c)
+
 	period := c.DefaultQuery("period", "monthly") // monthly, quarterly, yearly
 
 	var trends struct {
@@ -420,26 +511,18 @@ func GetTrends(c *gin.Context) {
 			EvaluationCount int64
 		}
 
-		var pattern string
-		switch period {
-		case "monthly":
-			pattern = p + "%"
-		case "quarterly":
-			pattern = p + "%"
-		case "yearly":
-			pattern = p + "%"
-		}
+		periodScope, _ := periodLabelScope(p)
 
-		models.DB.Model(&models.KPIEvaluation{}).
-			Where("period LIKE ? AND status = ?", pattern, "completed").
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations, periodScope).
+			Where("status = ?", "completed").
 			Count(&periodStats.EvaluationCount)
 
 		var avgResult struct {
 			AvgScore float64
 		}
-		models.DB.Model(&models.KPIEvaluation{}).
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations, periodScope).
 			Select("AVG(total_score) as avg_score").
-			Where("period LIKE ? AND status = ?", pattern, "completed").
+			Where("status = ?", "completed").
 			Scan(&avgResult)
 
 		trends.ScoreTrends = append(trends.ScoreTrends, struct {
@@ -460,7 +543,7 @@ func GetTrends(c *gin.Context) {
 		EvaluationCount int64
 	}
 
-	models.DB.Model(&models.KPIEvaluation{}).
+	models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 		Select("departments.name as department_name, AVG(kpi_evaluations.total_score) as average_score, COUNT(*) as evaluation_count").
 		Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 		Joins("JOIN departments ON employees.department_id = departments.id").
@@ -550,10 +633,17 @@ func ExportDepartmentEvaluations(c *gin.Context) {
 // 导出周期评估
 func ExportPeriodEvaluations(c *gin.Context) {
 	period := c.Param("period")
+	periodScope, ok := periodLabelScope(period)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的考核周期，格式应为 2006-01、2006-Q1 或 2006",
+		})
+		return
+	}
 
 	var evaluations []models.KPIEvaluation
 	result := models.DB.Preload("Employee.Department").Preload("Template").
-		Where("period LIKE ?", period+"%").
+		Scopes(periodScope).
 		Find(&evaluations)
 
 	if result.Error != nil {
@@ -573,11 +663,22 @@ func ExportPeriodEvaluations(c *gin.Context) {
 
 // 获取完整的统计分析数据
 func GetStatisticsData(c *gin.Context) {
+	// 统计数据只包含当前用户可查看的评估
+	scope := getDataScope(c)
+
 	// 获取查询参数
 	year := c.DefaultQuery("year", strconv.Itoa(time.Now().Year()))
 	period := c.DefaultQuery("period", "monthly")
 	month := c.DefaultQuery("month", strconv.Itoa(int(time.Now().Month())))
 	quarter := c.DefaultQuery("quarter", strconv.Itoa((int(time.Now().Month())-1)/3+1))
+	cycleID := c.Query("cycle_id")
+	ratingScaleID := c.Query("rating_scale_id")
+
+	// score=normalized 时平均分使用标准化总分（未标准化的评估使用原始总分），便于跨团队比较
+	scoreColumn := "kpi_evaluations.total_score"
+	if c.Query("score") == "normalized" {
+		scoreColumn = "COALESCE(kpi_evaluations.normalized_score, kpi_evaluations.total_score)"
+	}
 
 	// 部门统计结构
 	type DepartmentStat struct {
@@ -598,6 +699,7 @@ func GetStatisticsData(c *gin.Context) {
 
 	// 分数分布结构
 	type ScoreDistrib struct {
+		Grade string `json:"grade"`
 		Range string `json:"range"`
 		Count int64  `json:"count"`
 		Color string `json:"color"`
@@ -642,7 +744,7 @@ func GetStatisticsData(c *gin.Context) {
 		stat.Name = dept.Name
 
 		// 总评估数
-		query := models.DB.Model(&models.KPIEvaluation{}).
+		query := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 			Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 			Where("employees.department_id = ?", dept.ID)
 
@@ -655,6 +757,9 @@ func GetStatisticsData(c *gin.Context) {
 			// 兼容历史数据格式，支持 period="yearly" 和 period="年份"
 			query = query.Where("(kpi_evaluations.period = ? OR kpi_evaluations.period = ?) AND kpi_evaluations.year = ?", "yearly", year, year)
 		}
+		if cycleID != "" {
+			query = query.Where("kpi_evaluations.cycle_id = ?", cycleID)
+		}
 
 		query.Count(&stat.Total)
 
@@ -668,7 +773,7 @@ func GetStatisticsData(c *gin.Context) {
 		var avgResult struct {
 			AvgScore float64
 		}
-		query.Select("AVG(kpi_evaluations.total_score) as avg_score").Scan(&avgResult)
+		query.Select("AVG(" + scoreColumn + ") as avg_score").Scan(&avgResult)
 		stat.AvgScore = avgResult.AvgScore
 
 		response.DepartmentStats = append(response.DepartmentStats, stat)
@@ -683,7 +788,7 @@ func GetStatisticsData(c *gin.Context) {
 		trend.Month = monthStr
 
 		// 该月评估数量
-		models.DB.Model(&models.KPIEvaluation{}).
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 			Where("year = ? AND month = ?", date.Year(), int(date.Month())).
 			Count(&trend.Evaluations)
 
@@ -691,15 +796,15 @@ func GetStatisticsData(c *gin.Context) {
 		var avgResult struct {
 			AvgScore float64
 		}
-		models.DB.Model(&models.KPIEvaluation{}).
-			Select("AVG(total_score) as avg_score").
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
+			Select("AVG("+scoreColumn+") as avg_score").
 			Where("year = ? AND month = ? AND status = ?", date.Year(), int(date.Month()), "completed").
 			Scan(&avgResult)
 		trend.AvgScore = avgResult.AvgScore
 
 		// 完成率
 		var completed int64
-		models.DB.Model(&models.KPIEvaluation{}).
+		models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
 			Where("year = ? AND month = ? AND status = ?", date.Year(), int(date.Month()), "completed").
 			Count(&completed)
 
@@ -710,24 +815,20 @@ func GetStatisticsData(c *gin.Context) {
 		response.MonthlyTrends = append(response.MonthlyTrends, trend)
 	}
 
-	// 3. 获取分数分布
-	scoreRanges := []struct {
-		min   float64
-		max   float64
-		label string
-		color string
-	}{
-		{90, 100, "90-100", "#22c55e"},
-		{80, 89, "80-89", "#3b82f6"},
-		{70, 79, "70-79", "#f59e0b"},
-		{60, 69, "60-69", "#ef4444"},
-		{0, 59, "60以下", "#6b7280"},
+	// 3. 获取等级分布（按指定的评级量表统计，未指定时为默认量表）
+	var scale models.RatingScale
+	scaleQuery := models.DB.Scopes(models.PreloadRatingScale)
+	if ratingScaleID != "" {
+		scaleQuery = scaleQuery.Where("id = ?", ratingScaleID)
+	} else {
+		scaleQuery = scaleQuery.Where("is_default = ?", true)
 	}
+	scaleQuery.First(&scale)
 
-	for _, scoreRange := range scoreRanges {
+	for i, grade := range scale.Grades {
 		var count int64
-		query := models.DB.Model(&models.KPIEvaluation{}).
-			Where("status = ? AND total_score >= ? AND total_score <= ?", "completed", scoreRange.min, scoreRange.max)
+		query := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
+			Where("status = ? AND rating_scale_id = ? AND grade = ?", "completed", scale.ID, grade.Label)
 
 		switch period {
 		case "monthly":
@@ -738,13 +839,17 @@ func GetStatisticsData(c *gin.Context) {
 			// 兼容历史数据格式，支持 period="yearly" 和 period="年份"
 			query = query.Where("(period = ? OR period = ?) AND year = ?", "yearly", year, year)
 		}
+		if cycleID != "" {
+			query = query.Where("cycle_id = ?", cycleID)
+		}
 
 		query.Count(&count)
 
 		response.ScoreDistribution = append(response.ScoreDistribution, ScoreDistrib{
-			Range: scoreRange.label,
+			Grade: grade.Label,
+			Range: formatGradeRange(scale.Grades, i),
 			Count: count,
-			Color: scoreRange.color,
+			Color: grade.Color,
 		})
 	}
 
@@ -757,8 +862,8 @@ func GetStatisticsData(c *gin.Context) {
 		EvalCount    int64
 	}
 
-	query := models.DB.Model(&models.KPIEvaluation{}).
-		Select("employees.id as employee_id, employees.name as employee_name, departments.name as dept_name, AVG(kpi_evaluations.total_score) as avg_score, COUNT(*) as eval_count").
+	query := models.DB.Model(&models.KPIEvaluation{}).Scopes(scope.Evaluations).
+		Select("employees.id as employee_id, employees.name as employee_name, departments.name as dept_name, AVG("+scoreColumn+") as avg_score, COUNT(*) as eval_count").
 		Joins("JOIN employees ON kpi_evaluations.employee_id = employees.id").
 		Joins("JOIN departments ON employees.department_id = departments.id").
 		Where("kpi_evaluations.status = ?", "completed")
@@ -772,6 +877,9 @@ func GetStatisticsData(c *gin.Context) {
 		// 兼容历史数据格式，支持 period="yearly" 和 period="年份"
 		query = query.Where("(kpi_evaluations.period = ? OR kpi_evaluations.period = ?) AND kpi_evaluations.year = ?", "yearly", year, year)
 	}
+	if cycleID != "" {
+		query = query.Where("kpi_evaluations.cycle_id = ?", cycleID)
+	}
 
 	query.Group("employees.id, employees.name, departments.name").
 		Order("avg_score DESC").
@@ -789,7 +897,7 @@ func GetStatisticsData(c *gin.Context) {
 
 	// 5. 获取最近评估记录
 	var recentEvals []models.KPIEvaluation
-	models.DB.Preload("Employee.Department").Preload("Template").
+	models.DB.Scopes(scope.Evaluations).Preload("Employee.Department").Preload("Template").
 		Order("created_at DESC").
 		Limit(10).
 		Find(&recentEvals)
@@ -813,3 +921,35 @@ func GetStatisticsData(c *gin.Context) {
 		"data": response,
 	})
 }
+
+// 按自然月筛选评估（包含该月的所有评估）
+func evaluationMonthScope(year, month int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("kpi_evaluations.year = ? AND kpi_evaluations.month = ?", year, month)
+	}
+}
+
+// 按周期标签筛选评估：2006-01 为月度评估，2006-Q1 为季度评估，2006 为年度评估
+func periodLabelScope(label string) (func(db *gorm.DB) *gorm.DB, bool) {
+	if date, err := time.Parse("2006-01", label); err == nil {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("kpi_evaluations.period = ? AND kpi_evaluations.year = ? AND kpi_evaluations.month = ?", "monthly", date.Year(), int(date.Month()))
+		}, true
+	}
+	if yearText, quarterText, found := strings.Cut(label, "-Q"); found {
+		year, yearErr := strconv.Atoi(yearText)
+		quarter, quarterErr := strconv.Atoi(quarterText)
+		if yearErr != nil || quarterErr != nil || quarter < 1 || quarter > 4 {
+			return nil, false
+		}
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("kpi_evaluations.period = ? AND kpi_evaluations.year = ? AND kpi_evaluations.quarter = ?", "quarterly", year, quarter)
+		}, true
+	}
+	if year, err := strconv.Atoi(label); err == nil && len(label) == 4 {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("kpi_evaluations.period = ? AND kpi_evaluations.year = ?", "yearly", year)
+		}, true
+	}
+	return nil, false
+}
@@ -1,19 +1,21 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"dootask-kpi-server/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 获取所有KPI模板
 func GetTemplates(c *gin.Context) {
 	var templates []models.KPITemplate
 
-	result := models.DB.Preload("Items").Find(&templates)
+	result := models.DB.Preload("Items", currentVersionItems).Preload("ScoringPolicy").Find(&templates)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "获取模板列表失败",
@@ -40,11 +42,86 @@ func CreateTemplate(c *gin.Context) {
 		return
 	}
 
-	result := models.DB.Create(&template)
-	if result.Error != nil {
+	// 校验评分策略
+	if template.ScoringPolicy != nil {
+		if err := validateScoringPolicy(template.ScoringPolicy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	// 评级量表通过评级量表接口维护，模板只引用已有量表
+	template.RatingScale = nil
+	if err := validateTemplateRatingScale(models.DB, &template.RatingScaleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 随模板提交的考核项目与单独创建项目时一样校验
+	items := template.Items
+	template.Items = nil
+	for i := range items {
+		if err := validateItemMetric(&items[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "考核项目配置错误",
+				"message": fmt.Sprintf("「%s」：%s", items[i].Name, err.Error()),
+			})
+			return
+		}
+	}
+
+	// 新模板从版本1开始
+	template.Version = 1
+
+	var itemErr error
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&template).Error; err != nil {
+			return err
+		}
+		if err := ensureTemplateVersion(tx, &template); err != nil {
+			return err
+		}
+
+		// 逐个创建考核项目，分类权重校验需要读取已创建的分类
+		for i := range items {
+			item := &items[i]
+			item.ID = 0
+			item.TemplateID = template.ID
+			item.Version = template.Version
+			item.OriginID = 0
+			if err := validateItemHierarchy(tx, item); err != nil {
+				itemErr = fmt.Errorf("「%s」：%s", item.Name, err.Error())
+				return itemErr
+			}
+			if err := tx.Create(item).Error; err != nil {
+				return err
+			}
+		}
+		template.Items = items
+
+		if err := auditCreate(tx, c, AuditEntityTemplate, template.ID, template); err != nil {
+			return err
+		}
+		if template.ScoringPolicy != nil {
+			return auditCreate(tx, c, AuditEntityScoringPolicy, template.ScoringPolicy.ID, template.ScoringPolicy)
+		}
+		return nil
+	})
+	if itemErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "考核项目配置错误",
+			"message": itemErr.Error(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "创建模板失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -67,7 +144,7 @@ func GetTemplate(c *gin.Context) {
 	}
 
 	var template models.KPITemplate
-	result := models.DB.Preload("Items").First(&template, templateId)
+	result := models.DB.Preload("Items", currentVersionItems).Preload("ScoringPolicy").First(&template, templateId)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "模板不存在",
@@ -109,8 +186,45 @@ func UpdateTemplate(c *gin.Context) {
 		return
 	}
 
-	result = models.DB.Model(&template).Updates(updateData)
+	// 版本号由系统维护，考核项目通过项目接口维护
+	updateData.Version = 0
+	updateData.Items = nil
+
+	// rating_scale_id 为 0 表示改为使用默认评级量表
+	updateData.RatingScale = nil
+	useDefaultScale := updateData.RatingScaleID != nil && *updateData.RatingScaleID == 0
+	if err := validateTemplateRatingScale(models.DB, &updateData.RatingScaleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tx := models.DB.Begin()
+
+	// 模板当前版本已被评估使用时，修改模板信息生成新版本
+	if (updateData.Name != "" && updateData.Name != template.Name) ||
+		(updateData.Description != "" && updateData.Description != template.Description) ||
+		(updateData.Period != "" && updateData.Period != template.Period) {
+		if _, err := prepareTemplateEdit(tx, c, &template, "修改模板信息"); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "创建模板新版本失败",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	// 评分策略通过单独的接口维护
+	before := template
+	result = tx.Model(&template).Omit("ScoringPolicy", "RatingScale").Updates(updateData)
+	if result.Error == nil && useDefaultScale {
+		result = tx.Model(&template).Update("rating_scale_id", nil)
+		template.RatingScaleID = nil
+	}
 	if result.Error != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "更新模板失败",
 			"message": result.Error.Error(),
@@ -118,6 +232,21 @@ func UpdateTemplate(c *gin.Context) {
 		return
 	}
 
+	err = syncTemplateVersion(tx, &template)
+	if err == nil {
+		err = auditUpdate(tx, c, AuditEntityTemplate, template.ID, before, template)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "更新模板版本失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx.Commit()
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "模板更新成功",
 		"data":    template,
@@ -135,6 +264,14 @@ func DeleteTemplate(c *gin.Context) {
 		return
 	}
 
+	var template models.KPITemplate
+	if err := models.DB.Preload("Items").Preload("ScoringPolicy").First(&template, templateId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
 	// 检查是否有相关的评估记录
 	var evaluationCount int64
 	models.DB.Model(&models.KPIEvaluation{}).Where("template_id = ?", templateId).Count(&evaluationCount)
@@ -145,14 +282,43 @@ func DeleteTemplate(c *gin.Context) {
 		return
 	}
 
-	// 删除模板的同时删除相关的KPI项目
-	models.DB.Where("template_id = ?", templateId).Delete(&models.KPIItem{})
+	// 检查是否有模板分配规则使用该模板
+	var ruleCount int64
+	models.DB.Model(&models.TemplateAssignmentRule{}).Where("template_id = ?", templateId).Count(&ruleCount)
+	if ruleCount > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "该模板正在被模板分配规则使用，无法删除",
+		})
+		return
+	}
 
-	result := models.DB.Delete(&models.KPITemplate{}, templateId)
-	if result.Error != nil {
+	// 删除模板的同时删除相关的KPI项目（所有版本）、版本记录和评分策略
+	err = models.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.KPIItem{}, &models.KPITemplateVersion{}, &models.ScoringPolicy{}} {
+			if err := tx.Where("template_id = ?", templateId).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&models.KPITemplate{}, templateId).Error; err != nil {
+			return err
+		}
+
+		for _, item := range template.Items {
+			if err := auditDelete(tx, c, AuditEntityItem, item.ID, item); err != nil {
+				return err
+			}
+		}
+		if template.ScoringPolicy != nil {
+			if err := auditDelete(tx, c, AuditEntityScoringPolicy, template.ScoringPolicy.ID, template.ScoringPolicy); err != nil {
+				return err
+			}
+		}
+		return auditDelete(tx, c, AuditEntityTemplate, template.ID, template)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "删除模板失败",
-			"message": result.Error.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
@@ -173,8 +339,28 @@ func GetTemplateItems(c *gin.Context) {
 		return
 	}
 
+	var template models.KPITemplate
+	if err := models.DB.First(&template, templateId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	// 默认获取当前版本的项目，可以通过 version 参数获取历史版本
+	version := template.Version
+	if value := c.Query("version"); value != "" {
+		version, err = strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的版本号",
+			})
+			return
+		}
+	}
+
 	var items []models.KPIItem
-	result := models.DB.Where("template_id = ?", templateId).Order("`order`").Find(&items)
+	result := models.DB.Where("template_id = ? AND version = ?", templateId, version).Scopes(orderedBySortOrder).Find(&items)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "获取KPI项目失败",
@@ -183,8 +369,15 @@ func GetTemplateItems(c *gin.Context) {
 		return
 	}
 
+	total := len(items)
+
+	// tree=true 时按分类返回层级结构
+	if c.Query("tree") == "true" {
+		items = models.BuildItemTree(items)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"data":  items,
-		"total": len(items),
+		"total": total,
 	})
 }
@@ -0,0 +1,721 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"dootask-kpi-server/config"
+	"dootask-kpi-server/global"
+	"dootask-kpi-server/models"
+	"dootask-kpi-server/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 导入模板与已有模板重名时的处理方式
+const (
+	ImportConflictError     = "error"     // 整体失败
+	ImportConflictSkip      = "skip"      // 跳过
+	ImportConflictRename    = "rename"    // 自动重命名后创建
+	ImportConflictOverwrite = "overwrite" // 覆盖已有模板
+)
+
+// 模板导入操作
+const (
+	ImportActionCreate    = "create"
+	ImportActionSkip      = "skip"
+	ImportActionOverwrite = "overwrite"
+)
+
+// 模板导入结果
+type TemplateImportResult struct {
+	Name       string               `json:"name"`       // 文件中的模板名称
+	FinalName  string               `json:"final_name"` // 导入后的模板名称
+	Action     string               `json:"action"`     // create, skip, overwrite
+	TemplateID uint                 `json:"template_id,omitempty"`
+	Version    int                  `json:"version,omitempty"`
+	ItemCount  int                  `json:"item_count"`
+	Changes    *TemplateVersionDiff `json:"changes,omitempty"` // 覆盖已有模板时的变更
+}
+
+// 复制模板请求
+type CloneTemplateRequest struct {
+	Name string `json:"name"` // 新模板名称，为空时使用「原名称 (副本)」
+}
+
+// 预加载模板当前版本的考核项目（按排序）
+func currentVersionItemsOrdered(db *gorm.DB) *gorm.DB {
+	return orderedBySortOrder(currentVersionItems(db))
+}
+
+// 复制模板（当前版本的考核项目和评分策略）
+func CloneTemplate(c *gin.Context) {
+	id := c.Param("id")
+	templateId, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的模板ID",
+		})
+		return
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.Preload("Items", currentVersionItemsOrdered).Preload("ScoringPolicy").First(&template, templateId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return
+	}
+
+	var req CloneTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx := models.DB.Begin()
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name, err = uniqueTemplateName(tx, template.Name+" (副本)")
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "复制模板失败",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	document := models.NewTemplateDocument(&template)
+	clone := document.ToTemplate()
+	clone.Name = name
+	if err := createTemplateWithItems(tx, c, &clone); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "复制模板失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "模板复制成功",
+		"data":    clone,
+	})
+}
+
+// 导出模板文件（JSON/YAML）
+func ExportTemplates(c *gin.Context) {
+	format := c.DefaultQuery("format", models.TemplateFileFormatYAML)
+	if format != models.TemplateFileFormatJSON && format != models.TemplateFileFormatYAML {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "导出格式必须为 json 或 yaml",
+		})
+		return
+	}
+
+	query := models.DB.Preload("Items", currentVersionItemsOrdered).Preload("ScoringPolicy").Order("id")
+	if ids := c.Query("ids"); ids != "" {
+		var templateIDs []uint
+		for _, value := range strings.Split(ids, ",") {
+			templateID, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "无效的模板ID：" + value,
+				})
+				return
+			}
+			templateIDs = append(templateIDs, uint(templateID))
+		}
+		query = query.Where("id IN ?", templateIDs)
+	}
+
+	var templates []models.KPITemplate
+	if err := query.Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取模板失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(templates) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "没有可导出的模板",
+		})
+		return
+	}
+
+	file := models.TemplateFile{Schema: models.TemplateFileSchema}
+	for i := range templates {
+		file.Templates = append(file.Templates, models.NewTemplateDocument(&templates[i]))
+	}
+	data, err := file.Marshal(format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "生成模板文件失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 创建公共导出目录
+	if err := os.MkdirAll(config.Current.ExportDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "创建导出目录失败",
+		})
+		return
+	}
+
+	// 生成文件名
+	fileName := fmt.Sprintf("KPI模板-%d.%s", time.Now().Unix(), format)
+	filePath := filepath.Join(config.Current.ExportDir, fileName)
+
+	// 保存文件
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存文件失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 缓存到内存
+	randomKey := "export_" + uuid.New().String()
+	global.Cache.Set(randomKey, fileName, time.Minute*5)
+
+	// 返回下载URL
+	downloadURL := utils.GetFileURL(c.GetString("base_url"), fmt.Sprintf("/api/download/exports/%s", randomKey))
+
+	c.JSON(http.StatusOK, ExportResponse{
+		FileURL:  downloadURL,
+		FileName: fileName,
+		FileSize: int64(len(data)),
+		Message:  "导出成功",
+	})
+
+	// 30分钟后删除文件
+	go func() {
+		time.Sleep(time.Minute * 30)
+		os.Remove(filePath)
+	}()
+}
+
+// 导入模板文件（JSON/YAML），支持重名处理和预览
+func ImportTemplates(c *gin.Context) {
+	conflict := c.DefaultQuery("conflict", ImportConflictError)
+	switch conflict {
+	case ImportConflictError, ImportConflictSkip, ImportConflictRename, ImportConflictOverwrite:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("不支持的重名处理方式：%s", conflict),
+		})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	data, fileName, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "读取模板文件失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请上传模板文件",
+		})
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = models.DetectTemplateFileFormat(fileName, data)
+	}
+	file, err := models.ParseTemplateFile(data, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "模板文件格式错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if errs := validateTemplateFile(file); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "模板文件校验失败",
+			"details": errs,
+		})
+		return
+	}
+
+	// 重名时整体失败
+	if conflict == ImportConflictError {
+		var conflicts []string
+		for _, document := range file.Templates {
+			var count int64
+			models.DB.Model(&models.KPITemplate{}).Where("name = ?", document.Name).Count(&count)
+			if count > 0 {
+				conflicts = append(conflicts, document.Name)
+			}
+		}
+		if len(conflicts) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "以下模板名称已存在：" + strings.Join(conflicts, "、"),
+				"conflicts": conflicts,
+			})
+			return
+		}
+	}
+
+	// 预览时同样在事务中执行导入，最后回滚
+	tx := models.DB.Begin()
+	results := make([]TemplateImportResult, 0, len(file.Templates))
+	for i := range file.Templates {
+		result, err := importTemplateDocument(tx, c, &file.Templates[i], conflict)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   fmt.Sprintf("导入模板「%s」失败", file.Templates[i].Name),
+				"message": err.Error(),
+			})
+			return
+		}
+		if dryRun && result.Action == ImportActionCreate {
+			result.TemplateID = 0
+		}
+		results = append(results, *result)
+	}
+
+	message := "模板导入成功"
+	if dryRun {
+		tx.Rollback()
+		message = "模板导入预览"
+	} else {
+		tx.Commit()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"dry_run": dryRun,
+		"data":    results,
+	})
+}
+
+// 读取上传的模板文件：multipart 表单的 file 字段，或请求体
+func readImportFile(c *gin.Context) ([]byte, string, error) {
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		return data, fileHeader.Filename, err
+	}
+
+	data, err := c.GetRawData()
+	return data, "", err
+}
+
+// 校验模板文件，返回所有错误（字段路径：错误说明），并为评分策略填充默认值
+func validateTemplateFile(file *models.TemplateFile) []string {
+	var errs []string
+	if file.Schema != models.TemplateFileSchema {
+		errs = append(errs, fmt.Sprintf("schema: 不支持的格式版本「%s」，应为 %s", file.Schema, models.TemplateFileSchema))
+	}
+	if len(file.Templates) == 0 {
+		errs = append(errs, "templates: 至少需要一个模板")
+	}
+
+	names := make(map[string]bool)
+	for i := range file.Templates {
+		document := &file.Templates[i]
+		path := fmt.Sprintf("templates[%d]", i)
+
+		document.Name = strings.TrimSpace(document.Name)
+		if document.Name == "" {
+			errs = append(errs, path+".name: 不能为空")
+		} else if names[document.Name] {
+			errs = append(errs, fmt.Sprintf("%s.name: 模板名称「%s」重复", path, document.Name))
+		}
+		names[document.Name] = true
+
+		switch document.Period {
+		case "monthly", "quarterly", "yearly":
+		default:
+			errs = append(errs, fmt.Sprintf("%s.period: 不支持的考核周期「%s」，应为 monthly、quarterly 或 yearly", path, document.Period))
+		}
+
+		if document.ScoringPolicy != nil {
+			policy := models.ScoringPolicy{
+				SelfWeight:      document.ScoringPolicy.SelfWeight,
+				ManagerWeight:   document.ScoringPolicy.ManagerWeight,
+				PeerWeight:      document.ScoringPolicy.PeerWeight,
+				HRWeight:        document.ScoringPolicy.HRWeight,
+				GoalWeight:      document.ScoringPolicy.GoalWeight,
+				PeerAggregation: document.ScoringPolicy.PeerAggregation,
+				MissingStrategy: document.ScoringPolicy.MissingStrategy,
+			}
+			if err := validateScoringPolicy(&policy); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.scoring_policy: %s", path, err.Error()))
+			}
+			document.ScoringPolicy.PeerAggregation = policy.PeerAggregation
+			document.ScoringPolicy.MissingStrategy = policy.MissingStrategy
+		}
+
+		if len(document.Items) == 0 {
+			errs = append(errs, path+".items: 至少需要一个考核项目")
+		}
+		errs = append(errs, validateTemplateItems(document.Items, path, false, make(map[string]bool))...)
+	}
+	return errs
+}
+
+// 校验模板文件中的考核项目，nested 表示分类下的子项目
+// 考核项目名称在整个模板内不能重复
+func validateTemplateItems(items []models.TemplateItemDocument, path string, nested bool, itemNames map[string]bool) []string {
+	var errs []string
+	weights := 0.0
+	for j := range items {
+		item := &items[j]
+		itemPath := fmt.Sprintf("%s.items[%d]", path, j)
+
+		item.Name = strings.TrimSpace(item.Name)
+		if item.Name == "" {
+			errs = append(errs, itemPath+".name: 不能为空")
+		} else if itemNames[item.Name] {
+			errs = append(errs, fmt.Sprintf("%s.name: 考核项目名称「%s」重复", itemPath, item.Name))
+		}
+		itemNames[item.Name] = true
+
+		// 校验量化指标配置，并写回填充的默认值
+		metricItem := item.ToItem()
+		if err := validateItemMetric(&metricItem); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", itemPath, err.Error()))
+		}
+		item.Type = metricItem.Type
+		item.ScoringCurve = metricItem.ScoringCurve
+
+		if item.Type != ItemTypeCategory {
+			if item.MaxScore == 0 {
+				errs = append(errs, itemPath+".max_score: 满分不能为0")
+			}
+			if item.Weight != 0 {
+				errs = append(errs, itemPath+".weight: 只有分类可以设置权重")
+			}
+			if len(item.Items) > 0 {
+				errs = append(errs, itemPath+".items: 只有分类可以包含子项目")
+			}
+			continue
+		}
+
+		if nested {
+			errs = append(errs, itemPath+": 分类不能属于其他分类")
+			continue
+		}
+		if item.Weight < 0 || item.Weight > 100 {
+			errs = append(errs, itemPath+".weight: 分类权重必须在 0 到 100 之间")
+		}
+		weights += item.Weight
+		if len(item.Items) == 0 {
+			errs = append(errs, itemPath+".items: 分类至少需要一个考核项目")
+		}
+		errs = append(errs, validateTemplateItems(item.Items, itemPath, true, itemNames)...)
+	}
+	if weights > 100 {
+		errs = append(errs, fmt.Sprintf("%s.items: 分类权重之和不能超过100，当前为 %g", path, weights))
+	}
+	return errs
+}
+
+// 导入单个模板
+func importTemplateDocument(tx *gorm.DB, c *gin.Context, document *models.TemplateDocument, conflict string) (*TemplateImportResult, error) {
+	result := &TemplateImportResult{
+		Name:      document.Name,
+		FinalName: document.Name,
+		ItemCount: len(flattenTemplateItems(document.Items, "")),
+	}
+
+	var existing models.KPITemplate
+	err := tx.Where("name = ?", document.Name).Order("id").First(&existing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		switch conflict {
+		case ImportConflictSkip:
+			result.Action = ImportActionSkip
+			result.TemplateID = existing.ID
+			result.Version = existing.Version
+			return result, nil
+		case ImportConflictOverwrite:
+			changes, err := overwriteTemplate(tx, c, &existing, document)
+			if err != nil {
+				return nil, err
+			}
+			result.Action = ImportActionOverwrite
+			result.TemplateID = existing.ID
+			result.Version = existing.Version
+			result.Changes = changes
+			return result, nil
+		case ImportConflictRename:
+			if result.FinalName, err = uniqueTemplateName(tx, document.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	template := document.ToTemplate()
+	template.Name = result.FinalName
+	if err := createTemplateWithItems(tx, c, &template); err != nil {
+		return nil, err
+	}
+	result.Action = ImportActionCreate
+	result.TemplateID = template.ID
+	result.Version = template.Version
+	return result, nil
+}
+
+// 创建模板及其考核项目、评分策略，并记录版本和审计日志
+func createTemplateWithItems(tx *gorm.DB, c *gin.Context, template *models.KPITemplate) error {
+	// is_active 字段默认值为 true，创建时为 false 会被忽略，需要单独写入
+	isActive := template.IsActive
+	if err := models.CreateTemplateWithItemTree(tx, template); err != nil {
+		return err
+	}
+	if !isActive {
+		if err := tx.Model(template).Update("is_active", false).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := ensureTemplateVersion(tx, template); err != nil {
+		return err
+	}
+
+	if err := auditCreate(tx, c, AuditEntityTemplate, template.ID, template); err != nil {
+		return err
+	}
+	for _, item := range template.Items {
+		if err := auditCreate(tx, c, AuditEntityItem, item.ID, item); err != nil {
+			return err
+		}
+	}
+	if template.ScoringPolicy != nil {
+		if err := auditCreate(tx, c, AuditEntityScoringPolicy, template.ScoringPolicy.ID, template.ScoringPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 用模板文件覆盖已有模板：考核项目按名称对应，模板已被评估使用时生成新版本
+// 返回覆盖前后的差异
+func overwriteTemplate(tx *gorm.DB, c *gin.Context, template *models.KPITemplate, document *models.TemplateDocument) (*TemplateVersionDiff, error) {
+	before, err := snapshotTemplateVersion(tx, template)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := prepareTemplateEdit(tx, c, template, "导入覆盖"); err != nil {
+		return nil, err
+	}
+
+	// 模板信息
+	previous := *template
+	template.Description = document.Description
+	template.Period = document.Period
+	template.IsActive = document.IsActive == nil || *document.IsActive
+	if err := tx.Model(template).Select("description", "period", "is_active").Updates(template).Error; err != nil {
+		return nil, err
+	}
+	if err := syncTemplateVersion(tx, template); err != nil {
+		return nil, err
+	}
+	if err := auditUpdate(tx, c, AuditEntityTemplate, template.ID, previous, *template); err != nil {
+		return nil, err
+	}
+
+	// 考核项目
+	items, err := getTemplateVersionItems(tx, template.ID, template.Version)
+	if err != nil {
+		return nil, err
+	}
+	existingItems := make(map[string]models.KPIItem, len(items))
+	for _, item := range items {
+		existingItems[item.Name] = item
+	}
+
+	// 分类先于子项目处理，子项目按分类名称关联
+	itemIDs := make(map[string]uint)
+	for _, entry := range flattenTemplateItems(document.Items, "") {
+		itemDocument := entry.document
+		var parentID *uint
+		if entry.parent != "" {
+			id := itemIDs[entry.parent]
+			parentID = &id
+		}
+
+		item, ok := existingItems[itemDocument.Name]
+		if !ok {
+			newItem := itemDocument.ToItem()
+			newItem.Children = nil
+			newItem.TemplateID = template.ID
+			newItem.Version = template.Version
+			newItem.ParentID = parentID
+			if err := tx.Create(&newItem).Error; err != nil {
+				return nil, err
+			}
+			if err := auditCreate(tx, c, AuditEntityItem, newItem.ID, newItem); err != nil {
+				return nil, err
+			}
+			itemIDs[newItem.Name] = newItem.ID
+			continue
+		}
+
+		delete(existingItems, itemDocument.Name)
+		itemIDs[item.Name] = item.ID
+		previousItem := item
+		updated := itemDocument.ToItem()
+		item.ParentID = parentID
+		item.Weight = updated.Weight
+		item.Description = updated.Description
+		item.MaxScore = updated.MaxScore
+		item.Order = updated.Order
+		item.Type = updated.Type
+		item.Unit = updated.Unit
+		item.Target = updated.Target
+		item.Threshold = updated.Threshold
+		item.Stretch = updated.Stretch
+		item.ScoringCurve = updated.ScoringCurve
+		item.ScoreBands = updated.ScoreBands
+		item.CapPercent = updated.CapPercent
+		if err := tx.Model(&item).Select("parent_id", "weight", "description", "max_score", "order", "type", "unit", "target",
+			"threshold", "stretch", "scoring_curve", "score_bands", "cap_percent").Updates(&item).Error; err != nil {
+			return nil, err
+		}
+		if err := auditUpdate(tx, c, AuditEntityItem, item.ID, previousItem, item); err != nil {
+			return nil, err
+		}
+	}
+
+	// 文件中没有的项目从当前版本删除
+	for _, item := range existingItems {
+		if err := tx.Delete(&models.KPIItem{}, item.ID).Error; err != nil {
+			return nil, err
+		}
+		if err := auditDelete(tx, c, AuditEntityItem, item.ID, item); err != nil {
+			return nil, err
+		}
+	}
+
+	// 评分策略以文件为准，文件中没有时删除
+	var policy models.ScoringPolicy
+	hasPolicy := tx.Where("template_id = ?", template.ID).First(&policy).Error == nil
+	if document.ScoringPolicy == nil {
+		if hasPolicy {
+			if err := tx.Delete(&policy).Error; err != nil {
+				return nil, err
+			}
+			if err := auditDelete(tx, c, AuditEntityScoringPolicy, policy.ID, policy); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		previousPolicy := policy
+		policy.TemplateID = template.ID
+		policy.SelfWeight = document.ScoringPolicy.SelfWeight
+		policy.ManagerWeight = document.ScoringPolicy.ManagerWeight
+		policy.PeerWeight = document.ScoringPolicy.PeerWeight
+		policy.HRWeight = document.ScoringPolicy.HRWeight
+		policy.GoalWeight = document.ScoringPolicy.GoalWeight
+		policy.PeerAggregation = document.ScoringPolicy.PeerAggregation
+		policy.MissingStrategy = document.ScoringPolicy.MissingStrategy
+		if err := tx.Save(&policy).Error; err != nil {
+			return nil, err
+		}
+		if hasPolicy {
+			err = auditUpdate(tx, c, AuditEntityScoringPolicy, policy.ID, previousPolicy, policy)
+		} else {
+			err = auditCreate(tx, c, AuditEntityScoringPolicy, policy.ID, policy)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	after, err := snapshotTemplateVersion(tx, template)
+	if err != nil {
+		return nil, err
+	}
+	diff := diffTemplateVersions(before, after)
+	return &diff, nil
+}
+
+// 模板文件中的考核项目及其所属分类名称
+type templateItemEntry struct {
+	document models.TemplateItemDocument
+	parent   string
+}
+
+// 按层级顺序展开模板文件中的考核项目，分类排在其子项目之前
+func flattenTemplateItems(items []models.TemplateItemDocument, parent string) []templateItemEntry {
+	var entries []templateItemEntry
+	for _, item := range items {
+		entries = append(entries, templateItemEntry{document: item, parent: parent})
+		entries = append(entries, flattenTemplateItems(item.Items, item.Name)...)
+	}
+	return entries
+}
+
+// 生成模板当前版本的快照（模板信息和考核项目），用于比较差异
+func snapshotTemplateVersion(tx *gorm.DB, template *models.KPITemplate) (*models.KPITemplateVersion, error) {
+	items, err := getTemplateVersionItems(tx, template.ID, template.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &models.KPITemplateVersion{
+		TemplateID:  template.ID,
+		Version:     template.Version,
+		Name:        template.Name,
+		Description: template.Description,
+		Period:      template.Period,
+		Items:       items,
+	}, nil
+}
+
+// 生成不与已有模板重名的名称：名称 (2)、名称 (3) ...
+func uniqueTemplateName(tx *gorm.DB, name string) (string, error) {
+	candidate := name
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Model(&models.KPITemplate{}).Where("name = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"dootask-kpi-server/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 模板版本差异类型
+const (
+	VersionChangeAdded    = "added"    // 新增
+	VersionChangeRemoved  = "removed"  // 删除
+	VersionChangeModified = "modified" // 修改
+)
+
+// 可以迁移到新模板版本的评估状态：员工提交自评后各方已按旧版本评分，需要先退回到自评阶段
+var migratableEvaluationStatuses = []string{EvaluationStatusPending}
+
+// 版本比较时忽略的字段
+var versionDiffIgnoredFields = map[string]bool{
+	"template_id": true,
+	"version":     true,
+	"origin_id":   true,
+	"parent_id":   true, // 所属分类按分类的最初版本ID单独比较
+	"note":        true,
+	"created_by":  true,
+}
+
+// 字段变更
+type VersionFieldChange struct {
+	Field    string `json:"field"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// 考核项目变更
+type VersionItemChange struct {
+	OriginID uint                 `json:"origin_id"`
+	Name     string               `json:"name"`
+	Change   string               `json:"change"` // added, removed, modified
+	Fields   []VersionFieldChange `json:"fields,omitempty"`
+}
+
+// 模板版本差异
+type TemplateVersionDiff struct {
+	TemplateID uint                 `json:"template_id"`
+	From       int                  `json:"from"`
+	To         int                  `json:"to"`
+	Template   []VersionFieldChange `json:"template"`
+	Items      []VersionItemChange  `json:"items"`
+}
+
+// 迁移评估到新版本请求
+type MigrateEvaluationsRequest struct {
+	EvaluationIDs []uint `json:"evaluation_ids"` // 为空时迁移模板所有可迁移的评估
+}
+
+// 只预加载模板当前版本的考核项目，用于 Preload("Items", currentVersionItems)
+func currentVersionItems(db *gorm.DB) *gorm.DB {
+	return db.Where("kpi_items.version = (SELECT version FROM kpi_templates WHERE kpi_templates.id = kpi_items.template_id)")
+}
+
+// 按 order 字段排序，order 是SQL保留字，由数据库方言负责加引号
+func orderedBySortOrder(db *gorm.DB) *gorm.DB {
+	return db.Order(clause.OrderByColumn{Column: clause.Column{Name: "order"}})
+}
+
+// 获取模板指定版本的考核项目
+func getTemplateVersionItems(tx *gorm.DB, templateID uint, version int) ([]models.KPIItem, error) {
+	var items []models.KPIItem
+	err := tx.Where("template_id = ? AND version = ?", templateID, version).Scopes(orderedBySortOrder).Find(&items).Error
+	return items, err
+}
+
+// 确保模板当前版本有版本记录（升级前创建的模板没有版本记录）
+func ensureTemplateVersion(tx *gorm.DB, template *models.KPITemplate) error {
+	var count int64
+	if err := tx.Model(&models.KPITemplateVersion{}).
+		Where("template_id = ? AND version = ?", template.ID, template.Version).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return tx.Create(&models.KPITemplateVersion{
+		TemplateID:  template.ID,
+		Version:     template.Version,
+		Name:        template.Name,
+		Description: template.Description,
+		Period:      template.Period,
+	}).Error
+}
+
+// 模板当前版本是否已被评估使用
+func isTemplateVersionInUse(tx *gorm.DB, template *models.KPITemplate) (bool, error) {
+	var count int64
+	err := tx.Model(&models.KPIEvaluation{}).
+		Where("template_id = ? AND template_version = ?", template.ID, template.Version).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// 准备修改模板：当前版本已被评估使用时复制为新版本，之后的修改都作用于新版本
+// 返回旧项目ID到新项目ID的映射，未生成新版本时返回空
+func prepareTemplateEdit(tx *gorm.DB, c *gin.Context, template *models.KPITemplate, note string) (map[uint]uint, error) {
+	inUse, err := isTemplateVersionInUse(tx, template)
+	if err != nil || !inUse {
+		return nil, err
+	}
+
+	if err := ensureTemplateVersion(tx, template); err != nil {
+		return nil, err
+	}
+
+	items, err := getTemplateVersionItems(tx, template.ID, template.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := template.Version
+	version := previous + 1
+	itemMap := make(map[uint]uint, len(items))
+	for _, item := range items {
+		oldID := item.ID
+		item.ID = 0
+		item.Version = version
+		item.CreatedAt = time.Time{}
+		item.UpdatedAt = time.Time{}
+		if err := tx.Omit("Template").Create(&item).Error; err != nil {
+			return nil, err
+		}
+		itemMap[oldID] = item.ID
+	}
+
+	// 子项目关联到新版本中的分类
+	for _, item := range items {
+		if item.ParentID == nil {
+			continue
+		}
+		if err := tx.Model(&models.KPIItem{}).Where("id = ?", itemMap[item.ID]).Update("parent_id", itemMap[*item.ParentID]).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	templateVersion := models.KPITemplateVersion{
+		TemplateID:  template.ID,
+		Version:     version,
+		Name:        template.Name,
+		Description: template.Description,
+		Period:      template.Period,
+		Note:        note,
+		CreatedBy:   c.GetUint("user_id"),
+	}
+	if err := tx.Create(&templateVersion).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(template).Update("version", version).Error; err != nil {
+		return nil, err
+	}
+	template.Version = version
+
+	if err := auditField(tx, c, AuditEntityTemplate, template.ID, "version", previous, version); err != nil {
+		return nil, err
+	}
+	return itemMap, nil
+}
+
+// 同步当前版本记录的模板信息
+func syncTemplateVersion(tx *gorm.DB, template *models.KPITemplate) error {
+	if err := ensureTemplateVersion(tx, template); err != nil {
+		return err
+	}
+	return tx.Model(&models.KPITemplateVersion{}).
+		Where("template_id = ? AND version = ?", template.ID, template.Version).
+		Updates(map[string]interface{}{
+			"name":        template.Name,
+			"description": template.Description,
+			"period":      template.Period,
+		}).Error
+}
+
+// 将评估迁移到模板的当前版本：按最初版本ID对应考核项目，保留已填写的评分
+// 新版本删除的项目删除对应评分，新增的项目创建空评分，量化指标按新版本的配置重新计算得分
+func migrateEvaluation(tx *gorm.DB, c *gin.Context, evaluation *models.KPIEvaluation, template *models.KPITemplate) error {
+	items, err := getTemplateVersionItems(tx, template.ID, template.Version)
+	if err != nil {
+		return err
+	}
+	itemsByOrigin := make(map[uint]models.KPIItem, len(items))
+	for _, item := range items {
+		// 分类不直接评分
+		if isCategoryItem(&item) {
+			continue
+		}
+		itemsByOrigin[item.OriginID] = item
+	}
+
+	var scores []models.KPIScore
+	if err := tx.Preload("Item").Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err != nil {
+		return err
+	}
+
+	migrated := make(map[uint]bool, len(scores))
+	for _, score := range scores {
+		item, ok := itemsByOrigin[score.Item.OriginID]
+		if !ok {
+			if err := tx.Delete(&models.KPIScore{}, score.ID).Error; err != nil {
+				return err
+			}
+			if err := auditDelete(tx, c, AuditEntityScore, score.ID, score); err != nil {
+				return err
+			}
+			continue
+		}
+
+		migrated[item.OriginID] = true
+		if err := tx.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("item_id", item.ID).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityScore, score.ID, "item_id", score.Item.ID, item.ID); err != nil {
+			return err
+		}
+
+		var computed *float64
+		if isQuantitativeItem(&item) && score.ActualValue != nil {
+			value := computeMetricScore(&item, *score.ActualValue)
+			computed = &value
+		}
+		if err := tx.Model(&models.KPIScore{}).Where("id = ?", score.ID).Update("computed_score", computed).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, c, AuditEntityScore, score.ID, "computed_score", score.ComputedScore, computed); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range items {
+		if migrated[item.OriginID] || isCategoryItem(&item) {
+			continue
+		}
+		score := models.KPIScore{
+			EvaluationID: evaluation.ID,
+			ItemID:       item.ID,
+		}
+		if err := tx.Create(&score).Error; err != nil {
+			return fmt.Errorf("创建评分记录失败: %w", err)
+		}
+		if err := auditCreate(tx, c, AuditEntityScore, score.ID, score); err != nil {
+			return err
+		}
+	}
+
+	previous := evaluation.TemplateVersion
+	if err := tx.Model(evaluation).Update("template_version", template.Version).Error; err != nil {
+		return err
+	}
+	evaluation.TemplateVersion = template.Version
+	return auditField(tx, c, AuditEntityEvaluation, evaluation.ID, "template_version", previous, template.Version)
+}
+
+// 比较两条记录的字段差异
+func diffVersionFields(before, after any) []VersionFieldChange {
+	oldSnapshot := auditSnapshot(before)
+	newSnapshot := auditSnapshot(after)
+
+	changes := []VersionFieldChange{}
+	for _, field := range auditSnapshotFields(after) {
+		if versionDiffIgnoredFields[field] || oldSnapshot[field] == newSnapshot[field] {
+			continue
+		}
+		changes = append(changes, VersionFieldChange{
+			Field:    field,
+			OldValue: oldSnapshot[field],
+			NewValue: newSnapshot[field],
+		})
+	}
+	return changes
+}
+
+// 比较模板两个版本的差异
+func diffTemplateVersions(from, to *models.KPITemplateVersion) TemplateVersionDiff {
+	diff := TemplateVersionDiff{
+		TemplateID: to.TemplateID,
+		From:       from.Version,
+		To:         to.Version,
+		Template:   diffVersionFields(from, to),
+		Items:      []VersionItemChange{},
+	}
+
+	oldItems := make(map[uint]models.KPIItem, len(from.Items))
+	for _, item := range from.Items {
+		oldItems[item.OriginID] = item
+	}
+	oldParents, newParents := itemParents(from.Items), itemParents(to.Items)
+
+	for _, item := range to.Items {
+		oldItem, ok := oldItems[item.OriginID]
+		if !ok {
+			diff.Items = append(diff.Items, VersionItemChange{OriginID: item.OriginID, Name: item.Name, Change: VersionChangeAdded})
+			continue
+		}
+		delete(oldItems, item.OriginID)
+		fields := diffVersionFields(oldItem, item)
+		if oldParent, newParent := oldParents[item.OriginID], newParents[item.OriginID]; oldParent.OriginID != newParent.OriginID {
+			fields = append(fields, VersionFieldChange{Field: "parent", OldValue: oldParent.Name, NewValue: newParent.Name})
+		}
+		if len(fields) > 0 {
+			diff.Items = append(diff.Items, VersionItemChange{OriginID: item.OriginID, Name: item.Name, Change: VersionChangeModified, Fields: fields})
+		}
+	}
+
+	for _, item := range from.Items {
+		if _, ok := oldItems[item.OriginID]; ok {
+			diff.Items = append(diff.Items, VersionItemChange{OriginID: item.OriginID, Name: item.Name, Change: VersionChangeRemoved})
+		}
+	}
+
+	return diff
+}
+
+// 获取考核项目所属的分类，按项目的最初版本ID索引，顶级项目为空分类
+func itemParents(items []models.KPIItem) map[uint]models.KPIItem {
+	byID := make(map[uint]models.KPIItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	parents := make(map[uint]models.KPIItem)
+	for _, item := range items {
+		if item.ParentID != nil {
+			parents[item.OriginID] = byID[*item.ParentID]
+		}
+	}
+	return parents
+}
+
+// 加载模板版本及其考核项目
+func loadTemplateVersion(templateID uint, version int) (*models.KPITemplateVersion, error) {
+	var templateVersion models.KPITemplateVersion
+	if err := models.DB.Where("template_id = ? AND version = ?", templateID, version).First(&templateVersion).Error; err != nil {
+		return nil, err
+	}
+	items, err := getTemplateVersionItems(models.DB, templateID, version)
+	if err != nil {
+		return nil, err
+	}
+	templateVersion.Items = items
+	return &templateVersion, nil
+}
+
+// 获取路径中的模板，模板不存在时返回错误响应
+func loadTemplateParam(c *gin.Context) (*models.KPITemplate, bool) {
+	templateId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的模板ID",
+		})
+		return nil, false
+	}
+
+	var template models.KPITemplate
+	if err := models.DB.First(&template, templateId).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板不存在",
+		})
+		return nil, false
+	}
+	return &template, true
+}
+
+// 获取模板版本列表
+func GetTemplateVersions(c *gin.Context) {
+	template, ok := loadTemplateParam(c)
+	if !ok {
+		return
+	}
+
+	if err := ensureTemplateVersion(models.DB, template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取模板版本失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var versions []models.KPITemplateVersion
+	if err := models.DB.Where("template_id = ?", template.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取模板版本失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    versions,
+		"total":   len(versions),
+		"current": template.Version,
+	})
+}
+
+// 获取模板指定版本（包含该版本的考核项目）
+func GetTemplateVersion(c *gin.Context) {
+	template, ok := loadTemplateParam(c)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的版本号",
+		})
+		return
+	}
+
+	ensureTemplateVersion(models.DB, template)
+	templateVersion, err := loadTemplateVersion(template.ID, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "模板版本不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": templateVersion,
+	})
+}
+
+// 比较模板两个版本的差异，默认比较当前版本和上一版本
+func GetTemplateVersionDiff(c *gin.Context) {
+	template, ok := loadTemplateParam(c)
+	if !ok {
+		return
+	}
+
+	to := template.Version
+	if value := c.Query("to"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的版本号",
+			})
+			return
+		}
+		to = parsed
+	}
+	from := to - 1
+	if value := c.Query("from"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的版本号",
+			})
+			return
+		}
+		from = parsed
+	}
+
+	ensureTemplateVersion(models.DB, template)
+	fromVersion, err := loadTemplateVersion(template.ID, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("模板版本 %d 不存在", from),
+		})
+		return
+	}
+	toVersion, err := loadTemplateVersion(template.ID, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("模板版本 %d 不存在", to),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": diffTemplateVersions(fromVersion, toVersion),
+	})
+}
+
+// 将未开始评分的评估迁移到模板的当前版本
+func MigrateTemplateEvaluations(c *gin.Context) {
+	template, ok := loadTemplateParam(c)
+	if !ok {
+		return
+	}
+
+	var req MigrateEvaluationsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "请求参数错误",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	query := models.DB.Where("template_id = ? AND template_version < ?", template.ID, template.Version)
+	if len(req.EvaluationIDs) > 0 {
+		query = query.Where("id IN ?", req.EvaluationIDs)
+	}
+
+	var evaluations []models.KPIEvaluation
+	if err := query.Find(&evaluations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "获取评估失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	migrated := []uint{}
+	skipped := []gin.H{}
+	tx := models.DB.Begin()
+	for i := range evaluations {
+		evaluation := &evaluations[i]
+		if !slices.Contains(migratableEvaluationStatuses, evaluation.Status) {
+			skipped = append(skipped, gin.H{
+				"evaluation_id": evaluation.ID,
+				"reason":        fmt.Sprintf("评估处于「%s」阶段，请先退回到自评阶段再迁移", getStatusText(evaluation.Status)),
+			})
+			continue
+		}
+
+		if err := migrateEvaluation(tx, c, evaluation, template); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "迁移评估失败",
+				"message": err.Error(),
+			})
+			return
+		}
+		migrated = append(migrated, evaluation.ID)
+	}
+	tx.Commit()
+
+	// 发送实时通知
+	operatorID := c.GetUint("user_id")
+	for i := range evaluations {
+		if evaluations[i].TemplateVersion == template.Version {
+			GetNotificationService().SendNotification(operatorID, EventEvaluationUpdated, &evaluations[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("已迁移 %d 个评估到版本 %d", len(migrated), template.Version),
+		"data": gin.H{
+			"version":  template.Version,
+			"migrated": migrated,
+			"skipped":  skipped,
+		},
+	})
+}
@@ -0,0 +1,513 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+
+	"dootask-kpi-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 评估状态
+const (
+	EvaluationStatusPending          = "pending"
+	EvaluationStatusSelfEvaluated    = "self_evaluated"
+	EvaluationStatusManagerEvaluated = "manager_evaluated"
+	EvaluationStatusPendingConfirm   = "pending_confirm"
+	EvaluationStatusDisputed         = "disputed"
+	EvaluationStatusCompleted        = "completed"
+)
+
+// 评估参与者身份
+const (
+	ActorSelf    = "self"    // 被评估员工本人
+	ActorManager = "manager" // 被评估员工的直属上级
+	ActorHR      = "hr"      // HR
+)
+
+// 状态流转错误
+type TransitionError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *TransitionError) Error() string {
+	return e.Message
+}
+
+// 状态流转规则
+type TransitionRule struct {
+	From   string
+	To     string
+	Actors []string                                                  // 允许触发流转的身份，为空表示仅由系统触发
+	Return bool                                                      // 退回规则，只能通过退回操作触发并需要填写原因
+	Auto   func(tx *gorm.DB, evaluation *models.KPIEvaluation) bool  // 自动流转条件，满足时进入From状态后立即流转
+	Check  func(tx *gorm.DB, evaluation *models.KPIEvaluation) error // 前置条件检查
+	Action func(tx *gorm.DB, evaluation *models.KPIEvaluation) error // 流转时执行的动作
+}
+
+// 评估状态流转规则表
+var evaluationTransitions = []TransitionRule{
+	// 员工完成自评
+	{
+		From:   EvaluationStatusPending,
+		To:     EvaluationStatusSelfEvaluated,
+		Actors: []string{ActorSelf},
+		Check:  requireAll(requireScoresFilled("self_score", "自评分数"), requireGoalsScored("self_score", "自评分数")),
+		Action: startReviewChain,
+	},
+	// 没有直属上级且没有配置评审链时，自动将自评分数作为上级评分并进入HR审核
+	{
+		From: EvaluationStatusSelfEvaluated,
+		To:   EvaluationStatusManagerEvaluated,
+		Auto: func(tx *gorm.DB, evaluation *models.KPIEvaluation) bool {
+			return evaluation.Employee.ManagerID == nil && !hasReviewChain(tx, evaluation.ID)
+		},
+		Action: copySelfScoresToManager,
+	},
+	// 直属上级完成评分（配置了评审链时由评审链完成）
+	{
+		From:   EvaluationStatusSelfEvaluated,
+		To:     EvaluationStatusManagerEvaluated,
+		Actors: []string{ActorManager},
+		Check:  requireAll(requireNoReviewChain, requireScoresFilled("manager_score", "上级评分"), requireGoalsScored("manager_score", "上级评分")),
+	},
+	// 评审链的评审人全部完成后，汇总评审人评分作为上级评分
+	{
+		From:   EvaluationStatusSelfEvaluated,
+		To:     EvaluationStatusManagerEvaluated,
+		Action: completeReviewChain,
+	},
+	// HR完成审核，计算最终得分后等待员工确认
+	{
+		From:   EvaluationStatusManagerEvaluated,
+		To:     EvaluationStatusPendingConfirm,
+		Actors: []string{ActorHR},
+		Action: calculateFinalScores,
+	},
+	// 员工对最终得分提出申诉
+	{
+		From:   EvaluationStatusPendingConfirm,
+		To:     EvaluationStatusDisputed,
+		Actors: []string{ActorSelf},
+		Check:  requireAppealPending,
+	},
+	// 上级或HR处理完申诉，按调整后的得分重新计算，再次等待员工确认
+	{
+		From:   EvaluationStatusDisputed,
+		To:     EvaluationStatusPendingConfirm,
+		Actors: []string{ActorManager, ActorHR},
+		Check:  requireAppealsResolved,
+		Action: calculateFinalScores,
+	},
+	// 上级退回自评，员工重新自评
+	{
+		From:   EvaluationStatusSelfEvaluated,
+		To:     EvaluationStatusPending,
+		Actors: []string{ActorManager, ActorHR},
+		Return: true,
+	},
+	// HR退回上级评分，上级（或评审链的评审人）重新评分
+	{
+		From:   EvaluationStatusManagerEvaluated,
+		To:     EvaluationStatusSelfEvaluated,
+		Actors: []string{ActorHR},
+		Return: true,
+		Action: reopenReviewChain,
+	},
+	// 没有直属上级时上级评分由自评自动生成，HR直接退回给员工重新自评
+	{
+		From:   EvaluationStatusManagerEvaluated,
+		To:     EvaluationStatusPending,
+		Actors: []string{ActorHR},
+		Return: true,
+	},
+	// 员工确认最终得分
+	{
+		From:   EvaluationStatusPendingConfirm,
+		To:     EvaluationStatusCompleted,
+		Actors: []string{ActorSelf},
+		Action: confirmFinalScores,
+	},
+	// HR重新开放已完成的评估，回到HR审核阶段（校准会议锁定的结果除外）
+	{
+		From:   EvaluationStatusCompleted,
+		To:     EvaluationStatusManagerEvaluated,
+		Actors: []string{ActorHR},
+		Return: true,
+		Check:  requireNotCalibrated,
+	},
+}
+
+// 评分字段
+const (
+	ScoreFieldSelf    = "self"
+	ScoreFieldManager = "manager"
+	ScoreFieldHR      = "hr"
+	ScoreFieldFinal   = "final"
+)
+
+// 评分写入规则
+type ScoreWriteRule struct {
+	Label  string   // 评分名称
+	Status string   // 允许填写的评估阶段
+	Actors []string // 允许填写的身份
+}
+
+// 评分写入规则表
+var scoreWriteRules = map[string]ScoreWriteRule{
+	// 员工本人在自评阶段填写自评分数
+	ScoreFieldSelf: {
+		Label:  "自评分数",
+		Status: EvaluationStatusPending,
+		Actors: []string{ActorSelf},
+	},
+	// 直属上级在上级评分阶段填写上级评分
+	ScoreFieldManager: {
+		Label:  "上级评分",
+		Status: EvaluationStatusSelfEvaluated,
+		Actors: []string{ActorManager},
+	},
+	// HR在HR审核阶段填写HR评分和最终得分
+	ScoreFieldHR: {
+		Label:  "HR评分",
+		Status: EvaluationStatusManagerEvaluated,
+		Actors: []string{ActorHR},
+	},
+	ScoreFieldFinal: {
+		Label:  "最终得分",
+		Status: EvaluationStatusManagerEvaluated,
+		Actors: []string{ActorHR},
+	},
+}
+
+// CheckScoreWrite 校验用户能否在评估当前阶段填写评分，以及分数是否在考核项目的分值范围内
+// evaluation 需要预加载 Employee
+func CheckScoreWrite(evaluation *models.KPIEvaluation, item *models.KPIItem, field string, value *float64, userID uint, userRole string) error {
+	rule, ok := scoreWriteRules[field]
+	if !ok {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "不支持的评分类型",
+		}
+	}
+
+	// 已完成的评估锁定评分，需要HR重新开放后才能修改
+	if evaluation.Status == EvaluationStatusCompleted {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    "评估已完成，评分已锁定，如需修改请由HR重新开放评估",
+		}
+	}
+
+	actors := getEvaluationActors(evaluation, userID, userRole)
+	if !slices.ContainsFunc(rule.Actors, func(actor string) bool { return slices.Contains(actors, actor) }) {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    fmt.Sprintf("无权限填写该评估的%s", rule.Label),
+		}
+	}
+
+	// 配置了评审链的评估，上级评分由评审人评分汇总得出
+	if field == ScoreFieldManager && hasReviewChain(models.DB, evaluation.ID) {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "该评估由评审链评分，请通过评审接口填写评分",
+		}
+	}
+
+	if evaluation.Status != rule.Status {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估当前处于「%s」阶段，不能填写%s", getStatusText(evaluation.Status), rule.Label),
+		}
+	}
+
+	// 量化指标的得分由实际值自动计算
+	if isQuantitativeItem(item) && field != ScoreFieldFinal {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("「%s」为量化指标，得分根据实际值自动计算，请填写实际值", item.Name),
+		}
+	}
+
+	if value != nil {
+		if err := checkScoreRange(item, *value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckActualValueWrite 校验用户能否在评估当前阶段填写量化指标的实际值
+// 实际值由当前阶段的评分人填写：自评阶段为员工本人，上级评分阶段为直属上级，HR审核阶段为HR
+// evaluation 需要预加载 Employee
+func CheckActualValueWrite(evaluation *models.KPIEvaluation, item *models.KPIItem, userID uint, userRole string) error {
+	if !isQuantitativeItem(item) {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("「%s」不是量化指标，不能填写实际值", item.Name),
+		}
+	}
+
+	if evaluation.Status == EvaluationStatusCompleted {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    "评估已完成，评分已锁定，如需修改请由HR重新开放评估",
+		}
+	}
+
+	var rule *ScoreWriteRule
+	for _, field := range []string{ScoreFieldSelf, ScoreFieldManager, ScoreFieldHR} {
+		if r := scoreWriteRules[field]; r.Status == evaluation.Status {
+			rule = &r
+			break
+		}
+	}
+	if rule == nil {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估当前处于「%s」阶段，不能填写实际值", getStatusText(evaluation.Status)),
+		}
+	}
+
+	actors := getEvaluationActors(evaluation, userID, userRole)
+	if !slices.ContainsFunc(rule.Actors, func(actor string) bool { return slices.Contains(actors, actor) }) {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    fmt.Sprintf("无权限在「%s」阶段填写实际值", getStatusText(evaluation.Status)),
+		}
+	}
+
+	return nil
+}
+
+// 校验分数在考核项目的分值范围内：满分为正数时范围为 [0, 满分]，为负数（扣分项）时范围为 [满分, 0]
+func checkScoreRange(item *models.KPIItem, value float64) error {
+	low, high := 0.0, item.MaxScore
+	if item.MaxScore < 0 {
+		low, high = item.MaxScore, 0
+	}
+	if math.IsNaN(value) || value < low || value > high {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("「%s」的评分必须在 %g 到 %g 之间", item.Name, low, high),
+		}
+	}
+	return nil
+}
+
+// 获取用户相对于评估的身份
+func getEvaluationActors(evaluation *models.KPIEvaluation, userID uint, userRole string) []string {
+	var actors []string
+	if evaluation.EmployeeID == userID {
+		actors = append(actors, ActorSelf)
+	}
+	if evaluation.Employee.ManagerID != nil && *evaluation.Employee.ManagerID == userID {
+		actors = append(actors, ActorManager)
+	}
+	if userRole == "hr" {
+		actors = append(actors, ActorHR)
+	}
+	return actors
+}
+
+// 查找手动流转规则
+func findTransitionRule(from, to string, isReturn bool) *TransitionRule {
+	for i := range evaluationTransitions {
+		rule := &evaluationTransitions[i]
+		if rule.From == from && rule.To == to && len(rule.Actors) > 0 && rule.Return == isReturn {
+			return rule
+		}
+	}
+	return nil
+}
+
+// 获取退回的目标状态（上一阶段），不能退回时返回空
+func getReturnTarget(evaluation *models.KPIEvaluation) string {
+	switch evaluation.Status {
+	case EvaluationStatusSelfEvaluated:
+		return EvaluationStatusPending
+	case EvaluationStatusManagerEvaluated:
+		// 上级评分阶段是自动完成的，退回到自评
+		if evaluation.Employee.ManagerID == nil {
+			return EvaluationStatusPending
+		}
+		return EvaluationStatusSelfEvaluated
+	default:
+		return ""
+	}
+}
+
+// 查找满足条件的自动流转规则
+func findAutoTransitionRule(tx *gorm.DB, evaluation *models.KPIEvaluation) *TransitionRule {
+	for i := range evaluationTransitions {
+		rule := &evaluationTransitions[i]
+		if rule.From == evaluation.Status && rule.Auto != nil && rule.Auto(tx, evaluation) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// 查找仅由系统触发的流转规则
+func findSystemTransitionRule(from, to string) *TransitionRule {
+	for i := range evaluationTransitions {
+		rule := &evaluationTransitions[i]
+		if rule.From == from && rule.To == to && len(rule.Actors) == 0 && rule.Auto == nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// 执行单条流转规则
+func applyTransitionRule(tx *gorm.DB, evaluation *models.KPIEvaluation, rule *TransitionRule) error {
+	if rule.Check != nil {
+		if err := rule.Check(tx, evaluation); err != nil {
+			return err
+		}
+	}
+	if rule.Action != nil {
+		if err := rule.Action(tx, evaluation); err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(evaluation).Update("status", rule.To).Error; err != nil {
+		return err
+	}
+	evaluation.Status = rule.To
+	return nil
+}
+
+// TransitionEvaluation 按状态流转规则变更评估状态
+// evaluation 需要预加载 Employee，流转完成后 evaluation.Status 为最终状态（可能经过自动流转）
+func TransitionEvaluation(tx *gorm.DB, evaluation *models.KPIEvaluation, target string, userID uint, userRole string) error {
+	rule := findTransitionRule(evaluation.Status, target, false)
+	if rule == nil {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("评估状态不能从「%s」变更为「%s」", getStatusText(evaluation.Status), getStatusText(target)),
+		}
+	}
+
+	return executeTransition(tx, evaluation, rule, userID, userRole)
+}
+
+// ReturnEvaluation 将评估退回上一阶段，已填写的评分保持不变
+// evaluation 需要预加载 Employee
+func ReturnEvaluation(tx *gorm.DB, evaluation *models.KPIEvaluation, userID uint, userRole string) error {
+	target := getReturnTarget(evaluation)
+	rule := findTransitionRule(evaluation.Status, target, true)
+	if rule == nil {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("「%s」状态的评估不能退回", getStatusText(evaluation.Status)),
+		}
+	}
+
+	return executeTransition(tx, evaluation, rule, userID, userRole)
+}
+
+// ReopenCompletedEvaluation 重新开放已完成的评估，回到HR审核阶段
+// evaluation 需要预加载 Employee
+func ReopenCompletedEvaluation(tx *gorm.DB, evaluation *models.KPIEvaluation, userID uint, userRole string) error {
+	if evaluation.Status != EvaluationStatusCompleted {
+		return &TransitionError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "只有已完成的评估可以重新开放",
+		}
+	}
+
+	rule := findTransitionRule(evaluation.Status, EvaluationStatusManagerEvaluated, true)
+
+	return executeTransition(tx, evaluation, rule, userID, userRole)
+}
+
+// 校验身份并执行流转规则，随后执行满足条件的自动流转
+func executeTransition(tx *gorm.DB, evaluation *models.KPIEvaluation, rule *TransitionRule, userID uint, userRole string) error {
+	actors := getEvaluationActors(evaluation, userID, userRole)
+	if !slices.ContainsFunc(rule.Actors, func(actor string) bool { return slices.Contains(actors, actor) }) {
+		return &TransitionError{
+			StatusCode: http.StatusForbidden,
+			Message:    fmt.Sprintf("无权限将评估变更为「%s」", getStatusText(rule.To)),
+		}
+	}
+
+	if err := applyTransitionRule(tx, evaluation, rule); err != nil {
+		return err
+	}
+
+	// 自动流转
+	for auto := findAutoTransitionRule(tx, evaluation); auto != nil; auto = findAutoTransitionRule(tx, evaluation) {
+		if err := applyTransitionRule(tx, evaluation, auto); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 依次检查多个前置条件
+func requireAll(checks ...func(tx *gorm.DB, evaluation *models.KPIEvaluation) error) func(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	return func(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+		for _, check := range checks {
+			if err := check(tx, evaluation); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// 前置条件：所有考核项目的指定评分都已填写，量化指标需要填写实际值
+func requireScoresFilled(column, label string) func(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	return func(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+		var missing int64
+		if err := tx.Model(&models.KPIScore{}).
+			Joins("JOIN kpi_items ON kpi_scores.item_id = kpi_items.id").
+			Where("kpi_scores.evaluation_id = ?", evaluation.ID).
+			Where("(kpi_items.type = ? AND kpi_scores.actual_value IS NULL) OR (kpi_items.type <> ? AND kpi_scores."+column+" IS NULL)",
+				ItemTypeQuantitative, ItemTypeQuantitative).
+			Count(&missing).Error; err != nil {
+			return err
+		}
+		if missing > 0 {
+			return &TransitionError{
+				StatusCode: http.StatusBadRequest,
+				Message:    fmt.Sprintf("还有 %d 个考核项目未填写%s", missing, label),
+			}
+		}
+		return nil
+	}
+}
+
+// 将自评分数复制为上级评分
+func copySelfScoresToManager(tx *gorm.DB, evaluation *models.KPIEvaluation) error {
+	var scores []models.KPIScore
+	if err := tx.Where("evaluation_id = ?", evaluation.ID).Find(&scores).Error; err != nil {
+		return err
+	}
+	for _, score := range scores {
+		if score.SelfScore == nil {
+			continue
+		}
+		previous := score.ManagerScore
+		if err := tx.Model(&score).Updates(map[string]interface{}{
+			"manager_score":     *score.SelfScore,
+			"manager_comment":   "（自评分数）",
+			"manager_auto":      true,
+			"manager_scorer_id": nil,
+		}).Error; err != nil {
+			return err
+		}
+		if err := auditField(tx, nil, AuditEntityScore, score.ID, "manager_score", previous, score.SelfScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
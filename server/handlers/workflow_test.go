@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"dootask-kpi-server/models"
+)
+
+// 校验错误为指定HTTP状态码的 TransitionError
+func assertTransitionError(t *testing.T, err error, statusCode int) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("期望返回 %d 错误，实际成功", statusCode)
+	}
+	transitionErr, ok := err.(*TransitionError)
+	if !ok {
+		t.Fatalf("期望返回 TransitionError，实际为 %v", err)
+	}
+	if transitionErr.StatusCode != statusCode {
+		t.Fatalf("返回 %d（%s），期望 %d", transitionErr.StatusCode, transitionErr.Message, statusCode)
+	}
+}
+
+func TestTransitionEvaluation(t *testing.T) {
+	tests := []struct {
+		name       string
+		solo       bool               // 被评估员工没有直属上级
+		status     string             // 评估当前状态
+		fill       map[string]float64 // 已填写的评分字段及占满分的比例
+		actor      string             // 触发流转的用户：employee, manager, hr
+		target     string
+		wantStatus string
+		wantTotal  float64
+		wantCode   int
+	}{
+		{name: "员工完成自评", status: EvaluationStatusPending, fill: map[string]float64{"self_score": 0.5}, actor: "employee", target: EvaluationStatusSelfEvaluated, wantStatus: EvaluationStatusSelfEvaluated},
+		{name: "自评未填完", status: EvaluationStatusPending, actor: "employee", target: EvaluationStatusSelfEvaluated, wantCode: http.StatusBadRequest},
+		{name: "上级不能代替员工自评", status: EvaluationStatusPending, fill: map[string]float64{"self_score": 0.5}, actor: "manager", target: EvaluationStatusSelfEvaluated, wantCode: http.StatusForbidden},
+		{name: "没有上级时自动进入HR审核", solo: true, status: EvaluationStatusPending, fill: map[string]float64{"self_score": 0.5}, actor: "employee", target: EvaluationStatusSelfEvaluated, wantStatus: EvaluationStatusManagerEvaluated},
+		{name: "上级完成评分", status: EvaluationStatusSelfEvaluated, fill: map[string]float64{"manager_score": 0.8}, actor: "manager", target: EvaluationStatusManagerEvaluated, wantStatus: EvaluationStatusManagerEvaluated},
+		{name: "上级评分未填完", status: EvaluationStatusSelfEvaluated, actor: "manager", target: EvaluationStatusManagerEvaluated, wantCode: http.StatusBadRequest},
+		{name: "HR不能代替上级评分", status: EvaluationStatusSelfEvaluated, fill: map[string]float64{"manager_score": 0.8}, actor: "hr", target: EvaluationStatusManagerEvaluated, wantCode: http.StatusForbidden},
+		{name: "HR审核完成时计算总分", status: EvaluationStatusManagerEvaluated, fill: map[string]float64{"self_score": 0.5, "manager_score": 0.8}, actor: "hr", target: EvaluationStatusPendingConfirm, wantStatus: EvaluationStatusPendingConfirm, wantTotal: 24},
+		{name: "员工确认最终得分", status: EvaluationStatusPendingConfirm, fill: map[string]float64{"manager_score": 0.8}, actor: "employee", target: EvaluationStatusCompleted, wantStatus: EvaluationStatusCompleted, wantTotal: 24},
+		{name: "上级不能代替员工确认", status: EvaluationStatusPendingConfirm, actor: "manager", target: EvaluationStatusCompleted, wantCode: http.StatusForbidden},
+		{name: "没有申诉记录时不能进入申诉", status: EvaluationStatusPendingConfirm, actor: "employee", target: EvaluationStatusDisputed, wantCode: http.StatusBadRequest},
+		{name: "不能跳过阶段", status: EvaluationStatusPending, fill: map[string]float64{"self_score": 0.5}, actor: "employee", target: EvaluationStatusCompleted, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+			actors := map[string]models.Employee{"employee": f.Employee, "manager": f.Manager, "hr": f.HR}
+
+			subject := f.Employee
+			if tt.solo {
+				subject = f.Solo
+				actors["employee"] = f.Solo
+			}
+			evaluation := f.createEvaluation(t, db, subject, tt.status)
+			for column, ratio := range tt.fill {
+				fillScores(t, db, evaluation.ID, column, ratio)
+			}
+
+			actor := actors[tt.actor]
+			err := TransitionEvaluation(db, &evaluation, tt.target, actor.ID, actor.Role)
+
+			var saved models.KPIEvaluation
+			if err := db.First(&saved, evaluation.ID).Error; err != nil {
+				t.Fatalf("查询评估失败: %v", err)
+			}
+			if tt.wantCode != 0 {
+				assertTransitionError(t, err, tt.wantCode)
+				if saved.Status != tt.status {
+					t.Errorf("流转失败后状态为 %s，期望保持 %s", saved.Status, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("流转失败: %v", err)
+			}
+			if evaluation.Status != tt.wantStatus || saved.Status != tt.wantStatus {
+				t.Errorf("流转后状态为 %s（已保存 %s），期望 %s", evaluation.Status, saved.Status, tt.wantStatus)
+			}
+			if saved.TotalScore != tt.wantTotal {
+				t.Errorf("总分为 %g，期望 %g", saved.TotalScore, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// 没有直属上级时，自评分数自动作为上级评分
+func TestTransitionEvaluationCopiesSelfScores(t *testing.T) {
+	db := openTestDB(t)
+	f := createWorkflowFixture(t, db)
+	evaluation := f.createEvaluation(t, db, f.Solo, EvaluationStatusPending)
+	fillScores(t, db, evaluation.ID, "self_score", 0.5)
+
+	if err := TransitionEvaluation(db, &evaluation, EvaluationStatusSelfEvaluated, f.Solo.ID, f.Solo.Role); err != nil {
+		t.Fatalf("流转失败: %v", err)
+	}
+	for _, score := range findScores(t, db, evaluation.ID) {
+		if score.ManagerScore == nil || *score.ManagerScore != score.Item.MaxScore*0.5 || !score.ManagerAuto {
+			t.Errorf("「%s」的上级评分为 %v（自动填入 %v），期望自动填入 %g", score.Item.Name, score.ManagerScore, score.ManagerAuto, score.Item.MaxScore*0.5)
+		}
+	}
+}
+
+func TestReturnEvaluation(t *testing.T) {
+	tests := []struct {
+		name       string
+		solo       bool
+		status     string
+		actor      string
+		wantStatus string
+		wantCode   int
+	}{
+		{name: "上级退回自评", status: EvaluationStatusSelfEvaluated, actor: "manager", wantStatus: EvaluationStatusPending},
+		{name: "HR退回自评", status: EvaluationStatusSelfEvaluated, actor: "hr", wantStatus: EvaluationStatusPending},
+		{name: "员工不能退回自己的自评", status: EvaluationStatusSelfEvaluated, actor: "employee", wantCode: http.StatusForbidden},
+		{name: "HR退回上级评分", status: EvaluationStatusManagerEvaluated, actor: "hr", wantStatus: EvaluationStatusSelfEvaluated},
+		{name: "上级不能退回HR审核", status: EvaluationStatusManagerEvaluated, actor: "manager", wantCode: http.StatusForbidden},
+		{name: "没有上级时HR退回到自评", solo: true, status: EvaluationStatusManagerEvaluated, actor: "hr", wantStatus: EvaluationStatusPending},
+		{name: "待自评的评估不能退回", status: EvaluationStatusPending, actor: "hr", wantCode: http.StatusBadRequest},
+		{name: "待确认的评估不能退回", status: EvaluationStatusPendingConfirm, actor: "hr", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+			actors := map[string]models.Employee{"employee": f.Employee, "manager": f.Manager, "hr": f.HR}
+
+			subject := f.Employee
+			if tt.solo {
+				subject = f.Solo
+			}
+			evaluation := f.createEvaluation(t, db, subject, tt.status)
+
+			actor := actors[tt.actor]
+			err := ReturnEvaluation(db, &evaluation, actor.ID, actor.Role)
+			if tt.wantCode != 0 {
+				assertTransitionError(t, err, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("退回失败: %v", err)
+			}
+			if evaluation.Status != tt.wantStatus {
+				t.Errorf("退回后状态为 %s，期望 %s", evaluation.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReopenCompletedEvaluation(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		calibrated bool // 结果已被校准会议锁定
+		actor      string
+		wantCode   int
+	}{
+		{name: "HR重新开放", status: EvaluationStatusCompleted, actor: "hr"},
+		{name: "上级不能重新开放", status: EvaluationStatusCompleted, actor: "manager", wantCode: http.StatusForbidden},
+		{name: "校准锁定后不能重新开放", status: EvaluationStatusCompleted, calibrated: true, actor: "hr", wantCode: http.StatusBadRequest},
+		{name: "未完成的评估不能重新开放", status: EvaluationStatusPendingConfirm, actor: "hr", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			f := createWorkflowFixture(t, db)
+			actors := map[string]models.Employee{"manager": f.Manager, "hr": f.HR}
+
+			evaluation := f.createEvaluation(t, db, f.Employee, tt.status)
+			if tt.calibrated {
+				sessionID := uint(1)
+				evaluation.CalibrationID = &sessionID
+			}
+
+			actor := actors[tt.actor]
+			err := ReopenCompletedEvaluation(db, &evaluation, actor.ID, actor.Role)
+			if tt.wantCode != 0 {
+				assertTransitionError(t, err, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("重新开放失败: %v", err)
+			}
+			if evaluation.Status != EvaluationStatusManagerEvaluated {
+				t.Errorf("重新开放后状态为 %s，期望 %s", evaluation.Status, EvaluationStatusManagerEvaluated)
+			}
+		})
+	}
+}
+
+func TestCheckScoreWrite(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+	target := 100.0
+	rating := models.KPIItem{Name: "工作质量", MaxScore: 10, Type: ItemTypeRating}
+	quantitative := models.KPIItem{Name: "销售额", MaxScore: 20, Type: ItemTypeQuantitative, Target: &target, ScoringCurve: ScoringCurveLinear}
+
+	tests := []struct {
+		name     string
+		status   string
+		item     models.KPIItem
+		field    string
+		value    *float64
+		actor    string
+		wantCode int
+	}{
+		{name: "员工填写自评", status: EvaluationStatusPending, item: rating, field: ScoreFieldSelf, value: floatPtr(8), actor: "employee"},
+		{name: "上级不能填写自评", status: EvaluationStatusPending, item: rating, field: ScoreFieldSelf, value: floatPtr(8), actor: "manager", wantCode: http.StatusForbidden},
+		{name: "自评完成后不能修改自评", status: EvaluationStatusSelfEvaluated, item: rating, field: ScoreFieldSelf, value: floatPtr(8), actor: "employee", wantCode: http.StatusBadRequest},
+		{name: "自评超过满分", status: EvaluationStatusPending, item: rating, field: ScoreFieldSelf, value: floatPtr(11), actor: "employee", wantCode: http.StatusBadRequest},
+		{name: "自评为负数", status: EvaluationStatusPending, item: rating, field: ScoreFieldSelf, value: floatPtr(-1), actor: "employee", wantCode: http.StatusBadRequest},
+		{name: "只填写评价", status: EvaluationStatusPending, item: rating, field: ScoreFieldSelf, actor: "employee"},
+		{name: "上级填写上级评分", status: EvaluationStatusSelfEvaluated, item: rating, field: ScoreFieldManager, value: floatPtr(10), actor: "manager"},
+		{name: "员工不能填写上级评分", status: EvaluationStatusSelfEvaluated, item: rating, field: ScoreFieldManager, value: floatPtr(10), actor: "employee", wantCode: http.StatusForbidden},
+		{name: "HR填写HR评分", status: EvaluationStatusManagerEvaluated, item: rating, field: ScoreFieldHR, value: floatPtr(9), actor: "hr"},
+		{name: "量化指标不能填写自评", status: EvaluationStatusPending, item: quantitative, field: ScoreFieldSelf, value: floatPtr(8), actor: "employee", wantCode: http.StatusBadRequest},
+		{name: "HR可以指定量化指标的最终得分", status: EvaluationStatusManagerEvaluated, item: quantitative, field: ScoreFieldFinal, value: floatPtr(15), actor: "hr"},
+		{name: "已完成的评估锁定评分", status: EvaluationStatusCompleted, item: rating, field: ScoreFieldHR, value: floatPtr(9), actor: "hr", wantCode: http.StatusForbidden},
+		{name: "不支持的评分类型", status: EvaluationStatusPending, item: rating, field: "peer", value: floatPtr(8), actor: "employee", wantCode: http.StatusBadRequest},
+	}
+
+	db := openTestDB(t)
+	f := createWorkflowFixture(t, db)
+	actors := map[string]models.Employee{"employee": f.Employee, "manager": f.Manager, "hr": f.HR}
+	evaluation := f.createEvaluation(t, db, f.Employee, EvaluationStatusPending)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluation.Status = tt.status
+			actor := actors[tt.actor]
+			err := CheckScoreWrite(&evaluation, &tt.item, tt.field, tt.value, actor.ID, actor.Role)
+			if tt.wantCode != 0 {
+				assertTransitionError(t, err, tt.wantCode)
+				return
+			}
+			if err != nil {
+				t.Fatalf("校验失败: %v", err)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"os"
 
+	"dootask-kpi-server/cli"
+	"dootask-kpi-server/config"
 	"dootask-kpi-server/handlers"
 	"dootask-kpi-server/models"
 	"dootask-kpi-server/routes"
@@ -13,11 +17,28 @@ import (
 )
 
 func main() {
-	// 初始化数据库
+	// 加载配置，配置不合法时拒绝启动
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("配置加载失败:", err)
+	}
+
+	// 命令行模式，执行子命令后退出
+	seed := flag.Bool("seed", false, "启动时创建测试数据（生产环境默认不创建）")
+	noSeed := flag.Bool("no-seed", false, "启动时不创建测试数据")
+	flag.Usage = cli.PrintUsage
+	flag.Parse()
+	if flag.NArg() > 0 {
+		os.Exit(cli.Run(flag.Args()))
+	}
+
+	// 初始化数据库，数据库结构不是最新版本时拒绝启动
 	models.InitDB()
 
-	// 创建测试数据
-	models.CreateTestData()
+	// 创建测试数据：开发环境默认创建，可通过 --no-seed 跳过；生产环境默认不创建，需使用 create-admin 创建管理员
+	if (*seed || !cfg.IsProduction()) && !*noSeed {
+		models.CreateTestData()
+	}
 
 	// 设置Gin模式
 	gin.SetMode(gin.ReleaseMode)
@@ -26,11 +47,15 @@ func main() {
 	r := gin.Default()
 
 	// 配置CORS
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "DooTaskAuth"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	r.Use(cors.New(config))
+	corsConfig := cors.DefaultConfig()
+	if cfg.AllowAllOrigins() {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = cfg.AllowedOrigins
+	}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "DooTaskAuth"}
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	r.Use(cors.New(corsConfig))
 
 	// 基础中间件
 	r.Use(handlers.BaseMiddleware())
@@ -51,6 +76,12 @@ func main() {
 	handlers.StartSSECleanupTask()
 	handlers.CleanupExportFiles()
 
-	log.Println("KPI系统服务器启动在端口 :8080")
-	log.Fatal(r.Run(":8080"))
+	// 启动定时发起评估任务
+	handlers.StartEvaluationScheduleTask()
+
+	// 启动截止提醒任务
+	handlers.StartDeadlineReminderTask()
+
+	log.Printf("KPI系统服务器启动在 %s（%s）", cfg.ListenAddr, cfg.Env)
+	log.Fatal(r.Run(cfg.ListenAddr))
 }
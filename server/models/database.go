@@ -1,52 +1,155 @@
 package models
 
 import (
+	"errors"
 	"log"
 	"os"
 
+	"dootask-kpi-server/config"
+	"dootask-kpi-server/models/schemav1"
+
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 var DB *gorm.DB
 
-// 初始化数据库连接
+// 配置的日志级别对应的SQL日志级别
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// 初始化数据库连接，并检查数据库结构版本
+// 空数据库自动执行全部迁移，已有数据的数据库需要先通过 migrate 命令升级
 func InitDB() {
-	var err error
+	OpenDB()
+
+	tables, err := DB.Migrator().GetTables()
+	if err != nil {
+		log.Fatal("读取数据表失败:", err)
+	}
+	if len(tables) == 0 {
+		log.Println("数据库为空，初始化数据库结构")
+		if err := MigrateUp(DB); err != nil {
+			log.Fatal("数据库迁移失败:", err)
+		}
+	}
 
-	// 创建db目录
-	os.MkdirAll("db", 0755)
+	if err := CheckSchemaVersion(DB); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("数据库结构版本 %d", LatestSchemaVersion())
+}
 
-	// 连接SQLite数据库
-	DB, err = gorm.Open(sqlite.Open("db/kpi.db"), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+// 连接数据库
+func OpenDB() {
+	var err error
+	cfg := config.Current
+
+	// 根据连接串选择数据库驱动
+	dialector, err := openDialector(cfg.DatabaseDSN)
+	if err != nil {
+		log.Fatal("数据库连接失败:", err)
+	}
+	DB, err = gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(logLevels[cfg.LogLevel]),
 	})
 	if err != nil {
 		log.Fatal("数据库连接失败:", err)
 	}
 
-	log.Println("数据库连接成功")
-
-	// 自动迁移数据库表
-	err = DB.AutoMigrate(
-		&Department{},
-		&Employee{},
-		&KPITemplate{},
-		&KPIItem{},
-		&KPIEvaluation{},
-		&KPIScore{},
-		&EvaluationComment{},
-		&EvaluationInvitation{},
-		&InvitedScore{},
-		&SystemSetting{},
-	)
-	if err != nil {
-		log.Fatal("数据库迁移失败:", err)
+	log.Printf("数据库连接成功（%s）", DetectDialect(cfg.DatabaseDSN))
+}
+
+// 没有任何评级量表时创建默认的五级评级量表，由迁移3调用，按初始迁移的表结构写入
+func createDefaultRatingScale(tx *gorm.DB) error {
+	var count int64
+	if err := tx.Model(&schemav1.RatingScale{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	scale := schemav1.RatingScale{
+		Name:        "五级评级",
+		Description: "按总分划分为 S/A/B/C/D 五个等级",
+		IsDefault:   true,
+		Grades: []schemav1.RatingGrade{
+			{Label: "S", MinScore: 90, Color: "#22c55e", Description: "卓越"},
+			{Label: "A", MinScore: 80, Color: "#3b82f6", Description: "优秀"},
+			{Label: "B", MinScore: 70, Color: "#f59e0b", Description: "良好"},
+			{Label: "C", MinScore: 60, Color: "#ef4444", Description: "合格"},
+			{Label: "D", MinScore: 0, Color: "#6b7280", Description: "待改进"},
+		},
+		Anchors: []schemav1.RatingAnchor{
+			{Label: "卓越", MinPercent: 90, Description: "远超预期，成果可作为团队标杆"},
+			{Label: "良好", MinPercent: 75, Description: "超出预期，工作质量和效率稳定"},
+			{Label: "达标", MinPercent: 60, Description: "基本达到预期，偶有需要改进之处"},
+			{Label: "待改进", MinPercent: 0, Description: "未达到预期，需要制定改进计划"},
+		},
+	}
+	return tx.Create(&scale).Error
+}
+
+// 为已完成但没有等级的评估按总分确定等级，由迁移3调用
+func backfillEvaluationGrades(tx *gorm.DB) error {
+	var evaluations []KPIEvaluation
+	if err := tx.Where("status = ? AND (grade = '' OR grade IS NULL)", "completed").Find(&evaluations).Error; err != nil {
+		return err
 	}
 
-	log.Println("数据库表迁移完成")
+	scales := make(map[uint]*RatingScale)
+	for _, evaluation := range evaluations {
+		scale, ok := scales[evaluation.TemplateID]
+		if !ok {
+			var err error
+			if scale, err = FindRatingScale(tx, evaluation.TemplateID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			scales[evaluation.TemplateID] = scale
+		}
+		if scale == nil {
+			continue
+		}
+		grade := MatchRatingGrade(scale.Grades, evaluation.TotalScore)
+		if grade == nil {
+			continue
+		}
+		if err := tx.Model(&schemav1.KPIEvaluation{}).Where("id = ?", evaluation.ID).UpdateColumns(map[string]interface{}{
+			"grade":           grade.Label,
+			"rating_scale_id": scale.ID,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 为已有的上级评分回填评分人，由迁移6调用
+// 优先使用审计日志中最后一次填写上级评分的操作人；审计日志之前的评分只能按员工当前的直属上级估计
+// 评审链汇总的上级评分按评审人记录统计，不回填
+func backfillManagerScorers(tx *gorm.DB) error {
+	scored := tx.Model(&kpiScoreV6{}).Where("manager_score IS NOT NULL AND manager_auto = ?", false).Session(&gorm.Session{})
+
+	lastScorer := tx.Model(&schemav1.AuditLog{}).Select("audit_logs.actor_id").
+		Where("audit_logs.entity_type = ? AND audit_logs.field = ? AND audit_logs.entity_id = kpi_scores.id AND audit_logs.actor_id > 0", "score", "manager_score").
+		Order("audit_logs.id DESC").Limit(1)
+	if err := scored.Where("EXISTS (?)", lastScorer).
+		UpdateColumn("manager_scorer_id", gorm.Expr("(?)", lastScorer)).Error; err != nil {
+		return err
+	}
+
+	currentManager := tx.Model(&schemav1.KPIEvaluation{}).Select("employees.manager_id").
+		Joins("JOIN employees ON employees.id = kpi_evaluations.employee_id").
+		Where("kpi_evaluations.id = kpi_scores.evaluation_id")
+	reviewChains := tx.Model(&schemav1.EvaluationReviewer{}).Select("evaluation_id").Where("role = ?", "scorer")
+	return scored.Where("manager_scorer_id IS NULL AND evaluation_id NOT IN (?)", reviewChains).
+		UpdateColumn("manager_scorer_id", gorm.Expr("(?)", currentManager)).Error
 }
 
 // 创建测试数据
@@ -61,14 +164,18 @@ func CreateTestData() {
 		return
 	}
 
-	// 根据系统模式创建测试数据
+	// 根据系统模式创建测试数据，测试用户按固定的部门ID创建，已有部门时跳过
 	if os.Getenv("SYSTEM_MODE") == "integrated" {
 		log.Println("集成模式，仅创建KPI模板")
+	} else if count > 0 {
+		log.Println("已有部门数据，跳过创建测试用户")
 	} else {
 		log.Println("独立模式，创建测试数据")
 		CreateTestDataForUser()
 	}
-	CreateTestDataForTemplate()
+	if count2 == 0 {
+		CreateTestDataForTemplate()
+	}
 
 	log.Println("测试数据创建完成")
 }
@@ -112,44 +219,29 @@ func CreateTestDataForUser() {
 
 // 创建测试数据（KPI模板）
 func CreateTestDataForTemplate() {
-	// 创建KPI模板
-	templates := []KPITemplate{
-		{Name: "技术岗位月度考核", Description: "适用于技术人员的月度绩效考核", Period: "monthly"},
-		{Name: "市场岗位季度考核", Description: "适用于市场人员的季度绩效考核", Period: "quarterly"},
-		{Name: "管理岗位年度考核", Description: "适用于管理人员的年度绩效考核", Period: "yearly"},
-	}
-
-	for _, template := range templates {
-		DB.Create(&template)
-	}
-
-	// 创建KPI考核项目
-	items := []KPIItem{
-		// 技术岗位月度考核项目
-		{TemplateID: 1, Name: "代码质量", Description: "代码规范性、可维护性评估", MaxScore: 20, Order: 1},
-		{TemplateID: 1, Name: "任务完成度", Description: "按时完成分配的开发任务", MaxScore: 25, Order: 2},
-		{TemplateID: 1, Name: "技术创新", Description: "技术方案创新和改进", MaxScore: 15, Order: 3},
-		{TemplateID: 1, Name: "团队协作", Description: "与团队成员的协作配合", MaxScore: 20, Order: 4},
-		{TemplateID: 1, Name: "学习成长", Description: "技术学习和个人提升", MaxScore: 20, Order: 5},
-
-		// 市场岗位季度考核项目
-		{TemplateID: 2, Name: "销售业绩", Description: "季度销售目标达成情况", MaxScore: 40, Order: 1},
-		{TemplateID: 2, Name: "客户维护", Description: "客户关系维护和满意度", MaxScore: 30, Order: 2},
-		{TemplateID: 2, Name: "市场活动", Description: "市场推广活动执行效果", MaxScore: 30, Order: 3},
-
-		// 管理岗位年度考核项目
-		{TemplateID: 3, Name: "团队管理", Description: "团队建设和人员管理", MaxScore: 30, Order: 1},
-		{TemplateID: 3, Name: "业务发展", Description: "部门业务发展和目标达成", MaxScore: 35, Order: 2},
-		{TemplateID: 3, Name: "战略规划", Description: "部门战略规划和执行", MaxScore: 35, Order: 3},
+	// 从内置模板库创建KPI模板和考核项目
+	documents, err := LoadTemplateLibrary()
+	if err != nil {
+		log.Fatal("加载内置模板库失败:", err)
 	}
 
-	for _, item := range items {
-		DB.Create(&item)
+	for _, document := range documents {
+		template := document.ToTemplate()
+		CreateTemplateWithItemTree(DB, &template)
+		DB.Create(&KPITemplateVersion{
+			TemplateID:  template.ID,
+			Version:     template.Version,
+			Name:        template.Name,
+			Description: template.Description,
+			Period:      template.Period,
+		})
 	}
 
 	// 创建默认系统设置
 	settings := []SystemSetting{
 		{Key: "allow_registration", Value: "true", Type: "boolean"},
+		{Key: "deadline_reminder_hours", Value: "72,24", Type: "string"},
+		{Key: "deadline_escalation_hours", Value: "0", Type: "number"},
 	}
 
 	for _, setting := range settings {
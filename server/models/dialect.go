@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// 支持的数据库类型
+const (
+	DialectSQLite   = "sqlite"
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+)
+
+// 根据连接串判断数据库类型：
+// postgres:// 或 postgresql:// 开头（或 host=... 形式）为PostgreSQL，
+// mysql:// 开头（或 user:pass@tcp(host)/db 形式）为MySQL，其他视为SQLite数据文件路径
+func DetectDialect(dsn string) string {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"), strings.HasPrefix(dsn, "host="):
+		return DialectPostgres
+	case strings.HasPrefix(dsn, "mysql://"), strings.Contains(dsn, "@tcp("), strings.Contains(dsn, "@unix("):
+		return DialectMySQL
+	default:
+		return DialectSQLite
+	}
+}
+
+// 根据连接串创建数据库驱动
+func openDialector(dsn string) (gorm.Dialector, error) {
+	switch DetectDialect(dsn) {
+	case DialectPostgres:
+		return postgres.Open(dsn), nil
+	case DialectMySQL:
+		return mysql.Open(mysqlDSN(dsn)), nil
+	default:
+		return openSQLite(dsn)
+	}
+}
+
+// SQLite数据文件路径，连接串可以是文件路径或 file: URI
+func sqliteFilePath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "file:")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
+// MySQL驱动使用的连接串：去掉 mysql:// 前缀，并开启时间类型解析
+func mysqlDSN(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "mysql://")
+	if !strings.Contains(dsn, "parseTime=") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "parseTime=true"
+	}
+	return dsn
+}
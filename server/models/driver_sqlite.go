@@ -0,0 +1,19 @@
+//go:build cgo
+
+package models
+
+import (
+	"os"
+	"path/filepath"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 打开SQLite数据库，自动创建数据文件所在目录
+func openSQLite(dsn string) (gorm.Dialector, error) {
+	if err := os.MkdirAll(filepath.Dir(sqliteFilePath(dsn)), 0755); err != nil {
+		return nil, err
+	}
+	return sqlite.Open(dsn), nil
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 未启用cgo构建时不包含SQLite驱动，只能使用PostgreSQL或MySQL
+func openSQLite(dsn string) (gorm.Dialector, error) {
+	return nil, errors.New("当前构建未启用cgo，不支持SQLite，请使用 CGO_ENABLED=1 构建或改用 PostgreSQL/MySQL")
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaVersion 已执行的数据库迁移
+type SchemaVersion struct {
+	Version   int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `json:"name" gorm:"size:100;not null"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// Migration 数据库迁移，版本号从1开始连续递增
+// Down 为空表示该迁移不可回滚
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationStatus 迁移的执行状态
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// 最新的数据库结构版本
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// 当前数据库结构版本，未执行过任何迁移时为0
+func CurrentSchemaVersion(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&SchemaVersion{}) {
+		return 0, nil
+	}
+	var version int
+	if err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// 所有迁移的执行状态
+func SchemaStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	applied := make(map[int]SchemaVersion)
+	if db.Migrator().HasTable(&SchemaVersion{}) {
+		var versions []SchemaVersion
+		if err := db.Find(&versions).Error; err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			applied[version.Version] = version
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if version, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &version.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// 检查数据库结构是否为最新版本，启动服务前调用
+func CheckSchemaVersion(db *gorm.DB) error {
+	current, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current < latest {
+		return fmt.Errorf("数据库结构版本为 %d，低于程序要求的版本 %d，请先执行 migrate up", current, latest)
+	}
+	if current > latest {
+		return fmt.Errorf("数据库结构版本为 %d，高于程序支持的版本 %d，请使用新版本程序或执行 migrate to %d", current, latest, latest)
+	}
+	return nil
+}
+
+// 校验目标版本是否存在，0表示回滚全部迁移
+func ValidateSchemaVersion(version int) error {
+	if version < 0 || version > LatestSchemaVersion() {
+		return fmt.Errorf("目标版本 %d 不存在，可用版本为 0 到 %d", version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// 迁移到最新版本
+func MigrateUp(db *gorm.DB) error {
+	return MigrateTo(db, LatestSchemaVersion())
+}
+
+// 迁移到指定版本，高于当前版本时依次执行升级，低于当前版本时依次回滚
+// 每个迁移在单独的事务中执行并记录版本，失败时停留在上一个成功的版本
+// 注意：MySQL的DDL语句会隐式提交事务，结构变更失败时需要手动处理
+func MigrateTo(db *gorm.DB, target int) error {
+	if err := ValidateSchemaVersion(target); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return err
+	}
+	current, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if target >= current {
+		for _, migration := range migrations {
+			if migration.Version <= current || migration.Version > target {
+				continue
+			}
+			log.Printf("执行迁移 %d_%s", migration.Version, migration.Name)
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaVersion{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			}); err != nil {
+				return fmt.Errorf("迁移 %d_%s 执行失败: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	}
+
+	// 回滚前确认所有需要回滚的迁移都支持回滚，避免回滚到一半中断
+	var rollbacks []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version > current || migration.Version <= target {
+			continue
+		}
+		if migration.Down == nil {
+			return fmt.Errorf("迁移 %d_%s 不支持回滚", migration.Version, migration.Name)
+		}
+		rollbacks = append(rollbacks, migration)
+	}
+	for _, migration := range rollbacks {
+		log.Printf("回滚迁移 %d_%s", migration.Version, migration.Name)
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{}, migration.Version).Error
+		}); err != nil {
+			return fmt.Errorf("迁移 %d_%s 回滚失败: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// 迁移前备份SQLite数据库，返回备份文件路径
+// 其他数据库或数据文件不存在时不备份，返回空路径
+func BackupSQLite(db *gorm.DB, dsn string) (string, error) {
+	if DetectDialect(dsn) != DialectSQLite {
+		return "", nil
+	}
+	path := sqliteFilePath(dsn)
+	if path == "" || strings.Contains(path, ":memory:") {
+		return "", nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", nil
+	}
+
+	version, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(path), "backups")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	prefix := fmt.Sprintf("%s-v%d-%s", name, version, time.Now().Format("20060102150405"))
+	backup := filepath.Join(dir, prefix+".db")
+	// 同一秒内多次备份时加序号，避免备份文件已存在导致失败
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = filepath.Join(dir, fmt.Sprintf("%s-%d.db", prefix, i))
+	}
+
+	// VACUUM INTO 生成一致的数据库副本，不受其他连接写入的影响
+	if err := db.Exec("VACUUM INTO ?", backup).Error; err != nil {
+		return "", fmt.Errorf("备份数据库失败: %w", err)
+	}
+	return backup, nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"time"
+
+	"dootask-kpi-server/models/schemav1"
+
+	"gorm.io/gorm"
+)
+
+// 数据库迁移，按版本号顺序追加，已发布的迁移不能修改
+// 修改表结构（新增、重命名字段，回填数据等）时新增一个迁移
+// 迁移只使用迁移内定义的表结构（初始迁移见 schemav1 包），不引用会随版本修改的模型，保证每个版本的表结构固定
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(schemav1.Models...)
+		},
+		Down: func(tx *gorm.DB) error {
+			// 多对多关联表不在模型列表中，需要单独删除
+			if err := tx.Migrator().DropTable("review_cycle_departments", "evaluation_schedule_departments"); err != nil {
+				return err
+			}
+			for i := len(schemav1.Models) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(schemav1.Models[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "backfill_item_origin",
+		Up: func(tx *gorm.DB) error {
+			// 历史考核项目以自身ID作为最初版本的ID
+			return tx.Model(&schemav1.KPIItem{}).Where("origin_id = 0 OR origin_id IS NULL").UpdateColumn("origin_id", gorm.Expr("id")).Error
+		},
+		// 回填的数据在旧版本中同样有效，回滚时保留
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 3,
+		Name:    "default_rating_scale",
+		Up: func(tx *gorm.DB) error {
+			// 创建默认评级量表，并为已完成的历史评估确定等级
+			if err := createDefaultRatingScale(tx); err != nil {
+				return err
+			}
+			return backfillEvaluationGrades(tx)
+		},
+		// 默认评级量表可能已被评估引用，回滚时保留
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 4,
+		Name:    "user_sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userSessionV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userSessionV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "score_adjusted_score",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&kpiScoreV5{}, "AdjustedScore")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&kpiScoreV5{}, "AdjustedScore")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "score_manager_scorer",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&kpiScoreV6{}, "ManagerScorerID"); err != nil {
+				return err
+			}
+			return backfillManagerScorers(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&kpiScoreV6{}, "ManagerScorerID")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "item_cap_percent",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&kpiItemV7{}, "CapPercent")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&kpiItemV7{}, "CapPercent")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "score_override_score",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&kpiScoreV8{}, "OverrideScore")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&kpiScoreV8{}, "OverrideScore")
+		},
+	},
+}
+
+// 迁移4创建的登录会话表
+type userSessionV4 struct {
+	ID                uint   `gorm:"primaryKey"`
+	EmployeeID        uint   `gorm:"index"`
+	RefreshTokenHash  string `gorm:"size:64;uniqueIndex"`
+	PreviousTokenHash string `gorm:"size:64;index"`
+	UserAgent         string
+	IP                string
+	LastUsedAt        time.Time
+	ExpiresAt         time.Time
+	RevokedAt         *time.Time
+	RevokeReason      string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (userSessionV4) TableName() string {
+	return "user_sessions"
+}
+
+// 迁移5为考核得分新增的申诉调整得分字段
+type kpiScoreV5 struct {
+	AdjustedScore *float64
+}
+
+func (kpiScoreV5) TableName() string {
+	return "kpi_scores"
+}
+
+// 迁移6为考核得分新增的上级评分人字段
+type kpiScoreV6 struct {
+	ManagerScorerID *uint `gorm:"index"`
+}
+
+func (kpiScoreV6) TableName() string {
+	return "kpi_scores"
+}
+
+// 迁移7为考核项目增加的封顶比例
+type kpiItemV7 struct {
+	CapPercent *float64
+}
+
+func (kpiItemV7) TableName() string {
+	return "kpi_items"
+}
+
+// 迁移8为评分增加的HR指定最终得分
+type kpiScoreV8 struct {
+	OverrideScore *float64
+}
+
+func (kpiScoreV8) TableName() string {
+	return "kpi_scores"
+}
@@ -1,9 +1,15 @@
 package models
 
 import (
+	"errors"
 	"time"
+
+	"gorm.io/gorm"
 )
 
+// 审计日志只允许追加
+var ErrAuditLogImmutable = errors.New("审计日志不允许修改或删除")
+
 // 部门模型
 type Department struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
@@ -39,76 +45,652 @@ type Employee struct {
 
 // KPI模板模型
 type KPITemplate struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Name          string    `json:"name" gorm:"not null"`
+	Description   string    `json:"description"`
+	Period        string    `json:"period"` // monthly, quarterly, yearly
+	IsActive      bool      `json:"is_active" gorm:"default:true"`
+	Version       int       `json:"version" gorm:"default:1"`  // 当前版本号，已被评估使用的版本修改时生成新版本
+	RatingScaleID *uint     `json:"rating_scale_id,omitempty"` // 评级量表，为空时使用默认量表
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// 关联关系
+	Items         []KPIItem      `json:"items,omitempty" gorm:"foreignKey:TemplateID"`
+	ScoringPolicy *ScoringPolicy `json:"scoring_policy,omitempty" gorm:"foreignKey:TemplateID"`
+	RatingScale   *RatingScale   `json:"rating_scale,omitempty" gorm:"foreignKey:RatingScaleID"`
+}
+
+// 评分策略模型（每个模板一条，未配置时按 HR > 上级 > 自评 的优先级取分）
+type ScoringPolicy struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	TemplateID      uint      `json:"template_id" gorm:"uniqueIndex"`
+	SelfWeight      float64   `json:"self_weight"`                                  // 自评权重（百分比）
+	ManagerWeight   float64   `json:"manager_weight"`                               // 上级评分权重（百分比）
+	PeerWeight      float64   `json:"peer_weight"`                                  // 邀请评分权重（百分比）
+	HRWeight        float64   `json:"hr_weight"`                                    // HR评分权重（百分比）
+	GoalWeight      float64   `json:"goal_weight"`                                  // 个人目标得分占总分的比例（百分比），0表示目标不计入总分
+	PeerAggregation string    `json:"peer_aggregation" gorm:"default:mean"`         // 多个邀请评分的聚合方式：mean, trimmed_mean, median
+	MissingStrategy string    `json:"missing_strategy" gorm:"default:redistribute"` // 角色评分缺失时的处理：redistribute（按比例分摊给其他角色）, zero（按0分计算）
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// 评级量表模型：将评估总分映射为等级（如 S/A/B/C/D）
+type RatingScale struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
 	Name        string    `json:"name" gorm:"not null"`
 	Description string    `json:"description"`
-	Period      string    `json:"period"` // monthly, quarterly, yearly
-	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	IsDefault   bool      `json:"is_default"` // 默认量表，模板未指定量表时使用
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
 	// 关联关系
-	Items []KPIItem `json:"items,omitempty" gorm:"foreignKey:TemplateID"`
+	Grades  []RatingGrade  `json:"grades,omitempty" gorm:"foreignKey:ScaleID"`
+	Anchors []RatingAnchor `json:"anchors,omitempty" gorm:"foreignKey:ScaleID"`
+}
+
+// 评级等级：总分不低于下限且低于更高等级的下限时评为该等级
+type RatingGrade struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	ScaleID     uint    `json:"scale_id" gorm:"index"`
+	Label       string  `json:"label" gorm:"not null"` // 等级名称，如 S、A、B
+	MinScore    float64 `json:"min_score"`             // 总分下限（含）
+	Color       string  `json:"color"`                 // 统计图表中的颜色
+	Description string  `json:"description"`
+}
+
+// 评分锚点：考核项目得分率达到某一水平时的表现描述，评分时作为评分标准展示
+type RatingAnchor struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	ScaleID     uint    `json:"scale_id" gorm:"index"`
+	Label       string  `json:"label" gorm:"not null"` // 水平名称，如 卓越、达标
+	MinPercent  float64 `json:"min_percent"`           // 得分率下限（百分比，含）
+	Description string  `json:"description"`
+}
+
+// 预加载评级量表的等级和锚点，均按下限从高到低排列
+func PreloadRatingScale(db *gorm.DB) *gorm.DB {
+	return db.Preload("Grades", func(db *gorm.DB) *gorm.DB {
+		return db.Order("min_score DESC")
+	}).Preload("Anchors", func(db *gorm.DB) *gorm.DB {
+		return db.Order("min_percent DESC")
+	})
+}
+
+// 获取模板使用的评级量表：模板指定的量表，未指定时为默认量表，都没有时返回空
+func FindRatingScale(tx *gorm.DB, templateID uint) (*RatingScale, error) {
+	var template KPITemplate
+	if err := tx.First(&template, templateID).Error; err != nil {
+		return nil, err
+	}
+
+	var scale RatingScale
+	query := tx.Scopes(PreloadRatingScale)
+	if template.RatingScaleID != nil {
+		query = query.Where("id = ?", *template.RatingScaleID)
+	} else {
+		query = query.Where("is_default = ?", true)
+	}
+	if err := query.First(&scale).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &scale, nil
+}
+
+// 根据总分匹配等级，grades 需要按下限从高到低排列
+func MatchRatingGrade(grades []RatingGrade, score float64) *RatingGrade {
+	for i := range grades {
+		if score >= grades[i].MinScore {
+			return &grades[i]
+		}
+	}
+	return nil
 }
 
 // KPI考核项目模型
 type KPIItem struct {
+	ID          uint    `json:"id" gorm:"primaryKey"`
+	TemplateID  uint    `json:"template_id"`
+	Name        string  `json:"name" gorm:"not null"`
+	Description string  `json:"description"`
+	MaxScore    float64 `json:"max_score"`                      // 满分
+	Order       int     `json:"order"`                          // 排序
+	Type        string  `json:"type" gorm:"default:rating"`     // rating（主观评分）, quantitative（量化指标）, category（分类）
+	Version     int     `json:"version" gorm:"default:1;index"` // 所属模板版本
+	OriginID    uint    `json:"origin_id" gorm:"index"`         // 该项目在最初版本中的ID，用于跨版本对应同一考核项目
+
+	// 分类：分类项目不直接评分，得分由子项目汇总
+	ParentID *uint     `json:"parent_id,omitempty" gorm:"index"` // 所属分类（同一模板版本中的分类项目ID），为空表示顶级项目
+	Weight   float64   `json:"weight"`                           // 分类权重（百分比），为0表示子项目得分直接计入总分
+	Children []KPIItem `json:"children,omitempty" gorm:"-"`      // 分类下的子项目，仅用于按层级返回
+
+	// 量化指标配置，得分根据实际值自动计算
+	Unit         string     `json:"unit,omitempty"`                               // 单位
+	Target       *float64   `json:"target,omitempty"`                             // 目标值，达成时得满分
+	Threshold    *float64   `json:"threshold,omitempty"`                          // 门槛值，未达到时得0分，为空时为0
+	Stretch      *float64   `json:"stretch,omitempty"`                            // 挑战值，capped 曲线超额完成时最高计算到挑战值
+	ScoringCurve string     `json:"scoring_curve,omitempty"`                      // linear（线性且不超过满分）, step（分段）, capped（线性且超额部分封顶）
+	ScoreBands   ScoreBands `json:"score_bands,omitempty" gorm:"serializer:json"` // step 曲线的分段
+	CapPercent   *float64   `json:"cap_percent,omitempty"`                        // capped 曲线的得分上限（占满分的百分比，大于100）
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// 关联关系
+	Template KPITemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+}
+
+// 量化指标得分分段：达成率不低于 Min 时得分为满分的 Score%
+type ScoreBand struct {
+	Min   float64 `json:"min" yaml:"min"`     // 达成率（百分比）
+	Score float64 `json:"score" yaml:"score"` // 得分（占满分的百分比）
+}
+
+// 量化指标得分分段列表
+type ScoreBands []ScoreBand
+
+// 将考核项目列表整理为层级结构：分类项目的 Children 为其子项目，返回顶级项目
+func BuildItemTree(items []KPIItem) []KPIItem {
+	children := make(map[uint][]KPIItem)
+	for _, item := range items {
+		if item.ParentID != nil {
+			children[*item.ParentID] = append(children[*item.ParentID], item)
+		}
+	}
+
+	tree := []KPIItem{}
+	for _, item := range items {
+		if item.ParentID != nil {
+			continue
+		}
+		item.Children = children[item.ID]
+		tree = append(tree, item)
+	}
+	return tree
+}
+
+// 创建模板及其层级考核项目：template.Items 为顶级项目，分类的子项目放在 Children 中
+// 创建后 template.Items 为按层级顺序展开的全部考核项目
+func CreateTemplateWithItemTree(tx *gorm.DB, template *KPITemplate) error {
+	tree := template.Items
+	template.Items = nil
+	if err := tx.Create(template).Error; err != nil {
+		return err
+	}
+	items, err := createItemTree(tx, template, tree, nil)
+	template.Items = items
+	return err
+}
+
+// 按层级创建考核项目，返回展开后的全部考核项目
+func createItemTree(tx *gorm.DB, template *KPITemplate, tree []KPIItem, parentID *uint) ([]KPIItem, error) {
+	var created []KPIItem
+	for _, item := range tree {
+		children := item.Children
+		item.Children = nil
+		item.TemplateID = template.ID
+		item.Version = template.Version
+		item.ParentID = parentID
+		if err := tx.Omit("Template").Create(&item).Error; err != nil {
+			return created, err
+		}
+		created = append(created, item)
+
+		if len(children) > 0 {
+			id := item.ID
+			descendants, err := createItemTree(tx, template, children, &id)
+			created = append(created, descendants...)
+			if err != nil {
+				return created, err
+			}
+		}
+	}
+	return created, nil
+}
+
+// 新建的考核项目以自身ID作为最初版本的ID
+func (item *KPIItem) AfterCreate(tx *gorm.DB) error {
+	if item.OriginID != 0 {
+		return nil
+	}
+	item.OriginID = item.ID
+	return tx.Model(item).UpdateColumn("origin_id", item.ID).Error
+}
+
+// KPI模板版本模型（记录每个版本的模板信息，考核项目通过 KPIItem.Version 关联）
+type KPITemplateVersion struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
-	TemplateID  uint      `json:"template_id"`
-	Name        string    `json:"name" gorm:"not null"`
+	TemplateID  uint      `json:"template_id" gorm:"uniqueIndex:idx_template_version"`
+	Version     int       `json:"version" gorm:"uniqueIndex:idx_template_version"`
+	Name        string    `json:"name"`
 	Description string    `json:"description"`
-	MaxScore    float64   `json:"max_score"` // 满分
-	Order       int       `json:"order"`     // 排序
+	Period      string    `json:"period"`
+	Note        string    `json:"note"`       // 版本说明
+	CreatedBy   uint      `json:"created_by"` // 创建人ID，0表示系统
 	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
 
-	// 关联关系
-	Template KPITemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+	Items []KPIItem `json:"items,omitempty" gorm:"-"`
 }
 
 // KPI评估记录模型
 type KPIEvaluation struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	EmployeeID      uint       `json:"employee_id"`
+	TemplateID      uint       `json:"template_id"`
+	Period          string     `json:"period"` // 2024-01, 2024-Q1, 2024
+	Year            int        `json:"year"`
+	Month           *int       `json:"month,omitempty"`
+	Quarter         *int       `json:"quarter,omitempty"`
+	CycleID         *uint      `json:"cycle_id,omitempty" gorm:"index"`   // 所属考核周期，手动创建的评估为空
+	TemplateVersion int        `json:"template_version" gorm:"default:1"` // 评估使用的模板版本
+	Status          string     `json:"status" gorm:"default:pending"`     // pending, self_evaluated, manager_evaluated, pending_confirm, disputed, completed
+	TotalScore      float64    `json:"total_score"`
+	Grade           string     `json:"grade" gorm:"index"`                    // 根据评级量表由总分得出的等级，进入待确认时确定，员工确认前得分变化时重新确定
+	RatingScaleID   *uint      `json:"rating_scale_id,omitempty"`             // 确定等级时使用的评级量表
+	CalibrationID   *uint      `json:"calibration_id,omitempty" gorm:"index"` // 锁定结果的校准会议，锁定后不能重新开放
+	NormalizedScore *float64   `json:"normalized_score,omitempty"`            // 消除评分人宽严差异后的总分，与原始总分并存
+	NormalizationID *uint      `json:"normalization_id,omitempty"`            // 生成标准化总分的标准化记录
+	GoalScore       *float64   `json:"goal_score,omitempty"`                  // 个人目标加权得分（0-100），按评分策略的目标权重计入总分
+	FinalComment    string     `json:"final_comment"`
+	SelfDueAt       *time.Time `json:"self_due_at,omitempty"`    // 自评截止时间，为空时继承考核周期
+	ManagerDueAt    *time.Time `json:"manager_due_at,omitempty"` // 上级评分截止时间，为空时继承考核周期
+	HRDueAt         *time.Time `json:"hr_due_at,omitempty"`      // HR审核截止时间，为空时继承考核周期
+	ConfirmDueAt    *time.Time `json:"confirm_due_at,omitempty"` // 员工确认截止时间，为空时继承考核周期
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	// 关联关系
+	Employee   Employee           `json:"employee,omitempty" gorm:"foreignKey:EmployeeID"`
+	Template   KPITemplate        `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+	Cycle      *ReviewCycle       `json:"cycle,omitempty" gorm:"foreignKey:CycleID"`
+	Scores     []KPIScore         `json:"scores,omitempty" gorm:"foreignKey:EvaluationID"`
+	Breakdowns []ScoreBreakdown   `json:"breakdowns,omitempty" gorm:"foreignKey:EvaluationID"`
+	Appeals    []EvaluationAppeal `json:"appeals,omitempty" gorm:"foreignKey:EvaluationID"`
+}
+
+// 考核周期模型
+type ReviewCycle struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	Name              string     `json:"name" gorm:"not null"`
+	Period            string     `json:"period"` // monthly, quarterly, yearly
+	Year              int        `json:"year"`
+	Month             *int       `json:"month,omitempty"`
+	Quarter           *int       `json:"quarter,omitempty"`
+	StartDate         *time.Time `json:"start_date"`                  // 考核开始日期
+	EndDate           *time.Time `json:"end_date"`                    // 考核截止日期
+	DefaultTemplateID *uint      `json:"default_template_id"`         // 没有匹配的模板规则时使用的模板
+	Status            string     `json:"status" gorm:"default:draft"` // draft, open, launched, closed, archived
+	LaunchedAt        *time.Time `json:"launched_at,omitempty"`       // 最近一次发起时间
+	SelfDueAt         *time.Time `json:"self_due_at,omitempty"`       // 自评截止时间
+	ManagerDueAt      *time.Time `json:"manager_due_at,omitempty"`    // 上级评分截止时间
+	HRDueAt           *time.Time `json:"hr_due_at,omitempty"`         // HR审核截止时间
+	ConfirmDueAt      *time.Time `json:"confirm_due_at,omitempty"`    // 员工确认截止时间
+	CreatedBy         uint       `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	// 关联关系
+	DefaultTemplate *KPITemplate              `json:"default_template,omitempty" gorm:"foreignKey:DefaultTemplateID"`
+	Departments     []Department              `json:"departments,omitempty" gorm:"many2many:review_cycle_departments"` // 参与部门，为空表示全部部门
+	TemplateRules   []ReviewCycleTemplateRule `json:"template_rules,omitempty" gorm:"foreignKey:CycleID"`
+}
+
+// 考核周期模板分配规则模型
+type ReviewCycleTemplateRule struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CycleID      uint      `json:"cycle_id" gorm:"index"`
+	DepartmentID *uint     `json:"department_id"` // 为空表示匹配所有部门
+	Position     string    `json:"position"`      // 为空表示匹配所有职位
+	Role         string    `json:"role"`          // 为空表示匹配所有角色
+	TemplateID   uint      `json:"template_id"`
+	Priority     int       `json:"priority"` // 数值越小优先级越高
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// 关联关系
+	Template KPITemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+}
+
+// 模板分配规则模型：按部门、职位、角色为员工确定各考核周期类型的默认模板
+type TemplateAssignmentRule struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Name          string    `json:"name"`
+	Period        string    `json:"period" gorm:"index"`                 // 适用的考核周期类型：monthly, quarterly, yearly
+	DepartmentID  *uint     `json:"department_id"`                       // 为空表示匹配所有部门
+	Position      string    `json:"position"`                            // 为空表示匹配所有职位
+	PositionMatch string    `json:"position_match" gorm:"default:exact"` // exact（完全一致）, pattern（通配符，* 匹配任意字符，? 匹配单个字符）
+	Role          string    `json:"role"`                                // 为空表示匹配所有角色
+	TemplateID    uint      `json:"template_id"`
+	Priority      int       `json:"priority"` // 数值越小优先级越高
+	IsActive      bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// 关联关系
+	Department *Department `json:"department,omitempty" gorm:"foreignKey:DepartmentID"`
+	Template   KPITemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+}
+
+// 定时发起计划模型（每个模板一条，按模板周期自动发起评估）
+type EvaluationSchedule struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	TemplateID uint       `json:"template_id" gorm:"uniqueIndex"`
+	Enabled    bool       `json:"enabled"`
+	RunDay     int        `json:"run_day" gorm:"default:1"` // 每期第几天发起（1-28），季度为季度首月、年度为一月
+	StartDate  time.Time  `json:"start_date"`               // 从该日期所在的周期开始发起，停机后从这里补发
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	CreatedBy  uint       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// 关联关系
+	Template    KPITemplate  `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+	Departments []Department `json:"departments,omitempty" gorm:"many2many:evaluation_schedule_departments"` // 参与部门，为空表示全部部门
+}
+
+// 定时发起运行记录模型
+type ScheduleRun struct {
 	ID           uint      `json:"id" gorm:"primaryKey"`
-	EmployeeID   uint      `json:"employee_id"`
+	ScheduleID   uint      `json:"schedule_id" gorm:"index"`
 	TemplateID   uint      `json:"template_id"`
-	Period       string    `json:"period"` // 2024-01, 2024-Q1, 2024
+	Period       string    `json:"period"`
 	Year         int       `json:"year"`
 	Month        *int      `json:"month,omitempty"`
 	Quarter      *int      `json:"quarter,omitempty"`
-	Status       string    `json:"status" gorm:"default:pending"` // pending, self_evaluated, manager_evaluated, pending_confirm, completed
-	TotalScore   float64   `json:"total_score"`
-	FinalComment string    `json:"final_comment"`
+	Trigger      string    `json:"trigger"` // scheduled, catch_up, manual
+	Status       string    `json:"status"`  // success, failed
+	CycleID      *uint     `json:"cycle_id,omitempty"`
+	CreatedCount int       `json:"created_count"`
+	SkippedCount int       `json:"skipped_count"`
+	Message      string    `json:"message" gorm:"type:text"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+
+	// 关联关系
+	Template KPITemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+}
+
+// 评估申诉模型
+type EvaluationAppeal struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	EvaluationID uint       `json:"evaluation_id" gorm:"index"`
+	EmployeeID   uint       `json:"employee_id"`                   // 申诉人（被评估员工）
+	Reason       string     `json:"reason" gorm:"type:text"`       // 申诉理由
+	Status       string     `json:"status" gorm:"default:pending"` // pending, resolved
+	Response     string     `json:"response" gorm:"type:text"`     // 处理答复
+	ResolverID   *uint      `json:"resolver_id,omitempty"`         // 处理人
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// 关联关系
+	Employee Employee     `json:"employee,omitempty" gorm:"foreignKey:EmployeeID"`
+	Resolver *Employee    `json:"resolver,omitempty" gorm:"foreignKey:ResolverID"`
+	Items    []AppealItem `json:"items,omitempty" gorm:"foreignKey:AppealID"`
+}
+
+// 申诉项目模型（申诉的具体考核项目）
+type AppealItem struct {
+	ID            uint     `json:"id" gorm:"primaryKey"`
+	AppealID      uint     `json:"appeal_id" gorm:"index"`
+	ScoreID       uint     `json:"score_id"`
+	ItemID        uint     `json:"item_id"`
+	Reason        string   `json:"reason" gorm:"type:text"`         // 该项目的申诉理由
+	OriginalScore *float64 `json:"original_score"`                  // 申诉时按评分策略计算的得分
+	Decision      string   `json:"decision" gorm:"default:pending"` // pending, adjusted（调整）, upheld（维持）
+	AdjustedScore *float64 `json:"adjusted_score,omitempty"`        // 调整后的得分
+
+	// 关联关系
+	Item KPIItem `json:"item,omitempty" gorm:"foreignKey:ItemID"`
+}
+
+// 评审链模型：为员工或模板配置多位评审人，替代单一直属上级的上级评分
+// 员工的评审链优先于模板的评审链，都没有配置时由直属上级评分
+type ReviewerChain struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Name          string    `json:"name" gorm:"not null"`
+	EmployeeID    *uint     `json:"employee_id,omitempty" gorm:"uniqueIndex"` // 适用的员工
+	TemplateID    *uint     `json:"template_id,omitempty" gorm:"uniqueIndex"` // 适用的模板
+	FinalApproval bool      `json:"final_approval"`                           // 评审人全部完成后是否需要隔级上级审批
+	Description   string    `json:"description"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// 关联关系
+	Steps    []ReviewerChainStep `json:"steps,omitempty" gorm:"foreignKey:ChainID"`
+	Employee *Employee           `json:"employee,omitempty" gorm:"foreignKey:EmployeeID"`
+	Template *KPITemplate        `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+}
+
+// 评审链步骤模型：同一阶段的评审人并行评分，各阶段按顺序进行
+type ReviewerChainStep struct {
+	ID           uint    `json:"id" gorm:"primaryKey"`
+	ChainID      uint    `json:"chain_id" gorm:"index"`
+	Stage        int     `json:"stage"`         // 评审阶段，从1开始
+	ReviewerType string  `json:"reviewer_type"` // direct_manager（直属上级）, skip_level（隔级上级）, employee（指定员工，如虚线汇报的项目负责人）
+	ReviewerID   *uint   `json:"reviewer_id,omitempty"`
+	Weight       float64 `json:"weight"` // 评分权重，上级评分为各评审人评分的加权平均
+	Label        string  `json:"label"`  // 评审角色名称，如「项目负责人」
+
+	// 关联关系
+	Reviewer *Employee `json:"reviewer,omitempty" gorm:"foreignKey:ReviewerID"`
+}
+
+// 评估评审人模型：评估进入上级评分阶段时根据评审链生成
+type EvaluationReviewer struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	EvaluationID uint       `json:"evaluation_id" gorm:"index"`
+	ReviewerID   uint       `json:"reviewer_id" gorm:"index"`
+	Role         string     `json:"role"`  // scorer（评分）, approver（最终审批）
+	Stage        int        `json:"stage"` // 评审阶段，审批在所有评分阶段之后
+	Label        string     `json:"label"`
+	Weight       float64    `json:"weight"`
+	Status       string     `json:"status" gorm:"default:pending"` // pending, submitted, approved
+	Comment      string     `json:"comment" gorm:"type:text"`      // 评审意见或退回原因
+	SubmittedAt  *time.Time `json:"submitted_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// 关联关系
+	Reviewer Employee        `json:"reviewer,omitempty" gorm:"foreignKey:ReviewerID"`
+	Scores   []ReviewerScore `json:"scores,omitempty" gorm:"foreignKey:ReviewerRecordID"`
+}
+
+// 评审人评分模型：每位评审人对每个考核项目的评分
+type ReviewerScore struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	ReviewerRecordID uint      `json:"reviewer_record_id" gorm:"index"`
+	EvaluationID     uint      `json:"evaluation_id" gorm:"index"`
+	ScoreID          uint      `json:"score_id"`
+	ItemID           uint      `json:"item_id"`
+	Score            *float64  `json:"score,omitempty"`
+	Comment          string    `json:"comment"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// 个人目标模型（OKR）：员工在考核周期开始时与上级约定的目标，经上级审批后在评估时评分
+type EmployeeGoal struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	EmployeeID     uint       `json:"employee_id" gorm:"index"`
+	CycleID        *uint      `json:"cycle_id,omitempty" gorm:"index"`      // 所属考核周期
+	EvaluationID   *uint      `json:"evaluation_id,omitempty" gorm:"index"` // 计分的评估，周期发起评估时自动关联
+	Objective      string     `json:"objective" gorm:"not null"`            // 目标
+	Description    string     `json:"description" gorm:"type:text"`
+	Weight         float64    `json:"weight"`                         // 目标权重，目标得分按权重加权平均
+	Progress       float64    `json:"progress"`                       // 完成进度（百分比），有关键结果时为关键结果进度的平均值
+	Status         string     `json:"status" gorm:"default:draft"`    // draft, submitted, approved, rejected
+	ApproverID     *uint      `json:"approver_id,omitempty"`          // 审批人
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`          // 审批时间
+	RejectReason   string     `json:"reject_reason" gorm:"type:text"` // 退回原因
+	SelfScore      *float64   `json:"self_score,omitempty"`           // 自评分数（0-100）
+	SelfComment    string     `json:"self_comment"`
+	ManagerScore   *float64   `json:"manager_score,omitempty"` // 上级评分（0-100）
+	ManagerComment string     `json:"manager_comment"`
+	FinalScore     *float64   `json:"final_score,omitempty"` // 最终得分：上级评分 > 自评分数，进入待确认时确定
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// 关联关系
+	Employee   Employee             `json:"employee,omitempty" gorm:"foreignKey:EmployeeID"`
+	Approver   *Employee            `json:"approver,omitempty" gorm:"foreignKey:ApproverID"`
+	KeyResults []GoalKeyResult      `json:"key_results,omitempty" gorm:"foreignKey:GoalID"`
+	Updates    []GoalProgressUpdate `json:"updates,omitempty" gorm:"foreignKey:GoalID"`
+}
+
+// 目标关键结果模型
+type GoalKeyResult struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	GoalID    uint      `json:"goal_id" gorm:"index"`
+	Title     string    `json:"title" gorm:"not null"`
+	Target    string    `json:"target"`   // 衡量标准，如「接口 P99 < 200ms」
+	Progress  float64   `json:"progress"` // 完成进度（百分比）
+	Order     int       `json:"order"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 目标进度更新记录模型
+type GoalProgressUpdate struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	GoalID      uint      `json:"goal_id" gorm:"index"`
+	KeyResultID *uint     `json:"key_result_id,omitempty"` // 更新的关键结果，为空表示直接更新目标进度
+	Progress    float64   `json:"progress"`                // 更新后的进度（百分比）
+	Note        string    `json:"note" gorm:"type:text"`
+	UpdatedBy   uint      `json:"updated_by"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// 关联关系
+	Updater Employee `json:"updater,omitempty" gorm:"foreignKey:UpdatedBy"`
+}
+
+// 分数标准化记录模型：按考核周期应用的评分人宽严差异校正
+type ScoreNormalization struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	CycleID         uint       `json:"cycle_id" gorm:"index"`
+	Method          string     `json:"method"`   // zscore（标准分）, percentile（百分位）
+	GroupBy         string     `json:"group_by"` // reviewer（按评分人）, department（按部门）
+	EvaluationCount int        `json:"evaluation_count"`
+	AppliedBy       uint       `json:"applied_by"`
+	AppliedAt       time.Time  `json:"applied_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"` // 撤销时间，撤销后评估的标准化总分被清除
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// 关联关系
+	Applier *Employee `json:"applier,omitempty" gorm:"foreignKey:AppliedBy"`
+}
+
+// 等级配额模型：限制部门内某些等级的人数占比（强制分布）
+type GradeQuota struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	DepartmentID *uint     `json:"department_id" gorm:"index"`    // 适用部门，为空表示未单独设置配额的部门
+	Grades       []string  `json:"grades" gorm:"serializer:json"` // 配额包含的等级，如 ["S", "A"]
+	MaxPercent   *float64  `json:"max_percent"`                   // 人数占比上限（百分比）
+	MinPercent   *float64  `json:"min_percent"`                   // 人数占比下限（百分比）
+	Description  string    `json:"description"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 
 	// 关联关系
-	Employee Employee    `json:"employee,omitempty" gorm:"foreignKey:EmployeeID"`
-	Template KPITemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
-	Scores   []KPIScore  `json:"scores,omitempty" gorm:"foreignKey:EvaluationID"`
+	Department *Department `json:"department,omitempty" gorm:"foreignKey:DepartmentID"`
+}
+
+// 校准会议模型：HR和部门主管对照等级配额集中调整考核周期内的评估结果
+type CalibrationSession struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Name         string     `json:"name" gorm:"not null"`
+	CycleID      uint       `json:"cycle_id" gorm:"index"`
+	DepartmentID *uint      `json:"department_id,omitempty" gorm:"index"` // 校准的部门，为空表示整个考核周期
+	Description  string     `json:"description"`
+	Status       string     `json:"status" gorm:"default:open"` // open, locked
+	CreatedBy    uint       `json:"created_by"`
+	LockedBy     *uint      `json:"locked_by,omitempty"`
+	LockedAt     *time.Time `json:"locked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// 关联关系
+	Cycle      *ReviewCycle `json:"cycle,omitempty" gorm:"foreignKey:CycleID"`
+	Department *Department  `json:"department,omitempty" gorm:"foreignKey:DepartmentID"`
+	Creator    *Employee    `json:"creator,omitempty" gorm:"foreignKey:CreatedBy"`
+}
+
+// 校准调整记录模型：保留调整前的总分和等级
+type CalibrationAdjustment struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	SessionID     uint      `json:"session_id" gorm:"index"`
+	EvaluationID  uint      `json:"evaluation_id" gorm:"index"`
+	PreviousScore float64   `json:"previous_score"` // 调整前的总分
+	PreviousGrade string    `json:"previous_grade"` // 调整前的等级
+	NewScore      float64   `json:"new_score"`
+	NewGrade      string    `json:"new_grade"`
+	Justification string    `json:"justification" gorm:"type:text"` // 调整理由
+	AdjustedBy    uint      `json:"adjusted_by"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// 关联关系
+	Evaluation *KPIEvaluation `json:"evaluation,omitempty" gorm:"foreignKey:EvaluationID"`
+	Adjuster   *Employee      `json:"adjuster,omitempty" gorm:"foreignKey:AdjustedBy"`
+}
+
+// 评估截止提醒记录模型（用于避免重复提醒）
+type EvaluationReminder struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	EvaluationID uint      `json:"evaluation_id" gorm:"index"`
+	Stage        string    `json:"stage"`        // self, manager, hr, confirm
+	Kind         string    `json:"kind"`         // reminder（到期前提醒）, escalation（逾期升级）
+	OffsetHours  int       `json:"offset_hours"` // 提醒：到期前小时数；升级：逾期后小时数
+	DueAt        time.Time `json:"due_at"`       // 发送时的截止时间，截止时间变更后会重新提醒
+	RecipientIDs string    `json:"recipient_ids"`
+	SentAt       time.Time `json:"sent_at"`
 }
 
 // KPI具体得分模型
 type KPIScore struct {
-	ID             uint      `json:"id" gorm:"primaryKey"`
-	EvaluationID   uint      `json:"evaluation_id"`
-	ItemID         uint      `json:"item_id"`
-	SelfScore      *float64  `json:"self_score,omitempty"`              // 自评分数
-	SelfComment    string    `json:"self_comment"`                      // 自评评价
-	ManagerScore   *float64  `json:"manager_score,omitempty"`           // 上级评分
-	ManagerComment string    `json:"manager_comment"`                   // 上级评价
-	ManagerAuto    bool      `json:"manager_auto" gorm:"default:false"` // 是否自动填入上级评分
-	HRScore        *float64  `json:"hr_score,omitempty"`                // HR评分
-	HRComment      string    `json:"hr_comment"`                        // HR评价
-	FinalScore     *float64  `json:"final_score,omitempty"`             // 最终得分
-	FinalComment   string    `json:"final_comment"`                     // 最终得分说明
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	EvaluationID    uint      `json:"evaluation_id"`
+	ItemID          uint      `json:"item_id"`
+	SelfScore       *float64  `json:"self_score,omitempty"`                     // 自评分数
+	SelfComment     string    `json:"self_comment"`                             // 自评评价
+	ManagerScore    *float64  `json:"manager_score,omitempty"`                  // 上级评分
+	ManagerComment  string    `json:"manager_comment"`                          // 上级评价
+	ManagerAuto     bool      `json:"manager_auto" gorm:"default:false"`        // 是否自动填入上级评分
+	ManagerScorerID *uint     `json:"manager_scorer_id,omitempty" gorm:"index"` // 填写上级评分的评分人，自动填入或评审链汇总时为空
+	HRScore         *float64  `json:"hr_score,omitempty"`                       // HR评分
+	HRComment       string    `json:"hr_comment"`                               // HR评价
+	FinalScore      *float64  `json:"final_score,omitempty"`                    // 最终得分
+	FinalComment    string    `json:"final_comment"`                            // 最终得分说明
+	ActualValue     *float64  `json:"actual_value,omitempty"`                   // 量化指标的实际值
+	ActualComment   string    `json:"actual_comment"`                           // 实际值说明
+	ComputedScore   *float64  `json:"computed_score,omitempty"`                 // 根据实际值计算的得分
+	AdjustedScore   *float64  `json:"adjusted_score,omitempty"`                 // 申诉调整后的得分，设置后直接作为最终得分
+	OverrideScore   *float64  `json:"override_score,omitempty"`                 // HR指定的最终得分，设置后代替按评分策略计算的得分
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 
 	// 关联关系
 	Evaluation KPIEvaluation `json:"evaluation,omitempty" gorm:"foreignKey:EvaluationID"`
 	Item       KPIItem       `json:"item,omitempty" gorm:"foreignKey:ItemID"`
 }
 
+// 最终得分计算明细模型
+type ScoreBreakdown struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	EvaluationID uint      `json:"evaluation_id" gorm:"index"`
+	ScoreID      uint      `json:"score_id"`
+	ItemID       uint      `json:"item_id"`
+	Role         string    `json:"role"`            // self, manager, peer, hr, metric（量化指标）, appeal（申诉调整）
+	Score        *float64  `json:"score,omitempty"` // 该角色的评分（邀请评分为聚合后的分数），为空表示缺失
+	SampleCount  int       `json:"sample_count"`    // 参与计算的评分数量
+	Weight       float64   `json:"weight"`          // 实际生效的权重（百分比）
+	Contribution float64   `json:"contribution"`    // 对该项最终得分的贡献
+	CreatedAt    time.Time `json:"created_at"`
+}
+
 // 评论模型
 type EvaluationComment struct {
 	ID           uint      `json:"id" gorm:"primaryKey"`
@@ -116,6 +698,7 @@ type EvaluationComment struct {
 	UserID       uint      `json:"user_id"` // 评论者ID
 	Content      string    `json:"content" gorm:"not null"`
 	IsPrivate    bool      `json:"is_private" gorm:"default:false"` // 是否仅自己可见
+	IsSystem     bool      `json:"is_system" gorm:"default:false"`  // 是否系统记录（如退回原因），不可编辑和删除
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 
@@ -166,3 +749,45 @@ type InvitedScore struct {
 	Invitation EvaluationInvitation `json:"invitation,omitempty" gorm:"foreignKey:InvitationID"`
 	Item       KPIItem              `json:"item,omitempty" gorm:"foreignKey:ItemID"`
 }
+
+// 登录会话模型，保存刷新token，用于续期、退出登录和强制下线
+// 刷新token只保存SHA-256摘要，每次续期后更换
+type UserSession struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	EmployeeID        uint       `json:"employee_id" gorm:"index"`
+	RefreshTokenHash  string     `json:"-" gorm:"size:64;uniqueIndex"` // 当前刷新token的摘要
+	PreviousTokenHash string     `json:"-" gorm:"size:64;index"`       // 上一个刷新token的摘要，再次使用视为token泄露
+	UserAgent         string     `json:"user_agent"`
+	IP                string     `json:"ip"`
+	LastUsedAt        time.Time  `json:"last_used_at"` // 最近一次登录或续期的时间
+	ExpiresAt         time.Time  `json:"expires_at"`   // 刷新token过期时间，每次续期后顺延
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	RevokeReason      string     `json:"revoke_reason,omitempty"` // logout, logout_all, revoked, role_changed, deactivated, password_reset, token_reuse
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// 审计日志模型（只追加，不允许修改和删除）
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ActorID    uint      `json:"actor_id" gorm:"index"` // 操作人ID，系统任务为0
+	ActorName  string    `json:"actor_name"`
+	Action     string    `json:"action" gorm:"index"`                       // create, update, delete
+	EntityType string    `json:"entity_type" gorm:"index:idx_audit_entity"` // 操作对象类型，如 score, evaluation, employee
+	EntityID   uint      `json:"entity_id" gorm:"index:idx_audit_entity"`
+	Field      string    `json:"field"`                      // 变更字段，创建和删除时为空
+	OldValue   string    `json:"old_value" gorm:"type:text"` // 创建时为空，删除时为删除前的完整记录
+	NewValue   string    `json:"new_value" gorm:"type:text"` // 删除时为空，创建时为创建后的完整记录
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// 审计日志不允许修改
+func (AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+// 审计日志不允许删除
+func (AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditLogImmutable
+}
@@ -0,0 +1,655 @@
+// Package schemav1 是初始迁移（版本1）创建的数据表结构
+// 定义冻结为初始迁移发布时的模型，只用于建表和回滚，之后模型的修改需要通过新的迁移完成，不能修改这里的定义
+package schemav1
+
+import "time"
+
+// 初始迁移创建的数据表，按依赖顺序排列
+var Models = []interface{}{
+	&Department{},
+	&Employee{},
+	&KPITemplate{},
+	&KPIItem{},
+	&KPITemplateVersion{},
+	&ScoringPolicy{},
+	&RatingScale{},
+	&RatingGrade{},
+	&RatingAnchor{},
+	&ReviewCycle{},
+	&ReviewCycleTemplateRule{},
+	&TemplateAssignmentRule{},
+	&EvaluationSchedule{},
+	&ScheduleRun{},
+	&KPIEvaluation{},
+	&KPIScore{},
+	&ScoreBreakdown{},
+	&EvaluationReminder{},
+	&EvaluationAppeal{},
+	&AppealItem{},
+	&ReviewerChain{},
+	&ReviewerChainStep{},
+	&EvaluationReviewer{},
+	&ReviewerScore{},
+	&EmployeeGoal{},
+	&GoalKeyResult{},
+	&GoalProgressUpdate{},
+	&ScoreNormalization{},
+	&GradeQuota{},
+	&CalibrationSession{},
+	&CalibrationAdjustment{},
+	&EvaluationComment{},
+	&EvaluationInvitation{},
+	&InvitedScore{},
+	&SystemSetting{},
+	&AuditLog{},
+}
+
+// 量化指标得分分段：达成率不低于 Min 时得分为满分的 Score%
+type ScoreBand struct {
+	Min   float64
+	Score float64
+}
+
+// 量化指标得分分段列表
+type ScoreBands []ScoreBand
+
+// 部门模型
+type Department struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"not null"`
+	Description string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	Employees []Employee `gorm:"foreignKey:DepartmentID"`
+}
+
+// 员工模型
+type Employee struct {
+	ID            uint   `gorm:"primaryKey"`
+	Name          string `gorm:"not null"`
+	Email         string `gorm:"unique;not null"`
+	DooTaskUserID *uint
+	Password      string `gorm:"not null"`
+	Position      string
+	DepartmentID  uint
+	ManagerID     *uint
+	Role          string `gorm:"default:employee"`
+	IsActive      bool   `gorm:"default:true"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	Department   Department `gorm:"foreignKey:DepartmentID"`
+	Manager      *Employee  `gorm:"foreignKey:ManagerID"`
+	Subordinates []Employee `gorm:"foreignKey:ManagerID"`
+}
+
+// KPI模板模型
+type KPITemplate struct {
+	ID            uint   `gorm:"primaryKey"`
+	Name          string `gorm:"not null"`
+	Description   string
+	Period        string
+	IsActive      bool `gorm:"default:true"`
+	Version       int  `gorm:"default:1"`
+	RatingScaleID *uint
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	Items         []KPIItem      `gorm:"foreignKey:TemplateID"`
+	ScoringPolicy *ScoringPolicy `gorm:"foreignKey:TemplateID"`
+	RatingScale   *RatingScale   `gorm:"foreignKey:RatingScaleID"`
+}
+
+// KPI考核项目模型
+type KPIItem struct {
+	ID          uint `gorm:"primaryKey"`
+	TemplateID  uint
+	Name        string `gorm:"not null"`
+	Description string
+	MaxScore    float64
+	Order       int
+	Type        string `gorm:"default:rating"`
+	Version     int    `gorm:"default:1;index"`
+	OriginID    uint   `gorm:"index"`
+
+	ParentID *uint `gorm:"index"`
+	Weight   float64
+
+	Unit         string
+	Target       *float64
+	Threshold    *float64
+	Stretch      *float64
+	ScoringCurve string
+	ScoreBands   ScoreBands `gorm:"serializer:json"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Template KPITemplate `gorm:"foreignKey:TemplateID"`
+}
+
+// KPI模板版本模型（记录每个版本的模板信息，考核项目通过 KPIItem.Version 关联）
+type KPITemplateVersion struct {
+	ID          uint `gorm:"primaryKey"`
+	TemplateID  uint `gorm:"uniqueIndex:idx_template_version"`
+	Version     int  `gorm:"uniqueIndex:idx_template_version"`
+	Name        string
+	Description string
+	Period      string
+	Note        string
+	CreatedBy   uint
+	CreatedAt   time.Time
+}
+
+// 评分策略模型（每个模板一条，未配置时按 HR > 上级 > 自评 的优先级取分）
+type ScoringPolicy struct {
+	ID              uint `gorm:"primaryKey"`
+	TemplateID      uint `gorm:"uniqueIndex"`
+	SelfWeight      float64
+	ManagerWeight   float64
+	PeerWeight      float64
+	HRWeight        float64
+	GoalWeight      float64
+	PeerAggregation string `gorm:"default:mean"`
+	MissingStrategy string `gorm:"default:redistribute"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// 评级量表模型：将评估总分映射为等级（如 S/A/B/C/D）
+type RatingScale struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"not null"`
+	Description string
+	IsDefault   bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	Grades  []RatingGrade  `gorm:"foreignKey:ScaleID"`
+	Anchors []RatingAnchor `gorm:"foreignKey:ScaleID"`
+}
+
+// 评级等级：总分不低于下限且低于更高等级的下限时评为该等级
+type RatingGrade struct {
+	ID          uint   `gorm:"primaryKey"`
+	ScaleID     uint   `gorm:"index"`
+	Label       string `gorm:"not null"`
+	MinScore    float64
+	Color       string
+	Description string
+}
+
+// 评分锚点：考核项目得分率达到某一水平时的表现描述，评分时作为评分标准展示
+type RatingAnchor struct {
+	ID          uint   `gorm:"primaryKey"`
+	ScaleID     uint   `gorm:"index"`
+	Label       string `gorm:"not null"`
+	MinPercent  float64
+	Description string
+}
+
+// 考核周期模型
+type ReviewCycle struct {
+	ID                uint   `gorm:"primaryKey"`
+	Name              string `gorm:"not null"`
+	Period            string
+	Year              int
+	Month             *int
+	Quarter           *int
+	StartDate         *time.Time
+	EndDate           *time.Time
+	DefaultTemplateID *uint
+	Status            string `gorm:"default:draft"`
+	LaunchedAt        *time.Time
+	SelfDueAt         *time.Time
+	ManagerDueAt      *time.Time
+	HRDueAt           *time.Time
+	ConfirmDueAt      *time.Time
+	CreatedBy         uint
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+
+	DefaultTemplate *KPITemplate              `gorm:"foreignKey:DefaultTemplateID"`
+	Departments     []Department              `gorm:"many2many:review_cycle_departments"`
+	TemplateRules   []ReviewCycleTemplateRule `gorm:"foreignKey:CycleID"`
+}
+
+// 考核周期模板分配规则模型
+type ReviewCycleTemplateRule struct {
+	ID           uint `gorm:"primaryKey"`
+	CycleID      uint `gorm:"index"`
+	DepartmentID *uint
+	Position     string
+	Role         string
+	TemplateID   uint
+	Priority     int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Template KPITemplate `gorm:"foreignKey:TemplateID"`
+}
+
+// 模板分配规则模型：按部门、职位、角色为员工确定各考核周期类型的默认模板
+type TemplateAssignmentRule struct {
+	ID            uint `gorm:"primaryKey"`
+	Name          string
+	Period        string `gorm:"index"`
+	DepartmentID  *uint
+	Position      string
+	PositionMatch string `gorm:"default:exact"`
+	Role          string
+	TemplateID    uint
+	Priority      int
+	IsActive      bool `gorm:"default:true"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	Department *Department `gorm:"foreignKey:DepartmentID"`
+	Template   KPITemplate `gorm:"foreignKey:TemplateID"`
+}
+
+// 定时发起计划模型（每个模板一条，按模板周期自动发起评估）
+type EvaluationSchedule struct {
+	ID         uint `gorm:"primaryKey"`
+	TemplateID uint `gorm:"uniqueIndex"`
+	Enabled    bool
+	RunDay     int `gorm:"default:1"`
+	StartDate  time.Time
+	LastRunAt  *time.Time
+	CreatedBy  uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+
+	Template    KPITemplate  `gorm:"foreignKey:TemplateID"`
+	Departments []Department `gorm:"many2many:evaluation_schedule_departments"`
+}
+
+// 定时发起运行记录模型
+type ScheduleRun struct {
+	ID           uint `gorm:"primaryKey"`
+	ScheduleID   uint `gorm:"index"`
+	TemplateID   uint
+	Period       string
+	Year         int
+	Month        *int
+	Quarter      *int
+	Trigger      string
+	Status       string
+	CycleID      *uint
+	CreatedCount int
+	SkippedCount int
+	Message      string `gorm:"type:text"`
+	StartedAt    time.Time
+	FinishedAt   time.Time
+
+	Template KPITemplate `gorm:"foreignKey:TemplateID"`
+}
+
+// KPI评估记录模型
+type KPIEvaluation struct {
+	ID              uint `gorm:"primaryKey"`
+	EmployeeID      uint
+	TemplateID      uint
+	Period          string
+	Year            int
+	Month           *int
+	Quarter         *int
+	CycleID         *uint  `gorm:"index"`
+	TemplateVersion int    `gorm:"default:1"`
+	Status          string `gorm:"default:pending"`
+	TotalScore      float64
+	Grade           string `gorm:"index"`
+	RatingScaleID   *uint
+	CalibrationID   *uint `gorm:"index"`
+	NormalizedScore *float64
+	NormalizationID *uint
+	GoalScore       *float64
+	FinalComment    string
+	SelfDueAt       *time.Time
+	ManagerDueAt    *time.Time
+	HRDueAt         *time.Time
+	ConfirmDueAt    *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+
+	Employee   Employee           `gorm:"foreignKey:EmployeeID"`
+	Template   KPITemplate        `gorm:"foreignKey:TemplateID"`
+	Cycle      *ReviewCycle       `gorm:"foreignKey:CycleID"`
+	Scores     []KPIScore         `gorm:"foreignKey:EvaluationID"`
+	Breakdowns []ScoreBreakdown   `gorm:"foreignKey:EvaluationID"`
+	Appeals    []EvaluationAppeal `gorm:"foreignKey:EvaluationID"`
+}
+
+// KPI具体得分模型
+type KPIScore struct {
+	ID             uint `gorm:"primaryKey"`
+	EvaluationID   uint
+	ItemID         uint
+	SelfScore      *float64
+	SelfComment    string
+	ManagerScore   *float64
+	ManagerComment string
+	ManagerAuto    bool `gorm:"default:false"`
+	HRScore        *float64
+	HRComment      string
+	FinalScore     *float64
+	FinalComment   string
+	ActualValue    *float64
+	ActualComment  string
+	ComputedScore  *float64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	Evaluation KPIEvaluation `gorm:"foreignKey:EvaluationID"`
+	Item       KPIItem       `gorm:"foreignKey:ItemID"`
+}
+
+// 最终得分计算明细模型
+type ScoreBreakdown struct {
+	ID           uint `gorm:"primaryKey"`
+	EvaluationID uint `gorm:"index"`
+	ScoreID      uint
+	ItemID       uint
+	Role         string
+	Score        *float64
+	SampleCount  int
+	Weight       float64
+	Contribution float64
+	CreatedAt    time.Time
+}
+
+// 评估截止提醒记录模型（用于避免重复提醒）
+type EvaluationReminder struct {
+	ID           uint `gorm:"primaryKey"`
+	EvaluationID uint `gorm:"index"`
+	Stage        string
+	Kind         string
+	OffsetHours  int
+	DueAt        time.Time
+	RecipientIDs string
+	SentAt       time.Time
+}
+
+// 评估申诉模型
+type EvaluationAppeal struct {
+	ID           uint `gorm:"primaryKey"`
+	EvaluationID uint `gorm:"index"`
+	EmployeeID   uint
+	Reason       string `gorm:"type:text"`
+	Status       string `gorm:"default:pending"`
+	Response     string `gorm:"type:text"`
+	ResolverID   *uint
+	ResolvedAt   *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Employee Employee     `gorm:"foreignKey:EmployeeID"`
+	Resolver *Employee    `gorm:"foreignKey:ResolverID"`
+	Items    []AppealItem `gorm:"foreignKey:AppealID"`
+}
+
+// 申诉项目模型（申诉的具体考核项目）
+type AppealItem struct {
+	ID            uint `gorm:"primaryKey"`
+	AppealID      uint `gorm:"index"`
+	ScoreID       uint
+	ItemID        uint
+	Reason        string `gorm:"type:text"`
+	OriginalScore *float64
+	Decision      string `gorm:"default:pending"`
+	AdjustedScore *float64
+
+	Item KPIItem `gorm:"foreignKey:ItemID"`
+}
+
+// 评审链模型：为员工或模板配置多位评审人，替代单一直属上级的上级评分
+// 员工的评审链优先于模板的评审链，都没有配置时由直属上级评分
+type ReviewerChain struct {
+	ID            uint   `gorm:"primaryKey"`
+	Name          string `gorm:"not null"`
+	EmployeeID    *uint  `gorm:"uniqueIndex"`
+	TemplateID    *uint  `gorm:"uniqueIndex"`
+	FinalApproval bool
+	Description   string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	Steps    []ReviewerChainStep `gorm:"foreignKey:ChainID"`
+	Employee *Employee           `gorm:"foreignKey:EmployeeID"`
+	Template *KPITemplate        `gorm:"foreignKey:TemplateID"`
+}
+
+// 评审链步骤模型：同一阶段的评审人并行评分，各阶段按顺序进行
+type ReviewerChainStep struct {
+	ID           uint `gorm:"primaryKey"`
+	ChainID      uint `gorm:"index"`
+	Stage        int
+	ReviewerType string
+	ReviewerID   *uint
+	Weight       float64
+	Label        string
+
+	Reviewer *Employee `gorm:"foreignKey:ReviewerID"`
+}
+
+// 评估评审人模型：评估进入上级评分阶段时根据评审链生成
+type EvaluationReviewer struct {
+	ID           uint `gorm:"primaryKey"`
+	EvaluationID uint `gorm:"index"`
+	ReviewerID   uint `gorm:"index"`
+	Role         string
+	Stage        int
+	Label        string
+	Weight       float64
+	Status       string `gorm:"default:pending"`
+	Comment      string `gorm:"type:text"`
+	SubmittedAt  *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Reviewer Employee        `gorm:"foreignKey:ReviewerID"`
+	Scores   []ReviewerScore `gorm:"foreignKey:ReviewerRecordID"`
+}
+
+// 评审人评分模型：每位评审人对每个考核项目的评分
+type ReviewerScore struct {
+	ID               uint `gorm:"primaryKey"`
+	ReviewerRecordID uint `gorm:"index"`
+	EvaluationID     uint `gorm:"index"`
+	ScoreID          uint
+	ItemID           uint
+	Score            *float64
+	Comment          string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// 个人目标模型（OKR）：员工在考核周期开始时与上级约定的目标，经上级审批后在评估时评分
+type EmployeeGoal struct {
+	ID             uint   `gorm:"primaryKey"`
+	EmployeeID     uint   `gorm:"index"`
+	CycleID        *uint  `gorm:"index"`
+	EvaluationID   *uint  `gorm:"index"`
+	Objective      string `gorm:"not null"`
+	Description    string `gorm:"type:text"`
+	Weight         float64
+	Progress       float64
+	Status         string `gorm:"default:draft"`
+	ApproverID     *uint
+	ApprovedAt     *time.Time
+	RejectReason   string `gorm:"type:text"`
+	SelfScore      *float64
+	SelfComment    string
+	ManagerScore   *float64
+	ManagerComment string
+	FinalScore     *float64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	Employee   Employee             `gorm:"foreignKey:EmployeeID"`
+	Approver   *Employee            `gorm:"foreignKey:ApproverID"`
+	KeyResults []GoalKeyResult      `gorm:"foreignKey:GoalID"`
+	Updates    []GoalProgressUpdate `gorm:"foreignKey:GoalID"`
+}
+
+// 目标关键结果模型
+type GoalKeyResult struct {
+	ID        uint   `gorm:"primaryKey"`
+	GoalID    uint   `gorm:"index"`
+	Title     string `gorm:"not null"`
+	Target    string
+	Progress  float64
+	Order     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// 目标进度更新记录模型
+type GoalProgressUpdate struct {
+	ID          uint `gorm:"primaryKey"`
+	GoalID      uint `gorm:"index"`
+	KeyResultID *uint
+	Progress    float64
+	Note        string `gorm:"type:text"`
+	UpdatedBy   uint
+	CreatedAt   time.Time
+
+	Updater Employee `gorm:"foreignKey:UpdatedBy"`
+}
+
+// 分数标准化记录模型：按考核周期应用的评分人宽严差异校正
+type ScoreNormalization struct {
+	ID              uint `gorm:"primaryKey"`
+	CycleID         uint `gorm:"index"`
+	Method          string
+	GroupBy         string
+	EvaluationCount int
+	AppliedBy       uint
+	AppliedAt       time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+
+	Applier *Employee `gorm:"foreignKey:AppliedBy"`
+}
+
+// 等级配额模型：限制部门内某些等级的人数占比（强制分布）
+type GradeQuota struct {
+	ID           uint     `gorm:"primaryKey"`
+	DepartmentID *uint    `gorm:"index"`
+	Grades       []string `gorm:"serializer:json"`
+	MaxPercent   *float64
+	MinPercent   *float64
+	Description  string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Department *Department `gorm:"foreignKey:DepartmentID"`
+}
+
+// 校准会议模型：HR和部门主管对照等级配额集中调整考核周期内的评估结果
+type CalibrationSession struct {
+	ID           uint   `gorm:"primaryKey"`
+	Name         string `gorm:"not null"`
+	CycleID      uint   `gorm:"index"`
+	DepartmentID *uint  `gorm:"index"`
+	Description  string
+	Status       string `gorm:"default:open"`
+	CreatedBy    uint
+	LockedBy     *uint
+	LockedAt     *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Cycle      *ReviewCycle `gorm:"foreignKey:CycleID"`
+	Department *Department  `gorm:"foreignKey:DepartmentID"`
+	Creator    *Employee    `gorm:"foreignKey:CreatedBy"`
+}
+
+// 校准调整记录模型：保留调整前的总分和等级
+type CalibrationAdjustment struct {
+	ID            uint `gorm:"primaryKey"`
+	SessionID     uint `gorm:"index"`
+	EvaluationID  uint `gorm:"index"`
+	PreviousScore float64
+	PreviousGrade string
+	NewScore      float64
+	NewGrade      string
+	Justification string `gorm:"type:text"`
+	AdjustedBy    uint
+	CreatedAt     time.Time
+
+	Evaluation *KPIEvaluation `gorm:"foreignKey:EvaluationID"`
+	Adjuster   *Employee      `gorm:"foreignKey:AdjustedBy"`
+}
+
+// 评论模型
+type EvaluationComment struct {
+	ID           uint `gorm:"primaryKey"`
+	EvaluationID uint
+	UserID       uint
+	Content      string `gorm:"not null"`
+	IsPrivate    bool   `gorm:"default:false"`
+	IsSystem     bool   `gorm:"default:false"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Evaluation KPIEvaluation `gorm:"foreignKey:EvaluationID"`
+	User       Employee      `gorm:"foreignKey:UserID"`
+}
+
+// 评估邀请模型
+type EvaluationInvitation struct {
+	ID           uint `gorm:"primaryKey"`
+	EvaluationID uint
+	InviterID    uint
+	InviteeID    uint
+	Status       string `gorm:"default:pending"`
+	Message      string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Evaluation KPIEvaluation  `gorm:"foreignKey:EvaluationID"`
+	Inviter    Employee       `gorm:"foreignKey:InviterID"`
+	Invitee    Employee       `gorm:"foreignKey:InviteeID"`
+	Scores     []InvitedScore `gorm:"foreignKey:InvitationID"`
+}
+
+// 邀请评分模型
+type InvitedScore struct {
+	ID           uint `gorm:"primaryKey"`
+	InvitationID uint
+	ItemID       uint
+	Score        *float64
+	Comment      string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+
+	Invitation EvaluationInvitation `gorm:"foreignKey:InvitationID"`
+	Item       KPIItem              `gorm:"foreignKey:ItemID"`
+}
+
+// 系统设置模型
+type SystemSetting struct {
+	ID        uint   `gorm:"primaryKey"`
+	Key       string `gorm:"unique;not null"`
+	Value     string `gorm:"not null"`
+	Type      string `gorm:"default:string"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// 审计日志模型（只追加，不允许修改和删除）
+type AuditLog struct {
+	ID         uint `gorm:"primaryKey"`
+	ActorID    uint `gorm:"index"`
+	ActorName  string
+	Action     string `gorm:"index"`
+	EntityType string `gorm:"index:idx_audit_entity"`
+	EntityID   uint   `gorm:"index:idx_audit_entity"`
+	Field      string
+	OldValue   string `gorm:"type:text"`
+	NewValue   string `gorm:"type:text"`
+	IP         string
+	CreatedAt  time.Time `gorm:"index"`
+}
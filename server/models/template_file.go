@@ -0,0 +1,264 @@
+package models
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 模板文件格式版本，格式说明见 library/README.md
+const TemplateFileSchema = "kpi-template/v1"
+
+// 模板文件格式
+const (
+	TemplateFileFormatJSON = "json"
+	TemplateFileFormatYAML = "yaml"
+)
+
+// 内置模板库
+//
+//go:embed library/*.yaml
+var templateLibrary embed.FS
+
+// 模板文件
+type TemplateFile struct {
+	Schema    string             `json:"schema" yaml:"schema"`
+	Templates []TemplateDocument `json:"templates" yaml:"templates"`
+}
+
+// 模板文件中的模板
+type TemplateDocument struct {
+	Name          string                 `json:"name" yaml:"name"`
+	Description   string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Period        string                 `json:"period" yaml:"period"`
+	IsActive      *bool                  `json:"is_active,omitempty" yaml:"is_active,omitempty"`
+	ScoringPolicy *ScoringPolicyDocument `json:"scoring_policy,omitempty" yaml:"scoring_policy,omitempty"`
+	Items         []TemplateItemDocument `json:"items" yaml:"items"`
+}
+
+// 模板文件中的评分策略
+type ScoringPolicyDocument struct {
+	SelfWeight      float64 `json:"self_weight" yaml:"self_weight"`
+	ManagerWeight   float64 `json:"manager_weight" yaml:"manager_weight"`
+	PeerWeight      float64 `json:"peer_weight" yaml:"peer_weight"`
+	HRWeight        float64 `json:"hr_weight" yaml:"hr_weight"`
+	GoalWeight      float64 `json:"goal_weight,omitempty" yaml:"goal_weight,omitempty"`
+	PeerAggregation string  `json:"peer_aggregation,omitempty" yaml:"peer_aggregation,omitempty"`
+	MissingStrategy string  `json:"missing_strategy,omitempty" yaml:"missing_strategy,omitempty"`
+}
+
+// 模板文件中的考核项目
+type TemplateItemDocument struct {
+	Name        string  `json:"name" yaml:"name"`
+	Description string  `json:"description,omitempty" yaml:"description,omitempty"`
+	MaxScore    float64 `json:"max_score,omitempty" yaml:"max_score,omitempty"`
+	Order       int     `json:"order,omitempty" yaml:"order,omitempty"`
+
+	// 量化指标配置
+	Type         string      `json:"type,omitempty" yaml:"type,omitempty"`
+	Unit         string      `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Target       *float64    `json:"target,omitempty" yaml:"target,omitempty"`
+	Threshold    *float64    `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	Stretch      *float64    `json:"stretch,omitempty" yaml:"stretch,omitempty"`
+	ScoringCurve string      `json:"scoring_curve,omitempty" yaml:"scoring_curve,omitempty"`
+	ScoreBands   []ScoreBand `json:"score_bands,omitempty" yaml:"score_bands,omitempty"`
+	CapPercent   *float64    `json:"cap_percent,omitempty" yaml:"cap_percent,omitempty"`
+
+	// 分类配置：包含子项目的项目为分类
+	Weight float64                `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Items  []TemplateItemDocument `json:"items,omitempty" yaml:"items,omitempty"`
+}
+
+// 解析模板文件，不允许出现未知字段
+func ParseTemplateFile(data []byte, format string) (*TemplateFile, error) {
+	var file TemplateFile
+	switch format {
+	case TemplateFileFormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("JSON格式错误: %w", err)
+		}
+	case TemplateFileFormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("YAML格式错误: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的文件格式：%s", format)
+	}
+	return &file, nil
+}
+
+// 识别模板文件格式：优先使用文件扩展名，其次根据内容判断
+func DetectTemplateFileFormat(fileName string, data []byte) string {
+	switch {
+	case strings.HasSuffix(fileName, ".json"):
+		return TemplateFileFormatJSON
+	case strings.HasSuffix(fileName, ".yaml"), strings.HasSuffix(fileName, ".yml"):
+		return TemplateFileFormatYAML
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return TemplateFileFormatJSON
+	}
+	return TemplateFileFormatYAML
+}
+
+// 序列化模板文件
+func (f *TemplateFile) Marshal(format string) ([]byte, error) {
+	switch format {
+	case TemplateFileFormatJSON:
+		return json.MarshalIndent(f, "", "  ")
+	case TemplateFileFormatYAML:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(f); err != nil {
+			return nil, err
+		}
+		encoder.Close()
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("不支持的文件格式：%s", format)
+	}
+}
+
+// 根据模板及其考核项目、评分策略生成模板文件中的模板
+func NewTemplateDocument(template *KPITemplate) TemplateDocument {
+	isActive := template.IsActive
+	document := TemplateDocument{
+		Name:        template.Name,
+		Description: template.Description,
+		Period:      template.Period,
+		IsActive:    &isActive,
+		Items:       make([]TemplateItemDocument, 0, len(template.Items)),
+	}
+	if policy := template.ScoringPolicy; policy != nil {
+		document.ScoringPolicy = &ScoringPolicyDocument{
+			SelfWeight:      policy.SelfWeight,
+			ManagerWeight:   policy.ManagerWeight,
+			PeerWeight:      policy.PeerWeight,
+			HRWeight:        policy.HRWeight,
+			GoalWeight:      policy.GoalWeight,
+			PeerAggregation: policy.PeerAggregation,
+			MissingStrategy: policy.MissingStrategy,
+		}
+	}
+	for _, item := range BuildItemTree(template.Items) {
+		document.Items = append(document.Items, newTemplateItemDocument(&item))
+	}
+	return document
+}
+
+// 根据考核项目（包含分类的子项目）生成模板文件中的考核项目
+func newTemplateItemDocument(item *KPIItem) TemplateItemDocument {
+	document := TemplateItemDocument{
+		Name:        item.Name,
+		Description: item.Description,
+		MaxScore:    item.MaxScore,
+		Order:       item.Order,
+	}
+	switch item.Type {
+	case "quantitative":
+		document.Type = item.Type
+		document.Unit = item.Unit
+		document.Target = item.Target
+		document.Threshold = item.Threshold
+		document.Stretch = item.Stretch
+		document.ScoringCurve = item.ScoringCurve
+		document.ScoreBands = item.ScoreBands
+		document.CapPercent = item.CapPercent
+	case "category":
+		document.Type = item.Type
+		document.MaxScore = 0
+		document.Weight = item.Weight
+		for _, child := range item.Children {
+			document.Items = append(document.Items, newTemplateItemDocument(&child))
+		}
+	}
+	return document
+}
+
+// 生成模板（包含考核项目和评分策略），用于创建新模板
+func (d *TemplateDocument) ToTemplate() KPITemplate {
+	template := KPITemplate{
+		Name:        d.Name,
+		Description: d.Description,
+		Period:      d.Period,
+		IsActive:    d.IsActive == nil || *d.IsActive,
+		Version:     1,
+	}
+	if policy := d.ScoringPolicy; policy != nil {
+		template.ScoringPolicy = &ScoringPolicy{
+			SelfWeight:      policy.SelfWeight,
+			ManagerWeight:   policy.ManagerWeight,
+			PeerWeight:      policy.PeerWeight,
+			HRWeight:        policy.HRWeight,
+			GoalWeight:      policy.GoalWeight,
+			PeerAggregation: policy.PeerAggregation,
+			MissingStrategy: policy.MissingStrategy,
+		}
+	}
+	for _, item := range d.Items {
+		template.Items = append(template.Items, item.ToItem())
+	}
+	return template
+}
+
+// 生成考核项目，分类的子项目放在 Children 中
+func (d *TemplateItemDocument) ToItem() KPIItem {
+	item := KPIItem{
+		Name:         d.Name,
+		Description:  d.Description,
+		MaxScore:     d.MaxScore,
+		Order:        d.Order,
+		Type:         d.Type,
+		Unit:         d.Unit,
+		Target:       d.Target,
+		Threshold:    d.Threshold,
+		Stretch:      d.Stretch,
+		ScoringCurve: d.ScoringCurve,
+		ScoreBands:   d.ScoreBands,
+		CapPercent:   d.CapPercent,
+		Weight:       d.Weight,
+		Version:      1,
+	}
+	// 包含子项目且未指定类型时为分类
+	if item.Type == "" && len(d.Items) > 0 {
+		item.Type = "category"
+	}
+	for _, child := range d.Items {
+		item.Children = append(item.Children, child.ToItem())
+	}
+	return item
+}
+
+// 加载内置模板库（按文件名排序）
+func LoadTemplateLibrary() ([]TemplateDocument, error) {
+	names, err := fs.Glob(templateLibrary, "library/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var documents []TemplateDocument
+	for _, name := range names {
+		data, err := templateLibrary.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		file, err := ParseTemplateFile(data, TemplateFileFormatYAML)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		documents = append(documents, file.Templates...)
+	}
+	return documents, nil
+}
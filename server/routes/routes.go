@@ -24,7 +24,8 @@ func SetupRoutes(r *gin.RouterGroup) {
 		publicRoutes.POST("/register", handlers.Register)
 		publicRoutes.POST("/login", handlers.Login)
 		publicRoutes.POST("/login-by-dootask-token", handlers.LoginByDooTaskToken)
-		publicRoutes.POST("/refresh", handlers.RefreshToken)
+		publicRoutes.POST("/refresh", handlers.RefreshToken)      // 使用刷新token续期，刷新token同时更换
+		publicRoutes.POST("/logout", handlers.Logout)             // 退出登录，通过刷新token确定会话
 		publicRoutes.GET("/departments", handlers.GetDepartments) // 注册时需要获取部门列表
 	}
 
@@ -55,6 +56,11 @@ func SetupRoutes(r *gin.RouterGroup) {
 		// 当前用户信息
 		protected.GET("/me", handlers.GetCurrentUser)
 
+		// 登录会话管理
+		protected.POST("/auth/logout-all", handlers.LogoutAll)      // 退出所有设备
+		protected.GET("/sessions", handlers.GetMySessions)          // 当前用户的有效会话
+		protected.DELETE("/sessions/:id", handlers.RevokeMySession) // 下线指定会话
+
 		// 部门管理（HR和管理员）
 		departmentRoutes := protected.Group("/departments")
 		{
@@ -74,6 +80,8 @@ func SetupRoutes(r *gin.RouterGroup) {
 			employeeRoutes.PUT("/:id", handlers.RoleMiddleware("hr", "manager"), handlers.UpdateEmployee)
 			employeeRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteEmployee)
 			employeeRoutes.GET("/:id/subordinates", handlers.GetEmployeeSubordinates)
+			employeeRoutes.GET("/:id/sessions", handlers.RoleMiddleware("hr"), handlers.GetEmployeeSessions)       // 员工的有效会话
+			employeeRoutes.DELETE("/:id/sessions", handlers.RoleMiddleware("hr"), handlers.RevokeEmployeeSessions) // 员工在所有设备下线
 		}
 
 		// KPI模板管理（HR和管理员）
@@ -81,10 +89,20 @@ func SetupRoutes(r *gin.RouterGroup) {
 		{
 			templateRoutes.GET("", handlers.GetTemplates)
 			templateRoutes.POST("", handlers.RoleMiddleware("hr", "manager"), handlers.CreateTemplate)
+			templateRoutes.GET("/export", handlers.RoleMiddleware("hr", "manager"), handlers.ExportTemplates) // 导出模板文件
+			templateRoutes.POST("/import", handlers.RoleMiddleware("hr"), handlers.ImportTemplates)           // 导入模板文件
 			templateRoutes.GET("/:id", handlers.GetTemplate)
 			templateRoutes.PUT("/:id", handlers.RoleMiddleware("hr", "manager"), handlers.UpdateTemplate)
 			templateRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteTemplate)
 			templateRoutes.GET("/:id/items", handlers.GetTemplateItems)
+			templateRoutes.POST("/:id/clone", handlers.RoleMiddleware("hr", "manager"), handlers.CloneTemplate)     // 复制模板
+			templateRoutes.GET("/:id/versions", handlers.GetTemplateVersions)                                       // 模板版本列表
+			templateRoutes.GET("/:id/versions/:version", handlers.GetTemplateVersion)                               // 模板版本详情
+			templateRoutes.GET("/:id/diff", handlers.GetTemplateVersionDiff)                                        // 比较模板版本差异
+			templateRoutes.POST("/:id/migrate", handlers.RoleMiddleware("hr"), handlers.MigrateTemplateEvaluations) // 迁移评估到当前版本
+			templateRoutes.GET("/:id/scoring-policy", handlers.GetScoringPolicy)
+			templateRoutes.PUT("/:id/scoring-policy", handlers.RoleMiddleware("hr"), handlers.UpdateScoringPolicy)
+			templateRoutes.DELETE("/:id/scoring-policy", handlers.RoleMiddleware("hr"), handlers.DeleteScoringPolicy)
 		}
 
 		// KPI考核项目管理（HR和管理员）
@@ -96,6 +114,88 @@ func SetupRoutes(r *gin.RouterGroup) {
 			itemRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteItem)
 		}
 
+		// 评级量表管理（HR），评分时所有认证用户可查看评分标准
+		ratingScaleRoutes := protected.Group("/rating-scales")
+		{
+			ratingScaleRoutes.GET("", handlers.GetRatingScales)
+			ratingScaleRoutes.POST("", handlers.RoleMiddleware("hr"), handlers.CreateRatingScale)
+			ratingScaleRoutes.GET("/:id", handlers.GetRatingScale)
+			ratingScaleRoutes.PUT("/:id", handlers.RoleMiddleware("hr"), handlers.UpdateRatingScale)
+			ratingScaleRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteRatingScale)
+		}
+
+		// 等级配额管理（HR）
+		gradeQuotaRoutes := protected.Group("/grade-quotas")
+		{
+			gradeQuotaRoutes.GET("", handlers.RoleMiddleware("hr", "manager"), handlers.GetGradeQuotas)
+			gradeQuotaRoutes.POST("", handlers.RoleMiddleware("hr"), handlers.CreateGradeQuota)
+			gradeQuotaRoutes.PUT("/:id", handlers.RoleMiddleware("hr"), handlers.UpdateGradeQuota)
+			gradeQuotaRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteGradeQuota)
+		}
+
+		// 校准会议（HR和部门主管）
+		calibrationRoutes := protected.Group("/calibrations")
+		calibrationRoutes.Use(handlers.RoleMiddleware("hr", "manager"))
+		{
+			calibrationRoutes.GET("", handlers.GetCalibrationSessions)
+			calibrationRoutes.POST("", handlers.RoleMiddleware("hr"), handlers.CreateCalibrationSession)
+			calibrationRoutes.GET("/:id", handlers.GetCalibrationSession)
+			calibrationRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteCalibrationSession)
+			calibrationRoutes.POST("/:id/adjustments", handlers.AdjustCalibration)                             // 批量调整总分或等级
+			calibrationRoutes.GET("/:id/adjustments", handlers.GetCalibrationAdjustments)                      // 调整记录
+			calibrationRoutes.PUT("/:id/lock", handlers.RoleMiddleware("hr"), handlers.LockCalibrationSession) // 锁定结果
+		}
+
+		// 模板分配规则管理
+		templateRuleRoutes := protected.Group("/template-rules")
+		{
+			templateRuleRoutes.GET("", handlers.RoleMiddleware("hr"), handlers.GetTemplateAssignmentRules)
+			templateRuleRoutes.POST("", handlers.RoleMiddleware("hr"), handlers.CreateTemplateAssignmentRule)
+			templateRuleRoutes.PUT("/:id", handlers.RoleMiddleware("hr"), handlers.UpdateTemplateAssignmentRule)
+			templateRuleRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteTemplateAssignmentRule)
+			templateRuleRoutes.GET("/resolve", handlers.RoleMiddleware("hr", "manager"), handlers.ResolveEmployeeTemplate) // 查看员工会分配到的模板及原因
+		}
+
+		// 评审链管理（HR）
+		reviewerChainRoutes := protected.Group("/reviewer-chains")
+		reviewerChainRoutes.Use(handlers.RoleMiddleware("hr"))
+		{
+			reviewerChainRoutes.GET("", handlers.GetReviewerChains)
+			reviewerChainRoutes.POST("", handlers.CreateReviewerChain)
+			reviewerChainRoutes.GET("/:id", handlers.GetReviewerChain)
+			reviewerChainRoutes.PUT("/:id", handlers.UpdateReviewerChain)
+			reviewerChainRoutes.DELETE("/:id", handlers.DeleteReviewerChain)
+		}
+
+		// 考核周期管理（HR）
+		cycleRoutes := protected.Group("/cycles")
+		{
+			cycleRoutes.GET("", handlers.GetCycles)
+			cycleRoutes.POST("", handlers.RoleMiddleware("hr"), handlers.CreateCycle)
+			cycleRoutes.GET("/:id", handlers.GetCycle)
+			cycleRoutes.PUT("/:id", handlers.RoleMiddleware("hr"), handlers.UpdateCycle)
+			cycleRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteCycle)
+			cycleRoutes.PUT("/:id/open", handlers.RoleMiddleware("hr"), handlers.OpenCycle)                       // 开启周期
+			cycleRoutes.PUT("/:id/launch", handlers.RoleMiddleware("hr"), handlers.LaunchCycle)                   // 批量发起评估
+			cycleRoutes.PUT("/:id/close", handlers.RoleMiddleware("hr"), handlers.CloseCycle)                     // 关闭周期
+			cycleRoutes.PUT("/:id/archive", handlers.RoleMiddleware("hr"), handlers.ArchiveCycle)                 // 归档周期
+			cycleRoutes.GET("/:id/normalization", handlers.RoleMiddleware("hr"), handlers.PreviewNormalization)   // 预览分数标准化
+			cycleRoutes.POST("/:id/normalization", handlers.RoleMiddleware("hr"), handlers.ApplyNormalization)    // 应用分数标准化
+			cycleRoutes.DELETE("/:id/normalization", handlers.RoleMiddleware("hr"), handlers.RevokeNormalization) // 撤销分数标准化
+		}
+
+		// 定时发起计划（HR）
+		scheduleRoutes := protected.Group("/schedules")
+		scheduleRoutes.Use(handlers.RoleMiddleware("hr"))
+		{
+			scheduleRoutes.GET("", handlers.GetSchedules)
+			scheduleRoutes.POST("", handlers.CreateSchedule)
+			scheduleRoutes.GET("/runs", handlers.GetScheduleRuns) // 运行记录
+			scheduleRoutes.PUT("/:id", handlers.UpdateSchedule)
+			scheduleRoutes.DELETE("/:id", handlers.DeleteSchedule)
+			scheduleRoutes.POST("/:id/run", handlers.RunSchedule) // 立即执行
+		}
+
 		// KPI评估管理
 		evaluationRoutes := protected.Group("/evaluations")
 		{
@@ -104,6 +204,9 @@ func SetupRoutes(r *gin.RouterGroup) {
 			evaluationRoutes.GET("/:id", handlers.GetEvaluation)
 			evaluationRoutes.PUT("/:id", handlers.UpdateEvaluation)
 			evaluationRoutes.DELETE("/:id", handlers.RoleMiddleware("hr"), handlers.DeleteEvaluation)
+			evaluationRoutes.PUT("/:id/deadlines", handlers.RoleMiddleware("hr"), handlers.UpdateEvaluationDeadlines) // 设置各阶段截止时间
+			evaluationRoutes.PUT("/:id/reject", handlers.RejectEvaluation)                                            // 退回上一阶段
+			evaluationRoutes.PUT("/:id/reopen", handlers.RoleMiddleware("hr"), handlers.ReopenEvaluation)             // 重新开放已完成的评估
 			evaluationRoutes.GET("/employee/:employeeId", handlers.GetEmployeeEvaluations)
 			evaluationRoutes.GET("/pending/:employeeId", handlers.GetPendingEvaluations)
 			evaluationRoutes.GET("/pending/count", handlers.GetPendingCountEvaluations)
@@ -117,6 +220,39 @@ func SetupRoutes(r *gin.RouterGroup) {
 			// 邀请评分管理（HR发起邀请）
 			evaluationRoutes.POST("/:id/invitations", handlers.RoleMiddleware("hr"), handlers.CreateInvitation)
 			evaluationRoutes.GET("/:id/invitations", handlers.RoleMiddleware("hr"), handlers.GetEvaluationInvitations)
+
+			// 申诉管理（员工发起，上级或HR处理）
+			evaluationRoutes.GET("/:id/appeals", handlers.GetEvaluationAppeals)
+			evaluationRoutes.POST("/:id/appeals", handlers.CreateAppeal)
+
+			// 评审链评审（评审人评分，隔级上级审批）
+			evaluationRoutes.GET("/reviews/my", handlers.GetMyReviews)              // 待我评审的评估
+			evaluationRoutes.GET("/:id/reviewers", handlers.GetEvaluationReviewers) // 评审人及评分
+			evaluationRoutes.PUT("/:id/review", handlers.SubmitReview)              // 保存或提交评分
+			evaluationRoutes.PUT("/:id/review/approve", handlers.ApproveReview)     // 审批通过
+			evaluationRoutes.PUT("/:id/review/return", handlers.ReturnReview)       // 退回评审人重新评分
+		}
+
+		// 个人目标管理（员工设定，上级或HR审批）
+		goalRoutes := protected.Group("/goals")
+		{
+			goalRoutes.GET("", handlers.GetGoals)
+			goalRoutes.POST("", handlers.CreateGoal)
+			goalRoutes.GET("/:id", handlers.GetGoal)
+			goalRoutes.PUT("/:id", handlers.UpdateGoal)
+			goalRoutes.DELETE("/:id", handlers.DeleteGoal)
+			goalRoutes.PUT("/:id/submit", handlers.SubmitGoal)                                                        // 提交审批
+			goalRoutes.PUT("/:id/approve", handlers.RoleMiddleware("manager", "hr"), handlers.ApproveGoal)            // 审批通过
+			goalRoutes.PUT("/:id/reject", handlers.RoleMiddleware("manager", "hr"), handlers.RejectGoal)              // 退回
+			goalRoutes.POST("/:id/progress", handlers.UpdateGoalProgress)                                             // 更新进度
+			goalRoutes.PUT("/:id/self", handlers.UpdateGoalSelfScore)                                                 // 自评
+			goalRoutes.PUT("/:id/manager", handlers.RoleMiddleware("manager", "hr"), handlers.UpdateGoalManagerScore) // 上级评分
+		}
+
+		// 申诉处理
+		appealRoutes := protected.Group("/appeals")
+		{
+			appealRoutes.PUT("/:id/resolve", handlers.RoleMiddleware("manager", "hr"), handlers.ResolveAppeal) // 处理申诉
 		}
 
 		// 邀请评分管理
@@ -149,6 +285,7 @@ func SetupRoutes(r *gin.RouterGroup) {
 			scoreRoutes.PUT("/:id/manager", handlers.RoleMiddleware("manager", "hr"), handlers.UpdateManagerScore)
 			scoreRoutes.PUT("/:id/hr", handlers.RoleMiddleware("hr"), handlers.UpdateHRScore)
 			scoreRoutes.PUT("/:id/final", handlers.RoleMiddleware("hr"), handlers.UpdateFinalScore)
+			scoreRoutes.PUT("/:id/actual", handlers.UpdateActualValue)
 		}
 
 		// 统计分析（所有认证用户）
@@ -159,6 +296,15 @@ func SetupRoutes(r *gin.RouterGroup) {
 			statsRoutes.GET("/employee/:id", handlers.GetEmployeeStats)
 			statsRoutes.GET("/trends", handlers.GetTrends)
 			statsRoutes.GET("/data", handlers.GetStatisticsData)
+			statsRoutes.GET("/reviewers", handlers.RoleMiddleware("hr"), handlers.GetReviewerAnalysis) // 评分人宽严分析
+		}
+
+		// 审计日志（HR）
+		auditRoutes := protected.Group("/audit-logs")
+		auditRoutes.Use(handlers.RoleMiddleware("hr"))
+		{
+			auditRoutes.GET("", handlers.GetAuditLogs)
+			auditRoutes.GET("/export", handlers.ExportAuditLogs) // 导出Excel
 		}
 
 		// 导出功能（管理员和HR）